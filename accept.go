@@ -0,0 +1,124 @@
+package main // Which document types a run archives, and where each one goes
+
+import (
+	"fmt"     // Builds option errors
+	"mime"    // Parses Content-Type headers and looks up extensions
+	"sort"    // Lists known kinds in errors
+	"strings" // Parses the -accept option
+)
+
+// Canonical MIME type of every kind sniffing recognizes, so -accept can name a kind by either
+var kindMIMETypes = map[string]string{
+	"pdf":  "application/pdf",
+	"zip":  "application/zip",
+	"docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"doc":  "application/msword",
+	"rtf":  "application/rtf",
+}
+
+var (
+	acceptedKinds          = map[string]bool{"pdf": true, "zip": true, "docx": true, "xlsx": true, "doc": true, "rtf": true} // Kinds stored by a run; the rest are skipped as non-pdf
+	acceptedLinkExtensions = map[string]bool{}                                                                               // Extensions other than .pdf whose links are discovered, from -accept
+	acceptConfigured       = false                                                                                           // Whether -accept replaced the default kinds
+)
+
+// Parses one -accept option, a comma-separated list of type=directory entries where type is a kind (pdf, zip, docx,
+// xlsx, doc, rtf) or a MIME type; the first -accept replaces the default kinds and later ones add to it
+func setAcceptedTypes(value string) error {
+	if !acceptConfigured {
+		acceptedKinds = make(map[string]bool)
+		acceptConfigured = true
+	}
+	for _, item := range strings.Split(value, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		name, dir, found := strings.Cut(item, "=")
+		name, dir = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(dir)
+		if !found || name == "" || dir == "" {
+			return fmt.Errorf("-accept entries are type=directory, e.g. pdf=PDFs/ or text/csv=CSVs/")
+		}
+		dir = strings.TrimSuffix(dir, "/") + "/"
+		kind := name
+		for known, mimeType := range kindMIMETypes {
+			if name == mimeType {
+				kind = known // A MIME type of a kind sniffing recognizes
+			}
+		}
+		if _, known := kindMIMETypes[kind]; !known {
+			if !strings.Contains(kind, "/") {
+				return fmt.Errorf("-accept: unknown type %q (expected a MIME type or one of %s)", name, strings.Join(knownKinds(), ", "))
+			}
+			registerDeclaredKind(kind)
+		}
+		if kind == "pdf" {
+			pdfOutputDir = dir // The PDF tree is where every download starts out
+		} else {
+			entry := documentKinds[kind]
+			entry.Dir = &dir // Kinds sharing a default directory can be split up
+			documentKinds[kind] = entry
+			if entry.Extension != "" {
+				acceptedLinkExtensions[entry.Extension] = true
+			}
+		}
+		acceptedKinds[kind] = true
+	}
+	return nil
+}
+
+// Reports whether a run stores a kind; an Office container counts while either Office kind is accepted, since
+// only the complete file shows which one it is
+func acceptsKind(kind string) bool {
+	if kind == "docx" || kind == "xlsx" {
+		return acceptedKinds["docx"] || acceptedKinds["xlsx"]
+	}
+	return acceptedKinds[kind]
+}
+
+// Returns the extra link extensions as one sorted string, "" by default, so cached link extractions made with other
+// extensions aren't reused
+func acceptedLinkExtensionsKey() string {
+	extensions := make([]string, 0, len(acceptedLinkExtensions))
+	for extension := range acceptedLinkExtensions {
+		extensions = append(extensions, extension)
+	}
+	sort.Strings(extensions)
+	return strings.Join(extensions, ",")
+}
+
+// Returns the kinds sniffing recognizes, sorted
+func knownKinds() []string {
+	kinds := make([]string, 0, len(kindMIMETypes))
+	for kind := range kindMIMETypes {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Adds a MIME type without a recognizable signature as a kind of its own, trusted from the Content-Type header and
+// saved with the type's usual extension
+func registerDeclaredKind(mimeType string) {
+	extension := ""
+	if extensions, err := mime.ExtensionsByType(mimeType); err == nil && len(extensions) > 0 {
+		extension = extensions[0]
+	}
+	documentKinds[mimeType] = documentKind{Dir: new(string), Extension: extension, MIME: mimeType}
+}
+
+// Returns the declared kind a Content-Type names, or "" when it names none; kinds with a signature are only ever
+// recognized by sniffing, so a server can't pass an error page off as a PDF
+func declaredKind(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	if _, known := kindMIMETypes[mediaType]; known {
+		return ""
+	}
+	if _, registered := documentKinds[mediaType]; registered {
+		return mediaType
+	}
+	return ""
+}
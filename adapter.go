@@ -0,0 +1,173 @@
+package main // Vendor adapters: site-specific discovery of the documents each vendor publishes
+
+import (
+	"context" // Lets a run stop discovery between pages
+	"fmt"     // Builds validation errors
+	"log"     // Reports skipped links and pagination limits
+	"slices"  // Detects pagination loops
+	"sort"    // Lists adapter names in errors
+	"strings" // Matches vendor hosts
+)
+
+// Finds the documents one vendor site publishes. Each adapter is a self-contained unit: adding a vendor means adding
+// an adapter and registering it in vendorAdapters, not changing the crawl in main
+type vendorAdapter interface {
+	Name() string                               // Name targets select the adapter by, e.g. poolseason
+	Discover(ctx context.Context) []pdfDocument // Normalized documents in page order; duplicates are removed by the caller
+}
+
+// State shared by every adapter in one discovery run
+type discoveryRun struct {
+	pageCache  map[string]pageCacheEntry // Links extracted by earlier runs, keyed by page URL
+	found      int                       // Documents returned by the adapters that already ran
+	emptyPages []listingPage             // Pages fetched while nothing has been found, kept for the diagnosis
+	frontier   *crawlFrontier            // Pending and visited listing pages, kept with -frontier; nil otherwise
+	excluded   map[string]int            // Documents dropped because their category is disabled, by category
+}
+
+// Builds an adapter for the targets assigned to it
+type adapterFactory func(targets []scrapeTarget, run *discoveryRun) vendorAdapter
+
+// Adapters by name; a target's adapter field picks one, and hosts listed in vendorHosts pick theirs automatically
+var vendorAdapters = map[string]adapterFactory{
+	"listing":    newListingAdapter,    // Generic listing pages with optional selectors and pagination
+	"poolseason": newPoolSeasonAdapter, // www.poolseason.com
+}
+
+// Hosts whose targets use a vendor adapter unless the target names another one
+var vendorHosts = map[string]string{
+	"poolseason.com": "poolseason",
+}
+
+// Returns the adapter a target is handled by: the one it names, or the one registered for its host, or listing
+func (t scrapeTarget) adapterName() string {
+	if t.Adapter != "" {
+		return t.Adapter
+	}
+	if name, found := vendorHosts[normalizeDomain(getDomainFromURL(t.URL))]; found {
+		return name
+	}
+	return "listing"
+}
+
+// Checks that a target names a registered adapter
+func validateTargetAdapter(target scrapeTarget) error {
+	if _, found := vendorAdapters[target.adapterName()]; !found {
+		names := make([]string, 0, len(vendorAdapters))
+		for name := range vendorAdapters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("target %s: unknown adapter %q (expected one of %s)", target.URL, target.Adapter, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// Groups targets by adapter, in the order each adapter first appears, and builds the adapters
+func adaptersFor(targets []scrapeTarget, run *discoveryRun) []vendorAdapter {
+	var names []string
+	assigned := make(map[string][]scrapeTarget)
+	for _, target := range targets {
+		name := target.adapterName()
+		if _, seen := assigned[name]; !seen {
+			names = append(names, name)
+		}
+		assigned[name] = append(assigned[name], target)
+	}
+	adapters := make([]vendorAdapter, 0, len(names))
+	for _, name := range names {
+		adapters = append(adapters, vendorAdapters[name](assigned[name], run))
+	}
+	return adapters
+}
+
+// Crawls listing pages: every link to a document on each page, restricted to the target's container when one is
+// configured, following next links when the target asks for it
+type listingAdapter struct {
+	targets []scrapeTarget // Pages to crawl
+	run     *discoveryRun  // Page cache and diagnosis shared with the other adapters
+}
+
+// Builds the generic listing adapter
+func newListingAdapter(targets []scrapeTarget, run *discoveryRun) vendorAdapter {
+	return &listingAdapter{targets: targets, run: run}
+}
+
+// Returns the adapter's registry name
+func (a *listingAdapter) Name() string {
+	return "listing"
+}
+
+// Scrapes each listing page and returns its document links resolved against the page and normalized
+func (a *listingAdapter) Discover(ctx context.Context) []pdfDocument {
+	var documents []pdfDocument
+	for _, target := range a.targets {
+		pageURLs := target.startPages()      // The URL, or every page of its page range
+		depths := make(map[string]int)       // Next links followed to reach each queued page
+		sources := make(map[string]string)   // Page whose next link queued each followed page
+		visited := make(map[string]bool)     // Pages already scraped for this target
+		followed := 0                        // Pages reached through next links
+		for i := 0; i < len(pageURLs); i++ { // pageURLs grows as next links are found
+			if ctx.Err() != nil {
+				return documents // The run was cancelled
+			}
+			pageURL := pageURLs[i]
+			if canonical, err := normalizeURL(pageURL, pageURL); err == nil {
+				visited[canonical] = true // Next links are compared in canonical form
+			}
+			a.run.frontier.queue(pageURL, target.URL, depths[pageURL], sources[pageURL])
+			pageDocuments, next, resumed := a.run.frontier.resume(pageURL, target.URL) // Scraped before an interruption
+			if !resumed {
+				pageHTML := getDataFromURL(pageURL)                                                        // Scrape the page
+				links, categories, anchors := extractPageLinks(a.run.pageCache, pageURL, target, pageHTML) // Skip extraction when the page is unchanged
+				if a.run.found == 0 && len(documents) == 0 {
+					a.run.emptyPages = append(a.run.emptyPages, listingPage{URL: pageURL, HTML: pageHTML, Target: target})
+				}
+				for _, link := range links { // Iterate over each PDF link found
+					normalized, err := normalizeURL(pageURL, link) // Resolve and canonicalize the link
+					if err != nil {
+						log.Printf("Skipping unparseable link %q on %s: %v", link, pageURL, err)
+						continue
+					}
+					document := pdfDocument{URL: normalized, Category: categories[link], Anchor: anchors[link]}
+					if isWrappedLink(normalized) { // Name and fetch the document the link leads to, not the shortener
+						chain, err := resolveLink(ctx, normalized)
+						if err != nil {
+							log.Printf("Skipping link %s on %s that couldn't be resolved: %v", normalized, pageURL, err)
+							continue
+						}
+						document.URL, document.Resolution = chain[len(chain)-1], chain
+						log.Printf("Resolved %s to %s", normalized, document.URL)
+					}
+					pageDocuments = append(pageDocuments, document)
+				}
+				if target.Next != "" && pageHTML != "" { // Pagination is followed for this target
+					next = findNextPageLink(pageHTML, target.nextLink)
+				}
+				a.run.frontier.visit(pageURL, target.URL, pageDocuments, next)
+			}
+			for _, document := range pageDocuments { // Disabled categories are dropped before anything is fetched
+				if target.wantsCategory(document.Category) {
+					documents = append(documents, document)
+				} else {
+					a.run.excluded[document.Category]++
+				}
+			}
+			if next == "" {
+				continue // Last page, or pagination isn't followed
+			}
+			nextURL, err := normalizeURL(pageURL, next)
+			if err != nil || visited[nextURL] || slices.Contains(pageURLs[i+1:], nextURL) {
+				continue // Unusable link, or a loop back to a page already listed
+			}
+			if followed >= target.maxPages() {
+				log.Printf("Stopped following next links on %s after %d pages", target.URL, followed)
+				continue
+			}
+			followed++
+			depths[nextURL], sources[nextURL] = depths[pageURL]+1, pageURL
+			pageURLs = append(pageURLs, nextURL)
+		}
+	}
+	return documents
+}
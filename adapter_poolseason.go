@@ -0,0 +1,29 @@
+package main // The poolseason.com adapter, the first vendor shipped
+
+import "context" // Passed through to the listing crawl
+
+const poolSeasonListingURL = "https://www.poolseason.com/safety-data-sheets/" // Page listing every PoolSeason SDS
+
+// Discovers PoolSeason's safety data sheets. The site publishes them as PDF links on one listing page, so the
+// generic listing crawl does the work; targets only need to name the page, and none defaults to the SDS listing
+type poolSeasonAdapter struct {
+	listing listingAdapter // Crawl of the configured PoolSeason pages
+}
+
+// Builds the PoolSeason adapter
+func newPoolSeasonAdapter(targets []scrapeTarget, run *discoveryRun) vendorAdapter {
+	if len(targets) == 0 {
+		targets = []scrapeTarget{{URL: poolSeasonListingURL}}
+	}
+	return &poolSeasonAdapter{listing: listingAdapter{targets: targets, run: run}}
+}
+
+// Returns the adapter's registry name
+func (a *poolSeasonAdapter) Name() string {
+	return "poolseason"
+}
+
+// Returns the SDS links found on the PoolSeason listing pages
+func (a *poolSeasonAdapter) Discover(ctx context.Context) []pdfDocument {
+	return a.listing.Discover(ctx)
+}
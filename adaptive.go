@@ -0,0 +1,108 @@
+package main // Download concurrency that adapts to how the server copes, additive increase and multiplicative decrease
+
+import (
+	"log"         // Reports changes of the limit
+	"sync"        // Guards the limit and the window counters
+	"sync/atomic" // Publishes the limiter of the running pipeline
+	"time"        // Spaces out decreases
+)
+
+var autoConcurrency = false // Adapt the number of parallel downloads between 1 and -download-workers instead of always using all of them
+
+const (
+	adaptiveErrorRate = 0.2              // Share of failed downloads in a window above which concurrency is halved
+	adaptiveCooldown  = 10 * time.Second // Minimum time between two decreases, so one burst of 429s counts once
+)
+
+// Caps how many downloads run at once: halved when the server throttles or too many downloads fail, raised by one
+// after every window of as many clean downloads as the limit allows
+type adaptiveLimiter struct {
+	mu          sync.Mutex // Protects the fields below
+	changed     *sync.Cond // Signalled when a slot frees up or the limit rises
+	limit       int        // Downloads allowed at once
+	maximum     int        // -download-workers
+	inFlight    int        // Downloads running
+	completed   int        // Downloads finished in the current window
+	failed      int        // Of which failed
+	throttled   bool       // The server throttled during the current window
+	lastDecline time.Time  // When the limit was last halved
+	lowest      int        // Lowest limit reached this run
+}
+
+var activeLimiter atomic.Pointer[adaptiveLimiter] // Limiter of the running pipeline, nil when concurrency is fixed
+
+// Returns a limiter that starts at half of the workers, leaving room to grow and to back off
+func newAdaptiveLimiter(maximum int) *adaptiveLimiter {
+	limiter := &adaptiveLimiter{limit: max(1, maximum/2), maximum: maximum}
+	limiter.lowest = limiter.limit
+	limiter.changed = sync.NewCond(&limiter.mu)
+	return limiter
+}
+
+// Blocks until a download may start
+func (l *adaptiveLimiter) acquire() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.inFlight >= l.limit {
+		l.changed.Wait()
+	}
+	l.inFlight++
+}
+
+// Ends a download and adjusts the limit once a window's worth of downloads has finished
+func (l *adaptiveLimiter) release(failed bool) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	l.completed++
+	if failed {
+		l.failed++
+	}
+	if l.completed >= l.limit {
+		switch {
+		case float64(l.failed)/float64(l.completed) > adaptiveErrorRate:
+			l.decrease("%d of %d downloads failed", l.failed, l.completed)
+		case !l.throttled && l.limit < l.maximum:
+			l.limit++
+			log.Printf("Raising download concurrency to %d", l.limit)
+		}
+		l.completed, l.failed, l.throttled = 0, 0, false
+	}
+	l.changed.Broadcast()
+}
+
+// Halves the limit because the server asked us to slow down
+func (l *adaptiveLimiter) throttle() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.throttled = true
+	l.decrease("the server throttled a request")
+}
+
+// Halves the limit unless it was halved within the cooldown; the caller holds l.mu
+func (l *adaptiveLimiter) decrease(reason string, args ...any) {
+	if l.limit == 1 || time.Since(l.lastDecline) < adaptiveCooldown {
+		return
+	}
+	l.limit = max(1, l.limit/2)
+	l.lastDecline = time.Now()
+	l.completed, l.failed = 0, 0 // Judge the new limit on its own downloads
+	l.lowest = min(l.lowest, l.limit)
+	log.Printf("Lowering download concurrency to %d: "+reason, append([]any{l.limit}, args...)...)
+}
+
+// Returns the limit in effect and the lowest it went this run
+func (l *adaptiveLimiter) levels() (current int, lowest int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit, l.lowest
+}
@@ -0,0 +1,174 @@
+package main // API keys and roles required by the serve endpoints
+
+import (
+	"context"       // Carries the caller's metadata
+	"crypto/sha256" // Hashes keys so comparisons take the same time
+	"crypto/subtle" // Compares key digests in constant time
+	"encoding/json" // Reads the key file
+	"fmt"           // Formats errors
+	"log"           // Reports who triggered a run
+	"os"            // Reads the key file and environment
+	"strings"       // Parses the environment variable and headers
+
+	"google.golang.org/grpc"          // Interceptor types
+	"google.golang.org/grpc/codes"    // Unauthenticated and PermissionDenied
+	"google.golang.org/grpc/metadata" // Reads the key sent by the caller
+	"google.golang.org/grpc/status"   // Builds gRPC errors
+)
+
+var apiKeysPath = "" // JSON file listing the API keys serve accepts; POOLSEASON_API_KEYS is read when empty
+
+var allowNoAuth = false // Serve without API keys when none are configured, instead of refusing to start
+
+const apiKeysEnvironment = "POOLSEASON_API_KEYS" // Comma-separated role:key pairs, for deployments without a key file
+
+// Roles an API key can have; admin can do everything read can
+const (
+	roleRead  = "read"  // List and fetch documents and poll run status
+	roleAdmin = "admin" // Also trigger runs
+)
+
+// One key as written in the key file; the key itself is an env:NAME or secret:NAME reference, never the value
+type apiKeyConfig struct {
+	Name string `json:"name"` // Who holds the key, for the log
+	Key  string `json:"key"`  // env:NAME or secret:NAME reference to the key
+	Role string `json:"role"` // read or admin
+}
+
+// A resolved key
+type apiKey struct {
+	name   string   // Who holds the key
+	role   string   // read or admin
+	digest [32]byte // SHA-256 of the key
+}
+
+// Keys accepted by the server; empty only when -insecure-no-auth turns authentication off
+type apiKeyring []apiKey
+
+// Loads the keys from -api-keys, or from POOLSEASON_API_KEYS when no file is given; no keys is an error unless
+// -insecure-no-auth leaves the API open
+func loadAPIKeys() (apiKeyring, error) {
+	keys, err := readAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 && !allowNoAuth {
+		return nil, fmt.Errorf("no API keys configured; give -api-keys or %s, or pass -insecure-no-auth to serve without authentication", apiKeysEnvironment)
+	}
+	return keys, nil
+}
+
+// Reads the keys from -api-keys, or from POOLSEASON_API_KEYS when no file is given
+func readAPIKeys() (apiKeyring, error) {
+	var configs []apiKeyConfig
+	if apiKeysPath != "" {
+		data, err := os.ReadFile(apiKeysPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &configs); err != nil {
+			return nil, fmt.Errorf("parsing API keys %s: %w", apiKeysPath, err)
+		}
+	}
+	var keys apiKeyring
+	for index, config := range configs {
+		if config.Role != roleRead && config.Role != roleAdmin {
+			return nil, fmt.Errorf("API key %d in %s: unknown role %q (expected read or admin)", index+1, apiKeysPath, config.Role)
+		}
+		value, err := resolveCredential(config.Key)
+		if err != nil {
+			return nil, fmt.Errorf("API key %d in %s: %w", index+1, apiKeysPath, err)
+		}
+		name := config.Name
+		if name == "" {
+			name = fmt.Sprintf("key %d", index+1)
+		}
+		keys = append(keys, apiKey{name: name, role: config.Role, digest: sha256.Sum256([]byte(value))})
+	}
+	if apiKeysPath != "" {
+		return keys, nil
+	}
+	for index, pair := range strings.Split(os.Getenv(apiKeysEnvironment), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		role, value, found := strings.Cut(pair, ":")
+		if !found || value == "" || (role != roleRead && role != roleAdmin) {
+			return nil, fmt.Errorf("%s entry %d must be read:<key> or admin:<key>", apiKeysEnvironment, index+1)
+		}
+		keys = append(keys, apiKey{name: fmt.Sprintf("%s entry %d", apiKeysEnvironment, index+1), role: role, digest: sha256.Sum256([]byte(value))})
+	}
+	return keys, nil
+}
+
+// Returns the key matching the one presented, comparing against every key so the time taken doesn't tell which
+// matched
+func (keys apiKeyring) lookup(presented string) (apiKey, bool) {
+	digest := sha256.Sum256([]byte(presented))
+	var match apiKey
+	found := false
+	for _, key := range keys {
+		if subtle.ConstantTimeCompare(digest[:], key.digest[:]) == 1 {
+			match, found = key, true
+		}
+	}
+	return match, found
+}
+
+// Returns the role a method needs: admin for TriggerRun, read for everything else
+func methodRole(fullMethod string) string {
+	if fullMethod == "/"+scraperServiceName+"/TriggerRun" {
+		return roleAdmin
+	}
+	return roleRead
+}
+
+// Returns the key sent in the x-api-key or authorization: Bearer metadata
+func presentedKey(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("x-api-key"); len(values) > 0 {
+		return values[0]
+	}
+	for _, value := range md.Get("authorization") {
+		if scheme, token, found := strings.Cut(value, " "); found && strings.EqualFold(scheme, "bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return ""
+}
+
+// Checks that the caller sent a key whose role allows the method
+func (keys apiKeyring) authorize(ctx context.Context, fullMethod string) error {
+	presented := presentedKey(ctx)
+	if presented == "" {
+		return status.Error(codes.Unauthenticated, "an API key is required (x-api-key or authorization: Bearer metadata)")
+	}
+	key, found := keys.lookup(presented)
+	if !found {
+		return status.Error(codes.Unauthenticated, "unknown API key")
+	}
+	required := methodRole(fullMethod)
+	if required == roleAdmin && key.role != roleAdmin {
+		return status.Errorf(codes.PermissionDenied, "%s needs an admin API key", fullMethod)
+	}
+	if required == roleAdmin {
+		log.Printf("%s called with the API key of %s", fullMethod, key.name)
+	}
+	return nil
+}
+
+// Rejects unary calls without a suitable key
+func (keys apiKeyring) unaryInterceptor(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := keys.authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, request)
+}
+
+// Rejects streaming calls without a suitable key
+func (keys apiKeyring) streamInterceptor(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := keys.authorize(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
@@ -0,0 +1,124 @@
+package main // Append-only audit log of every network request
+
+import (
+	"encoding/json" // Encodes audit records
+	"errors"        // Recognizes the end of a body
+	"io"            // Wraps response bodies
+	"log"           // Reports audit write failures
+	"net/http"      // Wraps transports
+	"os"            // Appends to the audit log
+	"sync"          // Serializes writes and closes
+	"time"          // Times each request
+)
+
+var auditLogPath = "audit.jsonl" // One JSON record per network request is appended here; empty disables the log
+
+var auditMu sync.Mutex // Keeps concurrent records from interleaving
+
+// One network request as recorded in the audit log
+type auditRecord struct {
+	SchemaVersion int       `json:"schema_version"`   // auditSchemaVersion
+	At            time.Time `json:"at"`               // When the request was sent
+	RunID         string    `json:"run_id"`           // Run that sent it
+	Method        string    `json:"method"`           // HTTP method
+	URL           string    `json:"url"`              // Requested URL; each redirect hop is its own record
+	Status        int       `json:"status,omitempty"` // Response status, absent when no response arrived
+	Bytes         int64     `json:"bytes"`            // Response body bytes read
+	DurationMS    int64     `json:"duration_ms"`      // Time from sending the request until the body was closed
+	Outcome       string    `json:"outcome"`          // complete, aborted (closed before the end) or error
+	Error         string    `json:"error,omitempty"`  // What went wrong, for errors
+}
+
+// Appends a record to the audit log
+func appendAuditRecord(record auditRecord) {
+	if auditLogPath == "" {
+		return
+	}
+	record.SchemaVersion = auditSchemaVersion
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	file, err := os.OpenFile(auditLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.Printf("Failed to open audit log %s: %v", auditLogPath, err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Printf("Failed to write audit log %s: %v", auditLogPath, err)
+	}
+}
+
+// Records every request sent through the wrapped transport
+type auditTransport struct {
+	next http.RoundTripper // Transport doing the actual work
+}
+
+// Wraps a transport so its requests are audited; nil wraps http.DefaultTransport
+func newAuditTransport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return auditTransport{next: next}
+}
+
+// Sends the request and records it once the response body is closed, or at once when the request fails
+func (t auditTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	record := auditRecord{At: time.Now().UTC(), RunID: runID(), Method: request.Method, URL: request.URL.String()}
+	response, err := t.next.RoundTrip(request)
+	runStats.requestSent(err != nil)
+	if err != nil {
+		record.Outcome, record.Error = "error", err.Error()
+		record.DurationMS = time.Since(record.At).Milliseconds()
+		appendAuditRecord(record)
+		return nil, err
+	}
+	record.Status = response.StatusCode
+	empty := request.Method == http.MethodHead || response.ContentLength == 0 // Nothing to read, so closing it completes the request
+	response.Body = &auditBody{body: response.Body, record: record, done: empty}
+	return response, nil
+}
+
+// Counts the bytes read from a response body and writes the audit record when it is closed
+type auditBody struct {
+	body   io.ReadCloser // Response body being read
+	record auditRecord   // Record completed on Close
+	done   bool          // The body was read to the end
+	err    error         // First read error other than EOF
+	once   sync.Once     // Writes the record once
+}
+
+// Reads from the body, counting bytes and remembering how the read ended
+func (b *auditBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.record.Bytes += int64(n)
+	runStats.received(n)
+	if errors.Is(err, io.EOF) {
+		b.done = true
+	} else if err != nil && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// Closes the body and appends the audit record
+func (b *auditBody) Close() error {
+	err := b.body.Close()
+	b.once.Do(func() {
+		b.record.DurationMS = time.Since(b.record.At).Milliseconds()
+		switch {
+		case b.err != nil:
+			b.record.Outcome, b.record.Error = "error", b.err.Error()
+		case b.done:
+			b.record.Outcome = "complete"
+		default:
+			b.record.Outcome = "aborted"
+		}
+		appendAuditRecord(b.record)
+	})
+	return err
+}
@@ -0,0 +1,162 @@
+package main // Per-target authentication for listing pages and document APIs
+
+import (
+	"encoding/base64" // Encodes basic credentials
+	"encoding/json"   // Reads the secrets file
+	"fmt"             // Builds validation errors
+	"net/http"        // Adds credentials to requests
+	"os"              // Reads environment variables and the secrets file
+	"reflect"         // Compares auth settings shared by several targets
+	"strings"         // Parses credential references
+	"sync"            // Guards the host credentials
+)
+
+var secretsFilePath = "" // JSON object of named secrets referenced as "secret:<name>" by target auth settings
+
+// How a target authenticates; credential fields hold "env:NAME" or "secret:NAME" references, never the values
+type targetAuth struct {
+	Type       string            `json:"type"`                  // basic, bearer, header or session
+	Username   string            `json:"username,omitempty"`    // Basic auth user name
+	Password   string            `json:"password,omitempty"`    // Basic auth password
+	Token      string            `json:"token,omitempty"`       // Bearer token
+	Header     string            `json:"header,omitempty"`      // Header name for API keys, e.g. X-API-Key
+	Value      string            `json:"value,omitempty"`       // API key sent in Header
+	LoginURL   string            `json:"login_url,omitempty"`   // Form posted to start a session
+	Form       map[string]string `json:"form,omitempty"`        // Login form fields; env: and secret: values are resolved
+	TokenField string            `json:"token_field,omitempty"` // JSON field of the login response holding a bearer token; empty uses its cookies
+}
+
+// A header carrying resolved credentials
+type authHeader struct {
+	name  string // Header name
+	value string // Header value, including any scheme prefix
+}
+
+var (
+	hostAuthMu sync.RWMutex                  // Protects hostAuth
+	hostAuth   = make(map[string]authHeader) // Credentials keyed by normalized host
+	hostLogins = make(map[string]targetAuth) // Auth settings keyed by normalized host, used to refresh hostAuth
+	secrets    map[string]string             // Loaded from secretsFilePath on first use
+)
+
+// Resolves an "env:NAME" or "secret:NAME" reference to its value
+func resolveCredential(reference string) (string, error) {
+	source, name, found := strings.Cut(reference, ":")
+	if !found || name == "" {
+		return "", fmt.Errorf("credential %q must be an env:NAME or secret:NAME reference", reference)
+	}
+	var value string
+	switch source {
+	case "env":
+		value = os.Getenv(name)
+	case "secret":
+		if secrets == nil {
+			if secretsFilePath == "" {
+				return "", fmt.Errorf("secret %q referenced but no -secrets file given", name)
+			}
+			data, err := os.ReadFile(secretsFilePath)
+			if err != nil {
+				return "", err
+			}
+			if err := json.Unmarshal(data, &secrets); err != nil {
+				return "", fmt.Errorf("parsing secrets %s: %w", secretsFilePath, err)
+			}
+		}
+		value = secrets[name]
+	default:
+		return "", fmt.Errorf("credential %q must be an env:NAME or secret:NAME reference", reference)
+	}
+	if value == "" {
+		return "", fmt.Errorf("credential %s is empty or not set", reference)
+	}
+	return value, nil
+}
+
+// Resolves the credentials of an auth setting into the header sent with each request
+func (a targetAuth) header() (authHeader, error) {
+	switch strings.ToLower(a.Type) {
+	case "basic":
+		username, err := resolveCredential(a.Username)
+		if err != nil {
+			return authHeader{}, err
+		}
+		password, err := resolveCredential(a.Password)
+		if err != nil {
+			return authHeader{}, err
+		}
+		return authHeader{name: "Authorization", value: "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))}, nil
+	case "bearer":
+		token, err := resolveCredential(a.Token)
+		if err != nil {
+			return authHeader{}, err
+		}
+		return authHeader{name: "Authorization", value: "Bearer " + token}, nil
+	case "header":
+		if a.Header == "" {
+			return authHeader{}, fmt.Errorf("auth type header needs a header name")
+		}
+		value, err := resolveCredential(a.Value)
+		if err != nil {
+			return authHeader{}, err
+		}
+		return authHeader{name: http.CanonicalHeaderKey(a.Header), value: value}, nil
+	case "session":
+		return a.login()
+	}
+	return authHeader{}, fmt.Errorf("unknown auth type %q (expected basic, bearer, header or session)", a.Type)
+}
+
+// Registers a target's credentials for its host; pages and documents on that host are then fetched with them
+func registerTargetAuth(target scrapeTarget) error {
+	if target.Auth == nil {
+		return nil
+	}
+	host := normalizeDomain(getDomainFromURL(target.URL))
+	hostAuthMu.RLock()
+	existing, found := hostLogins[host]
+	hostAuthMu.RUnlock()
+	if found && reflect.DeepEqual(existing, *target.Auth) {
+		return nil // Another page of the same site; sessions aren't started twice
+	}
+	header, err := target.Auth.header()
+	if err != nil {
+		return fmt.Errorf("target %s: auth: %w", target.URL, err)
+	}
+	hostAuthMu.Lock()
+	defer hostAuthMu.Unlock()
+	if existing, found := hostAuth[host]; found && existing != header {
+		return fmt.Errorf("target %s: %s already has different credentials from another target", target.URL, host)
+	}
+	hostAuth[host] = header
+	hostLogins[host] = *target.Auth
+	return nil
+}
+
+// Forgets every registered credential, before the targets are replaced
+func resetTargetAuth() {
+	hostAuthMu.Lock()
+	defer hostAuthMu.Unlock()
+	clear(hostAuth)
+	clear(hostLogins)
+}
+
+// Adds the registered credentials to requests for their host; other hosts, including redirect targets on other
+// domains, never see them
+type authTransport struct {
+	next http.RoundTripper // Transport doing the actual work
+}
+
+// Sets the host's auth header unless the request already carries one or is a login
+func (t authTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Context().Value(withoutTargetAuthKey{}) != nil {
+		return t.next.RoundTrip(request) // Logins must not send the session they replace
+	}
+	hostAuthMu.RLock()
+	header, found := hostAuth[normalizeDomain(request.URL.Hostname())]
+	hostAuthMu.RUnlock()
+	if found && request.Header.Get(header.name) == "" {
+		request = request.Clone(request.Context()) // RoundTrippers must not modify the caller's request
+		request.Header.Set(header.name, header.value)
+	}
+	return t.next.RoundTrip(request)
+}
@@ -0,0 +1,137 @@
+package main // Per-run accounting of bytes downloaded and bytes avoided
+
+import (
+	"encoding/json" // Appends run reports to the bandwidth log
+	"fmt"           // Formats byte counts
+	"log"           // Reports log write failures
+	"os"            // Appends to the bandwidth log
+	"sync"          // Guards the counters against concurrent downloads
+	"time"          // Stamps each report
+)
+
+var bandwidthLogPath = "bandwidth.jsonl" // One JSON report per run is appended here; empty disables the log
+
+// Files and bytes that didn't have to be transferred for one reason
+type skipTotal struct {
+	Files int   `json:"files"` // Documents skipped for this reason
+	Bytes int64 `json:"bytes"` // Bytes they would have cost, from the manifest
+}
+
+// Bytes a run transferred and avoided
+type bandwidthReport struct {
+	SchemaVersion  int                  `json:"schema_version,omitempty"` // runReportSchemaVersion in the bandwidth log
+	RunID          string               `json:"run_id,omitempty"`         // Run the counters belong to
+	At             time.Time            `json:"at"`                       // When the run finished
+	PDFBytes       int64                `json:"pdf_bytes"`                // PDF bytes received, including discarded attempts
+	PageBytes      int64                `json:"page_bytes"`               // Listing page bytes received
+	DiscardedBytes int64                `json:"discarded_bytes"`          // Received bytes thrown away after failed verification or storage
+	Skipped        map[string]skipTotal `json:"skipped,omitempty"`        // Avoided transfers keyed by reason, e.g. already-archived
+}
+
+// Returns every byte received during the run
+func (r bandwidthReport) downloadedBytes() int64 {
+	return r.PDFBytes + r.PageBytes
+}
+
+// Returns the bytes avoided across all skip reasons
+func (r bandwidthReport) skippedBytes() (int64, int) {
+	var bytes int64
+	files := 0
+	for _, total := range r.Skipped {
+		bytes += total.Bytes
+		files += total.Files
+	}
+	return bytes, files
+}
+
+// Collects bandwidth counters for the current run
+type bandwidthStats struct {
+	mu     sync.Mutex      // Protects report
+	report bandwidthReport // Totals so far
+}
+
+var runBandwidth = &bandwidthStats{} // Shared by scraping and downloading
+
+// Counts bytes received for a listing page
+func (b *bandwidthStats) addPage(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.report.PageBytes += int64(n)
+}
+
+// Counts bytes received for a PDF; discarded marks bytes that won't be kept
+func (b *bandwidthStats) addPDF(n int64, discarded bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.report.PDFBytes += n
+	if discarded {
+		b.report.DiscardedBytes += n
+	}
+}
+
+// Counts bytes discarded after they were already counted as received
+func (b *bandwidthStats) discard(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.report.DiscardedBytes += n
+}
+
+// Counts a document that didn't need to be transferred
+func (b *bandwidthStats) addSkipped(reason string, size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.report.Skipped == nil {
+		b.report.Skipped = make(map[string]skipTotal)
+	}
+	total := b.report.Skipped[reason]
+	total.Files++
+	total.Bytes += size
+	b.report.Skipped[reason] = total
+}
+
+// Returns the totals so far and resets the counters for the next run
+func (b *bandwidthStats) drain() bandwidthReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	report := b.report
+	report.At = time.Now().UTC()
+	report.RunID = runID()
+	b.report = bandwidthReport{}
+	return report
+}
+
+// Appends a run's report to the bandwidth log as one JSON line
+func appendBandwidthLog(filePath string, report bandwidthReport) {
+	if filePath == "" {
+		return
+	}
+	report.SchemaVersion = runReportSchemaVersion
+	line, err := json.Marshal(report)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.Printf("Failed to open bandwidth log %s: %v", filePath, err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Printf("Failed to write bandwidth log %s: %v", filePath, err)
+	}
+}
+
+// Formats a byte count with binary units, e.g. 1.5 MiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, exponent := float64(n)/unit, 0
+	for value >= unit && exponent < 4 {
+		value /= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[exponent])
+}
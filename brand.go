@@ -0,0 +1,83 @@
+package main // Manufacturer and supplier names read from Section 1 of a Safety Data Sheet
+
+import (
+	"regexp"  // Finds section headings and company names
+	"strings" // Normalizes company names
+)
+
+var (
+	sectionOneRegex = regexp.MustCompile(`(?im)^[ \t]*(?:section[ \t]*)?1[ \t]*[.:]?[ \t]*[-–]?[ \t]*(?:identification|chemical product|product and company|product identification|identificaci)`)                                       // Heading of the identification section
+	sectionTwoRegex = regexp.MustCompile(`(?im)^[ \t]*(?:section[ \t]*)?2[ \t]*[.:]?[ \t]*[-–]?[ \t]*(?:hazards?|identificaci[oó]n de (?:los )?(?:riesgos|peligros))`)                                                                   // Heading of the hazards section that ends it
+	cityLineRegex   = regexp.MustCompile(`^[A-Z][A-Za-z .'\-]+,[ \t]*[A-Z]{2}[ \t]+\d{5}(?:-\d{4})?\b`)                                                                                                                                  // "Alpharetta, GA 30004" closing a US address
+	companyRegex    = regexp.MustCompile(`\b([A-Z][\w&'\-]*(?:[ \t]+(?:&[ \t]+)?[A-Z][\w&'\-]*){0,5}),?[ \t]+(Inc\b\.?|Incorporated\b|Corporation\b|Corp\b\.?|Co\b\.|LLC\b|L\.L\.C\.|Ltd\b\.?|Limited\b|Company\b|GmbH\b|S\.A\.|PLC\b)`) // Capitalized name followed by a corporate suffix
+)
+
+// Maximum characters of Section 1 searched when no heading ends it
+const sectionOneLength = 3000
+
+// Returns the text of the identification section: from its heading to the hazards heading, or the start of the
+// first page when the sheet has no recognizable headings
+func sectionOneText(text string) string {
+	firstPage := identificationText(text)
+	if location := sectionOneRegex.FindStringIndex(firstPage); location != nil {
+		return firstPage[location[0]:]
+	}
+	return firstPage
+}
+
+// Returns the first page up to the hazards heading: the title block and the identification section
+func identificationText(text string) string {
+	section, _, _ := strings.Cut(text, "\f")
+	if location := sectionTwoRegex.FindStringIndex(section); location != nil {
+		section = section[:location[0]]
+	}
+	if len(section) > sectionOneLength {
+		section = section[:sectionOneLength]
+	}
+	return section
+}
+
+// Returns the companies named in Section 1 in order of appearance, e.g. the distributor and the manufacturer
+func findCompanies(text string) []string {
+	var companies []string
+	seen := make(map[string]bool) // Lowercased names already listed
+	for _, match := range companyRegex.FindAllStringSubmatch(sectionOneText(text), -1) {
+		name := strings.Join(strings.Fields(match[0]), " ") // Collapse the spacing of the PDF text
+		if key := strings.ToLower(strings.TrimSuffix(name, ".")); !seen[key] {
+			seen[key] = true
+			companies = append(companies, name)
+		}
+	}
+	if len(companies) == 0 {
+		if name := addresseeName(sectionOneText(text)); name != "" {
+			companies = append(companies, name) // Company names without a corporate suffix
+		}
+	}
+	return companies
+}
+
+// Returns the name heading the first postal address in the section: the line above the street lines that precede
+// its city line
+func addresseeName(section string) string {
+	lines := strings.Split(section, "\n")
+	for i, line := range lines {
+		if !cityLineRegex.MatchString(strings.TrimSpace(line)) {
+			continue
+		}
+		for j := i - 1; j >= 0 && j >= i-4; j-- {
+			candidate := strings.Join(strings.Fields(lines[j]), " ")
+			switch {
+			case candidate == "":
+			case candidate[0] >= '0' && candidate[0] <= '9', strings.HasPrefix(strings.ToUpper(candidate), "P.O."), strings.Contains(strings.ToLower(candidate), "floor"):
+				// Street, box and floor lines of the address
+			default:
+				if strings.Contains(candidate, ":") {
+					return "" // A labelled field, not a name
+				}
+				return candidate
+			}
+		}
+		return ""
+	}
+	return ""
+}
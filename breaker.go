@@ -0,0 +1,116 @@
+package main // Per-host circuit breaker that stops hammering hosts that keep failing
+
+import (
+	"fmt"      // Builds the open-circuit error
+	"log"      // Reports state changes
+	"net/http" // Status codes
+	"sort"     // Orders the open hosts
+	"sync"     // Guards the breaker state
+	"time"     // Tracks the cooldown
+)
+
+var (
+	breakerThreshold = 5               // Consecutive failures that open a host's circuit; 0 disables the breaker
+	breakerCooldown  = 5 * time.Minute // How long an open circuit rejects requests before allowing a trial
+)
+
+// Failure tracking for one host
+type hostCircuit struct {
+	failures  int       // Consecutive failures since the last success
+	openUntil time.Time // Requests are rejected until this time
+	trial     bool      // A half-open trial request is in flight
+}
+
+// Circuit breakers for every host contacted during the run
+type circuitBreaker struct {
+	mu    sync.Mutex              // Protects hosts
+	hosts map[string]*hostCircuit // State keyed by host name
+}
+
+var hostBreakers = &circuitBreaker{hosts: make(map[string]*hostCircuit)} // Shared by scraping and downloading
+
+// Returned for requests to a host whose circuit is open
+type circuitOpenError struct {
+	host  string    // Host being skipped
+	until time.Time // When a trial request will be allowed
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for %s until %s after repeated failures", e.host, e.until.Format(time.RFC3339))
+}
+
+// Returns an error when requests to the host should be skipped; after the cooldown one trial request is let through
+func (b *circuitBreaker) allow(host string) error {
+	if breakerThreshold <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	circuit := b.hosts[host]
+	if circuit == nil || circuit.failures < breakerThreshold {
+		return nil // Closed
+	}
+	if time.Now().Before(circuit.openUntil) || circuit.trial {
+		return &circuitOpenError{host: host, until: circuit.openUntil} // Open, or half-open with a trial already running
+	}
+	circuit.trial = true // Half-open: this request decides whether the host has recovered
+	log.Printf("Circuit for %s half-open; sending a trial request", host)
+	return nil
+}
+
+// Records a failed request; reaching the threshold (or failing a trial) opens the circuit
+func (b *circuitBreaker) failure(host string) {
+	if breakerThreshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	circuit := b.hosts[host]
+	if circuit == nil {
+		circuit = &hostCircuit{}
+		b.hosts[host] = circuit
+	}
+	circuit.failures++
+	if circuit.failures >= breakerThreshold {
+		circuit.openUntil = time.Now().Add(breakerCooldown)
+		if circuit.trial || circuit.failures == breakerThreshold {
+			log.Printf("Circuit for %s opened after %d consecutive failures; skipping it for %s", host, circuit.failures, breakerCooldown)
+		}
+		circuit.trial = false
+	}
+}
+
+// Records a successful request, closing the circuit
+func (b *circuitBreaker) success(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if circuit := b.hosts[host]; circuit != nil {
+		if circuit.failures >= breakerThreshold {
+			log.Printf("Circuit for %s closed; host recovered", host)
+		}
+		delete(b.hosts, host)
+	}
+}
+
+// Returns the hosts whose circuit is currently open
+func (b *circuitBreaker) openHosts() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var hosts []string
+	for host, circuit := range b.hosts {
+		if breakerThreshold > 0 && circuit.failures >= breakerThreshold {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts) // Stable order for logs and status
+	return hosts
+}
+
+// Records the outcome of a response: server errors are failures, anything else proves the host is up
+func recordHostResponse(host string, statusCode int) {
+	if statusCode >= 500 && statusCode != http.StatusServiceUnavailable { // 503 is handled by the pipeline throttle
+		hostBreakers.failure(host)
+		return
+	}
+	hostBreakers.success(host)
+}
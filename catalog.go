@@ -0,0 +1,158 @@
+package main // Catalog subcommand that exports the document inventory as CSV or XLSX
+
+import (
+	"archive/zip"   // XLSX workbooks are ZIP packages
+	"encoding/csv"  // Writes the CSV catalog
+	"encoding/xml"  // Escapes cell text inside the XLSX worksheet
+	"flag"          // Parses the catalog subcommand options
+	"fmt"           // Builds error messages and XML fragments
+	"log"           // Reports where the catalog was written
+	"os"            // Creates the output file
+	"path/filepath" // Derives product names from file paths
+	"strings"       // Builds product names and worksheet XML
+	"unicode"       // Capitalizes product name words
+)
+
+// Column headings shared by the CSV and XLSX catalogs
+var catalogHeader = []string{"Product", "Category", "URL", "Local File", "Revision Date", "SHA-256"}
+
+// Runs the catalog subcommand with its own command-line options
+func runCatalog(args []string) error {
+	flags := flag.NewFlagSet("catalog", flag.ExitOnError)                                    // Options specific to catalog
+	format := flags.String("format", "csv", "catalog format: csv or xlsx")                   // Output format
+	output := flags.String("output", "", "file to write (defaults to sds-catalog.<format>)") // Destination file
+	manifestPath := flags.String("manifest", manifestFilePath, "manifest describing the archive")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	*format = strings.ToLower(*format) // Accept CSV/XLSX in any case
+	if *output == "" {
+		*output = "sds-catalog." + *format // Default file name follows the format
+	}
+
+	rows := catalogRows(loadManifest(*manifestPath).list()) // Flatten the manifest into table rows
+	var err error
+	switch *format {
+	case "csv":
+		err = writeCatalogCSV(*output, rows)
+	case "xlsx":
+		err = writeCatalogXLSX(*output, rows)
+	default:
+		return fmt.Errorf("unknown catalog format %q (expected csv or xlsx)", *format)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Wrote %d catalog rows to %s", len(rows), *output)
+	return nil
+}
+
+// Converts manifest entries into catalog rows in the column order of catalogHeader
+func catalogRows(entries []manifestEntry) [][]string {
+	rows := make([][]string, 0, len(entries)) // One row per document
+	for _, entry := range entries {
+		revisionDate := "" // Left blank when neither the sheet nor the server gave a date
+		if revision := entry.revisionDate(); !revision.IsZero() {
+			revisionDate = revision.Format("2006-01-02")
+		}
+		rows = append(rows, []string{
+			productNameFromFile(entry.File),
+			entry.Category,
+			entry.URL,
+			entry.File,
+			revisionDate,
+			entry.SHA256,
+		})
+	}
+	return rows // Return the table body
+}
+
+// Turns a sanitized file name like pool_season_algae_control_60.pdf into "Pool Season Algae Control 60"
+func productNameFromFile(filePath string) string {
+	base := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))          // Drop directory and extension
+	words := strings.FieldsFunc(base, func(r rune) bool { return r == '_' || r == '-' }) // Split on separators
+	for i, word := range words {
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0]) // Capitalize the first letter of each word
+		words[i] = string(runes)
+	}
+	return strings.Join(words, " ") // Join back into a readable name
+}
+
+// Writes the catalog as a CSV file with a header row
+func writeCatalogCSV(filePath string, rows [][]string) error {
+	out, err := os.Create(filePath) // Create the CSV file
+	if err != nil {
+		return err
+	}
+	defer out.Close() // Close once written
+
+	writer := csv.NewWriter(out) // CSV writer handles quoting
+	if err := writer.Write(catalogHeader); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(rows); err != nil { // WriteAll flushes for us
+		return err
+	}
+	return out.Close() // Report errors from the final close
+}
+
+// Writes the catalog as a minimal single-sheet XLSX workbook
+func writeCatalogXLSX(filePath string, rows [][]string) error {
+	out, err := os.Create(filePath) // Create the workbook file
+	if err != nil {
+		return err
+	}
+	defer out.Close() // Close even if writing fails
+
+	parts := map[string]string{ // Every part an XLSX package needs, keyed by path
+		"[Content_Types].xml": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`,
+		"_rels/.rels": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`,
+		"xl/workbook.xml": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="SDS Inventory" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/worksheets/sheet1.xml": worksheetXML(append([][]string{catalogHeader}, rows...)),
+	}
+
+	archive := zip.NewWriter(out) // Workbook parts are stored in a ZIP container
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml"} {
+		if err := addBytesToArchive(archive, name, []byte(parts[name])); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil { // Flush the ZIP central directory
+		return err
+	}
+	return out.Close() // Report errors from the final close
+}
+
+// Renders rows as a worksheet using inline strings so no shared-string table is needed
+func worksheetXML(rows [][]string) string {
+	var sheet strings.Builder // Accumulates the worksheet XML
+	sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for rowIndex, row := range rows {
+		fmt.Fprintf(&sheet, `<row r="%d">`, rowIndex+1) // Rows are 1-based
+		for columnIndex, value := range row {
+			fmt.Fprintf(&sheet, `<c r="%s%d" t="inlineStr"><is><t>`, columnName(columnIndex), rowIndex+1)
+			xml.EscapeText(&sheet, []byte(value)) // Escape &, < and > in cell text
+			sheet.WriteString(`</t></is></c>`)
+		}
+		sheet.WriteString(`</row>`)
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+	return sheet.String() // Return the finished worksheet
+}
+
+// Converts a zero-based column index into a spreadsheet column name (0 → A, 26 → AA)
+func columnName(index int) string {
+	name := "" // Built from the least significant letter upwards
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name // Return the column letters
+}
@@ -0,0 +1,32 @@
+package main // Enabling and disabling whole categories of documents by the page heading they are listed under
+
+import "strings" // Matches headings case-insensitively
+
+var (
+	enabledCategories  = "" // Comma-separated headings whose documents are downloaded, for every target; empty enables all
+	disabledCategories = "" // Comma-separated headings whose documents are never downloaded, for every target
+)
+
+// Reports whether a target's documents listed under a heading are wanted. A target's own categories replace
+// -categories, skip_categories add to -skip-categories; documents without a heading are only dropped by an allow list
+func (t scrapeTarget) wantsCategory(category string) bool {
+	category = strings.Join(strings.Fields(category), " ") // Headings are compared as extracted
+	if listsCategory(t.SkipCategories, category) || listsCategory(strings.Split(disabledCategories, ","), category) {
+		return false
+	}
+	enabled := t.Categories
+	if len(enabled) == 0 && strings.TrimSpace(enabledCategories) != "" {
+		enabled = strings.Split(enabledCategories, ",")
+	}
+	return len(enabled) == 0 || listsCategory(enabled, category)
+}
+
+// Reports whether a heading is in a list, ignoring case and surrounding space
+func listsCategory(categories []string, category string) bool {
+	for _, listed := range categories {
+		if listed = strings.TrimSpace(listed); listed != "" && strings.EqualFold(listed, category) {
+			return true
+		}
+	}
+	return false
+}
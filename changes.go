@@ -0,0 +1,124 @@
+package main // Re-downloading archived documents that changed upstream, keeping the superseded copies
+
+import (
+	"fmt"      // Describes detected changes
+	"log"      // Reports probes and archived revisions
+	"net/http" // Sends conditional HEAD requests
+	"path"     // Builds revision keys
+	"slices"   // Moves changed documents to the front of the queue
+	"time"     // Compares Last-Modified times and stamps revision keys
+)
+
+var checkChanges = false // Probe archived documents with conditional HEAD requests and re-download the ones that changed
+
+// Asks the server whether an archived document changed since it was downloaded, using its ETag and Last-Modified
+// time; returns why it's considered changed, or "" when it isn't or the server can't tell
+func probeUpstreamChange(client *http.Client, entry manifestEntry) string {
+	request, err := http.NewRequest(http.MethodHead, entry.URL, nil)
+	if err != nil {
+		return ""
+	}
+	setAcceptLanguage(request, entry.Language)
+	if entry.ETag != "" {
+		request.Header.Set("If-None-Match", entry.ETag)
+	}
+	if !entry.LastModified.IsZero() {
+		request.Header.Set("If-Modified-Since", entry.LastModified.UTC().Format(http.TimeFormat))
+	}
+	host := getDomainFromURL(entry.URL)
+	if err := hostBreakers.allow(host); err != nil {
+		return "" // Don't probe hosts that keep failing
+	}
+	request, cancel := withFileDeadline(request)
+	defer cancel()
+	requestThrottle.wait()
+	response, err := client.Do(request)
+	if err != nil {
+		log.Printf("Failed to check %s for changes: %v", entry.URL, err)
+		return ""
+	}
+	response.Body.Close()
+	recordHostResponse(host, response.StatusCode)
+	if response.StatusCode != http.StatusOK {
+		return "" // 304 Not Modified, or a server that rejects HEAD
+	}
+	if etag := response.Header.Get("ETag"); etag != "" && entry.ETag != "" && etag != entry.ETag {
+		return fmt.Sprintf("ETag changed from %s to %s", entry.ETag, etag)
+	}
+	if modified := parseHTTPTime(response.Header.Get("Last-Modified")); !modified.IsZero() && modified.After(entry.LastModified) {
+		return "modified " + modified.Format(time.RFC3339)
+	}
+	if response.ContentLength >= 0 && response.Header.Get("Content-Encoding") == "" && response.ContentLength != entry.Size {
+		return fmt.Sprintf("size changed from %d to %d bytes", entry.Size, response.ContentLength)
+	}
+	return ""
+}
+
+// Probes every already-archived job for upstream changes, marks the changed ones for re-download and moves them to
+// the front of the queue; the rest keep their order
+func prioritizeUpstreamChanges(jobs []pipelineJob, documentManifest *manifest) []pipelineJob {
+	client := httpClient()
+	changed := 0
+	for i, job := range jobs {
+		entry, found := documentManifest.lookup(job.Document.URL, job.Language)
+		if !found {
+			continue // New documents are downloaded anyway
+		}
+		if reason := probeUpstreamChange(client, entry); reason != "" {
+			log.Printf("%s changed upstream (%s); re-downloading it first", entry.URL, reason)
+			jobs[i].Changed = true
+			changed++
+		}
+	}
+	if changed > 0 {
+		moveChangedFirst(jobs)
+	}
+	log.Printf("%d of %d archived documents changed upstream", changed, len(jobs))
+	return jobs
+}
+
+// Moves the jobs marked as changed to the front of the queue; the rest keep their order
+func moveChangedFirst(jobs []pipelineJob) {
+	slices.SortStableFunc(jobs, func(a pipelineJob, b pipelineJob) int {
+		switch {
+		case a.Changed == b.Changed:
+			return 0
+		case a.Changed:
+			return -1
+		}
+		return 1
+	})
+}
+
+// Returns the key a superseded copy is kept under: a revisions directory next to the file, stamped with the time
+// the copy was downloaded, e.g. PDFs/revisions/sds_20250102T030405Z.pdf
+func revisionKey(file string, downloadedAt time.Time) string {
+	extension := path.Ext(file)
+	stem := path.Base(file[:len(file)-len(extension)])
+	return path.Join(path.Dir(file), "revisions", stem+"_"+downloadedAt.UTC().Format("20060102T150405Z")+extension)
+}
+
+// Keeps the previous copy of a document that is being replaced by different contents at filePath. A copy stored
+// under the same key is moved to its revision key first; one stored elsewhere stays where it is. Reports false
+// when there is no previous copy left to keep
+func keepPreviousRevision(previous manifestEntry, filePath string) (manifestRevision, bool) {
+	revision := manifestRevision{File: previous.File, Size: previous.Size, SHA256: previous.SHA256, DownloadedAt: previous.DownloadedAt}
+	if digest, err := archiveStorage.Hash(previous.File); err != nil || digest != previous.SHA256 {
+		return revision, false // Missing or already overwritten
+	}
+	if previous.File != filePath {
+		return revision, true // The new copy goes under another name, e.g. a product name with a new revision date
+	}
+	data, err := archiveStorage.Get(previous.File)
+	if err != nil {
+		log.Printf("Not keeping the previous copy of %s: %v", previous.URL, err)
+		return revision, false
+	}
+	revision.File = revisionKey(previous.File, previous.DownloadedAt)
+	if err := archiveStorage.Put(revision.File, data); err != nil {
+		log.Printf("Not keeping the previous copy of %s: %v", previous.URL, err)
+		return revision, false
+	}
+	log.Printf("Kept the previous copy of %s as %s", previous.URL, revision.File)
+	return revision, true
+}
@@ -0,0 +1,45 @@
+package main // Companion checksum files compatible with sha256sum -c
+
+import (
+	"fmt"     // Formats checksum lines
+	"log"     // Reports write failures
+	"path"    // Works with slash-separated storage keys
+	"strings" // Builds the consolidated checksum file
+)
+
+var writeChecksums = false // Whether to write <file>.sha256 sidecars and a consolidated SHA256SUMS file
+
+const checksumListName = "SHA256SUMS" // Name of the consolidated checksum file in the PDF directory
+
+// Formats a line in the format produced by sha256sum (digest, two spaces, file name)
+func checksumLine(digest string, name string) string {
+	return fmt.Sprintf("%s  %s\n", digest, name) // Two spaces mark a binary-safe text-mode entry
+}
+
+// Writes a sidecar next to every document that lacks one, plus SHA256SUMS covering the whole PDF directory
+func writeChecksumFiles(entries []manifestEntry) {
+	baseDir := strings.TrimSuffix(pdfOutputDir, "/") // Consolidated list lives at the root of the PDF directory
+	var list strings.Builder                         // Lines of the consolidated list
+	for _, entry := range entries {
+		if entry.SHA256 == "" {
+			continue // Entries recorded before hashing was added
+		}
+		sidecar := entry.File + ".sha256"             // Sidecar sits next to the document
+		exists, err := archiveStorage.Exists(sidecar) // Keep sidecars that are already there
+		if err != nil {
+			log.Printf("Failed to check %s: %v", sidecar, err)
+		} else if !exists {
+			line := checksumLine(entry.SHA256, path.Base(entry.File)) // Relative to the sidecar's own directory
+			if err := archiveStorage.Put(sidecar, []byte(line)); err != nil {
+				log.Printf("Failed to write %s: %v", sidecar, err)
+			}
+		}
+		if relative, found := strings.CutPrefix(entry.File, baseDir+"/"); found { // Only documents inside the PDF directory
+			list.WriteString(checksumLine(entry.SHA256, relative))
+		}
+	}
+	listPath := path.Join(baseDir, checksumListName) // Verify with: cd PDFs && sha256sum -c SHA256SUMS
+	if err := archiveStorage.Put(listPath, []byte(list.String())); err != nil {
+		log.Printf("Failed to write %s: %v", listPath, err)
+	}
+}
@@ -0,0 +1,111 @@
+package main // Parallel ranged downloads for large files on high-latency links
+
+import (
+	"context"  // Cancels the other chunks when one fails
+	"errors"   // Joins chunk failures
+	"fmt"      // Builds Range headers and errors
+	"io"       // Reads chunk bodies
+	"log"      // Reports chunked transfers
+	"net/http" // Ranged requests
+	"strings"  // Inspects Accept-Ranges and ETag values
+	"sync"     // Waits for the chunk workers
+)
+
+var (
+	downloadChunks       = 1                  // Parallel ranged requests per large file; 1 disables chunking
+	chunkThreshold int64 = 32 * 1024 * 1024   // Files at least this large are split into chunks
+	chunkMaxSize   int64 = 1024 * 1024 * 1024 // Files claiming to be larger are downloaded as one stream, since chunking allocates the claimed size up front
+)
+
+// Reports whether a response can be completed with parallel ranged requests: the server accepts byte ranges, the
+// size is known, large enough and within -chunk-max-size, and a validator lets every chunk be pinned to the same
+// version of the file
+func canDownloadInChunks(response *http.Response) bool {
+	return downloadChunks > 1 &&
+		response.StatusCode == http.StatusOK &&
+		strings.Contains(strings.ToLower(response.Header.Get("Accept-Ranges")), "bytes") &&
+		response.ContentLength >= chunkThreshold &&
+		response.ContentLength <= chunkMaxSize && // A bogus Content-Length can't make the buffer exhaust memory
+		response.Header.Get("Content-Encoding") == "" && // Ranges of a compressed representation can't be stitched together
+		rangeValidator(response.Header) != ""
+}
+
+// Returns the validator sent in If-Range: a strong ETag, or else Last-Modified
+func rangeValidator(header http.Header) string {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag // Weak ETags aren't allowed in If-Range
+	}
+	return header.Get("Last-Modified")
+}
+
+// Reads the first chunk from the open response and fetches the others in parallel, returning the reassembled file
+// and the number of bytes received
+func fetchInChunks(client *http.Client, request *http.Request, response *http.Response, body io.Reader) ([]byte, int64, error) {
+	size := response.ContentLength
+	chunkSize := (size + int64(downloadChunks) - 1) / int64(downloadChunks)
+	data := make([]byte, size)
+	log.Printf("Downloading %s in %d chunks of %s", request.URL, downloadChunks, formatBytes(chunkSize))
+
+	read, err := io.ReadFull(body, data[:chunkSize]) // The open response already delivers the first chunk
+	response.Body.Close()                            // The rest comes from the ranged requests
+	received := int64(read)
+	if err != nil {
+		return nil, received, fmt.Errorf("chunk 1: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(request.Context()) // One failed chunk stops the rest
+	defer cancel()
+	var (
+		wait   sync.WaitGroup
+		mu     sync.Mutex // Protects received and failures
+		errs   []error
+		host   = getDomainFromURL(request.URL.String())
+		pinned = rangeValidator(response.Header)
+	)
+	for start, index := chunkSize, 2; start < size; start, index = start+chunkSize, index+1 {
+		end := min(start+chunkSize, size) - 1
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			n, err := fetchChunk(ctx, client, request, pinned, data[start:end+1], start, end, size, host)
+			mu.Lock()
+			defer mu.Unlock()
+			received += n
+			if err != nil {
+				errs = append(errs, fmt.Errorf("chunk %d: %w", index, err))
+				cancel()
+			}
+		}()
+	}
+	wait.Wait()
+	if len(errs) > 0 {
+		return nil, received, errors.Join(errs...)
+	}
+	return data, received, nil
+}
+
+// Fetches bytes start through end into dst with a ranged request pinned to the validator
+func fetchChunk(ctx context.Context, client *http.Client, original *http.Request, validator string, dst []byte, start int64, end int64, size int64, host string) (int64, error) {
+	chunkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	request := original.Clone(chunkCtx)
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	request.Header.Set("If-Range", validator) // A changed file is sent whole instead of mixing versions
+
+	requestThrottle.wait()
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	recordHostResponse(host, response.StatusCode)
+	response.Body = newIdleTimeoutReader(response.Body, bodyIdleTimeout, cancel)
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("expected 206 Partial Content, got %s; the file may have changed", response.Status)
+	}
+	if want := fmt.Sprintf("bytes %d-%d/%d", start, end, size); response.Header.Get("Content-Range") != want {
+		return 0, fmt.Errorf("server sent range %q instead of %q", response.Header.Get("Content-Range"), want)
+	}
+	n, err := io.ReadFull(response.Body, dst)
+	return int64(n), err
+}
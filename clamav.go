@@ -0,0 +1,150 @@
+package main // Virus scanning of downloads through clamd before they reach the archive, with flagged files quarantined
+
+import (
+	"bytes"           // Parses clamd replies
+	"encoding/binary" // Frames INSTREAM chunks
+	"encoding/json"   // Writes quarantine reports
+	"errors"          // Declares the infection error
+	"flag"            // Registers the scanning options
+	"fmt"             // Builds errors and file names
+	"io"              // Reads clamd replies
+	"log"             // Reports quarantined files
+	"net"             // Connects to clamd
+	"os"              // Writes quarantined files
+	"path/filepath"   // Builds quarantine paths
+	"strings"         // Parses the address and replies
+	"time"            // Bounds the scan and stamps reports
+)
+
+var (
+	clamdAddress  = ""              // clamd socket: a unix socket path or unix:///path, or tcp://host:port; empty disables scanning
+	clamdTimeout  = 2 * time.Minute // Deadline for connecting to clamd and scanning one file
+	quarantineDir = "Quarantine/"   // Local directory flagged files are moved to instead of the archive
+)
+
+const clamdChunkSize = 64 << 10 // Bytes per INSTREAM chunk; well below clamd's default StreamMaxLength
+
+var errInfected = errors.New("flagged by the virus scanner") // Returned with the signature name for infected files
+
+// Details stored next to a quarantined file
+type quarantineReport struct {
+	RunID     string    `json:"run_id"`    // Run that downloaded the file
+	URL       string    `json:"url"`       // Where the file came from
+	Signature string    `json:"signature"` // What clamd found, e.g. Eicar-Signature
+	SHA256    string    `json:"sha256"`    // Digest of the quarantined bytes
+	Size      int       `json:"size"`      // Number of bytes
+	At        time.Time `json:"at"`        // When the file was flagged
+}
+
+// Returns the network and address of the clamd socket
+func clamdEndpoint() (string, string, error) {
+	switch address := strings.TrimSpace(clamdAddress); {
+	case strings.HasPrefix(address, "unix://"):
+		return "unix", strings.TrimPrefix(address, "unix://"), nil
+	case strings.HasPrefix(address, "tcp://"):
+		return "tcp", strings.TrimPrefix(address, "tcp://"), nil
+	case strings.HasPrefix(address, "/"):
+		return "unix", address, nil
+	case strings.Contains(address, ":"):
+		return "tcp", address, nil
+	}
+	return "", "", fmt.Errorf("-clamd %q must be a unix socket path or tcp://host:port", clamdAddress)
+}
+
+// Registers the virus scanning options on a flag set
+func addClamdFlags(flags *flag.FlagSet) {
+	flags.StringVar(&clamdAddress, "clamd", clamdAddress, "scan every download with clamd before storing it, at a unix socket path (e.g. /run/clamav/clamd.ctl) or tcp://host:3310; flagged files are quarantined and files that can't be scanned aren't stored (empty disables scanning)")
+	flags.DurationVar(&clamdTimeout, "clamd-timeout", clamdTimeout, "deadline for connecting to clamd and scanning one file")
+	flags.StringVar(&quarantineDir, "quarantine-dir", quarantineDir, "local directory files flagged by clamd are moved to, each with a JSON report")
+}
+
+// Checks the -clamd option
+func validateClamd() error {
+	if clamdAddress == "" {
+		return nil
+	}
+	_, _, err := clamdEndpoint()
+	return err
+}
+
+// Streams data to clamd with INSTREAM and returns the signature it found, or "" when the data is clean
+func clamdScan(data []byte) (string, error) {
+	network, address, err := clamdEndpoint()
+	if err != nil {
+		return "", err
+	}
+	connection, err := net.DialTimeout(network, address, clamdTimeout)
+	if err != nil {
+		return "", fmt.Errorf("connecting to clamd: %w", err)
+	}
+	defer connection.Close()
+	connection.SetDeadline(time.Now().Add(clamdTimeout))
+
+	if _, err := connection.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", fmt.Errorf("sending to clamd: %w", err)
+	}
+	var size [4]byte
+	for offset := 0; offset < len(data); offset += clamdChunkSize {
+		chunk := data[offset:min(offset+clamdChunkSize, len(data))]
+		binary.BigEndian.PutUint32(size[:], uint32(len(chunk)))
+		if _, err := connection.Write(append(size[:], chunk...)); err != nil {
+			return "", fmt.Errorf("sending to clamd: %w", err)
+		}
+	}
+	if _, err := connection.Write([]byte{0, 0, 0, 0}); err != nil { // A zero-length chunk ends the stream
+		return "", fmt.Errorf("sending to clamd: %w", err)
+	}
+	reply, err := io.ReadAll(connection)
+	if err != nil {
+		return "", fmt.Errorf("reading from clamd: %w", err)
+	}
+	result := strings.TrimSpace(string(bytes.TrimRight(reply, "\x00")))
+	result = strings.TrimPrefix(result, "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	}
+	return "", fmt.Errorf("clamd: %s", result) // e.g. INSTREAM size limit exceeded. ERROR
+}
+
+// Scans a download when scanning is enabled; infected files are quarantined and reported with errInfected, and
+// files that couldn't be scanned are refused rather than archived unchecked
+func scanDownload(rawURL string, filePath string, data []byte) error {
+	if clamdAddress == "" {
+		return nil
+	}
+	signature, err := clamdScan(data)
+	if err != nil {
+		return fmt.Errorf("virus scan failed: %w", err)
+	}
+	if signature == "" {
+		return nil
+	}
+	quarantined := quarantineFile(rawURL, filePath, signature, data)
+	log.Printf("Quarantined %s: clamd found %s; saved to %s", rawURL, signature, quarantined)
+	publishEvent(archiveEvent{Type: eventDocumentQuarantined, URL: rawURL, File: quarantined, Size: int64(len(data)), SHA256: sha256Hex(data), Signature: signature})
+	return fmt.Errorf("%w: %s", errInfected, signature)
+}
+
+// Writes a flagged file and its report to the quarantine directory and returns where the file went
+func quarantineFile(rawURL string, filePath string, signature string, data []byte) string {
+	if err := os.MkdirAll(quarantineDir, 0o700); err != nil {
+		log.Printf("Failed to create quarantine directory %s: %v", quarantineDir, err)
+		return ""
+	}
+	base := filepath.Join(quarantineDir, time.Now().UTC().Format("20060102T150405Z")+"_"+filepath.Base(filePath))
+	if err := os.WriteFile(base+".quarantined", data, 0o600); err != nil { // The suffix keeps it from being opened by accident
+		log.Printf("Failed to quarantine %s: %v", rawURL, err)
+		return ""
+	}
+	report, err := json.MarshalIndent(quarantineReport{RunID: runID(), URL: rawURL, Signature: signature, SHA256: sha256Hex(data), Size: len(data), At: time.Now().UTC()}, "", "  ")
+	if err == nil {
+		err = os.WriteFile(base+".json", report, 0o600)
+	}
+	if err != nil {
+		log.Printf("Failed to write the quarantine report for %s: %v", rawURL, err)
+	}
+	return base + ".quarantined"
+}
@@ -0,0 +1,79 @@
+package main // Heuristics telling Safety Data Sheets apart from product labels, brochures and other PDFs
+
+import (
+	"path"    // Takes file names from URLs
+	"regexp"  // Matches keywords and section headings
+	"strings" // Joins the name evidence
+)
+
+// Document types recorded in the manifest
+const (
+	documentTypeSDS   = "sds"   // A Safety Data Sheet
+	documentTypeLabel = "label" // A product label
+	documentTypeOther = "other" // Any other PDF, such as a brochure or manual
+)
+
+var sdsOnly = false // Only download documents classified as Safety Data Sheets
+
+// Keywords in a file name or link text
+var (
+	sdsNameRegex   = regexp.MustCompile(`(?i)(^|[^a-z])(m?sds|hds|safety[\s_\-]*data|hoja[\s_\-]*de[\s_\-]*(datos[\s_\-]*de[\s_\-]*)?seguridad)([^a-z]|$)`)
+	labelNameRegex = regexp.MustCompile(`(?i)(^|[^a-z])(label|etiqueta|lbl)s?([^a-z]|$)`)
+	otherNameRegex = regexp.MustCompile(`(?i)(^|[^a-z])(brochure|flyer|catalog(ue)?|manual|instructions?|spec[\s_\-]*sheet|warranty|price[\s_\-]*list|guide|folleto)s?([^a-z]|$)`)
+)
+
+// Phrases printed on the first pages of an SDS
+var (
+	sdsTitleRegex   = regexp.MustCompile(`(?i)(material\s+)?safety\s+data\s+sheet|hoja\s+de\s+datos\s+de\s+seguridad|fiche\s+de\s+donn[ée]es\s+de\s+s[ée]curit[ée]`)
+	sdsSectionRegex = regexp.MustCompile(`(?i)(section|secci[oó]n)\s*1\s*[:.\-]?\s*(product\s+and\s+company\s+)?identifica`)
+	sdsHazardsRegex = regexp.MustCompile(`(?i)(section|secci[oó]n)\s*2\s*[:.\-]?\s*(hazards?|identificaci[oó]n\s+de\s+(los\s+)?peligros)`)
+	labelCueRegex   = regexp.MustCompile(`(?i)keep\s+out\s+of\s+reach\s+of\s+children|directions\s+for\s+use|epa\s+reg(istration)?\.?\s+no|epa\s+est\.?\s+no|net\s+(wt|weight|contents)|precautionary\s+statements|storage\s+and\s+disposal|mant[eé]ngase\s+fuera\s+del\s+alcance`)
+)
+
+const minLabelCues = 2 // Distinct label phrases required before text counts as a product label
+
+const minClassifyChars = 200 // Pages with less text than this are likely scans and say nothing either way
+
+// Classifies a document from its file name and link text, returning "" when neither says anything
+func classifyDocumentName(documentURL string, anchor string) string {
+	name := path.Base(documentURL) + " " + anchor
+	switch {
+	case sdsNameRegex.MatchString(name):
+		return documentTypeSDS // "SDS" wins over words like "label" that SDS names sometimes contain
+	case labelNameRegex.MatchString(name):
+		return documentTypeLabel
+	case otherNameRegex.MatchString(name):
+		return documentTypeOther
+	}
+	return ""
+}
+
+// Classifies a document from the text of its first pages, returning "" when there is too little text to judge
+func classifyDocumentText(text string) string {
+	if sdsTitleRegex.MatchString(text) || (sdsSectionRegex.MatchString(text) && sdsHazardsRegex.MatchString(text)) {
+		return documentTypeSDS
+	}
+	cues := make(map[string]bool) // Distinct label phrases, lowercased
+	for _, cue := range labelCueRegex.FindAllString(text, -1) {
+		cues[strings.ToLower(strings.Join(strings.Fields(cue), " "))] = true
+	}
+	if len(cues) >= minLabelCues { // Labels are short, so they are judged before the length check
+		return documentTypeLabel
+	}
+	if len(strings.TrimSpace(text)) < minClassifyChars {
+		return ""
+	}
+	return documentTypeOther // Plenty of text, none of it SDS headings
+}
+
+// Classifies a downloaded document: the text of its first two pages decides, the name and link text are the fallback
+func classifyDocument(document pdfDocument, kind string, data []byte) string {
+	if kind == "pdf" {
+		if pages, err := extractPDFPages(data); err == nil {
+			if class := classifyDocumentText(strings.Join(pages[:min(2, len(pages))], "\n")); class != "" {
+				return class
+			}
+		}
+	}
+	return classifyDocumentName(document.URL, document.Anchor)
+}
@@ -0,0 +1,77 @@
+package main // Optional gzip compression of the artifacts written next to documents
+
+import (
+	"bytes"         // Buffers compressed data
+	"compress/gzip" // Compresses and decompresses artifacts
+	"io"            // Reads decompressed data
+)
+
+var compressArtifacts = false // Whether metadata sidecars and the CAS index are stored gzip-compressed, with a .gz suffix
+
+const gzipSuffix = ".gz" // Appended to the name of a compressed artifact
+
+// Returns the name an artifact is written under: with .gz when artifacts are compressed
+func artifactName(name string) string {
+	if compressArtifacts {
+		return name + gzipSuffix
+	}
+	return name
+}
+
+// Returns the name of the artifact's other form, which is removed once the current one is written
+func otherArtifactName(name string) string {
+	if compressArtifacts {
+		return name
+	}
+	return name + gzipSuffix
+}
+
+// Compresses an artifact when artifacts are compressed; data is returned unchanged otherwise
+func encodeArtifact(data []byte) ([]byte, error) {
+	if !compressArtifacts {
+		return data, nil
+	}
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Decompresses gzip data and returns anything else unchanged, so readers handle both forms whatever the option says
+func decodeArtifact(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b { // gzip magic number
+		return data, nil
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// Reads an artifact from storage in whichever form it was written, preferring the current one; reports false when
+// neither exists
+func readArtifact(storage Storage, name string) ([]byte, bool, error) {
+	for _, key := range []string{artifactName(name), otherArtifactName(name)} {
+		exists, err := storage.Exists(key)
+		if err != nil {
+			return nil, false, err
+		}
+		if !exists {
+			continue
+		}
+		data, err := storage.Get(key)
+		if err != nil {
+			return nil, false, err
+		}
+		decoded, err := decodeArtifact(data)
+		return decoded, err == nil, err
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,325 @@
+package main // Daemon configuration file, reloaded between runs without restarting the server
+
+import (
+	"bytes"         // Compares file contents
+	"crypto/sha256" // Detects changed contents
+	"encoding/json" // Parses the configuration file
+	"fmt"           // Builds validation errors and the change log
+	"log"           // Reports reloads and rejected files
+	"os"            // Reads the configuration file
+	"path/filepath" // Matches change events to the file
+	"slices"        // Compares target lists
+	"strings"       // Joins the change log
+	"sync"          // Guards the pending configuration
+	"time"          // Polls the file and parses durations
+
+	"github.com/fsnotify/fsnotify" // Delivers changes to the configuration file
+)
+
+var configPollInterval = 2 * time.Second // How often the configuration file is checked when change notifications aren't available
+
+// Contents of the -config file; omitted settings keep the value the daemon was started with
+type daemonConfig struct {
+	Targets            []scrapeTarget  `json:"targets,omitempty"`              // Listing pages, as in a -targets file
+	DownloadWorkers    *int            `json:"download_workers,omitempty"`     // Documents downloaded at the same time
+	AutoConcurrency    *bool           `json:"auto_concurrency,omitempty"`     // Adapt parallel downloads up to download_workers
+	ProcessWorkers     *int            `json:"process_workers,omitempty"`      // Downloaded documents processed at the same time
+	RequestInterval    *configDuration `json:"request_interval,omitempty"`     // Minimum time between two requests, e.g. "500ms"
+	BreakerThreshold   *int            `json:"breaker_threshold,omitempty"`    // Consecutive failures that open a host's circuit; 0 disables it
+	BreakerCooldown    *configDuration `json:"breaker_cooldown,omitempty"`     // How long an open circuit rejects requests
+	MaxThrottleRetries *int            `json:"max_throttle_retries,omitempty"` // Times a request is retried after a 429 or 503
+	PreRun             *string         `json:"pre_run,omitempty"`              // Shell command run before every run; a failure aborts it
+	PostRun            *string         `json:"post_run,omitempty"`             // Shell command run after every run
+}
+
+// A duration written as a string such as "1m30s"
+type configDuration time.Duration
+
+// Parses a duration string
+func (d *configDuration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("durations are strings such as \"500ms\" or \"5m\"")
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = configDuration(parsed)
+	return nil
+}
+
+// Settings a configuration file controls, as the daemon currently runs with them
+type daemonSettings struct {
+	Targets            []scrapeTarget
+	DownloadWorkers    int
+	AutoConcurrency    bool
+	ProcessWorkers     int
+	RequestInterval    time.Duration
+	BreakerThreshold   int
+	BreakerCooldown    time.Duration
+	MaxThrottleRetries int
+	PreRun             string
+	PostRun            string
+}
+
+// Returns the settings in effect
+func currentDaemonSettings() daemonSettings {
+	return daemonSettings{
+		Targets:            scrapeTargets,
+		DownloadWorkers:    downloadWorkers,
+		AutoConcurrency:    autoConcurrency,
+		ProcessWorkers:     processWorkers,
+		RequestInterval:    requestInterval,
+		BreakerThreshold:   breakerThreshold,
+		BreakerCooldown:    breakerCooldown,
+		MaxThrottleRetries: maxThrottleRetries,
+		PreRun:             preRunHook,
+		PostRun:            postRunHook,
+	}
+}
+
+// Puts the settings into effect; called between runs only
+func (s daemonSettings) apply() error {
+	resetTargetAuth() // Credentials of removed targets go away, changed ones are replaced
+	for _, target := range s.Targets {
+		if err := registerTargetAuth(target); err != nil {
+			return err
+		}
+	}
+	scrapeTargets = s.Targets
+	downloadWorkers, processWorkers = s.DownloadWorkers, s.ProcessWorkers
+	autoConcurrency = s.AutoConcurrency
+	requestInterval = s.RequestInterval
+	breakerThreshold, breakerCooldown = s.BreakerThreshold, s.BreakerCooldown
+	maxThrottleRetries = s.MaxThrottleRetries
+	preRunHook, postRunHook = s.PreRun, s.PostRun
+	return nil
+}
+
+// Returns the baseline settings overridden by a configuration file's contents, after validating them
+func parseDaemonConfig(data []byte, baseline daemonSettings) (daemonSettings, error) {
+	var config daemonConfig
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields() // A misspelled setting shouldn't be ignored silently
+	if err := decoder.Decode(&config); err != nil {
+		return baseline, err
+	}
+	settings := baseline
+	if config.Targets != nil {
+		targets, err := compileTargets(config.Targets)
+		if err != nil {
+			return baseline, err
+		}
+		if len(targets) == 0 {
+			return baseline, fmt.Errorf("targets lists no pages")
+		}
+		settings.Targets = targets
+	}
+	if config.DownloadWorkers != nil {
+		settings.DownloadWorkers = *config.DownloadWorkers
+	}
+	if config.AutoConcurrency != nil {
+		settings.AutoConcurrency = *config.AutoConcurrency
+	}
+	if config.ProcessWorkers != nil {
+		settings.ProcessWorkers = *config.ProcessWorkers
+	}
+	if config.RequestInterval != nil {
+		settings.RequestInterval = time.Duration(*config.RequestInterval)
+	}
+	if config.BreakerThreshold != nil {
+		settings.BreakerThreshold = *config.BreakerThreshold
+	}
+	if config.BreakerCooldown != nil {
+		settings.BreakerCooldown = time.Duration(*config.BreakerCooldown)
+	}
+	if config.MaxThrottleRetries != nil {
+		settings.MaxThrottleRetries = *config.MaxThrottleRetries
+	}
+	if config.PreRun != nil {
+		settings.PreRun = *config.PreRun
+	}
+	if config.PostRun != nil {
+		settings.PostRun = *config.PostRun
+	}
+	switch {
+	case settings.DownloadWorkers < 1 || settings.ProcessWorkers < 1:
+		return baseline, fmt.Errorf("download_workers and process_workers must be at least 1")
+	case settings.RequestInterval < 0 || settings.BreakerCooldown < 0:
+		return baseline, fmt.Errorf("request_interval and breaker_cooldown can't be negative")
+	case settings.BreakerThreshold < 0 || settings.MaxThrottleRetries < 0:
+		return baseline, fmt.Errorf("breaker_threshold and max_throttle_retries can't be negative")
+	}
+	return settings, nil
+}
+
+// Describes what differs between two sets of settings, one line per change
+func describeSettingsChanges(before daemonSettings, after daemonSettings) []string {
+	var changes []string
+	changed := func(name string, from any, to any) {
+		if from != to {
+			changes = append(changes, fmt.Sprintf("%s: %v → %v", name, from, to))
+		}
+	}
+	describe := func(target scrapeTarget) string {
+		data, _ := json.Marshal(target) // Every exported field, so a changed selector counts as a change
+		return string(data)
+	}
+	var beforeTargets, afterTargets []string
+	for _, target := range before.Targets {
+		beforeTargets = append(beforeTargets, describe(target))
+	}
+	for _, target := range after.Targets {
+		afterTargets = append(afterTargets, describe(target))
+	}
+	for _, target := range beforeTargets {
+		if !slices.Contains(afterTargets, target) {
+			changes = append(changes, "target removed: "+target)
+		}
+	}
+	for _, target := range afterTargets {
+		if !slices.Contains(beforeTargets, target) {
+			changes = append(changes, "target added: "+target)
+		}
+	}
+	changed("download_workers", before.DownloadWorkers, after.DownloadWorkers)
+	changed("auto_concurrency", before.AutoConcurrency, after.AutoConcurrency)
+	changed("process_workers", before.ProcessWorkers, after.ProcessWorkers)
+	changed("request_interval", before.RequestInterval, after.RequestInterval)
+	changed("breaker_threshold", before.BreakerThreshold, after.BreakerThreshold)
+	changed("breaker_cooldown", before.BreakerCooldown, after.BreakerCooldown)
+	changed("max_throttle_retries", before.MaxThrottleRetries, after.MaxThrottleRetries)
+	changed("pre_run", before.PreRun, after.PreRun)
+	changed("post_run", before.PostRun, after.PostRun)
+	return changes
+}
+
+// Watches the daemon's configuration file and holds the latest valid contents until the next run applies them
+type configWatcher struct {
+	path     string          // Configuration file
+	baseline daemonSettings  // Settings from the command line, which the file overrides
+	mu       sync.Mutex      // Protects the fields below
+	digest   [32]byte        // SHA-256 of the contents last read
+	pending  *daemonSettings // Valid settings read since the last run, nil when there are none
+}
+
+var daemonConfigWatcher *configWatcher // Set when serve is started with -config
+
+// Reads the configuration file, applies it and starts watching it for changes
+func watchDaemonConfig(path string) (*configWatcher, error) {
+	watcher := &configWatcher{path: path, baseline: currentDaemonSettings()}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := parseDaemonConfig(data, watcher.baseline)
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	if err := settings.apply(); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	watcher.digest = sha256.Sum256(data)
+	log.Printf("Loaded configuration from %s", path)
+	notifier, err := fsnotify.NewWatcher()
+	if err == nil {
+		// The directory is watched, not the file: editors and config management replace the file by renaming a new
+		// one over it, which ends a watch on the file itself
+		if err = notifier.Add(filepath.Dir(path)); err != nil {
+			notifier.Close()
+		}
+	}
+	if err != nil {
+		log.Printf("Can't watch %s (%v); checking it every %s instead", path, err, configPollInterval)
+		go watcher.poll()
+		return watcher, nil
+	}
+	go watcher.watch(notifier)
+	return watcher, nil
+}
+
+// Rereads the file on every change notification until the process exits, falling back to polling if the
+// notifications stop
+func (w *configWatcher) watch(notifier *fsnotify.Watcher) {
+	defer notifier.Close()
+	name := filepath.Clean(w.path)
+	for {
+		select {
+		case event, ok := <-notifier.Events:
+			if !ok {
+				go w.poll()
+				return
+			}
+			if filepath.Clean(event.Name) == name && event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+				w.reload()
+			}
+		case err, ok := <-notifier.Errors:
+			if !ok {
+				go w.poll()
+				return
+			}
+			log.Printf("Watching config %s: %v; checking it every %s instead", w.path, err, configPollInterval)
+			go w.poll() // Notifications may have been dropped, e.g. on queue overflow
+			return
+		}
+	}
+}
+
+// Checks the file for changes until the process exits; used where change notifications aren't delivered, such
+// as on network shares
+func (w *configWatcher) poll() {
+	for range time.Tick(configPollInterval) {
+		w.reload()
+	}
+}
+
+// Reads the file and, when its contents changed, holds the new settings for the next run
+func (w *configWatcher) reload() {
+	data, err := os.ReadFile(w.path)
+	if err != nil || len(data) == 0 {
+		return // Being replaced, truncated before a rewrite, or removed; the current settings stay
+	}
+	digest := sha256.Sum256(data) // Contents, not the modification time, so edits within the same second count
+	w.mu.Lock()
+	unchanged := digest == w.digest
+	w.digest = digest
+	w.mu.Unlock()
+	if unchanged {
+		return
+	}
+	settings, err := parseDaemonConfig(data, w.baseline)
+	if err != nil {
+		log.Printf("Ignoring the changed config %s: %v", w.path, err)
+		return
+	}
+	w.mu.Lock()
+	w.pending = &settings
+	w.mu.Unlock()
+	log.Printf("Config %s changed; the next run uses it", w.path)
+}
+
+// Applies the settings read since the last run, if any, logging what changed
+func (w *configWatcher) applyPending() {
+	w.mu.Lock()
+	pending := w.pending
+	w.pending = nil
+	w.mu.Unlock()
+	if pending == nil {
+		return
+	}
+	before := currentDaemonSettings()
+	if err := pending.apply(); err != nil {
+		log.Printf("Not applying config %s: %v", w.path, err)
+		if err := before.apply(); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	changes := describeSettingsChanges(before, *pending)
+	if len(changes) == 0 {
+		log.Printf("Reloaded config %s; nothing changed", w.path)
+		return
+	}
+	log.Printf("Applied config %s:\n  %s", w.path, strings.Join(changes, "\n  "))
+}
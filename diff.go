@@ -0,0 +1,158 @@
+package main // Diff subcommand comparing two archive snapshots
+
+import (
+	"flag"          // Parses the diff options
+	"fmt"           // Prints the comparison
+	"io/fs"         // Walks snapshot directories
+	"os"            // Reads snapshot files
+	"path/filepath" // Builds relative paths
+	"sort"          // Orders the report
+	"strings"       // Describes changes
+)
+
+// One document in a snapshot, keyed by URL for manifests or by relative path for directories
+type snapshotDocument struct {
+	Key          string // URL (plus language) or path relative to the snapshot directory
+	SHA256       string // Digest of the contents
+	Size         int64  // Size in bytes
+	RevisionDate string // SDS revision date, when known from a manifest
+}
+
+// A document present in both snapshots with different contents
+type changedDocument struct {
+	Old snapshotDocument // Document in the older snapshot
+	New snapshotDocument // Document in the newer snapshot
+}
+
+// Loads a snapshot from a manifest file, a directory holding a manifest, or a directory of documents
+func loadSnapshot(snapshotPath string) (map[string]snapshotDocument, error) {
+	info, err := os.Stat(snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return manifestSnapshot(snapshotPath)
+	}
+	if manifestPath := filepath.Join(snapshotPath, manifestFilePath); fileExists(manifestPath) {
+		return manifestSnapshot(manifestPath) // A copy of a whole working directory
+	}
+	return directorySnapshot(snapshotPath)
+}
+
+// Reads the documents recorded in a manifest file
+func manifestSnapshot(manifestPath string) (map[string]snapshotDocument, error) {
+	if _, err := os.Stat(manifestPath); err != nil {
+		return nil, err // loadManifest would silently return an empty manifest
+	}
+	documents := make(map[string]snapshotDocument)
+	for _, entry := range loadManifest(manifestPath).list() {
+		documents[entry.key()] = snapshotDocument{Key: entry.key(), SHA256: entry.SHA256, Size: entry.Size, RevisionDate: entry.SDS.RevisionDate}
+	}
+	return documents, nil
+}
+
+// Hashes every file below an output directory
+func directorySnapshot(dir string) (map[string]snapshotDocument, error) {
+	documents := make(map[string]snapshotDocument)
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		if isSidecarKey(filePath) {
+			return nil // Sidecars change whenever their document does
+		}
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relative)
+		documents[key] = snapshotDocument{Key: key, SHA256: sha256Hex(data), Size: int64(len(data))}
+		return nil
+	})
+	return documents, err
+}
+
+// Splits two snapshots into added, removed and changed documents, each sorted by key
+func diffSnapshots(old map[string]snapshotDocument, new map[string]snapshotDocument) (added []snapshotDocument, removed []snapshotDocument, changed []changedDocument) {
+	for key, document := range new {
+		previous, found := old[key]
+		switch {
+		case !found:
+			added = append(added, document)
+		case previous.SHA256 != document.SHA256:
+			changed = append(changed, changedDocument{Old: previous, New: document})
+		}
+	}
+	for key, document := range old {
+		if _, found := new[key]; !found {
+			removed = append(removed, document)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i].Key < added[j].Key })
+	sort.Slice(removed, func(i, j int) bool { return removed[i].Key < removed[j].Key })
+	sort.Slice(changed, func(i, j int) bool { return changed[i].New.Key < changed[j].New.Key })
+	return added, removed, changed
+}
+
+// Describes what changed between two copies of a document
+func describeChange(change changedDocument) string {
+	details := []string{"contents changed"}
+	if change.Old.Size != change.New.Size {
+		details[0] = fmt.Sprintf("%s → %s", formatBytes(change.Old.Size), formatBytes(change.New.Size))
+	}
+	if change.Old.RevisionDate != change.New.RevisionDate && change.New.RevisionDate != "" {
+		old := change.Old.RevisionDate
+		if old == "" {
+			old = "unknown"
+		}
+		details = append(details, fmt.Sprintf("revised %s → %s", old, change.New.RevisionDate))
+	}
+	return strings.Join(details, ", ")
+}
+
+// Runs the diff subcommand with its own command-line options
+func runDiff(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError) // Options specific to diff
+	failOnDifference := flags.Bool("fail-on-difference", false, "exit with an error when the snapshots differ")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: diff [options] <old manifest or directory> <new manifest or directory>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return fmt.Errorf("diff needs exactly two snapshots")
+	}
+	old, err := loadSnapshot(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	new, err := loadSnapshot(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	added, removed, changed := diffSnapshots(old, new)
+	fmt.Printf("Added: %d\n", len(added))
+	for _, document := range added {
+		fmt.Printf("  + %s\n", document.Key)
+	}
+	fmt.Printf("Removed: %d\n", len(removed))
+	for _, document := range removed {
+		fmt.Printf("  - %s\n", document.Key)
+	}
+	fmt.Printf("Changed: %d\n", len(changed))
+	for _, change := range changed {
+		fmt.Printf("  ~ %s (%s)\n", change.New.Key, describeChange(change))
+	}
+	if *failOnDifference && len(added)+len(removed)+len(changed) > 0 {
+		return fmt.Errorf("snapshots differ: %d added, %d removed, %d changed", len(added), len(removed), len(changed))
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main // Allowlist of hosts documents may be downloaded from, so links to third-party sites are reported instead of fetched
+
+import (
+	"fmt"     // Describes invalid entries and refused hosts
+	"net/url" // Reads the host of a document URL
+	"strings" // Parses the allowlist
+)
+
+var downloadAllowedHosts = "" // Comma-separated hosts documents may come from besides the targets' own; "*." prefixes allow subdomains; empty disables the allowlist
+
+// Validates the -download-hosts entries, which are bare host names
+func validateDownloadHosts() error {
+	for _, allowed := range strings.Split(downloadAllowedHosts, ",") {
+		allowed = strings.TrimPrefix(strings.TrimSpace(allowed), "*.")
+		if strings.ContainsAny(allowed, "/:@ ") {
+			return fmt.Errorf("invalid -download-hosts entry %q (expected a host name such as cdn.example.com or *.example.com)", allowed)
+		}
+	}
+	return nil
+}
+
+// Reports whether documents may be downloaded from a host: always when the allowlist is disabled, otherwise when it
+// is a target's host or listed, e.g. a vendor's CDN
+func downloadHostAllowed(host string) bool {
+	if strings.TrimSpace(downloadAllowedHosts) == "" {
+		return true
+	}
+	host = normalizeDomain(host)
+	for _, target := range scrapeTargets {
+		if normalizeDomain(getDomainFromURL(target.URL)) == host {
+			return true
+		}
+	}
+	for _, allowed := range strings.Split(downloadAllowedHosts, ",") {
+		if hostMatches(host, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns why a document URL may not be downloaded, or "" when its host is allowed
+func disallowedDownloadHost(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	if downloadHostAllowed(parsed.Hostname()) {
+		return ""
+	}
+	return fmt.Sprintf("host %s isn't in -download-hosts", parsed.Hostname())
+}
@@ -0,0 +1,59 @@
+package main // Diagnostics for runs whose listing pages yield no document links at all
+
+import (
+	"fmt"           // Builds hints
+	"log"           // Reports the diagnosis
+	"os"            // Writes the captured pages
+	"path/filepath" // Builds debug file paths
+	"strings"       // Builds debug file names
+)
+
+var discoveryDebugDir = "discovery-debug" // Where listing pages are saved when they yield no document links
+
+const exitNoDocuments = 3 // Exit status of runs that found no documents, distinct from the status of other failures
+
+// A listing page kept while no document link has been found, for the diagnosis
+type listingPage struct {
+	URL    string       // Page address
+	HTML   string       // Body as fetched, empty when the request failed
+	Target scrapeTarget // Target the page belongs to
+}
+
+// Saves every fetched listing page under the debug directory and logs why no links were found, with a hint on what
+// to check; returns the directory the pages were saved to
+func reportEmptyDiscovery(pages []listingPage) string {
+	dir := filepath.Join(discoveryDebugDir, runID())
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Printf("Failed to create %s: %v", dir, err)
+		dir = ""
+	}
+	for i, page := range pages {
+		if dir != "" {
+			name := fmt.Sprintf("%02d_%s.html", i+1, strings.Trim(slugRegex.ReplaceAllString(strings.ToLower(page.URL), "_"), "_"))
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(page.HTML), 0o644); err != nil {
+				log.Printf("Failed to save %s: %v", page.URL, err)
+			}
+		}
+		log.Printf("No document links on %s: %s", page.URL, emptyPageHint(page))
+	}
+	if dir != "" {
+		log.Printf("Saved the %d listing pages to %s for inspection", len(pages), dir)
+	}
+	return dir
+}
+
+// Explains the likely reason a listing page yielded no links
+func emptyPageHint(page listingPage) string {
+	switch {
+	case strings.TrimSpace(page.HTML) == "":
+		return "the request failed or returned an empty body; the site may be blocking the scraper (see the log above and -audit-log)"
+	case page.Target.container != nil:
+		if _, containers, err := selectContainers(page.HTML, page.Target.container); err == nil && containers == 0 {
+			return fmt.Sprintf("nothing matches %s; the page layout may have changed, so update the target's \"selector\" or \"xpath\" in -targets", page.Target.containerKey())
+		}
+		return fmt.Sprintf("the elements matching %s hold no links ending in .pdf; check the target's \"selector\" or \"xpath\" in -targets", page.Target.containerKey())
+	case !strings.Contains(strings.ToLower(page.HTML), "<a"):
+		return "the page has no links at all; it may be rendered by JavaScript or be a bot challenge page"
+	}
+	return "no links end in .pdf; if the site was redesigned, point a \"selector\" or \"xpath\" in -targets at the element listing the documents"
+}
@@ -0,0 +1,210 @@
+package main // Archive events published to a message queue for downstream services
+
+import (
+	"bufio"         // Reads NATS protocol lines
+	"bytes"         // Wraps Kafka REST request bodies
+	"encoding/json" // Encodes events
+	"fmt"           // Builds protocol messages and errors
+	"io"            // Drains Kafka REST responses
+	"log"           // Reports publish failures
+	"net"           // Connects to NATS
+	"net/http"      // Talks to the Kafka REST proxy
+	"net/url"       // Parses the queue URL
+	"strings"       // Parses protocol replies
+	"sync"          // Serializes publishes
+	"time"          // Stamps events and bounds network calls
+)
+
+// Event types published while the archive changes
+const (
+	eventDocumentDiscovered  = "document_discovered"  // A document not in the manifest was found on the site
+	eventDocumentDownloaded  = "document_downloaded"  // A document was stored
+	eventDocumentChanged     = "document_changed"     // A stored document's contents differ from the previous download
+	eventDocumentQuarantined = "document_quarantined" // A download was flagged by the virus scanner and quarantined
+	eventRunCompleted        = "run_completed"        // A run finished
+)
+
+var (
+	eventsURL   = ""                   // nats://host:4222 or kafka+http(s)://rest-proxy:8082; empty disables events
+	eventsTopic = "poolseason.archive" // NATS subject or Kafka topic events are published to
+	eventSink   eventPublisher         // Publisher opened by the daemon, nil when events are disabled
+)
+
+// One archive event as published to the queue
+type archiveEvent struct {
+	Type           string    `json:"type"`                      // One of the event* constants
+	RunID          string    `json:"run_id"`                    // Run that produced the event
+	At             time.Time `json:"at"`                        // When it happened
+	URL            string    `json:"url,omitempty"`             // Document URL
+	Language       string    `json:"language,omitempty"`        // Language variant of the document
+	Category       string    `json:"category,omitempty"`        // Listing page heading of the document
+	File           string    `json:"file,omitempty"`            // Storage key of the document
+	Size           int64     `json:"size,omitempty"`            // Document size in bytes
+	SHA256         string    `json:"sha256,omitempty"`          // Digest of the stored document
+	PreviousSHA256 string    `json:"previous_sha256,omitempty"` // Digest of the copy it replaces, for document_changed
+	Signature      string    `json:"signature,omitempty"`       // What the virus scanner found, for document_quarantined
+	Discovered     int       `json:"discovered,omitempty"`      // Documents found, for run_completed
+	Downloaded     int       `json:"downloaded,omitempty"`      // Documents stored, for run_completed
+	Failed         int       `json:"failed,omitempty"`          // Documents that could not be archived, for run_completed
+}
+
+// Sends encoded events to a message queue
+type eventPublisher interface {
+	publish(topic string, payload []byte) error // Delivers one message
+}
+
+// Opens the publisher for a queue URL
+func newEventPublisher(rawURL string) (eventPublisher, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch parsed.Scheme {
+	case "nats":
+		return &natsPublisher{address: parsed.Host, user: parsed.User}, nil
+	case "kafka+http", "kafka+https":
+		parsed.Scheme = strings.TrimPrefix(parsed.Scheme, "kafka+")
+		return &kafkaRESTPublisher{endpoint: parsed, client: &http.Client{Timeout: 30 * time.Second, Transport: newAuditTransport(nil)}}, nil
+	}
+	return nil, fmt.Errorf("unknown events URL %q (expected nats://host:port or kafka+http://rest-proxy:port)", rawURL)
+}
+
+// Publishes an event when the daemon has a queue configured; failures are logged and never stop the run
+func publishEvent(event archiveEvent) {
+	if eventSink == nil {
+		return
+	}
+	event.RunID, event.At = runID(), time.Now().UTC()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := eventSink.publish(eventsTopic, payload); err != nil {
+		log.Printf("Failed to publish %s event: %v", event.Type, err)
+	}
+}
+
+// Publishes with the NATS text protocol over one connection, reconnecting after failures
+type natsPublisher struct {
+	address string        // host:port of the NATS server
+	user    *url.Userinfo // user:password or token from the URL, if any
+	mu      sync.Mutex    // Serializes use of the connection
+	conn    net.Conn      // Open connection, nil until the first publish or after a failure
+	reader  *bufio.Reader // Reads server replies
+}
+
+// Connects and performs the CONNECT handshake
+func (p *natsPublisher) connect() error {
+	conn, err := net.DialTimeout("tcp", p.address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := reader.ReadString('\n'); err != nil { // The server greets with INFO
+		conn.Close()
+		return err
+	}
+	options := map[string]any{"verbose": false, "pedantic": false, "name": "poolseason-scraper", "lang": "go", "version": "1.0.0"}
+	if p.user != nil {
+		if password, set := p.user.Password(); set {
+			options["user"], options["pass"] = p.user.Username(), password
+		} else {
+			options["auth_token"] = p.user.Username()
+		}
+	}
+	connect, err := json.Marshal(options)
+	if err == nil {
+		_, err = fmt.Fprintf(conn, "CONNECT %s\r\n", connect)
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	p.conn, p.reader = conn, reader
+	return nil
+}
+
+// Publishes one message and waits for the PONG confirming the server accepted it
+func (p *natsPublisher) publish(subject string, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+	err := p.send(subject, payload)
+	if err != nil {
+		p.conn.Close()
+		p.conn = nil // Reconnect on the next event
+	}
+	return err
+}
+
+// Writes PUB followed by PING and reads replies until the PONG arrives
+func (p *natsPublisher) send(subject string, payload []byte) error {
+	p.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := fmt.Fprintf(p.conn, "PUB %s %d\r\n%s\r\nPING\r\n", subject, len(payload), payload); err != nil {
+		return err
+	}
+	for {
+		line, err := p.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case line == "PING": // Keepalive from the server
+			if _, err := p.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// Publishes to Kafka through a Confluent-compatible REST proxy
+type kafkaRESTPublisher struct {
+	endpoint *url.URL     // Base URL of the REST proxy
+	client   *http.Client // Client used for every request
+}
+
+// Produces one JSON record to the topic
+func (p *kafkaRESTPublisher) publish(topic string, payload []byte) error {
+	body, err := json.Marshal(map[string]any{"records": []map[string]json.RawMessage{{"value": payload}}})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(p.endpoint.String(), "/")+"/topics/"+url.PathEscape(topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	request.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	response, err := p.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	reply, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("kafka rest proxy: %s: %s", response.Status, strings.TrimSpace(string(reply)))
+	}
+	var result struct {
+		Offsets []struct {
+			Error string `json:"error"`
+		} `json:"offsets"`
+	}
+	if json.Unmarshal(reply, &result) == nil {
+		for _, offset := range result.Offsets { // Records can fail individually inside a 200 response
+			if offset.Error != "" {
+				return fmt.Errorf("kafka rest proxy: %s", offset.Error)
+			}
+		}
+	}
+	return nil
+}
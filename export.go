@@ -0,0 +1,147 @@
+package main // Export subcommand that bundles the archive into a single ZIP
+
+import (
+	"archive/zip"   // Writes the distributable ZIP archive
+	"bytes"         // Buffers the generated HTML index
+	"flag"          // Parses the export subcommand options
+	"fmt"           // Builds error messages and archive names
+	"html/template" // Renders the HTML index of exported documents
+	"io"            // Copies PDF contents into the archive
+	"log"           // Reports export progress and problems
+	"os"            // Opens PDFs and creates the output file
+	"path/filepath" // Builds archive and output paths
+	"strings"       // Matches categories case-insensitively
+	"time"          // Parses the date filter and stamps the archive name
+)
+
+// Template for the index.html bundled with every export
+var exportIndexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{"pictogramName": pictogramName}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>PoolSeason Safety Data Sheets</title>
+<style>body{font-family:sans-serif;margin:2em}table{border-collapse:collapse}th,td{border:1px solid #ccc;padding:4px 8px;text-align:left}.ghs{display:inline-block;margin:1px;padding:1px 4px;border:2px solid #c00;border-radius:3px;font-size:smaller;white-space:nowrap}</style>
+</head>
+<body>
+<h1>PoolSeason Safety Data Sheets</h1>
+<p>Exported {{.ExportedAt.Format "2006-01-02 15:04 MST"}} — {{len .Entries}} documents.</p>
+<table>
+<tr><th>Document</th><th>Category</th><th>Language</th><th>Hazards</th><th>Size</th><th>Downloaded</th><th>Source</th></tr>
+{{range .Entries}}<tr><td><a href="{{.File}}">{{.File}}</a></td><td>{{.Category}}</td><td>{{.Language}}</td><td>{{range .SDS.Pictograms}}<span class="ghs">{{pictogramName .}}</span>{{end}}</td><td>{{.Size}}</td><td>{{.DownloadedAt.Format "2006-01-02"}}</td><td><a href="{{.URL}}">{{.URL}}</a></td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// Runs the export subcommand with its own command-line options
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)                                          // Options specific to export
+	outputDir := flags.String("output", "exports", "directory the timestamped ZIP is written to") // Destination directory
+	category := flags.String("category", "", "only export documents in this category (case-insensitive)")
+	since := flags.String("since", "", "only export documents downloaded on or after this date (YYYY-MM-DD)")
+	manifestPath := flags.String("manifest", manifestFilePath, "manifest describing the archive")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var sinceTime time.Time // Zero time disables the date filter
+	if *since != "" {
+		parsed, err := time.Parse("2006-01-02", *since) // Dates are given as calendar days
+		if err != nil {
+			return fmt.Errorf("invalid -since date %q: %w", *since, err)
+		}
+		sinceTime = parsed
+	}
+
+	var selected []manifestEntry                               // Entries that pass every filter
+	for _, entry := range loadManifest(*manifestPath).list() { // Walk the archive in manifest order
+		if *category != "" && !strings.EqualFold(entry.Category, *category) {
+			continue // Wrong category
+		}
+		if entry.DownloadedAt.Before(sinceTime) {
+			continue // Downloaded before the cut-off
+		}
+		if !fileExists(entry.File) {
+			log.Printf("Skipping %s: file %s is missing", entry.URL, entry.File)
+			continue // Nothing to bundle
+		}
+		selected = append(selected, withSDSMetadata(entry)) // Older entries get their hazard pictograms read now
+	}
+	if len(selected) == 0 {
+		return fmt.Errorf("no documents match the export filters")
+	}
+
+	if !directoryExists(*outputDir) { // Create the destination on first use
+		createDirectory(*outputDir, 0o755)
+	}
+	exportedAt := time.Now().UTC()                                                                           // Timestamp shared by the name and the index
+	archivePath := filepath.Join(*outputDir, "poolseason-sds-"+exportedAt.Format("20060102T150405Z")+".zip") // Timestamped archive name
+	if err := writeExportArchive(archivePath, selected, exportedAt); err != nil {
+		return err
+	}
+	log.Printf("Exported %d documents to %s", len(selected), archivePath)
+	return nil
+}
+
+// Writes the selected documents, their manifest and an HTML index into a ZIP archive
+func writeExportArchive(archivePath string, entries []manifestEntry, exportedAt time.Time) error {
+	out, err := os.Create(archivePath) // Create the archive file
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", archivePath, err)
+	}
+	defer out.Close() // Close the file even if writing fails
+
+	archive := zip.NewWriter(out) // Stream entries into the archive
+	for i, entry := range entries {
+		entries[i].File = filepath.ToSlash(entry.File) // Paths inside the archive use forward slashes
+		if err := addFileToArchive(archive, entry.File, entries[i].File); err != nil {
+			return err
+		}
+	}
+
+	manifestData, err := encodeManifest(entries) // Filtered manifest pointing at the bundled paths
+	if err != nil {
+		return err
+	}
+	if err := addBytesToArchive(archive, "manifest.json", manifestData); err != nil {
+		return err
+	}
+
+	var index bytes.Buffer // Rendered HTML index
+	if err := exportIndexTemplate.Execute(&index, struct {
+		ExportedAt time.Time
+		Entries    []manifestEntry
+	}{exportedAt, entries}); err != nil {
+		return err
+	}
+	if err := addBytesToArchive(archive, "index.html", index.Bytes()); err != nil {
+		return err
+	}
+	return archive.Close() // Flush the central directory
+}
+
+// Copies a file from disk into the archive under the given name
+func addFileToArchive(archive *zip.Writer, sourcePath string, name string) error {
+	source, err := os.Open(sourcePath) // Open the file to bundle
+	if err != nil {
+		return err
+	}
+	defer source.Close() // Close once copied
+
+	writer, err := archive.Create(name) // Start a new archive entry
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, source) // Copy the file contents
+	return err
+}
+
+// Writes an in-memory file into the archive under the given name
+func addBytesToArchive(archive *zip.Writer, name string, data []byte) error {
+	writer, err := archive.Create(name) // Start a new archive entry
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data) // Write the contents
+	return err
+}
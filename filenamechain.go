@@ -0,0 +1,126 @@
+package main // File names built by a configurable chain of transformers instead of the built-in URL naming
+
+import (
+	"fmt"     // Builds validation errors
+	"net/url" // Splits the document URL
+	"path"    // Takes the last path segment
+	"strings" // Parses the chain and edits names
+	"time"    // Dates names for prefix-date and {date}
+)
+
+// Rewrites a file name; source is the document URL the name came from
+type filenameTransformer func(name string, source *url.URL) string
+
+// One step of the chain and what the user wrote for it, for error messages
+type filenameStep struct {
+	spec      string              // e.g. slugify or template={host}_{name}
+	transform filenameTransformer // Applies the step
+}
+
+var filenameChain []filenameStep // Transformers applied in order to the URL's last path segment; empty uses the built-in naming
+
+// Transformers the chain can name; template takes its pattern after "="
+var filenameTransformers = map[string]func(argument string) (filenameTransformer, error){
+	"lowercase":   func(string) (filenameTransformer, error) { return lowercaseFilename, nil },
+	"strip-query": func(string) (filenameTransformer, error) { return stripQueryFilename, nil },
+	"slugify":     func(string) (filenameTransformer, error) { return slugifyFilename, nil },
+	"prefix-date": func(string) (filenameTransformer, error) { return prefixDateFilename, nil },
+	"template":    templateFilename,
+}
+
+// Parses the -filename-chain option, a comma-separated list of transformers applied in order
+func setFilenameChain(value string) error {
+	var chain []filenameStep
+	for _, spec := range strings.Split(value, ",") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		name, argument, _ := strings.Cut(spec, "=")
+		build, known := filenameTransformers[strings.ToLower(name)]
+		if !known {
+			return fmt.Errorf("-filename-chain: unknown transformer %q (expected lowercase, strip-query, slugify, prefix-date or template=<pattern>)", name)
+		}
+		transform, err := build(argument)
+		if err != nil {
+			return fmt.Errorf("-filename-chain: %s: %w", spec, err)
+		}
+		chain = append(chain, filenameStep{spec: spec, transform: transform})
+	}
+	filenameChain = chain
+	return nil
+}
+
+// Names a document by running the chain over the decoded last segment of its URL path, query string included;
+// falls back to the built-in naming when the chain leaves no usable name
+func chainedFilename(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return builtinURLFilename(rawURL)
+	}
+	name := path.Base(latin1ToUTF8(parsed.Path))
+	if parsed.RawQuery != "" {
+		name += "?" + parsed.RawQuery
+	}
+	if parsed.Fragment != "" {
+		name += "#" + parsed.Fragment
+	}
+	for _, step := range filenameChain {
+		name = step.transform(name, parsed)
+	}
+	name = strings.NewReplacer("/", "_", `\`, "_").Replace(strings.TrimSpace(name)) // A name never leaves its directory
+	if name == "" || name == "." || name == ".." {
+		return builtinURLFilename(rawURL)
+	}
+	return name
+}
+
+// Lowercases the name
+func lowercaseFilename(name string, _ *url.URL) string {
+	return strings.ToLower(name)
+}
+
+// Drops the query string and fragment, which would otherwise end up in the extension
+func stripQueryFilename(name string, _ *url.URL) string {
+	name, _, _ = strings.Cut(name, "#")
+	name, _, _ = strings.Cut(name, "?")
+	return name
+}
+
+// Turns the part before the extension into lowercase words joined by underscores, as the built-in naming does
+func slugifyFilename(name string, _ *url.URL) string {
+	ext := getFileExtension(name)
+	if strings.ContainsAny(ext, "?#") || strings.Trim(sanitizeFilename(ext), "_") == "" {
+		ext = "" // Not an extension, e.g. the dot of a query value
+	}
+	stem := strings.Trim(sanitizeFilename(strings.TrimSuffix(name, ext)), "_")
+	if ext != "" {
+		ext = "." + strings.Trim(sanitizeFilename(ext), "_")
+	}
+	return stem + ext
+}
+
+// Puts today's date in front of the name, e.g. 2024-06-10_name.pdf
+func prefixDateFilename(name string, _ *url.URL) string {
+	return time.Now().UTC().Format(time.DateOnly) + "_" + name
+}
+
+// Builds a transformer that fills a pattern's placeholders: {name} (the name so far), {stem} and {ext} (its parts,
+// ext with its dot), {host} (the URL's host) and {date} (today as YYYY-MM-DD)
+func templateFilename(pattern string) (filenameTransformer, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("template needs a pattern, e.g. template={host}_{name}")
+	}
+	if !strings.Contains(pattern, "{name}") && !strings.Contains(pattern, "{stem}") {
+		return nil, fmt.Errorf("the pattern must contain {name} or {stem}, or every document would get the same name")
+	}
+	return func(name string, source *url.URL) string {
+		ext := getFileExtension(name)
+		return strings.NewReplacer(
+			"{name}", name,
+			"{stem}", strings.TrimSuffix(name, ext),
+			"{ext}", ext,
+			"{host}", source.Hostname(),
+			"{date}", time.Now().UTC().Format(time.DateOnly),
+		).Replace(pattern)
+	}, nil
+}
@@ -0,0 +1,287 @@
+package main // Persistent crawl frontier, so an interrupted discovery resumes instead of starting over
+
+import (
+	"database/sql"  // Queries the frontier database
+	"encoding/json" // Stores resolution chains
+	"errors"        // Recognizes pages the frontier doesn't know
+	"flag"          // Parses the frontier subcommand options
+	"fmt"           // Prints the frontier
+	"log"           // Reports resumed and discarded frontiers
+	"os"            // Removes the frontier database
+	"time"          // Timestamps pages and ages out stale frontiers
+
+	_ "github.com/mattn/go-sqlite3" // SQLite driver for the frontier database
+)
+
+var (
+	frontierPath   = ""             // SQLite database of the listing crawl's pending and visited pages; empty disables it
+	frontierMaxAge = 24 * time.Hour // Older frontiers are discarded rather than resumed, since the site has moved on
+)
+
+// States of a page in the frontier
+const (
+	frontierPending = "pending" // The page was queued
+	frontierVisited = "visited" // The page was scraped, with what it yielded
+)
+
+const frontierTimeLayout = "2006-01-02T15:04:05.000Z" // Fixed-width UTC timestamps, so they sort as text
+
+// Tables of the frontier database; pages keep the order they were first queued in as their rowid
+const frontierSchema = `
+CREATE TABLE IF NOT EXISTS pages (
+	url        TEXT PRIMARY KEY,         -- Listing page
+	target     TEXT NOT NULL,            -- Target the page belongs to
+	state      TEXT NOT NULL,            -- pending or visited
+	depth      INTEGER NOT NULL,         -- Next links followed from the target's start pages to reach it
+	source     TEXT NOT NULL DEFAULT '', -- Page whose next link queued it; empty for start pages
+	queued_at  TEXT NOT NULL,            -- When the page was first queued
+	visited_at TEXT,                     -- When the page was scraped; NULL while pending
+	next       TEXT NOT NULL DEFAULT ''  -- Next link found on the visited page
+);
+CREATE TABLE IF NOT EXISTS documents (
+	page       TEXT NOT NULL REFERENCES pages (url) ON DELETE CASCADE, -- Visited page the link was found on
+	position   INTEGER NOT NULL,         -- Order of the link on the page
+	url        TEXT NOT NULL,            -- Normalized document URL
+	category   TEXT NOT NULL DEFAULT '', -- Page heading it was listed under
+	anchor     TEXT NOT NULL DEFAULT '', -- Link text
+	resolution TEXT NOT NULL DEFAULT '', -- JSON list of the shortened or tracking link and the hops to url
+	PRIMARY KEY (page, position)
+);`
+
+// The crawl's pending and visited pages, kept in a SQLite database that the frontier subcommand can read while the
+// crawl writes it
+type crawlFrontier struct {
+	db   *sql.DB // Frontier database
+	path string  // Database file, removed when the crawl finishes
+}
+
+// Formats a timestamp for the frontier database
+func frontierTime(at time.Time) string {
+	return at.UTC().Format(frontierTimeLayout)
+}
+
+// Opens the frontier database, creating its tables unless it's opened read-only
+func openFrontierDB(filePath string, readOnly bool) (*sql.DB, error) {
+	dsn := filePath + "?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on" // WAL lets readers in during the crawl
+	if readOnly {
+		dsn += "&_query_only=on"
+	}
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1) // SQLite takes one writer at a time anyway; one connection avoids busy errors between them
+	if !readOnly {
+		if _, err := db.Exec(frontierSchema); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// Opens the frontier database, resuming the crawl it records unless it's too old; returns nil when the frontier is
+// disabled or can't be written, which the crawl treats as no frontier
+func openCrawlFrontier(filePath string) *crawlFrontier {
+	if filePath == "" {
+		return nil
+	}
+	db, err := openFrontierDB(filePath, false)
+	if err != nil {
+		log.Printf("Not keeping a crawl frontier in %s: %v", filePath, err)
+		return nil
+	}
+	var started sql.NullString
+	var visited, pending int
+	err = db.QueryRow(`SELECT MIN(queued_at), COUNT(*) FILTER (WHERE state = ?), COUNT(*) FILTER (WHERE state = ?) FROM pages`,
+		frontierVisited, frontierPending).Scan(&started, &visited, &pending)
+	if err != nil {
+		log.Printf("Not keeping a crawl frontier in %s: %v", filePath, err)
+		db.Close()
+		return nil
+	}
+	if started.Valid {
+		if at, err := time.Parse(frontierTimeLayout, started.String); err != nil || time.Since(at) > frontierMaxAge {
+			log.Printf("Discarding the crawl frontier in %s from %s", filePath, started.String)
+			if _, err := db.Exec(`DELETE FROM pages`); err != nil { // Their documents go with them
+				log.Printf("Not keeping a crawl frontier in %s: %v", filePath, err)
+				db.Close()
+				return nil
+			}
+		} else {
+			log.Printf("Resuming the crawl in %s: %d pages visited, %d pending", filePath, visited, pending)
+		}
+	}
+	return &crawlFrontier{db: db, path: filePath}
+}
+
+// Records a queued page unless the frontier already knows it
+func (f *crawlFrontier) queue(pageURL string, target string, depth int, source string) {
+	if f == nil {
+		return
+	}
+	_, err := f.db.Exec(`INSERT INTO pages (url, target, state, depth, source, queued_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (url) DO NOTHING`, pageURL, target, frontierPending, depth, source, frontierTime(time.Now()))
+	if err != nil {
+		log.Printf("Failed to write the crawl frontier: %v", err)
+	}
+}
+
+// Returns what an earlier, interrupted run found on a page of the same target, so it needn't be fetched again
+func (f *crawlFrontier) resume(pageURL string, target string) ([]pdfDocument, string, bool) {
+	if f == nil {
+		return nil, "", false
+	}
+	var next string
+	err := f.db.QueryRow(`SELECT next FROM pages WHERE url = ? AND target = ? AND state = ?`, pageURL, target, frontierVisited).Scan(&next)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to read the crawl frontier: %v", err)
+		}
+		return nil, "", false
+	}
+	rows, err := f.db.Query(`SELECT url, category, anchor, resolution FROM documents WHERE page = ? ORDER BY position`, pageURL)
+	if err != nil {
+		log.Printf("Failed to read the crawl frontier: %v", err)
+		return nil, "", false
+	}
+	defer rows.Close()
+	documents := make([]pdfDocument, 0)
+	for rows.Next() {
+		var document pdfDocument
+		var resolution string
+		if err := rows.Scan(&document.URL, &document.Category, &document.Anchor, &resolution); err != nil {
+			log.Printf("Failed to read the crawl frontier: %v", err)
+			return nil, "", false
+		}
+		if resolution != "" {
+			json.Unmarshal([]byte(resolution), &document.Resolution) // Written by visit, so it parses
+		}
+		documents = append(documents, document)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to read the crawl frontier: %v", err)
+		return nil, "", false
+	}
+	return documents, next, true
+}
+
+// Records a scraped page together with the documents and next link it yielded, in one transaction so the page
+// survives a crash whole or not at all
+func (f *crawlFrontier) visit(pageURL string, target string, documents []pdfDocument, next string) {
+	if f == nil {
+		return
+	}
+	if err := f.writeVisit(pageURL, target, documents, next); err != nil {
+		log.Printf("Failed to write the crawl frontier: %v", err)
+	}
+}
+
+// Marks a page visited and replaces the documents recorded for it
+func (f *crawlFrontier) writeVisit(pageURL string, target string, documents []pdfDocument, next string) error {
+	tx, err := f.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // A no-op once committed
+	now := frontierTime(time.Now())
+	_, err = tx.Exec(`INSERT INTO pages (url, target, state, depth, queued_at, visited_at, next) VALUES (?, ?, ?, 0, ?, ?, ?)
+		ON CONFLICT (url) DO UPDATE SET target = excluded.target, state = excluded.state, visited_at = excluded.visited_at, next = excluded.next`,
+		pageURL, target, frontierVisited, now, now, next)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM documents WHERE page = ?`, pageURL); err != nil {
+		return err
+	}
+	insert, err := tx.Prepare(`INSERT INTO documents (page, position, url, category, anchor, resolution) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for position, document := range documents {
+		resolution := ""
+		if len(document.Resolution) > 0 {
+			data, err := json.Marshal(document.Resolution)
+			if err != nil {
+				return err
+			}
+			resolution = string(data)
+		}
+		if _, err := insert.Exec(pageURL, position, document.URL, document.Category, document.Anchor, resolution); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Ends the crawl: a finished crawl removes the database, so the next run starts from the start pages again
+func (f *crawlFrontier) close(finished bool) {
+	if f == nil {
+		return
+	}
+	if err := f.db.Close(); err != nil {
+		log.Println(err)
+	}
+	if finished {
+		for _, name := range []string{f.path, f.path + "-wal", f.path + "-shm"} { // The WAL files outlive a crash
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// Prints the frontier of a crawl in progress or interrupted
+func runFrontier(args []string) error {
+	flags := flag.NewFlagSet("frontier", flag.ExitOnError) // Options specific to frontier
+	filePath := flags.String("frontier", frontierPath, "crawl frontier database to inspect")
+	all := flags.Bool("all", false, "list visited pages too, not only pending ones")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *filePath == "" {
+		return fmt.Errorf("-frontier is required")
+	}
+	if _, err := os.Stat(*filePath); os.IsNotExist(err) {
+		fmt.Println("No crawl in progress")
+		return nil
+	}
+	db, err := openFrontierDB(*filePath, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var visited, pending, documents int
+	err = db.QueryRow(`SELECT (SELECT COUNT(*) FROM pages WHERE state = ?), (SELECT COUNT(*) FROM pages WHERE state = ?), (SELECT COUNT(*) FROM documents)`,
+		frontierVisited, frontierPending).Scan(&visited, &pending, &documents)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", *filePath, err)
+	}
+	fmt.Printf("%d pages visited (%d documents), %d pending\n", visited, documents, pending)
+	rows, err := db.Query(`SELECT p.url, p.state, p.depth, p.source, COALESCE(p.visited_at, ''), (SELECT COUNT(*) FROM documents d WHERE d.page = p.url)
+		FROM pages p WHERE p.state = ? OR ? ORDER BY p.rowid`, frontierPending, *all)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", *filePath, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pageURL, pageState, source, visitedAt string
+		var depth, found int
+		if err := rows.Scan(&pageURL, &pageState, &depth, &source, &visitedAt, &found); err != nil {
+			return fmt.Errorf("reading %s: %w", *filePath, err)
+		}
+		state := "pending"
+		if pageState == frontierVisited {
+			if at, err := time.Parse(frontierTimeLayout, visitedAt); err == nil {
+				visitedAt = at.Format(time.RFC3339)
+			}
+			state = fmt.Sprintf("visited %s, %d documents", visitedAt, found)
+		}
+		if source == "" {
+			source = "start page"
+		}
+		fmt.Printf("  depth %d  %s  (%s; from %s)\n", depth, pageURL, state, source)
+	}
+	return rows.Err()
+}
@@ -0,0 +1,42 @@
+//go:build linux || darwin || freebsd || openbsd || netbsd || dragonfly
+
+package main // Advisory locks and sharing errors on systems with flock, which SMB and NFS clients forward to the server
+
+import (
+	"errors"  // Matches errno values
+	"os"      // Opens the lock file
+	"syscall" // Calls flock
+)
+
+// Takes an exclusive advisory lock on an existing file; the lock lasts until the returned file is closed or the
+// process dies, so a crashed run can't leave it held
+func lockAdvisory(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Reports whether a live process, on this machine or another client of the share, holds the advisory lock on path
+func advisoryLockHeld(path string) bool {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == nil {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	}
+	return errors.Is(err, syscall.EWOULDBLOCK)
+}
+
+// Reports whether an operation failed because another process or client is using the file
+func isSharingViolation(err error) bool {
+	return errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.ETXTBSY)
+}
@@ -0,0 +1,23 @@
+//go:build !(linux || darwin || freebsd || openbsd || netbsd || dragonfly || windows)
+
+package main // Fallbacks for systems without a supported advisory lock; the lock file alone guards the archive
+
+import (
+	"errors" // Reports the missing support
+	"os"     // Matches the flock signature
+)
+
+// Reports that advisory locks aren't supported here
+func lockAdvisory(path string) (*os.File, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// Reports whether the lock on path is held; unknown without advisory locks
+func advisoryLockHeld(path string) bool {
+	return false
+}
+
+// Reports whether an operation failed because another process is using the file; never detected here
+func isSharingViolation(err error) bool {
+	return false
+}
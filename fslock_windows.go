@@ -0,0 +1,48 @@
+package main // Advisory locks and sharing errors on Windows, where files opened without sharing are locked on SMB shares too
+
+import (
+	"errors"  // Matches Windows error codes
+	"os"      // Wraps the handle
+	"syscall" // Opens files with explicit share modes
+)
+
+// Windows error codes returned while another process or client has a file open
+const (
+	errorAccessDenied     = syscall.Errno(5)  // ERROR_ACCESS_DENIED, also returned for files pending deletion or being scanned
+	errorSharingViolation = syscall.Errno(32) // ERROR_SHARING_VIOLATION
+	errorLockViolation    = syscall.Errno(33) // ERROR_LOCK_VIOLATION
+)
+
+// Opens an existing file with the given access and share modes
+func openShared(path string, access uint32, share uint32) (syscall.Handle, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+	return syscall.CreateFile(name, access, share, nil, syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+}
+
+// Opens an existing file for writing while letting others only read it, which locks it until the returned file is
+// closed or the process dies
+func lockAdvisory(path string) (*os.File, error) {
+	handle, err := openShared(path, syscall.GENERIC_READ|syscall.GENERIC_WRITE, syscall.FILE_SHARE_READ)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(handle), path), nil
+}
+
+// Reports whether a live process, on this machine or another client of the share, holds the lock on path
+func advisoryLockHeld(path string) bool {
+	handle, err := openShared(path, syscall.GENERIC_WRITE, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE)
+	if err != nil {
+		return errors.Is(err, errorSharingViolation)
+	}
+	syscall.CloseHandle(handle)
+	return false
+}
+
+// Reports whether an operation failed because another process or client is using the file
+func isSharingViolation(err error) bool {
+	return errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation) || errors.Is(err, errorAccessDenied)
+}
@@ -0,0 +1,117 @@
+package main // GHS hazard pictograms read from Safety Data Sheets
+
+import (
+	"regexp"  // Finds pictogram codes, names and hazard statements
+	"sort"    // Keeps pictogram lists in code order
+	"strings" // Normalizes label text
+)
+
+// The nine GHS pictograms with the words sheets use to caption them, longer captions before the ones they contain
+var ghsPictograms = []struct {
+	Code  string         // Official code such as GHS05
+	Name  string         // Short English name shown in reports
+	Label *regexp.Regexp // Caption used on the label
+}{
+	{"GHS01", "Exploding bomb", regexp.MustCompile(`(?i)exploding bomb|\bexplosive\b`)},
+	{"GHS03", "Flame over circle", regexp.MustCompile(`(?i)flame\s+over\s+circle`)},
+	{"GHS02", "Flame", regexp.MustCompile(`(?i)\bflames?\b`)},
+	{"GHS04", "Gas cylinder", regexp.MustCompile(`(?i)gas\s+cylinder`)},
+	{"GHS05", "Corrosion", regexp.MustCompile(`(?i)\bcorrosi(?:on|ve)\b`)},
+	{"GHS06", "Skull and crossbones", regexp.MustCompile(`(?i)skull\s+and\s+crossbones`)},
+	{"GHS07", "Exclamation mark", regexp.MustCompile(`(?i)exclamation\s+mark`)},
+	{"GHS08", "Health hazard", regexp.MustCompile(`(?i)health\s+hazard`)},
+	{"GHS09", "Environment", regexp.MustCompile(`(?i)\benvironment(?:al)?(?:\s+hazard)?\b`)},
+}
+
+// Hazard statement codes and the pictogram each one requires
+var hazardStatementPictograms = map[string]string{
+	"H200": "GHS01", "H201": "GHS01", "H202": "GHS01", "H203": "GHS01", "H204": "GHS01", "H205": "GHS01", "H240": "GHS01", "H241": "GHS01",
+	"H220": "GHS02", "H221": "GHS02", "H222": "GHS02", "H223": "GHS02", "H224": "GHS02", "H225": "GHS02", "H226": "GHS02", "H228": "GHS02",
+	"H242": "GHS02", "H250": "GHS02", "H251": "GHS02", "H252": "GHS02", "H260": "GHS02", "H261": "GHS02",
+	"H270": "GHS03", "H271": "GHS03", "H272": "GHS03",
+	"H280": "GHS04", "H281": "GHS04",
+	"H290": "GHS05", "H314": "GHS05", "H318": "GHS05",
+	"H300": "GHS06", "H301": "GHS06", "H310": "GHS06", "H311": "GHS06", "H330": "GHS06", "H331": "GHS06",
+	"H302": "GHS07", "H312": "GHS07", "H315": "GHS07", "H317": "GHS07", "H319": "GHS07", "H332": "GHS07", "H335": "GHS07", "H336": "GHS07",
+	"H304": "GHS08", "H334": "GHS08", "H340": "GHS08", "H341": "GHS08", "H350": "GHS08", "H351": "GHS08", "H360": "GHS08", "H361": "GHS08",
+	"H370": "GHS08", "H371": "GHS08", "H372": "GHS08", "H373": "GHS08",
+	"H400": "GHS09", "H410": "GHS09", "H411": "GHS09",
+}
+
+var (
+	ghsCodeRegex         = regexp.MustCompile(`\bGHS0([1-9])\b`)                                                       // Explicit pictogram codes
+	embeddedGHSRegex     = regexp.MustCompile(`GHS0([1-9])`)                                                           // Codes in image names and alt text
+	pictogramLabelRegex  = regexp.MustCompile(`(?i)hazard pictograms?|pictograms?|ghs symbols?|ghs label|symbol\(s\)`) // Start of the label section
+	pictogramEndRegex    = regexp.MustCompile(`(?i)signal word|hazard statements?|precautionary`)                      // End of the label section
+	hazardStatementRegex = regexp.MustCompile(`\b(H[2-4]\d\d)\b`)                                                      // Hazard statement codes such as H314
+)
+
+// Returns the GHS pictogram codes of a sheet and where they were found: code, label, embedded or hazard-statements
+func findPictograms(text string, data []byte) ([]string, string) {
+	found := make(map[string]bool)
+	for _, match := range ghsCodeRegex.FindAllStringSubmatch(text, -1) { // Sheets that print the codes themselves
+		found["GHS0"+match[1]] = true
+	}
+	if len(found) > 0 {
+		return sortedPictograms(found), "code"
+	}
+
+	flattened := strings.Join(strings.Fields(text), " ") // Captions are often split across lines
+	for _, location := range pictogramLabelRegex.FindAllStringIndex(flattened, -1) {
+		section := flattened[location[1]:min(location[1]+300, len(flattened))]
+		if end := pictogramEndRegex.FindStringIndex(section); end != nil {
+			section = section[:end[0]]
+		}
+		for _, pictogram := range ghsPictograms {
+			if pictogram.Label.MatchString(section) {
+				found[pictogram.Code] = true
+				section = pictogram.Label.ReplaceAllString(section, "") // So "flame over circle" isn't also a plain flame
+			}
+		}
+	}
+	if len(found) > 0 {
+		return sortedPictograms(found), "label"
+	}
+
+	for _, source := range pdfSearchSources(data) { // Image names, alt text and XMP of pictograms drawn as images
+		for _, match := range embeddedGHSRegex.FindAllSubmatch(source, -1) {
+			found["GHS0"+string(match[1])] = true
+		}
+	}
+	if len(found) > 0 {
+		return sortedPictograms(found), "embedded"
+	}
+
+	for _, match := range hazardStatementRegex.FindAllStringSubmatch(text, -1) { // Infer from the hazard statements
+		if code, known := hazardStatementPictograms[match[1]]; known {
+			found[code] = true
+		}
+	}
+	if found["GHS06"] {
+		delete(found, "GHS07") // The skull and crossbones replaces the exclamation mark
+	}
+	if len(found) > 0 {
+		return sortedPictograms(found), "hazard-statements"
+	}
+	return nil, ""
+}
+
+// Returns the codes of a pictogram set in order
+func sortedPictograms(found map[string]bool) []string {
+	codes := make([]string, 0, len(found))
+	for code := range found {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Returns the report name of a pictogram code, e.g. "GHS05 Corrosion"
+func pictogramName(code string) string {
+	for _, pictogram := range ghsPictograms {
+		if pictogram.Code == code {
+			return code + " " + pictogram.Name
+		}
+	}
+	return code
+}
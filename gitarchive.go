@@ -0,0 +1,123 @@
+package main // Git-backed archive mode: every run that changes the archive becomes a commit
+
+import (
+	"bytes"         // Captures git output
+	"fmt"           // Builds the commit message
+	"log"           // Reports commits and pushes
+	"os"            // Checks which archive paths exist
+	"os/exec"       // Runs git
+	"path/filepath" // Relates archive paths to the repository
+	"strings"       // Builds the commit message
+)
+
+var (
+	gitCommitRuns = false // Commit the archive to git after every run
+	gitPushRemote = ""    // Remote pushed to after each commit, e.g. origin; empty doesn't push
+)
+
+// Runs git in dir and returns its trimmed output, with stderr folded into errors
+func runGit(dir string, args ...string) (string, error) {
+	command := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stdout, stderr bytes.Buffer
+	command.Stdout, command.Stderr = &stdout, &stderr
+	if err := command.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// Returns the archive's paths relative to the repository: the output directories and the manifest files that live
+// inside it and exist
+func gitArchivePaths(repo string) []string {
+	var paths []string
+	for _, dir := range append(archiveDirs(), objectsPrefix) {
+		if directoryExists(filepath.Join(repo, dir)) {
+			paths = append(paths, filepath.Clean(dir))
+		}
+	}
+	root, _ := filepath.Abs(repo)
+	for _, file := range []string{manifestFilePath, lastSeenFilePath(manifestFilePath), objectIndexPath} {
+		absolute, _ := filepath.Abs(file)
+		relative, err := filepath.Rel(root, absolute)
+		if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+			continue // Kept outside the archive directory
+		}
+		if _, err := os.Stat(file); err == nil {
+			paths = append(paths, relative)
+		}
+	}
+	return paths
+}
+
+// Builds the commit message: a subject with the run's counts and a body listing what was stored or failed
+func gitCommitMessage(summary runSummary) string {
+	counts := make(map[string]int)
+	var stored, failed []string
+	for _, outcome := range summary.Documents {
+		counts[outcome.Status]++
+		switch outcome.Status {
+		case outcomeDownloaded:
+			stored = append(stored, fmt.Sprintf("  %s ← %s", outcome.File, outcome.URL))
+		case outcomeFailed:
+			failed = append(failed, fmt.Sprintf("  %s: %s", outcome.URL, outcome.Message))
+		}
+	}
+	var message strings.Builder
+	fmt.Fprintf(&message, "Archive run %s: %d downloaded, %d skipped, %d failed\n\n", summary.RunID, counts[outcomeDownloaded], counts[outcomeSkipped], counts[outcomeFailed])
+	fmt.Fprintf(&message, "Discovered %d documents and downloaded %s.\n", summary.Discovered, formatBytes(summary.Bandwidth.downloadedBytes()))
+	if len(summary.Skips) > 0 {
+		fmt.Fprintf(&message, "Skipped: %s.\n", formatSkipReasons(summary.Skips))
+	}
+	if len(stored) > 0 {
+		fmt.Fprintf(&message, "\nStored:\n%s\n", strings.Join(stored, "\n"))
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(&message, "\nFailed:\n%s\n", strings.Join(failed, "\n"))
+	}
+	return message.String()
+}
+
+// Stages the archive in the local storage root, creating the repository if needed, and commits it with the run
+// summary as the message; runs that changed nothing make no commit
+func commitArchive(summary runSummary) error {
+	if storageBackend != "" && storageBackend != "local" {
+		return fmt.Errorf("-git-commit needs local storage, not %s", storageBackend)
+	}
+	repo := storageURL // Local archives are rooted here
+	if repo == "" {
+		repo = "."
+	}
+	if _, err := runGit(repo, "rev-parse", "--is-inside-work-tree"); err != nil {
+		if _, err := runGit(repo, "init"); err != nil {
+			return err
+		}
+		log.Printf("Initialized a git repository for the archive in %s", repo)
+	}
+	paths := gitArchivePaths(repo)
+	if len(paths) == 0 {
+		return nil // Nothing archived yet
+	}
+	if _, err := runGit(repo, append([]string{"add", "-A", "--"}, paths...)...); err != nil {
+		return err
+	}
+	if _, err := runGit(repo, "diff", "--cached", "--quiet"); err == nil {
+		log.Printf("Archive unchanged; nothing to commit")
+		return nil
+	}
+	args := []string{"commit", "--quiet", "-m", gitCommitMessage(summary)}
+	if name, _ := runGit(repo, "config", "user.name"); name == "" {
+		args = append([]string{"-c", "user.name=poolseason-scraper", "-c", "user.email=poolseason-scraper@localhost"}, args...) // Unattended hosts often have no identity
+	}
+	if _, err := runGit(repo, args...); err != nil {
+		return err
+	}
+	commit, _ := runGit(repo, "rev-parse", "--short", "HEAD")
+	log.Printf("Committed the archive as %s", commit)
+	if gitPushRemote != "" {
+		if _, err := runGit(repo, "push", gitPushRemote, "HEAD"); err != nil {
+			return err
+		}
+		log.Printf("Pushed %s to %s", commit, gitPushRemote)
+	}
+	return nil
+}
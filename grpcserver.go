@@ -0,0 +1,382 @@
+package main // gRPC service mode for orchestrating scrapes from other systems
+
+import (
+	"context"       // Carries request deadlines through the handlers
+	"encoding/json" // Converts manifest entries into protobuf Structs
+	"errors"        // Classifies failed restores
+	"fmt"           // Formats error messages
+	"io"            // Streams documents in chunks
+	"log"           // Reports server lifecycle events
+	"net"           // Opens the listening socket
+	"slices"        // Filters documents by state
+	"sync"          // Guards the run table
+	"time"          // Records run start and finish times
+
+	"google.golang.org/grpc"                            // gRPC server implementation
+	"google.golang.org/grpc/codes"                      // Standard gRPC status codes
+	"google.golang.org/grpc/status"                     // Builds gRPC errors
+	"google.golang.org/protobuf/types/known/structpb"   // google.protobuf.Struct and ListValue
+	"google.golang.org/protobuf/types/known/wrapperspb" // google.protobuf.StringValue and BytesValue
+)
+
+const (
+	scraperServiceName = "poolseason.v1.Scraper" // Fully-qualified gRPC service name
+	fetchChunkSize     = 64 * 1024               // Size of each BytesValue message sent by FetchDocument
+)
+
+// Methods of the poolseason.v1.Scraper service described in proto/scraper.proto
+type scraperService interface {
+	TriggerRun(context.Context, *structpb.Struct) (*wrapperspb.StringValue, error)
+	GetRunStatus(context.Context, *wrapperspb.StringValue) (*structpb.Struct, error)
+	ListDocuments(context.Context, *structpb.Struct) (*structpb.ListValue, error)
+	FetchDocument(*wrapperspb.StringValue, grpc.ServerStream) error
+}
+
+// Tracks one scrape started over gRPC
+type grpcRun struct {
+	ID         string     // Identifier returned by TriggerRun
+	StartedAt  time.Time  // When the run began
+	FinishedAt time.Time  // When the run ended; zero while running
+	Summary    runSummary // Counts reported by the finished run
+	WebhookURL string     // Where the run's report is POSTed when it finishes; empty when the caller didn't ask
+	Webhook    string     // Delivery state of the webhook: pending, delivered or failed
+	WebhookErr string     // Why the webhook couldn't be delivered
+	Sections   []string   // Targets the run crawled when the sitemap watch started it for them; nil for every target
+}
+
+// Implements scraperService on top of runScrape and the manifest
+type scraperServer struct {
+	mu      sync.Mutex          // Protects runs and active
+	runs    map[string]*grpcRun // Every run started by this server
+	active  bool                // Whether a run is currently in progress
+	runFunc func() runSummary   // Performs a scrape; normally runScrape
+}
+
+// Runs the serve subcommand, also reachable under its older name serve-grpc, until the listener fails; it takes
+// every scrape option, which the runs it triggers use
+func runGRPCServer(args []string) error {
+	flags := stageFlags("serve")
+	listenAddress := flags.String("listen", ":50051", "address to listen on") // Listening address
+	flags.StringVar(&eventsURL, "events", eventsURL, "publish document_discovered, document_downloaded, document_changed, document_quarantined and run_completed events to nats://host:port or a Kafka REST proxy at kafka+http://host:port")
+	flags.StringVar(&eventsTopic, "events-topic", eventsTopic, "NATS subject or Kafka topic events are published to")
+	flags.Func("scrape-window", "only run between these local times, e.g. 01:00-05:00 or 22:00-02:00,12:00-13:00 (unset allows any time)", setScrapeWindows)
+	flags.Func("blackout-dates", "never run on these local dates: comma-separated YYYY-MM-DD, or MM-DD for every year", setBlackoutDates)
+	flags.DurationVar(&revalidateCycle, "revalidate-cycle", revalidateCycle, "re-validate the whole archive against its source URLs with conditional GETs once per this period, e.g. 168h, checking an even slice every hour; documents found changed are downloaded again by the next run (0 disables)")
+	flags.StringVar(&revalidateStatePath, "revalidate-state", revalidateStatePath, "file recording when each document was last re-validated and which ones changed")
+	flags.StringVar(&apiKeysPath, "api-keys", apiKeysPath, `JSON list of {"name", "key", "role"} objects; key is an env:NAME or secret:NAME reference and role is read (status, listing and fetching) or admin (also TriggerRun). Without it, `+apiKeysEnvironment+` is read as comma-separated role:key pairs; with neither, serve refuses to start`)
+	flags.BoolVar(&allowNoAuth, "insecure-no-auth", allowNoAuth, "serve without API keys when none are configured, so anyone who can reach the server can list and fetch documents and trigger runs")
+	flags.StringVar(&webhookSecret, "webhook-secret", webhookSecret, "sign completion webhooks with HMAC-SHA256 of the body under this key, sent as X-Poolseason-Signature: sha256=<hex> (empty sends them unsigned)")
+	flags.DurationVar(&sitemapPollInterval, "sitemap-poll", sitemapPollInterval, "check the sites' sitemaps this often, e.g. 15m, with conditional GETs, and start a run of only the targets whose pages' lastmod changed (0 disables)")
+	flags.Func("sitemap", "comma-separated sitemap or sitemap index URLs for -sitemap-poll (defaults to /sitemap.xml on every target's host)", setSitemapURLs)
+	flags.StringVar(&sitemapStatePath, "sitemap-state", sitemapStatePath, "file recording the lastmod of every sitemap page seen, so restarts don't trigger runs")
+	flags.BoolVar(&readThrough, "read-through", readThrough, "when a document in the manifest is missing locally, FetchDocument fetches it from its URL, checks it against the recorded SHA-256 and stores it again before streaming it")
+	configPath := flags.String("config", "", `JSON file with "targets" (as in a -targets file), "download_workers", "auto_concurrency", "process_workers", "request_interval", "breaker_threshold", "breaker_cooldown", "max_throttle_retries", "pre_run" and "post_run"; checked every few seconds and applied at the start of the next run, with the changes logged`)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := prepareRun(); err != nil { // The same checks and loading as scrape, so a bad option fails at startup
+		return err
+	}
+	keys, err := loadAPIKeys()
+	if err != nil {
+		return err
+	}
+	if *configPath != "" {
+		watcher, err := watchDaemonConfig(*configPath)
+		if err != nil {
+			return err
+		}
+		daemonConfigWatcher = watcher
+	}
+	if progressSocketPath != "" {
+		hub, err := startProgressSocket(progressSocketPath)
+		if err != nil {
+			return err
+		}
+		progress = hub
+		defer hub.close()
+	}
+	if eventsURL != "" {
+		publisher, err := newEventPublisher(eventsURL)
+		if err != nil {
+			return err
+		}
+		eventSink = publisher // Runs triggered over gRPC publish their events
+	}
+
+	listener, err := net.Listen("tcp", *listenAddress) // Open the socket
+	if err != nil {
+		return err
+	}
+	var options []grpc.ServerOption // Authentication, unless -insecure-no-auth turned it off
+	if len(keys) > 0 {
+		options = append(options, grpc.UnaryInterceptor(keys.unaryInterceptor), grpc.StreamInterceptor(keys.streamInterceptor))
+		log.Printf("Requiring one of %d API keys", len(keys))
+	} else {
+		log.Printf("Serving without authentication (-insecure-no-auth); anyone who can reach %s can trigger runs", listener.Addr())
+	}
+	server := grpc.NewServer(options...)
+	scraper := &scraperServer{runs: make(map[string]*grpcRun), runFunc: runScrape}
+	server.RegisterService(&scraperServiceDesc, scraper)
+	if revalidateCycle > 0 {
+		go runRevalidationSweep(scraper.running)
+	}
+	if sitemapPollInterval > 0 {
+		go runSitemapWatch(scraper)
+	}
+	log.Printf("gRPC scraper service listening on %s", listener.Addr())
+	return server.Serve(listener) // Blocks until the server stops
+}
+
+// Reports whether a triggered run is in progress
+func (s *scraperServer) running() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active
+}
+
+// Starts a scrape in the background unless one is already running and returns its job ID at once; a webhook_url
+// in the options is sent the run's report when it finishes
+func (s *scraperServer) TriggerRun(ctx context.Context, options *structpb.Struct) (*wrapperspb.StringValue, error) {
+	webhookURL := options.GetFields()["webhook_url"].GetStringValue()
+	if webhookURL != "" {
+		if err := validateWebhookURL(webhookURL); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	run, err := s.startRun(webhookURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return wrapperspb.String(run.ID), nil // Return the run ID for polling
+}
+
+// Starts a scrape in the background unless one is already running or the scrape window is closed; sections limits
+// it to the targets with those URLs, nil crawls every target
+func (s *scraperServer) startRun(webhookURL string, sections []string) (*grpcRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active { // Only one run may write the archive at a time
+		return nil, status.Error(codes.Aborted, "a run is already in progress")
+	}
+	if now := time.Now(); !scrapeAllowed(now) { // Keep off the store network during business hours
+		return nil, status.Error(codes.FailedPrecondition, scrapeWindowMessage(now))
+	}
+	if daemonConfigWatcher != nil { // Pick up configuration changes made since the last run
+		daemonConfigWatcher.applyPending()
+	}
+	serverRunID := runID()
+	run := &grpcRun{ID: newRunID(), StartedAt: time.Now().UTC(), WebhookURL: webhookURL, Sections: sections}
+	if webhookURL != "" {
+		run.Webhook = webhookPending
+	}
+	beginRun(run.ID)                          // The scrape logs and records under its own run ID
+	lock, err := acquireRunLock(lockFilePath) // Also exclude runs started outside this server
+	if err != nil {
+		beginRun(serverRunID)
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	s.runs[run.ID] = run
+	s.active = true
+	restoreTargets := func() {}
+	if sections != nil {
+		restoreTargets = narrowTargets(sections)
+	}
+
+	go func() { // Scrape without blocking the caller
+		defer lock.release()
+		defer beginRun(serverRunID) // Server logs go back to the server's own ID
+		summary := s.runFunc()
+		restoreTargets()
+		s.mu.Lock()
+		run.Summary = summary
+		run.FinishedAt = time.Now().UTC()
+		s.active = false
+		report := run.statusFields()
+		s.mu.Unlock()
+		if run.WebhookURL != "" {
+			go s.notify(run, report) // Deliveries may retry for a while; the next run needn't wait
+		}
+	}()
+	return run, nil
+}
+
+// Reports the state of a previously triggered run
+func (s *scraperServer) GetRunStatus(ctx context.Context, runID *wrapperspb.StringValue) (*structpb.Struct, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, found := s.runs[runID.GetValue()] // Look up the run
+	if !found {
+		return nil, status.Errorf(codes.NotFound, "unknown run %q", runID.GetValue())
+	}
+	return structpb.NewStruct(run.statusFields())
+}
+
+// POSTs a finished run's report to its webhook and records how delivery went
+func (s *scraperServer) notify(run *grpcRun, report map[string]any) {
+	delete(report, "webhook") // Still pending while it's being sent
+	err := deliverWebhook(run.WebhookURL, run.ID, report)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run.Webhook = webhookDelivered
+	if err != nil {
+		log.Printf("Gave up on the webhook for job %s: %v", run.ID, err)
+		run.Webhook, run.WebhookErr = webhookFailed, err.Error()
+	}
+}
+
+// Returns the status of a run as reported by GetRunStatus and its webhook; the caller holds s.mu
+func (run *grpcRun) statusFields() map[string]any {
+	fields := map[string]any{ // Status fields shared by running and finished runs
+		"id":         run.ID,
+		"state":      "running",
+		"started_at": run.StartedAt.Format(time.RFC3339),
+	}
+	if run.FinishedAt.IsZero() { // Running runs report the live counters
+		fields["progress"] = runStats.snapshot().fields()
+	} else { // Finished runs also report their counts
+		fields["state"] = "completed"
+		fields["finished_at"] = run.FinishedAt.Format(time.RFC3339)
+		fields["discovered"] = run.Summary.Discovered
+		fields["downloaded"] = run.Summary.Downloaded
+		if run.Summary.Aborted != "" {
+			fields["state"] = "aborted"
+			fields["aborted"] = run.Summary.Aborted // The pre_run hook failed; nothing was fetched
+		}
+		fields["no_links"] = run.Summary.NoLinks // The listing pages yielded nothing; see the discovery debug directory
+		if run.Summary.Rejected != "" {
+			fields["rejected"] = run.Summary.Rejected // Staged downloads weren't promoted; the archive is unchanged
+		}
+		if run.Summary.Remaining > 0 {
+			fields["remaining"] = run.Summary.Remaining // Left for the next run by -run-deadline
+		}
+		fields["throttled"] = len(run.Summary.Throttled)
+		fields["bytes_downloaded"] = run.Summary.Bandwidth.downloadedBytes()
+		skippedBytes, skippedFiles := run.Summary.Bandwidth.skippedBytes()
+		fields["bytes_skipped"] = skippedBytes
+		fields["files_skipped"] = skippedFiles
+		skips := make(map[string]any, len(run.Summary.Skips)) // structpb needs map[string]any
+		for reason, count := range run.Summary.Skips {
+			skips[string(reason)] = count
+		}
+		fields["skip_reasons"] = skips
+		openHosts := make([]any, 0, len(run.Summary.OpenHosts)) // structpb needs []any
+		for _, host := range run.Summary.OpenHosts {
+			openHosts = append(openHosts, host)
+		}
+		fields["open_hosts"] = openHosts
+		fields["progress"] = run.Summary.Stats.fields()
+	}
+	if run.Sections != nil {
+		sections := make([]any, 0, len(run.Sections)) // structpb needs []any
+		for _, section := range run.Sections {
+			sections = append(sections, section)
+		}
+		fields["sections"] = sections // Started by the sitemap watch for these targets only
+	}
+	if run.WebhookURL != "" {
+		fields["webhook_url"] = run.WebhookURL
+		fields["webhook"] = run.Webhook
+		if run.WebhookErr != "" {
+			fields["webhook_error"] = run.WebhookErr
+		}
+	}
+	return fields
+}
+
+// Lists every archived document recorded in the manifest, or with a state option every document in those states,
+// archived or not
+func (s *scraperServer) ListDocuments(ctx context.Context, options *structpb.Struct) (*structpb.ListValue, error) {
+	states, err := parseStates(options.GetFields()["state"].GetStringValue())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	entries := loadManifest(manifestFilePath).list()
+	if len(states) > 0 {
+		entries = slices.DeleteFunc(loadManifest(manifestFilePath).all(), func(entry manifestEntry) bool { return !slices.Contains(states, entry.state()) })
+	}
+	data, err := encodeManifestEntries(entries) // Reuse the manifest's JSON field names
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	var documents []any // Generic JSON values accepted by structpb
+	if err := json.Unmarshal(data, &documents); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return structpb.NewList(documents)
+}
+
+// Streams a downloaded document to the caller in fixed-size chunks
+func (s *scraperServer) FetchDocument(documentURL *wrapperspb.StringValue, stream grpc.ServerStream) error {
+	entry, found := loadManifest(manifestFilePath).lookup(documentURL.GetValue(), "") // Find the local copy
+	if !found {
+		return status.Errorf(codes.NotFound, "no document downloaded from %q", documentURL.GetValue())
+	}
+	file, err := openArchivedDocument(stream.Context(), entry) // Open the local copy, restoring it if it went missing
+	if errors.Is(err, errUpstreamChanged) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	defer file.Close()
+
+	buffer := make([]byte, fetchChunkSize) // Reused for every chunk
+	for {
+		n, err := file.Read(buffer)
+		if n > 0 {
+			if sendErr := stream.SendMsg(wrapperspb.Bytes(buffer[:n])); sendErr != nil {
+				return sendErr // Client went away
+			}
+		}
+		if err == io.EOF {
+			return nil // Whole file sent
+		}
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+}
+
+// Builds a unary handler that decodes the request into a fresh message of type Req
+func unaryHandler[Req any, Resp any](method string, call func(scraperService, context.Context, *Req) (Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv any, ctx context.Context, decode func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			request := new(Req) // Decode into a fresh request message
+			if err := decode(request); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(scraperService), ctx, request)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: fmt.Sprintf("/%s/%s", scraperServiceName, method)}
+			return interceptor(ctx, request, info, func(ctx context.Context, request any) (any, error) {
+				return call(srv.(scraperService), ctx, request.(*Req))
+			})
+		},
+	}
+}
+
+// Hand-written descriptor for poolseason.v1.Scraper; see proto/scraper.proto
+var scraperServiceDesc = grpc.ServiceDesc{
+	ServiceName: scraperServiceName,
+	HandlerType: (*scraperService)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryHandler("TriggerRun", scraperService.TriggerRun),
+		unaryHandler("GetRunStatus", scraperService.GetRunStatus),
+		unaryHandler("ListDocuments", scraperService.ListDocuments),
+	},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "FetchDocument",
+		ServerStreams: true,
+		Handler: func(srv any, stream grpc.ServerStream) error {
+			request := new(wrapperspb.StringValue) // The single request message
+			if err := stream.RecvMsg(request); err != nil {
+				return err
+			}
+			return srv.(scraperService).FetchDocument(request, stream)
+		},
+	}},
+	Metadata: "proto/scraper.proto",
+}
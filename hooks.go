@@ -0,0 +1,72 @@
+package main // Shell commands run before and after every run, e.g. to mount a network drive and to trigger a sync
+
+import (
+	"context" // Bounds how long a hook may take
+	"fmt"     // Formats the environment and errors
+	"log"     // Reports hooks as they run
+	"os"      // Passes the process environment and output on
+	"os/exec" // Runs the hook through the shell
+	"runtime" // Picks the shell
+	"strconv" // Formats counts
+	"time"    // Timestamps and the timeout
+)
+
+var (
+	preRunHook  = ""               // Shell command run before a run starts; a failure aborts the run
+	postRunHook = ""               // Shell command run after a run, once its reports are written
+	hookTimeout = 10 * time.Minute // Longest a hook may run before it's killed
+)
+
+// Runs a hook command through the shell with the run's details in its environment; an empty command does nothing
+func runHook(name string, command string, summary runSummary) error {
+	if command == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	shell := exec.CommandContext(ctx, "sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		shell = exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	shell.Env = append(os.Environ(), hookEnvironment(name, summary)...)
+	shell.Stdout, shell.Stderr = os.Stderr, os.Stderr // Alongside the log
+	log.Printf("Running the %s hook: %s", name, command)
+	began := time.Now()
+	if err := shell.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s hook timed out after %s", name, hookTimeout)
+		}
+		return fmt.Errorf("%s hook failed: %w", name, err)
+	}
+	log.Printf("The %s hook finished in %s", name, time.Since(began).Round(time.Millisecond))
+	return nil
+}
+
+// Returns the variables describing a run to its hooks; counts are only known to post_run
+func hookEnvironment(name string, summary runSummary) []string {
+	env := []string{
+		"POOLSEASON_HOOK=" + name,
+		"POOLSEASON_RUN_ID=" + summary.RunID,
+		"POOLSEASON_RUN_STARTED=" + summary.Started.Format(time.RFC3339),
+		"POOLSEASON_MANIFEST=" + manifestFilePath,
+		"POOLSEASON_OUTPUT_DIR=" + pdfOutputDir,
+		"POOLSEASON_REPORT=" + junitReportPath,
+		"POOLSEASON_BANDWIDTH_LOG=" + bandwidthLogPath,
+	}
+	if name != "post_run" {
+		return env
+	}
+	counts := make(map[string]int)
+	for _, outcome := range summary.Documents {
+		counts[outcome.Status]++
+	}
+	return append(env,
+		"POOLSEASON_DISCOVERED="+strconv.Itoa(summary.Discovered),
+		"POOLSEASON_DOWNLOADED="+strconv.Itoa(summary.Downloaded),
+		"POOLSEASON_SKIPPED="+strconv.Itoa(counts[outcomeSkipped]),
+		"POOLSEASON_FAILED="+strconv.Itoa(counts[outcomeFailed]),
+		"POOLSEASON_REMAINING="+strconv.Itoa(summary.Remaining),
+		"POOLSEASON_REJECTED="+summary.Rejected,
+		"POOLSEASON_FINISHED="+time.Now().UTC().Format(time.RFC3339),
+	)
+}
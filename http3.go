@@ -0,0 +1,573 @@
+package main // Opt-in HTTP/3 transport over QUIC, for CDNs that do better than TCP on lossy links
+
+import (
+	"bytes"      // Builds frames and field sections
+	"context"    // Bounds handshakes and header waits
+	"crypto/tls" // Negotiates h3 during the QUIC handshake
+	"errors"     // Describes protocol violations
+	"fmt"        // Formats errors and the status line
+	"io"         // Streams response bodies
+	"log"        // Reports hosts fetched over TCP instead
+	"net"        // Splits and joins host and port
+	"net/http"   // RoundTripper and the fallback transport
+	"strconv"    // Parses :status and Content-Length
+	"strings"    // Lowercases field names
+	"sync"       // Guards the connection pool
+	"time"       // Times the fallback period
+
+	"golang.org/x/net/http2/hpack" // Huffman decoding, which QPACK shares with HPACK
+	"golang.org/x/net/quic"        // QUIC connections and streams
+)
+
+var useHTTP3 = false // Fetch https URLs over HTTP/3, falling back to TCP for hosts that don't answer over QUIC
+
+const (
+	http3FallbackPeriod = 10 * time.Minute // How long a host whose QUIC handshake failed is fetched over TCP before QUIC is tried again
+	http3MaxFieldSize   = 1 << 20          // Largest HEADERS frame accepted
+	http3DataChunk      = 16 << 10         // Request body bytes sent per DATA frame
+)
+
+// HTTP/3 frame and stream types (RFC 9114) used by the client
+const (
+	http3FrameData     = 0x00 // Body bytes
+	http3FrameHeaders  = 0x01 // QPACK-encoded header or trailer section
+	http3FrameSettings = 0x04 // First frame of the control stream
+	http3StreamControl = 0x00 // Type of the client's control stream
+)
+
+var errHTTP3Protocol = errors.New("HTTP/3 protocol error") // The server sent something the client can't parse
+
+// Sends https requests over HTTP/3, and everything else, plus requests to hosts that don't answer over QUIC or are
+// reached through a proxy, through the TCP transport
+type http3Transport struct {
+	fallback *http.Transport       // Transport used when HTTP/3 isn't
+	mu       sync.Mutex            // Protects the fields below
+	endpoint *quic.Endpoint        // Local UDP endpoint shared by every connection, opened on first use
+	conns    map[string]*http3Conn // Open or dialing connections by host:port
+	broken   map[string]time.Time  // Hosts whose handshake failed, until when they're fetched over TCP
+}
+
+// One QUIC connection to a server; the fields after ready are set once it's closed
+type http3Conn struct {
+	ready   chan struct{} // Closed when the handshake finished or failed
+	err     error         // Why the handshake failed
+	conn    *quic.Conn    // Established connection
+	control *quic.Stream  // Client control stream, which must stay open as long as the connection
+}
+
+// Returns the transport requests are sent through: HTTP/3 with a TCP fallback when -http3 is set, TCP otherwise
+func newBaseTransport() http.RoundTripper {
+	if useHTTP3 {
+		return &http3Transport{fallback: newHTTPTransport(), conns: make(map[string]*http3Conn), broken: make(map[string]time.Time)}
+	}
+	return newHTTPTransport()
+}
+
+// Sends a request over HTTP/3 when possible; a connection that broke before the request was sent is redialed once
+func (t *http3Transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.URL.Scheme != "https" {
+		return t.fallback.RoundTrip(request)
+	}
+	if proxy, err := t.fallback.Proxy(request); err != nil || proxy != nil {
+		return t.fallback.RoundTrip(request) // Proxies speak HTTP/1.1 CONNECT, which QUIC can't go through
+	}
+	address := request.URL.Host
+	if request.URL.Port() == "" {
+		address = net.JoinHostPort(request.URL.Hostname(), "443")
+	}
+	for attempt := 0; ; attempt++ {
+		conn, err := t.connection(address)
+		if err != nil {
+			return t.fallback.RoundTrip(request)
+		}
+		stream, err := conn.conn.NewStream(request.Context())
+		if err != nil && attempt == 0 && request.Context().Err() == nil {
+			t.forget(address, conn) // Closed by the server, e.g. after its idle timeout
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		response, err := http3RoundTrip(stream, request)
+		if err != nil {
+			stream.Reset(0x0102) // H3_INTERNAL_ERROR
+			stream.CloseRead()
+		}
+		return response, err
+	}
+}
+
+// Returns the connection to a host, dialing it on first use; fails at once for hosts whose handshake failed lately
+func (t *http3Transport) connection(address string) (*http3Conn, error) {
+	t.mu.Lock()
+	if until, found := t.broken[address]; found && time.Now().Before(until) {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("%s didn't answer over QUIC", address)
+	}
+	if conn, found := t.conns[address]; found {
+		t.mu.Unlock()
+		<-conn.ready // Concurrent requests share one handshake
+		return conn, conn.err
+	}
+	conn := &http3Conn{ready: make(chan struct{})}
+	t.conns[address] = conn
+	t.mu.Unlock()
+
+	conn.err = t.dial(conn, address)
+	close(conn.ready)
+	if conn.err != nil {
+		log.Printf("HTTP/3 to %s failed, using TCP for the next %s: %v", address, http3FallbackPeriod, conn.err)
+		t.mu.Lock()
+		delete(t.conns, address)
+		t.broken[address] = time.Now().Add(http3FallbackPeriod)
+		t.mu.Unlock()
+		return nil, conn.err
+	}
+	go func() {
+		conn.conn.Wait(context.Background())
+		t.forget(address, conn)
+	}()
+	return conn, nil
+}
+
+// Opens the QUIC connection and the client control stream, and drains the streams the server opens
+func (t *http3Transport) dial(conn *http3Conn, address string) error {
+	network := "udp"
+	if ipVersion != "any" {
+		network += ipVersion // udp4 or udp6, like the TCP dialer
+	}
+	t.mu.Lock()
+	if t.endpoint == nil {
+		endpoint, err := quic.Listen(network, ":0", nil) // A nil config never accepts connections
+		if err != nil {
+			t.mu.Unlock()
+			return err
+		}
+		t.endpoint = endpoint
+	}
+	endpoint := t.endpoint
+	t.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout+tlsHandshakeTimeout)
+	defer cancel()
+	config := &quic.Config{
+		TLSConfig:        &tls.Config{NextProtos: []string{"h3"}, MinVersion: tls.VersionTLS13}, // Verified against the system roots, like TCP connections
+		HandshakeTimeout: tlsHandshakeTimeout,
+		KeepAlivePeriod:  15 * time.Second,
+	}
+	qconn, err := endpoint.Dial(ctx, network, address, config)
+	if err != nil {
+		return err
+	}
+	control, err := qconn.NewSendOnlyStream(ctx)
+	if err == nil {
+		var frames bytes.Buffer
+		frames.Write(appendQUICVarint(nil, http3StreamControl))
+		writeHTTP3Frame(&frames, http3FrameSettings, nil) // Defaults: no QPACK dynamic table, so responses only use the static one
+		if _, err = control.Write(frames.Bytes()); err == nil {
+			err = control.Flush()
+		}
+	}
+	if err != nil {
+		qconn.Abort(err)
+		return err
+	}
+	go func() { // The server's control and QPACK streams; nothing in them changes how this client behaves
+		for {
+			stream, err := qconn.AcceptStream(context.Background())
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, stream)
+		}
+	}()
+	conn.conn, conn.control = qconn, control
+	return nil
+}
+
+// Drops a connection from the pool so the next request dials again
+func (t *http3Transport) forget(address string, conn *http3Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conns[address] == conn {
+		delete(t.conns, address)
+	}
+	conn.conn.Abort(nil)
+}
+
+// Sends the request on a new stream and reads the response headers; the body is read from the stream as the caller
+// consumes it
+func http3RoundTrip(stream *quic.Stream, request *http.Request) (*http.Response, error) {
+	stream.SetWriteContext(request.Context())
+	var headers bytes.Buffer
+	writeHTTP3Frame(&headers, http3FrameHeaders, encodeHTTP3Fields(request))
+	if _, err := stream.Write(headers.Bytes()); err != nil {
+		return nil, err
+	}
+	if request.Body != nil && request.Body != http.NoBody {
+		buffer := make([]byte, http3DataChunk)
+		for {
+			n, err := request.Body.Read(buffer)
+			if n > 0 {
+				var frame bytes.Buffer
+				writeHTTP3Frame(&frame, http3FrameData, buffer[:n])
+				if _, err := stream.Write(frame.Bytes()); err != nil {
+					request.Body.Close()
+					return nil, err
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				request.Body.Close()
+				return nil, err
+			}
+		}
+		request.Body.Close()
+	}
+	stream.CloseWrite() // Flushes the request and ends it
+
+	headerCtx, cancel := context.WithTimeout(request.Context(), responseHeaderTimeout)
+	defer cancel()
+	stream.SetReadContext(headerCtx)
+	for {
+		frameType, length, err := readHTTP3FrameHeader(stream)
+		if err != nil {
+			return nil, err
+		}
+		if frameType != http3FrameHeaders {
+			if frameType == http3FrameData {
+				return nil, fmt.Errorf("%w: DATA before HEADERS", errHTTP3Protocol)
+			}
+			if _, err := io.CopyN(io.Discard, stream, int64(length)); err != nil { // Unknown and reserved frames are skipped
+				return nil, err
+			}
+			continue
+		}
+		if length > http3MaxFieldSize {
+			return nil, fmt.Errorf("%w: %d-byte header section", errHTTP3Protocol, length)
+		}
+		section := make([]byte, length)
+		if _, err := io.ReadFull(stream, section); err != nil {
+			return nil, err
+		}
+		status, header, err := decodeHTTP3Fields(section)
+		if err != nil {
+			return nil, err
+		}
+		if status >= 100 && status < 200 {
+			continue // Interim responses such as 103 Early Hints
+		}
+		stream.SetReadContext(request.Context()) // The body is bounded by the file deadline and idle timeout instead
+		response := &http.Response{
+			Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			StatusCode:    status,
+			Proto:         "HTTP/3.0",
+			ProtoMajor:    3,
+			Header:        header,
+			Body:          &http3Body{stream: stream},
+			ContentLength: -1,
+			Request:       request,
+		}
+		if length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil {
+			response.ContentLength = length
+		}
+		return response, nil
+	}
+}
+
+// Response body read from the DATA frames of a request stream
+type http3Body struct {
+	stream    *quic.Stream // Request stream
+	remaining uint64       // Bytes left in the current DATA frame
+}
+
+// Reads body bytes, skipping frame headers, trailers and unknown frames
+func (b *http3Body) Read(p []byte) (int, error) {
+	for b.remaining == 0 {
+		frameType, length, err := readHTTP3FrameHeader(b.stream)
+		if err != nil {
+			return 0, err // io.EOF at a frame boundary ends the body
+		}
+		if frameType == http3FrameData {
+			b.remaining = length
+			continue
+		}
+		if _, err := io.CopyN(io.Discard, b.stream, int64(length)); err != nil { // Trailers aren't used
+			return 0, err
+		}
+	}
+	if uint64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.stream.Read(p)
+	b.remaining -= uint64(n)
+	if err == io.EOF && b.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == io.EOF {
+		err = nil // Reported by the next call, which finds no further frame
+	}
+	return n, err
+}
+
+// Stops reading the stream; the server is told to stop sending if the body wasn't read to the end
+func (b *http3Body) Close() error {
+	b.stream.CloseRead()
+	return nil
+}
+
+// Reads the type and length of the next frame
+func readHTTP3FrameHeader(stream *quic.Stream) (uint64, uint64, error) {
+	frameType, err := readQUICVarint(stream)
+	if err != nil {
+		return 0, 0, err
+	}
+	length, err := readQUICVarint(stream)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF // The stream ended inside a frame
+	}
+	return frameType, length, err
+}
+
+// Appends a frame to a buffer
+func writeHTTP3Frame(buffer *bytes.Buffer, frameType uint64, payload []byte) {
+	buffer.Write(appendQUICVarint(nil, frameType))
+	buffer.Write(appendQUICVarint(nil, uint64(len(payload))))
+	buffer.Write(payload)
+}
+
+// Appends a QUIC variable-length integer (RFC 9000 section 16)
+func appendQUICVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return append(b, 0x40|byte(v>>8), byte(v))
+	case v < 1<<30:
+		return append(b, 0x80|byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	return append(b, 0xc0|byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// Reads a QUIC variable-length integer
+func readQUICVarint(r io.ByteReader) (uint64, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	v := uint64(first & 0x3f)
+	for range (1 << (first >> 6)) - 1 {
+		next, err := r.ReadByte()
+		if err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		v = v<<8 | uint64(next)
+	}
+	return v, nil
+}
+
+// Encodes the request's header section with QPACK, every field as a literal so no table state is involved
+func encodeHTTP3Fields(request *http.Request) []byte {
+	authority := request.Host
+	if authority == "" {
+		authority = request.URL.Host
+	}
+	section := []byte{0, 0} // Required Insert Count 0, Delta Base 0
+	field := func(name string, value string) {
+		section = appendQPACKInt(section, 0x20, 3, uint64(len(name))) // Literal field line with literal name, no Huffman
+		section = append(section, name...)
+		section = appendQPACKInt(section, 0x00, 7, uint64(len(value)))
+		section = append(section, value...)
+	}
+	field(":method", request.Method)
+	field(":scheme", request.URL.Scheme)
+	field(":authority", authority)
+	field(":path", request.URL.RequestURI())
+	for name, values := range request.Header {
+		lower := strings.ToLower(name)
+		switch lower {
+		case "host", "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade", "te":
+			continue // Connection-specific fields are forbidden in HTTP/3
+		}
+		for _, value := range values {
+			field(lower, value)
+		}
+	}
+	if request.ContentLength > 0 {
+		field("content-length", strconv.FormatInt(request.ContentLength, 10))
+	}
+	if request.Header.Get("User-Agent") == "" {
+		field("user-agent", "Go-http-client/3") // What net/http sends over HTTP/1.1 and HTTP/2, with the protocol's version
+	}
+	return section
+}
+
+// Appends a QPACK prefixed integer with the given first-byte flags and prefix length (RFC 7541 section 5.1)
+func appendQPACKInt(b []byte, flags byte, prefix uint, v uint64) []byte {
+	limit := uint64(1)<<prefix - 1
+	if v < limit {
+		return append(b, flags|byte(v))
+	}
+	b = append(b, flags|byte(limit))
+	for v -= limit; v >= 0x80; v >>= 7 {
+		b = append(b, byte(v)|0x80)
+	}
+	return append(b, byte(v))
+}
+
+// Decodes a response header section into the status code and header; responses may only refer to the static table,
+// as the client advertised no dynamic table
+func decodeHTTP3Fields(section []byte) (int, http.Header, error) {
+	reader := bytes.NewReader(section)
+	insertCount, err := readQPACKInt(reader, 8)
+	if err == nil && insertCount != 0 {
+		err = fmt.Errorf("%w: dynamic table reference", errHTTP3Protocol)
+	}
+	if err == nil {
+		_, err = readQPACKInt(reader, 7) // Delta Base, meaningless without a dynamic table
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	header := make(http.Header)
+	status := 0
+	for reader.Len() > 0 {
+		first, _ := reader.ReadByte()
+		reader.UnreadByte()
+		var name, value string
+		switch {
+		case first&0x80 != 0: // Indexed field line
+			if first&0x40 == 0 {
+				return 0, nil, fmt.Errorf("%w: dynamic table reference", errHTTP3Protocol)
+			}
+			index, err := readQPACKInt(reader, 6)
+			if err != nil || index >= uint64(len(qpackStaticTable)) {
+				return 0, nil, fmt.Errorf("%w: bad static table index", errHTTP3Protocol)
+			}
+			name, value = qpackStaticTable[index][0], qpackStaticTable[index][1]
+		case first&0x40 != 0: // Literal field line with name reference
+			if first&0x10 == 0 {
+				return 0, nil, fmt.Errorf("%w: dynamic table reference", errHTTP3Protocol)
+			}
+			index, err := readQPACKInt(reader, 4)
+			if err != nil || index >= uint64(len(qpackStaticTable)) {
+				return 0, nil, fmt.Errorf("%w: bad static table index", errHTTP3Protocol)
+			}
+			name = qpackStaticTable[index][0]
+			if value, err = readQPACKString(reader, 7); err != nil {
+				return 0, nil, err
+			}
+		case first&0x20 != 0: // Literal field line with literal name
+			if name, err = readQPACKString(reader, 3); err != nil {
+				return 0, nil, err
+			}
+			if value, err = readQPACKString(reader, 7); err != nil {
+				return 0, nil, err
+			}
+		default: // Post-base references only exist with a dynamic table
+			return 0, nil, fmt.Errorf("%w: dynamic table reference", errHTTP3Protocol)
+		}
+		if name == ":status" {
+			if status, err = strconv.Atoi(value); err != nil || status < 100 || status > 999 {
+				return 0, nil, fmt.Errorf("%w: bad :status %q", errHTTP3Protocol, value)
+			}
+			continue
+		}
+		if strings.HasPrefix(name, ":") {
+			return 0, nil, fmt.Errorf("%w: unexpected %s in a response", errHTTP3Protocol, name)
+		}
+		header.Add(http.CanonicalHeaderKey(name), value)
+	}
+	if status == 0 {
+		return 0, nil, fmt.Errorf("%w: response without :status", errHTTP3Protocol)
+	}
+	return status, header, nil
+}
+
+// Reads a QPACK prefixed integer whose prefix is the low bits of the next byte
+func readQPACKInt(reader *bytes.Reader, prefix uint) (uint64, error) {
+	first, err := reader.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("%w: truncated field section", errHTTP3Protocol)
+	}
+	limit := uint64(1)<<prefix - 1
+	v := uint64(first) & limit
+	if v < limit {
+		return v, nil
+	}
+	for shift := uint(0); shift < 63; shift += 7 {
+		next, err := reader.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("%w: truncated field section", errHTTP3Protocol)
+		}
+		v += uint64(next&0x7f) << shift
+		if next&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: integer overflow", errHTTP3Protocol)
+}
+
+// Reads a QPACK string literal whose Huffman flag is the bit above its length prefix
+func readQPACKString(reader *bytes.Reader, prefix uint) (string, error) {
+	first, err := reader.ReadByte()
+	if err != nil {
+		return "", fmt.Errorf("%w: truncated field section", errHTTP3Protocol)
+	}
+	reader.UnreadByte()
+	huffman := first&(1<<prefix) != 0
+	length, err := readQPACKInt(reader, prefix)
+	if err != nil {
+		return "", err
+	}
+	if length > uint64(reader.Len()) {
+		return "", fmt.Errorf("%w: truncated field section", errHTTP3Protocol)
+	}
+	raw := make([]byte, length)
+	reader.Read(raw)
+	if !huffman {
+		return string(raw), nil
+	}
+	decoded, err := hpack.HuffmanDecodeToString(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errHTTP3Protocol, err)
+	}
+	return decoded, nil
+}
+
+// QPACK static table (RFC 9204 appendix A), which differs from HPACK's
+var qpackStaticTable = [...][2]string{
+	{":authority", ""}, {":path", "/"}, {"age", "0"}, {"content-disposition", ""}, {"content-length", "0"},
+	{"cookie", ""}, {"date", ""}, {"etag", ""}, {"if-modified-since", ""}, {"if-none-match", ""},
+	{"last-modified", ""}, {"link", ""}, {"location", ""}, {"referer", ""}, {"set-cookie", ""},
+	{":method", "CONNECT"}, {":method", "DELETE"}, {":method", "GET"}, {":method", "HEAD"}, {":method", "OPTIONS"},
+	{":method", "POST"}, {":method", "PUT"}, {":scheme", "http"}, {":scheme", "https"}, {":status", "103"},
+	{":status", "200"}, {":status", "304"}, {":status", "404"}, {":status", "503"}, {"accept", "*/*"},
+	{"accept", "application/dns-message"}, {"accept-encoding", "gzip, deflate, br"}, {"accept-ranges", "bytes"},
+	{"access-control-allow-headers", "cache-control"}, {"access-control-allow-headers", "content-type"},
+	{"access-control-allow-origin", "*"}, {"cache-control", "max-age=0"}, {"cache-control", "max-age=2592000"},
+	{"cache-control", "max-age=604800"}, {"cache-control", "no-cache"}, {"cache-control", "no-store"},
+	{"cache-control", "public, max-age=31536000"}, {"content-encoding", "br"}, {"content-encoding", "gzip"},
+	{"content-type", "application/dns-message"}, {"content-type", "application/javascript"},
+	{"content-type", "application/json"}, {"content-type", "application/x-www-form-urlencoded"},
+	{"content-type", "image/gif"}, {"content-type", "image/jpeg"}, {"content-type", "image/png"},
+	{"content-type", "text/css"}, {"content-type", "text/html; charset=utf-8"}, {"content-type", "text/plain"},
+	{"content-type", "text/plain;charset=utf-8"}, {"range", "bytes=0-"},
+	{"strict-transport-security", "max-age=31536000"},
+	{"strict-transport-security", "max-age=31536000; includesubdomains"},
+	{"strict-transport-security", "max-age=31536000; includesubdomains; preload"}, {"vary", "accept-encoding"},
+	{"vary", "origin"}, {"x-content-type-options", "nosniff"}, {"x-xss-protection", "1; mode=block"},
+	{":status", "100"}, {":status", "204"}, {":status", "206"}, {":status", "302"}, {":status", "400"},
+	{":status", "403"}, {":status", "421"}, {":status", "425"}, {":status", "500"}, {"accept-language", ""},
+	{"access-control-allow-credentials", "FALSE"}, {"access-control-allow-credentials", "TRUE"},
+	{"access-control-allow-headers", "*"}, {"access-control-allow-methods", "get"},
+	{"access-control-allow-methods", "get, post, options"}, {"access-control-allow-methods", "options"},
+	{"access-control-expose-headers", "content-length"}, {"access-control-request-headers", "content-type"},
+	{"access-control-request-method", "get"}, {"access-control-request-method", "post"}, {"alt-svc", "clear"},
+	{"authorization", ""}, {"content-security-policy", "script-src 'none'; object-src 'none'; base-uri 'none'"},
+	{"early-data", "1"}, {"expect-ct", ""}, {"forwarded", ""}, {"if-range", ""}, {"origin", ""},
+	{"purpose", "prefetch"}, {"server", ""}, {"timing-allow-origin", "*"}, {"upgrade-insecure-requests", "1"},
+	{"user-agent", ""}, {"x-forwarded-for", ""}, {"x-frame-options", "deny"}, {"x-frame-options", "sameorigin"},
+}
@@ -0,0 +1,148 @@
+package main // Shared HTTP client with separate timeouts for each phase of a request
+
+import (
+	"context"  // Enforces the per-file deadline and idle-body cancellation
+	"errors"   // Builds the idle timeout error
+	"fmt"      // Describes idle timeouts
+	"io"       // Wraps response bodies
+	"net"      // Configures the dialer
+	"net/http" // Client and transport
+	"sync"     // Builds the client once
+	"time"     // Timeout durations
+)
+
+// Timeouts for each phase of a request; every one of them is configurable from the command line
+var (
+	dialTimeout           = 10 * time.Second // Establishing the TCP connection
+	tlsHandshakeTimeout   = 10 * time.Second // Completing the TLS handshake
+	responseHeaderTimeout = 30 * time.Second // Waiting for response headers after the request is sent
+	bodyIdleTimeout       = 60 * time.Second // Longest gap between two reads of the response body
+	fileDeadline          = 10 * time.Minute // Overall limit for fetching one file or page, including the body
+)
+
+var (
+	ipVersion     = "any"            // Address family of outgoing connections: any, 4 or 6
+	fallbackDelay = time.Duration(0) // How long a dual-stack dial waits on IPv6 before racing IPv4; 0 uses Go's 300ms, negative disables racing
+)
+
+var (
+	sharedClient     *http.Client // Client reused by every request so connections are pooled
+	sharedClientOnce sync.Once    // Builds sharedClient after the flags are parsed
+)
+
+// Returns the shared client configured with the dial, TLS and header timeouts, or the client set with setHTTPClient
+func httpClient() *http.Client {
+	sharedClientOnce.Do(func() {
+		sharedClient = &http.Client{Transport: newAuditTransport(authTransport{next: middlewareTransport{next: tracingTransport{next: newBaseTransport()}}}), CheckRedirect: checkRedirect} // No overall Timeout: big files are bounded by fileDeadline and the idle timeout instead
+	})
+	return sharedClient
+}
+
+// Builds a transport with the configured dial, TLS and header timeouts
+func newHTTPTransport() *http.Transport {
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second, FallbackDelay: fallbackDelay}
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			if ipVersion != "any" && network == "tcp" {
+				network += ipVersion // tcp4 or tcp6: only addresses of that family are tried
+			}
+			return dialer.DialContext(ctx, network, address)
+		},
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+		IdleConnTimeout:       90 * time.Second,
+		ForceAttemptHTTP2:     true,
+	}
+}
+
+// Checks the -ip-version option
+func validateIPVersion() error {
+	switch ipVersion {
+	case "any", "4", "6":
+		return nil
+	}
+	return fmt.Errorf("unknown -ip-version %q (expected any, 4 or 6)", ipVersion)
+}
+
+// Replaces the client used for scraping and downloading, e.g. one pointed at an httptest server or carrying
+// instrumentation, auth or retries; call it before the run starts. Requests are still audited, and the redirect
+// policy is kept unless the client sets its own CheckRedirect. Target credentials and middleware are added as usual.
+func setHTTPClient(client *http.Client) {
+	sharedClientOnce.Do(func() {}) // Keep httpClient from building the default client later
+	configured := *client
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	configured.Transport = newAuditTransport(authTransport{next: middlewareTransport{next: tracingTransport{next: next}}})
+	if configured.CheckRedirect == nil {
+		configured.CheckRedirect = checkRedirect
+	}
+	sharedClient = &configured
+}
+
+// Wraps the default transport, so instrumentation can observe requests without giving up the configured timeouts
+func wrapHTTPTransport(wrap func(next http.RoundTripper) http.RoundTripper) {
+	setHTTPClient(&http.Client{Transport: wrap(newBaseTransport())})
+}
+
+// Adapts a function to http.RoundTripper, for middleware and canned test responses
+type roundTripperFunc func(request *http.Request) (*http.Response, error)
+
+// Calls the function
+func (f roundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+// Returns a copy of the request bounded by the per-file deadline; call cancel once the body is read
+func withFileDeadline(request *http.Request) (*http.Request, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(request.Context(), fileDeadline)
+	return request.WithContext(ctx), cancel
+}
+
+var errBodyIdle = errors.New("response body stalled") // Reported when bodyIdleTimeout expires
+
+// Cancels a request when its body stops delivering data for longer than the idle timeout
+type idleTimeoutReader struct {
+	body    io.ReadCloser      // Response body being read
+	timer   *time.Timer        // Fires after timeout without a successful read
+	timeout time.Duration      // Allowed gap between reads
+	fired   chan struct{}      // Closed when the timer fired
+	cancel  context.CancelFunc // Aborts the request
+}
+
+// Wraps a response body so a stalled transfer is aborted instead of hanging until the file deadline
+func newIdleTimeoutReader(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) io.ReadCloser {
+	if timeout <= 0 {
+		return body // Idle timeout disabled
+	}
+	reader := &idleTimeoutReader{body: body, timeout: timeout, fired: make(chan struct{}), cancel: cancel}
+	reader.timer = time.AfterFunc(timeout, func() {
+		close(reader.fired)
+		cancel()
+	})
+	return reader
+}
+
+// Reads from the body and restarts the idle timer after every successful read
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	if err != nil && err != io.EOF {
+		select {
+		case <-r.fired:
+			return n, fmt.Errorf("%w: no data for %s", errBodyIdle, r.timeout)
+		default:
+		}
+	}
+	return n, err
+}
+
+// Stops the idle timer and closes the body
+func (r *idleTimeoutReader) Close() error {
+	r.timer.Stop()
+	return r.body.Close()
+}
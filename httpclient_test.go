@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// Serves a listing page and the PDF it links to
+func newDocumentServer(t *testing.T) (*httptest.Server, []byte) {
+	t.Helper()
+	pdf := []byte("%PDF-1.4\n1 0 obj << /Type /Catalog >> endobj\ntrailer << /Root 1 0 R >>\n%%EOF\n")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/index.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><a href="/sheet.pdf">Safety data sheet</a></body></html>`))
+	})
+	mux.HandleFunc("/sheet.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write(pdf)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, pdf
+}
+
+func TestFetchThroughInjectedClient(t *testing.T) {
+	auditLogPath = filepath.Join(t.TempDir(), "audit.jsonl")
+	server, pdf := newDocumentServer(t)
+	setHTTPClient(server.Client())
+
+	page := fetchPage(httpClient(), server.URL+"/index.html")
+	if !strings.Contains(page, `href="/sheet.pdf"`) {
+		t.Fatalf("fetchPage returned %q", page)
+	}
+	fetched, _, err := fetchPDF(context.Background(), httpClient(), server.URL+"/sheet.pdf", "", nil)
+	if err != nil {
+		t.Fatalf("fetchPDF: %v", err)
+	}
+	if !bytes.Equal(fetched.Data, pdf) || fetched.Kind != "pdf" {
+		t.Fatalf("fetchPDF returned %d bytes of kind %q", len(fetched.Data), fetched.Kind)
+	}
+
+	audit, err := os.ReadFile(auditLogPath)
+	if err != nil {
+		t.Fatalf("reading the audit log: %v", err)
+	}
+	if lines := strings.Count(string(audit), "\n"); lines != 2 {
+		t.Fatalf("audit log has %d records, want 2: the injected client must still be audited", lines)
+	}
+}
+
+func TestWrapHTTPTransportSeesRequests(t *testing.T) {
+	auditLogPath = ""
+	server, _ := newDocumentServer(t)
+	var seen atomic.Int32
+	wrapHTTPTransport(func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+			seen.Add(1)
+			return next.RoundTrip(request)
+		})
+	})
+
+	if page := fetchPage(httpClient(), server.URL+"/index.html"); page == "" {
+		t.Fatal("fetchPage returned nothing")
+	}
+	if _, _, err := fetchPDF(context.Background(), httpClient(), server.URL+"/sheet.pdf", "", nil); err != nil {
+		t.Fatalf("fetchPDF: %v", err)
+	}
+	if got := seen.Load(); got != 2 {
+		t.Fatalf("wrapper saw %d requests, want 2", got)
+	}
+}
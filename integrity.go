@@ -0,0 +1,257 @@
+package main // Hash-chained log of Merkle roots over the manifest, so retroactive edits to the archive's history show
+
+import (
+	"bufio"         // Reads the log line by line
+	"bytes"         // Skips blank lines
+	"crypto/sha256" // Hashes leaves, nodes and records
+	"encoding/hex"  // Encodes digests
+	"encoding/json" // Encodes log records
+	"flag"          // Parses the verify-integrity options
+	"fmt"           // Builds verification errors and prints results
+	"log"           // Reports log write failures
+	"os"            // Reads and appends to the log
+	"path/filepath" // Derives the log path from the manifest's
+	"strings"       // Derives the log path and normalizes -root
+	"sync"          // Serializes appends
+	"time"          // Timestamps records
+)
+
+var recordIntegrity = true // Whether every manifest save appends its Merkle root to <manifest>.integrity.jsonl
+
+var integrityMu sync.Mutex // Keeps two saves from chaining onto the same record
+
+// One manifest save as recorded in the integrity log; each record commits to its predecessor, so no record or root
+// can be altered or removed without breaking every later hash
+type integrityRecord struct {
+	Seq      int       `json:"seq"`                // Position in the chain, starting at 1
+	At       time.Time `json:"at"`                 // When the manifest was saved
+	RunID    string    `json:"run_id"`             // Run that saved it
+	Entries  int       `json:"entries"`            // Manifest entries the root covers
+	Root     string    `json:"root"`               // Merkle root over the entries
+	Previous string    `json:"previous,omitempty"` // Hash of the preceding record, absent for the first
+	Hash     string    `json:"hash,omitempty"`     // SHA-256 of this record with hash left out
+
+	UnrecordedChange bool `json:"unrecorded_change,omitempty"` // The manifest was edited outside the scraper since the preceding record
+}
+
+// Returns where the integrity log of a manifest is kept, e.g. manifest.integrity.jsonl
+func integrityLogFilePath(manifestPath string) string {
+	return strings.TrimSuffix(manifestPath, filepath.Ext(manifestPath)) + ".integrity.jsonl"
+}
+
+// Hashes one manifest entry as a Merkle leaf. last_seen is left out: it changes every run without the document
+// changing, and -stable-manifest keeps it elsewhere
+func merkleLeaf(entry manifestEntry) ([32]byte, error) {
+	entry.LastSeen = time.Time{}
+	data, err := encodeManifestEntries([]manifestEntry{entry}) // Canonical bytes, independent of how the entry was loaded
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return sha256.Sum256(append([]byte{0}, data...)), nil // Leaves and nodes are prefixed differently so neither can pose as the other
+}
+
+// Computes the Merkle root over entries in manifest order; an unpaired node moves up a level unchanged
+func merkleRoot(entries []manifestEntry) (string, error) {
+	level := make([][32]byte, 0, len(entries))
+	for _, entry := range entries {
+		leaf, err := merkleLeaf(entry)
+		if err != nil {
+			return "", err
+		}
+		level = append(level, leaf)
+	}
+	if len(level) == 0 {
+		empty := sha256.Sum256(nil)
+		return hex.EncodeToString(empty[:]), nil
+	}
+	for len(level) > 1 {
+		var next [][32]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, sha256.Sum256(append(append([]byte{1}, level[i][:]...), level[i+1][:]...)))
+		}
+		level = next
+	}
+	return hex.EncodeToString(level[0][:]), nil
+}
+
+// Hashes a record with its hash field left out
+func (r integrityRecord) digest() (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Reads every record of an integrity log; a missing log has none
+func readIntegrityLog(filePath string) ([]integrityRecord, error) {
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var records []integrityRecord
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record integrityRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", filePath, line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// Reports whether entries, as loaded from the manifest, still have the root its integrity log recorded last;
+// a manifest without a log matches. A mismatch is logged, since it means the manifest was edited by hand
+func matchesRecordedRoot(manifestPath string, entries []manifestEntry) bool {
+	if !recordIntegrity {
+		return true
+	}
+	integrityMu.Lock()
+	records, err := readIntegrityLog(integrityLogFilePath(manifestPath))
+	integrityMu.Unlock()
+	if err != nil || len(records) == 0 {
+		return true // Nothing to compare with; appending reports an unreadable log
+	}
+	root, err := merkleRoot(entries)
+	if err != nil {
+		log.Println(err)
+		return true
+	}
+	last := records[len(records)-1]
+	if root == last.Root {
+		return true
+	}
+	log.Printf("Warning: %s was changed outside the scraper since %s; the next saved root is flagged as following an unrecorded change", manifestPath, last.At.Format(time.RFC3339))
+	return false
+}
+
+// Appends the root of the entries just saved to the manifest's integrity log, chained to the last record;
+// unrecorded marks a save that follows a change made outside the scraper
+func appendIntegrityRecord(manifestPath string, entries []manifestEntry, unrecorded bool) {
+	if !recordIntegrity {
+		return
+	}
+	root, err := merkleRoot(entries)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	integrityMu.Lock()
+	defer integrityMu.Unlock()
+	logPath := integrityLogFilePath(manifestPath)
+	records, err := readIntegrityLog(logPath)
+	if err != nil {
+		log.Printf("Not recording the manifest root: %v", err) // Never chain onto a log that can't be read back
+		return
+	}
+	record := integrityRecord{Seq: 1, At: time.Now().UTC(), RunID: runID(), Entries: len(entries), Root: root, UnrecordedChange: unrecorded}
+	if len(records) > 0 {
+		last := records[len(records)-1]
+		record.Seq, record.Previous = last.Seq+1, last.Hash
+	}
+	if record.Hash, err = record.digest(); err != nil {
+		log.Println(err)
+		return
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	file, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.Printf("Failed to open integrity log %s: %v", logPath, err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Printf("Failed to write integrity log %s: %v", logPath, err)
+		return
+	}
+	if err := file.Sync(); err != nil {
+		log.Printf("Failed to flush integrity log %s: %v", logPath, err)
+	}
+}
+
+// Checks that every record hashes to its recorded hash and points at its predecessor
+func verifyIntegrityChain(records []integrityRecord) error {
+	previous := ""
+	for i, record := range records {
+		digest, err := record.digest()
+		if err != nil {
+			return err
+		}
+		switch {
+		case record.Seq != i+1:
+			return fmt.Errorf("record %d has sequence number %d; records were removed or reordered", i+1, record.Seq)
+		case record.Previous != previous:
+			return fmt.Errorf("record %d doesn't point at record %d", record.Seq, record.Seq-1)
+		case record.Hash != digest:
+			return fmt.Errorf("record %d (%s) was altered after it was written", record.Seq, record.At.Format(time.RFC3339))
+		}
+		previous = record.Hash
+	}
+	return nil
+}
+
+// Verifies the integrity log and that the manifest still has the root recorded by the last save
+func runVerifyIntegrity(args []string) error {
+	flags := flag.NewFlagSet("verify-integrity", flag.ExitOnError) // Options specific to verify-integrity
+	manifestPath := flags.String("manifest", manifestFilePath, "manifest to verify")
+	expected := flags.String("root", "", "also require this root, e.g. one handed to an auditor earlier, to appear in the chain")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	logPath := integrityLogFilePath(*manifestPath)
+	records, err := readIntegrityLog(logPath)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("%s has no records", logPath)
+	}
+	if err := verifyIntegrityChain(records); err != nil {
+		return fmt.Errorf("%s: %w", logPath, err)
+	}
+	if *expected != "" {
+		found := false
+		for _, record := range records {
+			if record.Root == strings.ToLower(strings.TrimSpace(*expected)) {
+				fmt.Printf("Root %s was recorded at %s by run %s (record %d)\n", record.Root, record.At.Format(time.RFC3339), record.RunID, record.Seq)
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("root %s isn't in %s", *expected, logPath)
+		}
+	}
+	root, err := merkleRoot(loadManifest(*manifestPath).all())
+	if err != nil {
+		return err
+	}
+	last := records[len(records)-1]
+	if root != last.Root {
+		return fmt.Errorf("%s was changed after it was last saved at %s: its root is %s, %s recorded %s", *manifestPath, last.At.Format(time.RFC3339), root, logPath, last.Root)
+	}
+	for _, record := range records {
+		if record.UnrecordedChange {
+			fmt.Printf("Record %d (%s, run %s) follows a change made outside the scraper\n", record.Seq, record.At.Format(time.RFC3339), record.RunID)
+		}
+	}
+	fmt.Printf("Chain of %d records intact; %s matches root %s recorded at %s by run %s\n", len(records), *manifestPath, root, last.At.Format(time.RFC3339), last.RunID)
+	return nil
+}
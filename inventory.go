@@ -0,0 +1,220 @@
+package main // Cross-references an on-site chemical inventory with the downloaded sheets
+
+import (
+	"encoding/csv" // Reads the inventory file
+	"flag"         // Parses the inventory report options
+	"fmt"          // Prints the cross-reference report
+	"os"           // Opens the inventory and local PDFs
+	"path"         // Takes file names from URLs
+	"strings"      // Normalizes product names
+	"unicode"      // Splits names into words
+)
+
+var (
+	inventoryFilePath string          // CSV of products kept on site; empty disables cross-referencing
+	inventoryOnly     bool            // Only download documents matching an inventory product
+	inventoryItems    []inventoryItem // Products loaded from inventoryFilePath
+)
+
+// Words that appear in almost every product or file name and would match anything
+var inventoryStopWords = map[string]bool{"pool": true, "season": true, "ps": true, "sds": true, "msds": true, "the": true, "and": true, "for": true, "english": true, "spanish": true}
+
+// A product listed in the on-site inventory
+type inventoryItem struct {
+	Name string // Product name as written in the inventory
+	UPC  string // UPC or other barcode digits, if the inventory has them
+}
+
+// An inventory product together with the archived sheets that cover it
+type inventoryMatch struct {
+	Item    inventoryItem   // Product from the inventory
+	Entries []manifestEntry // Matching documents; empty when the SDS is missing
+}
+
+// Reads an inventory CSV; a header row naming "product"/"name" and "upc" columns is optional
+func loadInventory(filePath string) ([]inventoryItem, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1 // Spreadsheets export ragged rows
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading inventory %s: %w", filePath, err)
+	}
+
+	nameColumn, upcColumn := 0, -1 // Without a header the first column is the product name
+	if len(records) > 0 {
+		headerFound := false
+		for i, cell := range records[0] {
+			switch strings.ToLower(strings.TrimSpace(cell)) {
+			case "product", "name", "product name", "description":
+				nameColumn, headerFound = i, true
+			case "upc", "barcode", "gtin", "ean":
+				upcColumn, headerFound = i, true
+			}
+		}
+		if headerFound {
+			records = records[1:] // Skip the header row
+		}
+	}
+
+	var items []inventoryItem
+	for _, record := range records {
+		item := inventoryItem{}
+		if nameColumn < len(record) {
+			item.Name = strings.TrimSpace(record[nameColumn])
+		}
+		if upcColumn >= 0 && upcColumn < len(record) {
+			item.UPC = digitsOnly(record[upcColumn])
+		}
+		if item.Name == "" && item.UPC == "" {
+			continue // Blank line
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Splits a product or file name into lowercase words, dropping stop words and dates
+func productTokens(name string) []string {
+	var tokens []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(name), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		if inventoryStopWords[word] || (len(word) >= 4 && strings.Trim(word, "0123456789") == "") {
+			continue // Years and other long numbers come from revision dates, not product names
+		}
+		tokens = append(tokens, word)
+	}
+	return tokens
+}
+
+// Reports whether two words match, allowing abbreviations like "chlor" for "chlorinating"
+func tokensMatch(a string, b string) bool {
+	if a == b {
+		return true
+	}
+	if len(a) < 4 || len(b) < 4 {
+		return false // Short words must match exactly
+	}
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+// Reports whether every significant word of the product name appears in the document name
+func matchesProductName(productName string, documentName string) bool {
+	wanted := productTokens(productName)
+	if len(wanted) == 0 {
+		return false
+	}
+	available := productTokens(documentName)
+	for _, word := range wanted {
+		found := false
+		for _, candidate := range available {
+			if tokensMatch(word, candidate) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether a discovered document belongs to any inventory product
+func inventoryIncludes(items []inventoryItem, documentURL string) bool {
+	for _, item := range items {
+		if matchesProductName(item.Name, path.Base(documentURL)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches each inventory product with archived documents by name, falling back to the UPC in the sheet text
+func crossReferenceInventory(items []inventoryItem, entries []manifestEntry) []inventoryMatch {
+	texts := make(map[string]string) // Extracted sheet text, read at most once per file
+	documentText := func(entry manifestEntry) string {
+		text, cached := texts[entry.File]
+		if !cached {
+			if data, err := os.ReadFile(entry.File); err == nil {
+				text, _ = extractPDFText(data)
+			}
+			texts[entry.File] = digitsOnly(text) // Barcodes are compared as bare digits
+		}
+		return texts[entry.File]
+	}
+
+	matches := make([]inventoryMatch, 0, len(items))
+	for _, item := range items {
+		match := inventoryMatch{Item: item}
+		for _, entry := range entries {
+			if matchesProductName(item.Name, path.Base(entry.File)) {
+				match.Entries = append(match.Entries, entry)
+			}
+		}
+		if len(match.Entries) == 0 && len(item.UPC) >= 8 { // Names differ; try the barcode printed on the sheet
+			for _, entry := range entries {
+				if strings.Contains(documentText(entry), item.UPC) {
+					match.Entries = append(match.Entries, entry)
+				}
+			}
+		}
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+// Removes everything but ASCII digits
+func digitsOnly(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// Prints which inventory products have a downloaded SDS and which are missing
+func runInventoryReport(args []string) error {
+	flags := flag.NewFlagSet("report inventory", flag.ExitOnError) // Options specific to this report
+	inventoryPath := flags.String("inventory", inventoryFilePath, "CSV of on-site products (name and optional UPC columns)")
+	manifestPath := flags.String("manifest", manifestFilePath, "manifest describing the archive")
+	failOnMissing := flags.Bool("fail-on-missing", false, "exit with an error when any product has no SDS")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *inventoryPath == "" {
+		return fmt.Errorf("-inventory is required")
+	}
+	items, err := loadInventory(*inventoryPath)
+	if err != nil {
+		return err
+	}
+
+	var missing []inventoryItem
+	fmt.Println("Products with an SDS:")
+	for _, match := range crossReferenceInventory(items, loadManifest(*manifestPath).list()) {
+		if len(match.Entries) == 0 {
+			missing = append(missing, match.Item)
+			continue
+		}
+		for _, entry := range match.Entries {
+			fmt.Printf("  %-40s  %s\n", match.Item.Name, entry.File)
+		}
+	}
+	fmt.Printf("Products missing an SDS: %d\n", len(missing))
+	for _, item := range missing {
+		if item.UPC != "" {
+			fmt.Printf("  %s (UPC %s)\n", item.Name, item.UPC)
+		} else {
+			fmt.Printf("  %s\n", item.Name)
+		}
+	}
+	if *failOnMissing && len(missing) > 0 {
+		return fmt.Errorf("%d inventory products have no SDS", len(missing))
+	}
+	return nil
+}
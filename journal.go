@@ -0,0 +1,184 @@
+package main // Write-ahead journal protecting the manifest against crashes mid-run
+
+import (
+	"bufio"         // Reads the journal line by line
+	"encoding/json" // Encodes journaled operations
+	"fmt"           // Formats checksums
+	"hash/crc32"    // Detects torn or corrupted journal lines
+	"log"           // Reports replayed and rolled back operations
+	"os"            // Opens, truncates and removes the journal file
+	"path/filepath" // Derives the journal file name
+	"strings"       // Splits checksum from payload
+	"time"          // Carries last-seen times
+)
+
+// Operations recorded in the journal
+const (
+	journalRecord = "record" // Store an entry, replacing any with the same key
+	journalRemove = "remove" // Drop the entry with the given key
+	journalSeen   = "seen"   // Mark every variant of a URL as seen at a time
+)
+
+// One manifest update as written to the journal; replaying it twice leaves the same state as replaying it once
+type journalOperation struct {
+	Op    string         `json:"op"`              // One of the journal* operations
+	Entry *manifestEntry `json:"entry,omitempty"` // Entry to store or remove
+	URL   string         `json:"url,omitempty"`   // URL marked as seen
+	At    time.Time      `json:"at,omitzero"`     // Time the URL was seen
+}
+
+// Returns where updates to a manifest are journaled until the next save, e.g. manifest.journal
+func journalFilePath(manifestPath string) string {
+	return strings.TrimSuffix(manifestPath, filepath.Ext(manifestPath)) + ".journal"
+}
+
+// Applies one journaled operation to the entries; the caller holds the lock
+func (m *manifest) apply(operation journalOperation) {
+	switch operation.Op {
+	case journalRecord:
+		m.entries[operation.Entry.key()] = *operation.Entry
+	case journalRemove:
+		delete(m.entries, operation.Entry.key())
+	case journalSeen:
+		for key, entry := range m.entries {
+			if entry.URL == operation.URL {
+				entry.LastSeen = operation.At
+				m.entries[key] = entry
+			}
+		}
+	}
+}
+
+// Replays the complete operations left by an interrupted run and rolls back the rest: a torn or corrupted line
+// and everything after it are ignored. Returns how many operations were replayed and rolled back
+func (m *manifest) replayJournal(filePath string) (int, int) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println(err)
+		}
+		return 0, 0
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	replayed, rolledBack := 0, 0
+	intact := true // Turns false at the first line that can't be trusted
+	for {
+		line, err := reader.ReadString('\n')
+		if line == "" {
+			break
+		}
+		if intact {
+			operation, ok := decodeJournalLine(line, err == nil)
+			if ok {
+				m.apply(operation)
+				replayed++
+				continue
+			}
+			intact = false // Later lines were written after a write that never finished
+		}
+		rolledBack++
+	}
+	return replayed, rolledBack
+}
+
+// Decodes "<crc32> <json>\n"; complete is false when the line has no final newline
+func decodeJournalLine(line string, complete bool) (journalOperation, bool) {
+	var operation journalOperation
+	sum, payload, found := strings.Cut(strings.TrimSuffix(line, "\n"), " ")
+	if !complete || !found || sum != journalChecksum(payload) {
+		return operation, false
+	}
+	if err := json.Unmarshal([]byte(payload), &operation); err != nil {
+		return operation, false
+	}
+	if (operation.Op == journalRecord || operation.Op == journalRemove) && operation.Entry == nil {
+		return operation, false
+	}
+	return operation, operation.Op == journalRecord || operation.Op == journalRemove || operation.Op == journalSeen
+}
+
+// Returns the checksum written in front of a journal payload
+func journalChecksum(payload string) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(payload)))
+}
+
+// Applies the journal an interrupted run left next to the manifest at manifestPath
+func (m *manifest) recoverJournal(manifestPath string) {
+	m.replayed, m.rolledBack = m.replayJournal(journalFilePath(manifestPath))
+	if m.replayed > 0 || m.rolledBack > 0 {
+		log.Printf("Recovered %d manifest updates from %s; rolled back %d incomplete", m.replayed, journalFilePath(manifestPath), m.rolledBack)
+	}
+}
+
+// Starts journaling updates for a manifest that will be saved to manifestPath. Operations replayed from an
+// interrupted run are saved into the manifest first so the new journal starts empty
+func (m *manifest) openJournal(manifestPath string) error {
+	if m.replayed > 0 || m.rolledBack > 0 || fileExists(journalFilePath(manifestPath)) {
+		m.save(manifestPath) // Checkpoint the recovered state
+		m.replayed, m.rolledBack = 0, 0
+	}
+	file, err := os.OpenFile(journalFilePath(manifestPath), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.journal = file
+	return nil
+}
+
+// Appends an operation to the journal, if one is open; the caller holds the lock. Record and remove operations
+// are flushed to disk before returning, while last-seen updates are cheap to lose and aren't
+func (m *manifest) writeJournal(operation journalOperation) {
+	if m.journal == nil {
+		return
+	}
+	payload, err := json.Marshal(operation)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if _, err := m.journal.WriteString(journalChecksum(string(payload)) + " " + string(payload) + "\n"); err != nil {
+		log.Printf("Failed to journal manifest update: %v", err)
+		return
+	}
+	if operation.Op != journalSeen {
+		if err := m.journal.Sync(); err != nil {
+			log.Printf("Failed to flush manifest journal: %v", err)
+		}
+	}
+}
+
+// Empties the journal once its operations are part of the saved manifest
+func (m *manifest) clearJournal(manifestPath string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.journal == nil {
+		if err := os.Remove(journalFilePath(manifestPath)); err != nil && !os.IsNotExist(err) {
+			log.Println(err)
+		}
+		return
+	}
+	if err := m.journal.Truncate(0); err != nil {
+		log.Printf("Failed to clear manifest journal: %v", err)
+	} else if _, err := m.journal.Seek(0, 0); err != nil {
+		log.Println(err)
+	}
+}
+
+// Closes the journal, removing it when every update was saved; unsaved updates are left for the next run to recover
+func (m *manifest) closeJournal(manifestPath string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.journal == nil {
+		return
+	}
+	info, err := m.journal.Stat()
+	m.journal.Close()
+	m.journal = nil
+	if err == nil && info.Size() == 0 {
+		os.Remove(journalFilePath(manifestPath))
+	}
+}
@@ -0,0 +1,111 @@
+package main // JUnit XML run reports for CI dashboards
+
+import (
+	"encoding/xml" // Encodes the report
+	"fmt"          // Formats durations
+	"os"           // Writes the report
+	"time"         // Times each document
+)
+
+var junitReportPath = "" // JUnit XML report written after every run; empty disables it
+
+// What a run did with one document
+const (
+	outcomeDownloaded = "downloaded" // Newly stored
+	outcomeSkipped    = "skipped"    // Not downloaded, e.g. already archived or its host was failing
+	outcomeFailed     = "failed"     // Every attempt failed
+)
+
+// What happened to one document variant during a run
+type documentOutcome struct {
+	URL      string        // Document URL
+	Language string        // Requested language variant, empty when none was asked for
+	Status   string        // outcomeDownloaded, outcomeSkipped or outcomeFailed
+	Reason   skipReason    // Why it was skipped
+	File     string        // Storage key, when the document is archived
+	Message  string        // Why it was skipped or failed
+	Duration time.Duration // Time spent on the document
+}
+
+// Returns the outcome of a document that could not be archived
+func failedOutcome(err error) documentOutcome {
+	return documentOutcome{Status: outcomeFailed, Message: err.Error()}
+}
+
+// Root element of a JUnit report
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+// One run as a test suite
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+// One document as a test case
+type junitCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+// Message attached to a failed or skipped test case
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// Formats a duration in seconds as JUnit expects
+func junitSeconds(duration time.Duration) string {
+	return fmt.Sprintf("%.3f", duration.Seconds())
+}
+
+// Writes the run as a JUnit report: one test case per document, grouped under its domain, that passes when the
+// document is archived, fails when every attempt failed and is skipped otherwise
+func writeJUnitReport(filePath string, summary runSummary) error {
+	elapsed := time.Since(summary.Started)
+	suite := junitSuite{
+		Name:      "archive run " + summary.RunID,
+		Time:      junitSeconds(elapsed),
+		Timestamp: summary.Started.Format("2006-01-02T15:04:05"), // JUnit timestamps carry no zone
+	}
+	for _, outcome := range summary.Documents {
+		name := outcome.URL
+		if outcome.Language != "" {
+			name += " [" + outcome.Language + "]"
+		}
+		testCase := junitCase{ClassName: normalizeDomain(getDomainFromURL(outcome.URL)), Name: name, Time: junitSeconds(outcome.Duration), SystemOut: outcome.File}
+		switch outcome.Status {
+		case outcomeFailed:
+			testCase.Failure = &junitMessage{Message: outcome.Message}
+			suite.Failures++
+		case outcomeSkipped:
+			testCase.Skipped = &junitMessage{Message: string(outcome.Reason) + ": " + outcome.Message}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	suite.Tests = len(suite.Cases)
+	report := junitSuites{Name: "poolseason-scraper", Tests: suite.Tests, Failures: suite.Failures, Skipped: suite.Skipped, Time: suite.Time, Suites: []junitSuite{suite}}
+
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, append([]byte(xml.Header), append(data, '\n')...), 0o644)
+}
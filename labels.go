@@ -0,0 +1,62 @@
+package main // Extraction profiles choosing which document types are downloaded, with product labels kept apart
+
+import (
+	"fmt"     // Builds validation errors
+	"strings" // Parses the profile list and rewrites paths
+)
+
+var (
+	labelOutputDir   = "Labels/" // Directory for product labels when the labels profile is enabled
+	documentProfiles = ""        // Comma-separated document types to download: sds, labels, other; empty downloads everything
+	profileTypes     = map[string]bool{}
+)
+
+// Profile names accepted by -profiles, mapped to the document types they select
+var profileDocumentTypes = map[string]string{
+	"sds":    documentTypeSDS,
+	"labels": documentTypeLabel,
+	"other":  documentTypeOther,
+}
+
+// Checks the -profiles option and resolves it into the document types to download
+func validateProfiles() error {
+	clear(profileTypes)
+	if strings.TrimSpace(documentProfiles) == "" {
+		return nil
+	}
+	if sdsOnly {
+		return fmt.Errorf("-sds-only and -profiles can't be combined; use -profiles sds")
+	}
+	for _, profile := range strings.Split(documentProfiles, ",") {
+		documentType, known := profileDocumentTypes[strings.ToLower(strings.TrimSpace(profile))]
+		if !known {
+			return fmt.Errorf("unknown profile %q in -profiles (expected sds, labels or other)", profile)
+		}
+		profileTypes[documentType] = true
+	}
+	return nil
+}
+
+// Reports whether documents of a type are downloaded; undetermined documents always are, so nothing is lost to a
+// classifier that can't decide
+func wantsDocumentType(documentType string) bool {
+	switch {
+	case documentType == "":
+		return true
+	case len(profileTypes) > 0:
+		return profileTypes[documentType]
+	case sdsOnly:
+		return documentType == documentTypeSDS
+	}
+	return true
+}
+
+// Moves labels from the PDF directory to the label directory, keeping any domain subdirectory, when the labels
+// profile is enabled
+func routeByType(filePath string, documentType string) string {
+	pdfDir := strings.TrimSuffix(pdfOutputDir, "/") + "/"
+	if documentType != documentTypeLabel || !profileTypes[documentTypeLabel] || !strings.HasPrefix(filePath, pdfDir) {
+		return filePath
+	}
+	return strings.TrimSuffix(labelOutputDir, "/") + "/" + strings.TrimPrefix(filePath, pdfDir)
+}
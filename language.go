@@ -0,0 +1,47 @@
+package main // Accept-Language negotiation for multilingual vendor endpoints
+
+import (
+	"net/http" // Sets the Accept-Language header on outgoing requests
+	"regexp"   // Sanitizes language tags for use in filenames
+	"strings"  // Splits and normalizes the configured language list
+)
+
+var acceptLanguages = "" // Comma-separated language tags to request (e.g. "en-US,es"); empty sends no header
+
+// Splits the configured language list into clean, de-duplicated tags
+func parseLanguages(list string) []string {
+	var languages []string                              // Parsed language tags
+	for _, language := range strings.Split(list, ",") { // Each comma-separated entry is one tag
+		language = strings.TrimSpace(language) // Drop surrounding whitespace
+		if language != "" {
+			languages = append(languages, language) // Keep non-empty tags
+		}
+	}
+	return removeDuplicatesFromSlice(languages) // Ignore repeated tags
+}
+
+// Returns the languages each document should be requested in; an empty tag means no preference
+func downloadLanguages() []string {
+	languages := parseLanguages(acceptLanguages) // Read the configured tags
+	if len(languages) == 0 {                     // No configuration keeps the original behaviour
+		return []string{""}
+	}
+	return languages // Request every configured variant
+}
+
+// Adds the Accept-Language header to a request when a language is given
+func setAcceptLanguage(request *http.Request, language string) {
+	if language != "" {
+		request.Header.Set("Accept-Language", language) // Ask the server for this language variant
+	}
+}
+
+// Inserts a language tag before the file extension (e.g. sds.pdf → sds_es.pdf)
+func languageTaggedFilename(filename string, language string) string {
+	if language == "" { // Untagged downloads keep their plain name
+		return filename
+	}
+	tag := regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(strings.ToLower(language), "_") // Filename-safe tag
+	ext := getFileExtension(filename)                                                        // Keep the original extension
+	return strings.TrimSuffix(filename, ext) + "_" + tag + ext                               // Tag goes before the extension
+}
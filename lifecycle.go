@@ -0,0 +1,258 @@
+package main // Lifecycle state of every document the scraper has come across, with the history of its transitions
+
+import (
+	"encoding/csv" // Writes the lifecycle report
+	"flag"         // Parses the report options
+	"fmt"          // Formats errors and counts
+	"io"           // Writes to a file or standard output
+	"os"           // Creates the output file
+	"slices"       // Checks state filters
+	"strings"      // Parses state lists
+	"time"         // Stamps transitions
+)
+
+// Lifecycle states of a document
+const (
+	stateDiscovered  = "discovered"        // Listed on the site but not archived yet
+	stateDownloaded  = "downloaded"        // Archived, and the archived copy is current
+	stateSuperseded  = "superseded"        // Archived, but the site has a newer revision of the same sheet under another URL
+	stateRemoved     = "removed-from-site" // No longer listed on the site, or its link leads to a not-found page
+	stateQuarantined = "quarantined"       // The last download was flagged by the virus scanner
+	stateFailed      = "failed"            // The last download attempt failed
+)
+
+var lifecycleStates = []string{stateDiscovered, stateDownloaded, stateSuperseded, stateRemoved, stateQuarantined, stateFailed} // In report order
+
+var partialDiscovery = false // The run crawls only some targets, so documents it doesn't list may still be on the site
+
+const maxStateTransitions = 20 // Transitions kept per document, newest last, so a flapping link can't grow the manifest without bound
+
+// One change of a document's state
+type stateTransition struct {
+	From   string    `json:"from,omitempty"`   // Previous state; empty for the first transition
+	To     string    `json:"to"`               // New state
+	At     time.Time `json:"at"`               // When it changed
+	RunID  string    `json:"run_id,omitempty"` // Run that changed it
+	Reason string    `json:"reason,omitempty"` // Why, e.g. the error of a failed download
+}
+
+// Reports whether the document has a copy in the archive; entries of documents that were only discovered, failed
+// or quarantined before their first download have no file
+func (e manifestEntry) archived() bool {
+	return e.File != ""
+}
+
+// Returns the document's state; entries written before states were tracked are downloaded when archived
+func (e manifestEntry) state() string {
+	switch {
+	case e.State != "":
+		return e.State
+	case e.archived():
+		return stateDownloaded
+	}
+	return stateDiscovered
+}
+
+// Moves the document to a state and records the transition; reports whether the state changed
+func (e *manifestEntry) moveTo(state string, at time.Time, reason string) bool {
+	from := e.State
+	if from == "" && e.archived() && state != stateDownloaded {
+		from = stateDownloaded // Entries written before states were tracked were downloaded
+	}
+	if from == state {
+		return false
+	}
+	e.State, e.StateChangedAt = state, at.UTC()
+	e.Transitions = append(slices.Clone(e.Transitions), stateTransition{From: from, To: state, At: at.UTC(), RunID: runID(), Reason: reason})
+	if len(e.Transitions) > maxStateTransitions {
+		e.Transitions = e.Transitions[len(e.Transitions)-maxStateTransitions:]
+	}
+	return true
+}
+
+// Moves a document variant to a state, creating an entry without a file for documents that aren't archived
+func (m *manifest) setState(rawURL string, language string, category string, state string, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := manifestEntry{URL: rawURL, Language: language}.key()
+	entry, found := m.entries[key]
+	if !found {
+		entry = manifestEntry{URL: rawURL, Language: language, Category: category, Domain: normalizeDomain(getDomainFromURL(rawURL))}
+	}
+	if !entry.moveTo(state, time.Now(), reason) {
+		return
+	}
+	m.entries[key] = entry
+	m.writeJournal(journalOperation{Op: journalRecord, Entry: &entry})
+}
+
+// Records the state a finished download attempt leaves its document in; downloads are recorded as they're stored
+func (m *manifest) noteOutcome(document pdfDocument, language string, outcome documentOutcome) {
+	switch {
+	case outcome.Status == outcomeFailed:
+		m.setState(document.URL, language, document.Category, stateFailed, outcome.Message)
+	case outcome.Status == outcomeSkipped && outcome.Reason == skipInfected:
+		m.setState(document.URL, language, document.Category, stateQuarantined, outcome.Message)
+	case outcome.Status == outcomeSkipped && outcome.Reason == skipMissing:
+		m.setState(document.URL, language, document.Category, stateRemoved, outcome.Message)
+	case outcome.Status == outcomeSkipped && outcome.Reason == skipExists:
+		if entry, found := m.lookup(document.URL, language); found && (entry.state() == stateFailed || entry.state() == stateQuarantined) {
+			m.setState(document.URL, language, document.Category, stateDownloaded, "the archived copy is current")
+		}
+	}
+}
+
+// Updates states from a run's discovery: variants not in the manifest are discovered, archived documents listed
+// again are back from removed-from-site, and archived documents of the crawled domains that weren't listed are
+// removed-from-site. Nothing is marked removed when discovery found nothing, which is more likely a broken listing,
+// or when the run crawled only some of the targets
+func (m *manifest) noteDiscovery(documents []pdfDocument, languages []string) {
+	if len(documents) == 0 {
+		return
+	}
+	listed := make(map[string]bool, len(documents)) // URLs found by this run
+	domains := make(map[string]bool)                // Domains this run crawled or found documents on
+	for _, target := range scrapeTargets {
+		domains[normalizeDomain(getDomainFromURL(target.URL))] = true
+	}
+	for _, document := range documents {
+		listed[document.URL] = true
+		domains[normalizeDomain(getDomainFromURL(document.URL))] = true
+		for _, language := range languages {
+			if entry, found := m.tracked(document.URL, language); !found {
+				m.setState(document.URL, language, document.Category, stateDiscovered, "")
+			} else if entry.state() == stateRemoved && entry.archived() {
+				m.setState(document.URL, language, document.Category, stateDownloaded, "listed on the site again")
+			}
+		}
+	}
+	if partialDiscovery {
+		return
+	}
+	for _, entry := range m.all() {
+		if !listed[entry.URL] && domains[entry.Domain] && entry.state() != stateRemoved {
+			m.setState(entry.URL, entry.Language, entry.Category, stateRemoved, "not listed on the site")
+		}
+	}
+}
+
+// Marks archived sheets superseded when the same product in the same language has a sheet with a later printed
+// revision date under another URL of its domain, and moves them back when that's no longer so
+func (m *manifest) noteSuperseded() {
+	newest := make(map[string]time.Time) // Latest revision date by domain, product and language
+	sheetKey := func(entry manifestEntry) string {
+		slug := productSlug(entry.SDS.Product)
+		if slug == "" || entry.SDS.revisionTime().IsZero() || !entry.archived() || entry.state() == stateRemoved {
+			return "" // Only sheets whose product and revision date were read can supersede each other
+		}
+		return entry.Domain + "/" + slug + "/" + entry.SDS.Language + "/" + entry.Language
+	}
+	entries := m.all()
+	for _, entry := range entries {
+		if key := sheetKey(entry); key != "" && entry.SDS.revisionTime().After(newest[key]) {
+			newest[key] = entry.SDS.revisionTime()
+		}
+	}
+	for _, entry := range entries {
+		key := sheetKey(entry)
+		if key == "" {
+			continue
+		}
+		older := entry.SDS.revisionTime().Before(newest[key])
+		switch state := entry.state(); {
+		case older && state != stateSuperseded:
+			m.setState(entry.URL, entry.Language, entry.Category, stateSuperseded, "a sheet revised "+newest[key].Format(time.DateOnly)+" is listed under another URL")
+		case !older && state == stateSuperseded:
+			m.setState(entry.URL, entry.Language, entry.Category, stateDownloaded, "no newer revision is listed any more")
+		}
+	}
+}
+
+// Parses a comma-separated list of states, as taken by the -state options
+func parseStates(value string) ([]string, error) {
+	var states []string
+	for _, state := range strings.Split(value, ",") {
+		if state = strings.ToLower(strings.TrimSpace(state)); state == "" {
+			continue
+		}
+		if !slices.Contains(lifecycleStates, state) {
+			return nil, fmt.Errorf("unknown state %q (expected %s)", state, strings.Join(lifecycleStates, ", "))
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// Adds a -state option to a report and returns a filter keeping the entries in the listed states, or every entry
+// when the option isn't given
+func addStateFilter(flags *flag.FlagSet) func(manifestEntry) bool {
+	var states []string
+	flags.Func("state", "only include documents in these comma-separated states: "+strings.Join(lifecycleStates, ", "), func(value string) error {
+		parsed, err := parseStates(value)
+		states = parsed
+		return err
+	})
+	return func(entry manifestEntry) bool {
+		return len(states) == 0 || slices.Contains(states, entry.state())
+	}
+}
+
+// Column headings of the lifecycle report
+var lifecycleHeader = []string{"URL", "Language", "State", "Since", "Reason", "File", "Transitions"}
+
+// Writes every document the scraper knows of with its state as CSV, archived or not, and prints how many are in
+// each state
+func runLifecycleReport(args []string) error {
+	flags := flag.NewFlagSet("report lifecycle", flag.ExitOnError) // Options specific to this report
+	output := flags.String("output", "", "CSV file to write (defaults to standard output)")
+	manifestPath := flags.String("manifest", manifestFilePath, "manifest describing the archive")
+	include := addStateFilter(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	writer := csv.NewWriter(out)
+	if err := writer.Write(lifecycleHeader); err != nil {
+		return err
+	}
+	counts := make(map[string]int)
+	for _, entry := range loadManifest(*manifestPath).all() {
+		if !include(entry) {
+			continue
+		}
+		counts[entry.state()]++
+		since, reason := "", ""
+		if !entry.StateChangedAt.IsZero() {
+			since = entry.StateChangedAt.Format(time.RFC3339)
+		}
+		if len(entry.Transitions) > 0 {
+			reason = entry.Transitions[len(entry.Transitions)-1].Reason
+		}
+		if err := writer.Write([]string{entry.URL, entry.Language, entry.state(), since, reason, entry.File, fmt.Sprint(len(entry.Transitions))}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	var totals []string
+	for _, state := range lifecycleStates {
+		if counts[state] > 0 {
+			totals = append(totals, fmt.Sprintf("%s %d", state, counts[state]))
+		}
+	}
+	if len(totals) == 0 {
+		totals = append(totals, "none")
+	}
+	fmt.Fprintf(os.Stderr, "Documents by state: %s\n", strings.Join(totals, ", "))
+	return nil
+}
@@ -0,0 +1,171 @@
+package main // Link health checks that probe documents without downloading them
+
+import (
+	"flag"     // Parses the check-links options
+	"fmt"      // Prints the report
+	"log"      // Reports discovery progress
+	"net/http" // Sends the probes
+	"path"     // Reads file extensions
+	"sort"     // Orders the report
+	"strings"  // Compares content types
+	"time"     // Compares modification times
+)
+
+// What a probe found out about one link
+type linkCheck struct {
+	URL       string        // Link that was probed
+	Entry     manifestEntry // Manifest record, when the document is archived
+	Archived  bool          // Entry is set
+	Status    int           // Final response status, zero when no response arrived
+	Error     string        // Network or policy error
+	Redirects []string      // Redirect chain, ending with the final URL
+	Changes   []string      // Differences from the manifest, e.g. size or type
+}
+
+// Reports whether the link no longer leads to a document
+func (c linkCheck) dead() bool {
+	return c.Error != "" || c.Status >= http.StatusBadRequest
+}
+
+// Probes a link with HEAD, falling back to a GET that is closed unread for servers that reject HEAD
+func probeLink(client *http.Client, rawURL string) (*http.Response, error) {
+	var response *http.Response
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		request, err := http.NewRequest(method, rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		request, cancel := withFileDeadline(request)
+		requestThrottle.wait()
+		response, err = client.Do(request)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		response.Body.Close() // Only the headers matter
+		cancel()
+		recordHostResponse(getDomainFromURL(rawURL), response.StatusCode)
+		if response.StatusCode != http.StatusMethodNotAllowed && response.StatusCode != http.StatusNotImplemented {
+			break
+		}
+	}
+	return response, nil
+}
+
+// Returns the Content-Type fragment expected for an archived file, or "" for unknown extensions
+func expectedMIME(file string) string {
+	extension := strings.ToLower(path.Ext(file))
+	for _, kind := range documentKinds {
+		if kind.Extension == extension {
+			return kind.MIME
+		}
+	}
+	return ""
+}
+
+// Probes one link and compares what the server reports with the manifest
+func checkLink(client *http.Client, rawURL string, documentManifest *manifest) linkCheck {
+	check := linkCheck{URL: rawURL}
+	check.Entry, check.Archived = documentManifest.lookup(rawURL, "")
+	if err := hostBreakers.allow(getDomainFromURL(rawURL)); err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	response, err := probeLink(client, rawURL)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.Status = response.StatusCode
+	check.Redirects = redirectChain(response)
+	if check.dead() || !check.Archived {
+		return check // Nothing to compare with
+	}
+	if size := response.ContentLength; size >= 0 && check.Entry.Size > 0 && size != check.Entry.Size {
+		check.Changes = append(check.Changes, fmt.Sprintf("size %s → %s", formatBytes(check.Entry.Size), formatBytes(size)))
+	}
+	if contentType, expected := response.Header.Get("Content-Type"), expectedMIME(check.Entry.File); contentType != "" && expected != "" && !strings.Contains(contentType, expected) {
+		check.Changes = append(check.Changes, fmt.Sprintf("type is now %s", contentType))
+	}
+	if modified := parseHTTPTime(response.Header.Get("Last-Modified")); !modified.IsZero() && !check.Entry.LastModified.IsZero() && modified.After(check.Entry.LastModified) {
+		check.Changes = append(check.Changes, "modified "+modified.Format(time.DateOnly))
+	}
+	return check
+}
+
+// Runs the check-links subcommand: probes every archived or freshly discovered link and reports dead links,
+// redirects and documents whose size or type changed, without downloading anything
+func runCheckLinks(args []string) error {
+	flags := flag.NewFlagSet("check-links", flag.ExitOnError) // Options specific to check-links
+	manifestPath := flags.String("manifest", manifestFilePath, "manifest whose links are checked and compared")
+	discover := flags.Bool("discover", false, "check the links found on the listing pages now instead of the manifest's")
+	failOnProblem := flags.Bool("fail-on-problem", false, "exit with an error when any link is dead or changed, for use in CI")
+	flags.StringVar(&targetsFilePath, "targets", targetsFilePath, "JSON file listing the pages to scrape, used with -discover and for per-host auth")
+	flags.StringVar(&secretsFilePath, "secrets", secretsFilePath, "JSON file of secrets referenced by target auth settings")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	targets, err := loadTargets(targetsFilePath) // Also registers the credentials of protected hosts
+	if err != nil {
+		return err
+	}
+
+	documentManifest := loadManifest(*manifestPath)
+	var urls []string
+	if *discover {
+		for _, document := range discoverDocuments(targets) {
+			urls = append(urls, document.URL)
+		}
+		log.Printf("Discovered %d links", len(urls))
+		if len(urls) == 0 {
+			return fmt.Errorf("no links discovered; the listing pages were saved to %s", discoveryDebugDir)
+		}
+	} else {
+		seen := make(map[string]bool) // Language variants share one URL
+		for _, entry := range documentManifest.list() {
+			if !seen[entry.URL] {
+				seen[entry.URL] = true
+				urls = append(urls, entry.URL)
+			}
+		}
+	}
+	sort.Strings(urls)
+
+	client := httpClient()
+	var dead, redirected, changed []linkCheck
+	for _, rawURL := range urls {
+		check := checkLink(client, rawURL, documentManifest)
+		switch {
+		case check.dead():
+			dead = append(dead, check)
+			continue
+		case check.Redirects != nil:
+			redirected = append(redirected, check)
+		}
+		if len(check.Changes) > 0 {
+			changed = append(changed, check)
+		}
+	}
+
+	fmt.Printf("Checked: %d links\n", len(urls))
+	fmt.Printf("Dead: %d\n", len(dead))
+	for _, check := range dead {
+		reason := check.Error
+		if reason == "" {
+			reason = fmt.Sprintf("%d %s", check.Status, http.StatusText(check.Status))
+		}
+		fmt.Printf("  ✗ %s (%s)\n", check.URL, reason)
+	}
+	fmt.Printf("Redirected: %d\n", len(redirected))
+	for _, check := range redirected {
+		fmt.Printf("  → %s\n", strings.Join(check.Redirects, " → "))
+	}
+	fmt.Printf("Changed: %d\n", len(changed))
+	for _, check := range changed {
+		fmt.Printf("  ~ %s (%s)\n", check.URL, strings.Join(check.Changes, ", "))
+	}
+	if *failOnProblem && len(dead)+len(changed) > 0 {
+		return fmt.Errorf("%d dead and %d changed links", len(dead), len(changed))
+	}
+	return nil
+}
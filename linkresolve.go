@@ -0,0 +1,178 @@
+package main // Shortened and tracking-wrapped document links, resolved to the document they lead to
+
+import (
+	"context"  // Cancels resolution with the run
+	"fmt"      // Describes links that can't be resolved
+	"mime"     // Reads the type of the final response
+	"net/http" // Follows the redirects one hop at a time
+	"net/url"  // Reads hosts and wrapper query parameters
+	"slices"   // Detects redirect loops
+	"strings"  // Parses the host list
+	"sync"     // Guards the resolution cache
+)
+
+var linkResolverHosts = "" // Comma-separated hosts besides the built-in shorteners whose links are resolved by following their redirects, e.g. a newsletter's click tracker; "*." prefixes match subdomains
+
+// URL shorteners and click trackers whose links don't show the document they lead to
+var shortenerHosts = []string{
+	"bit.ly", "bitly.com", "t.co", "tinyurl.com", "ow.ly", "buff.ly", "goo.gl", "is.gd", "rebrand.ly", "cutt.ly",
+	"lnkd.in", "t.ly", "tiny.cc", "shorturl.at", "*.hubspotlinks.com", "*.list-manage.com", "*.ct.sendgrid.net",
+}
+
+// Query parameters trackers carry the destination in, e.g. https://www.google.com/url?q=<document>
+var wrapperQueryParams = []string{"url", "u", "q", "target", "dest", "destination", "redirect", "redirect_url", "link", "goto", "to"}
+
+// Links already resolved by this process, so the daemon doesn't ask the shortener again every run
+var resolvedLinks = struct {
+	sync.Mutex
+	chains map[string][]string // Link → resolution chain, ending with the document URL
+}{chains: make(map[string][]string)}
+
+// Validates the -link-resolvers entries, which are bare host names
+func validateLinkResolvers() error {
+	for _, host := range strings.Split(linkResolverHosts, ",") {
+		host = strings.TrimPrefix(strings.TrimSpace(host), "*.")
+		if strings.ContainsAny(host, "/:@ ") {
+			return fmt.Errorf("invalid -link-resolvers entry %q (expected a host name such as click.example.com or *.example.com)", host)
+		}
+	}
+	return nil
+}
+
+// Reports whether a host matches an entry of a host list: the same domain, or a subdomain of a "*." entry
+func hostMatches(host string, pattern string) bool {
+	host, pattern = normalizeDomain(host), normalizeDomain(strings.TrimSpace(pattern))
+	if suffix, wildcard := strings.CutPrefix(pattern, "*."); wildcard {
+		return host == suffix || strings.HasSuffix(host, "."+suffix)
+	}
+	return pattern != "" && pattern == host
+}
+
+// Reports whether a link points at a URL shortener or a host listed in -link-resolvers
+func isShortenedLink(link string) bool {
+	parsed, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+	for _, pattern := range append(strings.Split(linkResolverHosts, ","), shortenerHosts...) {
+		if hostMatches(parsed.Hostname(), pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the absolute http or https URL a tracking link carries in one of its query parameters
+func unwrapTrackingLink(link string) (string, bool) {
+	parsed, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return "", false
+	}
+	query := parsed.Query()
+	for name := range query {
+		if !containsFold(wrapperQueryParams, name) {
+			continue
+		}
+		target, err := url.Parse(query.Get(name))
+		if err == nil && (target.Scheme == "http" || target.Scheme == "https") && target.Host != "" {
+			return target.String(), true
+		}
+	}
+	return "", false
+}
+
+// Reports whether a list holds a string, ignoring case
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether a link that doesn't name a document may still lead to one: a shortened link, or a tracking link
+// whose destination is a document or another shortened link
+func isWrappedLink(link string) bool {
+	if isPDFLink(link) {
+		return false
+	}
+	if isShortenedLink(link) {
+		return true
+	}
+	target, found := unwrapTrackingLink(link)
+	return found && (isPDFLink(target) || isShortenedLink(target))
+}
+
+// Follows a shortened or tracking link to the document it leads to, at most -max-redirects hops, and returns the
+// chain of URLs from the link to the document; destinations carried in a query parameter are taken without a request
+func resolveLink(ctx context.Context, link string) ([]string, error) {
+	resolvedLinks.Lock()
+	chain, found := resolvedLinks.chains[link]
+	resolvedLinks.Unlock()
+	if found {
+		return chain, nil
+	}
+	client := *httpClient()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse // Every hop is recorded, and policed when the document is downloaded
+	}
+	chain = []string{link}
+	current := link
+	for !isPDFLink(current) {
+		if len(chain) > maxRedirects {
+			return chain, fmt.Errorf("more than %d hops resolving %s", maxRedirects, link)
+		}
+		next, found := unwrapTrackingLink(current)
+		if !found {
+			location, document, err := followLink(ctx, &client, current)
+			if err != nil {
+				return chain, err
+			}
+			if document {
+				break // Served without a document extension
+			}
+			next = location
+		}
+		normalized, err := normalizeURL(current, next)
+		if err != nil {
+			return chain, fmt.Errorf("%s leads to an unparseable URL %q: %w", current, next, err)
+		}
+		if slices.Contains(chain, normalized) {
+			return chain, fmt.Errorf("%s redirects in a loop", link)
+		}
+		chain = append(chain, normalized)
+		current = normalized
+	}
+	resolvedLinks.Lock()
+	resolvedLinks.chains[link] = chain
+	resolvedLinks.Unlock()
+	return chain, nil
+}
+
+// Requests a link without following its redirect and returns where it leads, or reports that the link itself serves
+// a document
+func followLink(ctx context.Context, client *http.Client, link string) (string, bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil) // Some trackers don't answer HEAD
+	if err != nil {
+		return "", false, err
+	}
+	request, cancel := withFileDeadline(request)
+	defer cancel()
+	requestThrottle.wait()
+	response, err := client.Do(request)
+	if err != nil {
+		return "", false, err
+	}
+	response.Body.Close() // Only the headers matter
+	if location := response.Header.Get("Location"); location != "" && response.StatusCode >= 300 && response.StatusCode < 400 {
+		return location, false, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", false, fmt.Errorf("%s returned %s", link, response.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type")); mediaType == "application/pdf" {
+		return "", true, nil
+	}
+	return "", false, fmt.Errorf("%s leads to a %s page, not a document", link, response.Header.Get("Content-Type"))
+}
@@ -0,0 +1,134 @@
+package main // Single-instance enforcement through a lock file
+
+import (
+	"encoding/json" // Stores the owner details inside the lock file
+	"errors"        // Detects an existing lock file
+	"fmt"           // Builds lock errors
+	"log"           // Reports waiting and stolen locks
+	"os"            // Creates, reads and removes the lock file
+	"time"          // Stale-lock detection and polling
+)
+
+var (
+	lockFilePath   = ".scraper.lock"  // Lock file guarding the output directories
+	lockWait       = time.Duration(0) // How long to wait for another run to finish; 0 fails immediately
+	lockStaleAfter = 6 * time.Hour    // Locks older than this are considered stale
+	stealStaleLock = false            // Whether a stale lock may be taken over
+)
+
+const lockPollInterval = 5 * time.Second // How often a waiting run re-checks the lock
+
+// Contents of the lock file, identifying the run that holds it
+type lockOwner struct {
+	PID       int       `json:"pid"`              // Process ID of the owner
+	Hostname  string    `json:"hostname"`         // Host the owner runs on; PIDs are only checked locally
+	StartedAt time.Time `json:"started_at"`       // When the lock was taken
+	RunID     string    `json:"run_id,omitempty"` // Run holding the lock
+}
+
+// A held run lock; call release when the run is over
+type runLock struct {
+	path     string    // Lock file location
+	owner    lockOwner // What we wrote into the lock file
+	advisory *os.File  // Advisory lock on the lock file, released by the OS if the run dies; nil where unsupported
+}
+
+// Takes the run lock, waiting or stealing a stale lock as configured
+func acquireRunLock(path string) (*runLock, error) {
+	hostname, _ := os.Hostname() // Best effort; an empty hostname disables PID checks
+	owner := lockOwner{PID: os.Getpid(), Hostname: hostname, StartedAt: time.Now().UTC(), RunID: runID()}
+	deadline := time.Now().Add(lockWait) // Give up waiting after this time
+	for {
+		err := createLockFile(path, owner) // O_EXCL makes creation atomic, on SMB and NFS shares too
+		if err == nil {
+			lock := &runLock{path: path, owner: owner}
+			if lock.advisory, err = lockAdvisory(path); err != nil {
+				log.Printf("No advisory lock on %s (%v); relying on the lock file alone", path, err)
+			}
+			return lock, nil
+		}
+		if !errors.Is(err, os.ErrExist) && !isSharingViolation(err) {
+			return nil, err // Unexpected filesystem error
+		}
+
+		existing, readErr := readLockFile(path) // Who holds the lock?
+		stale, reason := isStaleLock(existing, readErr, hostname)
+		if stale && advisoryLockHeld(path) { // The owner is alive, even if it runs on another machine sharing the archive
+			stale = false
+		}
+		if stale && stealStaleLock {
+			log.Printf("Stealing stale lock %s (%s)", path, reason)
+			if err := removeShared(path); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			continue // Retry creation
+		}
+		if time.Now().After(deadline) {
+			if stale {
+				return nil, fmt.Errorf("lock %s looks stale (%s); rerun with -steal-stale-lock to take it over", path, reason)
+			}
+			return nil, fmt.Errorf("another run (%s, pid %d on %s, started %s) holds %s", existing.RunID, existing.PID, existing.Hostname, existing.StartedAt.Format(time.RFC3339), path)
+		}
+		log.Printf("Waiting for run lock %s held by run %s (pid %d)", path, existing.RunID, existing.PID)
+		time.Sleep(min(lockPollInterval, time.Until(deadline)+time.Millisecond)) // Poll until the lock frees up or we time out
+	}
+}
+
+// Creates the lock file only if it doesn't exist yet and writes the owner into it
+func createLockFile(path string, owner lockOwner) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644) // Fails if another run holds the lock
+	if err != nil {
+		return err
+	}
+	encodeErr := json.NewEncoder(file).Encode(owner)
+	closeErr := file.Close()
+	if encodeErr != nil || closeErr != nil {
+		os.Remove(path) // Don't leave a half-written lock behind
+		return errors.Join(encodeErr, closeErr)
+	}
+	return nil
+}
+
+// Reads the owner details from an existing lock file
+func readLockFile(path string) (lockOwner, error) {
+	var owner lockOwner
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return owner, err
+	}
+	return owner, json.Unmarshal(data, &owner)
+}
+
+// Decides whether a lock can be considered abandoned and explains why
+func isStaleLock(owner lockOwner, readErr error, hostname string) (bool, string) {
+	if readErr != nil {
+		return true, "unreadable lock file: " + readErr.Error()
+	}
+	if age := time.Since(owner.StartedAt); age > lockStaleAfter {
+		return true, fmt.Sprintf("held for %s", age.Round(time.Second))
+	}
+	if hostname != "" && owner.Hostname == hostname && !processAlive(owner.PID) {
+		return true, fmt.Sprintf("pid %d is no longer running", owner.PID)
+	}
+	return false, ""
+}
+
+// Removes the lock file if it still belongs to this run
+func (l *runLock) release() {
+	if l.owner.PID == 0 {
+		return // Already released
+	}
+	current, err := readLockFile(l.path)
+	if l.advisory != nil {
+		l.advisory.Close() // Windows can't remove a file it holds open
+		l.advisory = nil
+	}
+	if err != nil || current.PID != l.owner.PID || !current.StartedAt.Equal(l.owner.StartedAt) {
+		log.Printf("Run lock %s was taken over by another run; leaving it in place", l.path)
+		return
+	}
+	if err := removeShared(l.path); err != nil {
+		log.Println(err)
+	}
+	l.owner = lockOwner{}
+}
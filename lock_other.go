@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main // Process liveness check for Windows and other non-Unix systems
+
+import "os" // Looks up the process
+
+// Reports whether a process with the given PID is running
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid) // Fails on Windows when no such process exists
+	if err != nil {
+		return false
+	}
+	process.Release() // Close the handle opened by FindProcess
+	return true
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package main // Process liveness check for Unix-like systems
+
+import (
+	"errors"  // Matches the permission error
+	"os"      // Looks up the process
+	"syscall" // Sends the null signal
+)
+
+// Reports whether a process with the given PID is running
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid) // Always succeeds on Unix
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))            // Signal 0 checks existence without affecting the process
+	return err == nil || errors.Is(err, syscall.EPERM) // EPERM means it exists but belongs to someone else
+}
@@ -0,0 +1,102 @@
+package main // Lookup subcommand answering "which sheet covers this chemical?" and "which sheets come from this company?"
+
+import (
+	"encoding/json" // Writes the CAS index file
+	"flag"          // Parses the lookup options
+	"fmt"           // Prints lookup results
+	"log"           // Reports index write failures
+	"os"            // Removes the index's other form
+	"slices"        // Filters sheets by brand
+	"sort"          // Keeps the index stable between runs
+	"strings"       // Normalizes the requested CAS number
+)
+
+var casIndexFilePath = "cas-index.json" // CAS registry number → documents, rewritten after every run
+
+// A document listed under a CAS number in the index
+type casIndexDocument struct {
+	Product   string   `json:"product"`             // Product name derived from the file name
+	Brand     string   `json:"brand,omitempty"`     // Company the sheet is filed under
+	Companies []string `json:"companies,omitempty"` // Every company named in Section 1
+	File      string   `json:"file"`                // Local path of the sheet
+	URL       string   `json:"url"`                 // Source URL of the sheet
+}
+
+// Maps every CAS number found in the archive to the documents that list it
+func buildCASIndex(entries []manifestEntry) map[string][]casIndexDocument {
+	index := make(map[string][]casIndexDocument)
+	for _, entry := range entries {
+		entry = withSDSMetadata(entry) // Older entries are read from their local copy
+		for _, cas := range entry.SDS.CASNumbers {
+			index[cas] = append(index[cas], casIndexDocument{Product: productNameFromFile(entry.File), Brand: entry.SDS.brand(), Companies: entry.SDS.Companies, File: entry.File, URL: entry.URL})
+		}
+	}
+	for _, documents := range index {
+		sort.Slice(documents, func(i, j int) bool { return documents[i].File < documents[j].File })
+	}
+	return index
+}
+
+// Writes the CAS index as indented JSON for other tools to consume
+func writeCASIndex(filePath string, index map[string][]casIndexDocument) {
+	data, err := json.MarshalIndent(index, "", "  ") // Map keys are sorted by the encoder
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if data, err = encodeArtifact(data); err != nil {
+		log.Println(err)
+		return
+	}
+	if err := writeFileAtomic(artifactName(filePath), data); err != nil {
+		log.Printf("Failed to write CAS index %s: %v", artifactName(filePath), err)
+		return
+	}
+	if err := os.Remove(otherArtifactName(filePath)); err != nil && !os.IsNotExist(err) { // Converted to the configured form
+		log.Println(err)
+	}
+}
+
+// Runs the lookup subcommand with its own command-line options
+func runLookup(args []string) error {
+	flags := flag.NewFlagSet("lookup", flag.ExitOnError) // Options specific to lookup
+	cas := flags.String("cas", "", "CAS registry number to look up, e.g. 7778-54-3")
+	brand := flags.String("brand", "", "only list sheets naming this company in Section 1 (case-insensitive substring); alone, lists every sheet of the company")
+	manifestPath := flags.String("manifest", manifestFilePath, "manifest describing the archive")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	wanted := strings.TrimSpace(*cas)
+	wantedBrand := strings.ToLower(strings.TrimSpace(*brand))
+	if wanted == "" && wantedBrand == "" {
+		return fmt.Errorf("-cas or -brand is required")
+	}
+	if wanted != "" && !validCASNumber(wanted) {
+		return fmt.Errorf("%q is not a valid CAS registry number", wanted)
+	}
+
+	var documents []casIndexDocument
+	if wanted != "" {
+		documents = buildCASIndex(loadManifest(*manifestPath).list())[wanted]
+	} else {
+		for _, entry := range loadManifest(*manifestPath).list() { // Sheets without CAS numbers can still match a brand
+			entry = withSDSMetadata(entry)
+			documents = append(documents, casIndexDocument{Product: productNameFromFile(entry.File), Brand: entry.SDS.brand(), Companies: entry.SDS.Companies, File: entry.File, URL: entry.URL})
+		}
+	}
+	documents = slices.DeleteFunc(documents, func(document casIndexDocument) bool {
+		return wantedBrand != "" && !slices.ContainsFunc(document.Companies, func(company string) bool {
+			return strings.Contains(strings.ToLower(company), wantedBrand)
+		})
+	})
+	if len(documents) == 0 && wantedBrand == "" {
+		return fmt.Errorf("no sheet lists CAS %s", wanted)
+	}
+	if len(documents) == 0 {
+		return fmt.Errorf("no sheet from a company matching %q", *brand)
+	}
+	for _, document := range documents {
+		fmt.Printf("%s\t%s\t%s\t%s\n", document.Product, document.Brand, document.File, document.URL)
+	}
+	return nil
+}
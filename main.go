@@ -1,25 +1,155 @@
 package main // Define the main package, the starting point for Go executables
 
 import (
+	"bufio"         // Buffers the start of response bodies for content sniffing
 	"bytes"         // Provides functionality for manipulating byte slices and buffers
+	"context"       // Cancels requests that exceed their deadlines
+	"errors"        // Recognizes circuit breaker errors
+	"flag"          // Parses command-line options
+	"fmt"           // Formats error messages with context
 	"io"            // Defines basic interfaces to I/O primitives, like Reader and Writer
 	"log"           // Offers logging capabilities to standard output or error streams
+	"maps"          // Lists the categories dropped during discovery
 	"net/http"      // Allows interaction with HTTP clients and servers
 	"net/url"       // Provides URL parsing, encoding, and query manipulation
 	"os"            // Gives access to OS features, such as file and directory operations
 	"path"          // Provides functions for manipulating slash-separated paths (not OS specific)
 	"path/filepath" // Offers functions to handle file paths in a way compatible with the OS
-	"regexp"        // Supports regular expression handling using RE2 syntax
+	"slices"        // Searches small attribute lists
 	"strings"       // Contains utilities for string manipulation
 	"time"          // Contains time-related functionality such as sleeping or timeouts
+
+	"golang.org/x/net/html" // Tokenizes HTML pages to find document links
 )
 
 var (
-	pdfOutputDir = "PDFs/" // Directory path where downloaded PDFs will be stored
-	zipOutputDir = "ZIPs/" // Directory path where downloaded ZIP files will be stored
+	pdfOutputDir        = "PDFs/" // Directory path where downloaded PDFs will be stored
+	zipOutputDir        = "ZIPs/" // Directory path where downloaded ZIP files will be stored
+	maxDownloadAttempts = 3       // Number of times a truncated or corrupted download is retried
 )
 
+// Subcommands that operate on the existing archive, keyed by the name given as the first argument
+var subcommands = map[string]func(args []string) error{
+	"scrape":           runScrapeCommand,   // Discover and download every document, as when no subcommand is given
+	"discover":         runDiscover,        // List the document links without downloading them
+	"download":         runDownload,        // Download a list of documents without discovering them
+	"verify":           runVerify,          // Check the archived files against the manifest
+	"serve":            runGRPCServer,      // Expose scraping over gRPC
+	"serve-grpc":       runGRPCServer,      // Older name of serve, kept for existing scripts
+	"export":           runExport,          // Bundle the archive into a ZIP
+	"catalog":          runCatalog,         // Export the inventory as CSV or XLSX
+	"prune":            runPrune,           // Apply the retention policy to the archive
+	"report":           runReport,          // Summarize the archive contents
+	"lookup":           runLookup,          // Find the sheets listing a CAS number
+	"mirror":           runMirror,          // Generate a static website of the archive
+	"diff":             runDiff,            // Compare two archive snapshots
+	"check-links":      runCheckLinks,      // Probe every link without downloading
+	"selfupdate":       runSelfUpdate,      // Install the latest signed release
+	"frontier":         runFrontier,        // Inspect the crawl frontier of a running or interrupted crawl
+	"verify-integrity": runVerifyIntegrity, // Prove the manifest's history wasn't altered
+	"schema":           runSchema,          // Print the JSON Schema of the manifest, run report or audit log
+	"migrate":          runMigrate,         // Upgrade those files to the current schema versions
+	"restore":          runRestore,         // Rebuild the archive as it was on an earlier date
+	"migrate-layout":   runMigrateLayout,   // Move the archive to another naming scheme or storage layout
+}
+
+// Describes a discovered PDF link together with the page context it was found in
+type pdfDocument struct {
+	URL        string   // Absolute URL of the document
+	Category   string   // Page heading the link was listed under
+	Anchor     string   // Link text the document was listed with
+	Resolution []string // Shortened or tracking link the document was listed as and every hop to URL, ending with URL; nil for direct links
+}
+
 func init() {
+	addStorageFlags(flag.CommandLine) // Storage selection is shared with the subcommands that modify the archive
+	addClamdFlags(flag.CommandLine)   // Scanning applies to every run that downloads, the daemon's included
+	addStagingFlags(flag.CommandLine) // So does staging
+	flag.Func("max-file-size", "largest document downloaded, e.g. 50MB; larger ones are skipped as too-large (unset allows any size)", setMaxFileSize)
+	flag.Func("delta-min-size", "archived ZIPs at least this large are updated with zsync, fetching only changed blocks, when the server publishes <url>.zsync (default 8MiB; 0 disables)", setDeltaMinSize)
+	flag.Func("max-archive-size", "largest size the archive may grow to, e.g. 20GiB or 500MB (unset disables the quota)", setMaxArchiveSize)
+	flag.StringVar(&quotaAction, "quota-action", quotaAction, "what happens when a download would exceed -max-archive-size: stop (skip the remaining downloads), prune (delete the oldest superseded revisions first) or warn")
+	flag.BoolVar(&writeChecksums, "checksums", writeChecksums, "write a <file>.sha256 next to each PDF and a consolidated SHA256SUMS file")
+	flag.BoolVar(&compressArtifacts, "compress-artifacts", compressArtifacts, "store metadata sidecars and the CAS index gzip-compressed with a .gz suffix; both forms are read, and existing files are converted as they are rewritten")
+	flag.BoolVar(&writeMetadataSidecars, "metadata-sidecars", writeMetadataSidecars, "write a <file>.meta.yaml next to each document with its extracted fields and user-editable ones (location, product_code) that later runs keep")
+	flag.DurationVar(&runDeadline, "run-deadline", runDeadline, "time budget for the whole run, e.g. 45m: once it runs out no new downloads start, those under way finish, and the rest are saved to -remaining-queue for the next run (0 disables)")
+	flag.StringVar(&remainingQueuePath, "remaining-queue", remainingQueuePath, "file listing the documents a run left when -run-deadline ran out; the next run fetches them first")
+	flag.DurationVar(&lockWait, "lock-wait", lockWait, "how long to wait for another run to release the lock (0 fails immediately)")
+	flag.DurationVar(&lockStaleAfter, "lock-stale-after", lockStaleAfter, "age after which a run lock is considered stale")
+	flag.BoolVar(&stealStaleLock, "steal-stale-lock", stealStaleLock, "take over a lock whose owner is no longer running or that is older than -lock-stale-after")
+	flag.DurationVar(&dialTimeout, "dial-timeout", dialTimeout, "timeout for establishing a TCP connection")
+	flag.StringVar(&ipVersion, "ip-version", ipVersion, "address family for outgoing connections: any, 4 (IPv4 only, for networks with broken IPv6) or 6")
+	flag.BoolVar(&useHTTP3, "http3", useHTTP3, "fetch https URLs over HTTP/3 (QUIC), which copes better with lossy links; hosts that don't answer over UDP, and requests through a proxy, use TCP")
+	flag.DurationVar(&fallbackDelay, "fallback-delay", fallbackDelay, "how long a connection to a dual-stack host waits on IPv6 before also trying IPv4 (happy eyeballs); 0 uses the default of 300ms, a negative value disables the fallback")
+	flag.DurationVar(&tlsHandshakeTimeout, "tls-timeout", tlsHandshakeTimeout, "timeout for the TLS handshake")
+	flag.DurationVar(&responseHeaderTimeout, "header-timeout", responseHeaderTimeout, "timeout for response headers once the request is sent")
+	flag.DurationVar(&bodyIdleTimeout, "body-idle-timeout", bodyIdleTimeout, "abort a transfer when the body delivers no data for this long (0 disables)")
+	flag.DurationVar(&fileDeadline, "file-deadline", fileDeadline, "overall deadline for fetching one file or page, body included")
+	flag.StringVar(&tempDir, "temp-dir", tempDir, "directory for per-run copies of failed download attempts")
+	flag.StringVar(&tempRetention, "temp-retention", tempRetention, "what happens to failed download attempts after a run: delete, keep-on-failure or keep-days")
+	flag.IntVar(&tempKeepDays, "temp-keep-days", tempKeepDays, "days failed attempts are kept with -temp-retention keep-days")
+	flag.Func("accept", "document types to archive and their directories, as type=directory entries where type is pdf, zip, docx, xlsx, doc, rtf or a MIME type such as text/csv; types with a signature are recognized by sniffing, others by Content-Type; links ending in a listed type's extension are discovered too (default pdf=PDFs/,zip=ZIPs/,docx=DOCs/,xlsx=Spreadsheets/,doc=DOCs/,rtf=DOCs/)", setAcceptedTypes)
+	flag.BoolVar(&extractZips, "extract-zips", extractZips, "extract downloaded ZIP bundles into a directory next to the bundle")
+	flag.Int64Var(&zipMaxTotalBytes, "zip-max-bytes", zipMaxTotalBytes, "decompressed bytes allowed per ZIP bundle, nested bundles included")
+	flag.IntVar(&zipMaxFiles, "zip-max-files", zipMaxFiles, "files allowed per ZIP bundle, nested bundles included")
+	flag.IntVar(&zipMaxDepth, "zip-max-depth", zipMaxDepth, "levels of ZIPs inside ZIPs that are extracted")
+	flag.IntVar(&zipMaxCompressionRatio, "zip-max-ratio", zipMaxCompressionRatio, "largest compression ratio accepted for a ZIP entry")
+	flag.IntVar(&downloadWorkers, "download-workers", downloadWorkers, "documents downloaded at the same time")
+	flag.BoolVar(&autoConcurrency, "auto-concurrency", autoConcurrency, "adapt the number of parallel downloads between 1 and -download-workers: start at half, halve it when the server throttles or more than a fifth of downloads fail, and add one back after each clean stretch")
+	flag.IntVar(&processWorkers, "process-workers", processWorkers, "downloaded documents hashed, parsed, stored and indexed at the same time (defaults to the number of CPUs)")
+	flag.IntVar(&downloadChunks, "chunks", downloadChunks, "parallel ranged requests per large file when the server supports Range (1 disables)")
+	flag.Int64Var(&chunkThreshold, "chunk-threshold", chunkThreshold, "minimum file size in bytes for chunked downloading")
+	flag.Int64Var(&chunkMaxSize, "chunk-max-size", chunkMaxSize, "largest Content-Length in bytes downloaded in chunks, which holds the whole file in memory from the start; larger files are read as one stream")
+	flag.DurationVar(&requestInterval, "request-interval", requestInterval, "minimum time between the starts of two requests, across all workers (0 disables the limit)")
+	flag.IntVar(&breakerThreshold, "breaker-threshold", breakerThreshold, "consecutive failures after which a host is skipped (0 disables the circuit breaker)")
+	flag.DurationVar(&breakerCooldown, "breaker-cooldown", breakerCooldown, "how long a failing host is skipped before a trial request")
+	flag.StringVar(&targetsFilePath, "targets", targetsFilePath, `JSON list of listing pages to scrape, e.g. [{"url": "...?page={1..20}", "selector": "table.sds", "next": "auto"}]; each may set a CSS "selector" or "xpath" for the container holding the document links, a {first..last} page range in the url, "next" ("auto" or a CSS selector) to follow next-page links up to "max_pages", and "auth" ({"type": "basic", "username": "env:USER", "password": "secret:pw"}, bearer "token", header "header"/"value", or session "login_url"/"form"/"token_field" to log in with a form) for its host, "adapter" to pick the vendor adapter (listing or poolseason; defaults by host), and "categories" or "skip_categories" to download only, or never, the documents listed under those page headings`)
+	flag.StringVar(&authFailurePolicy, "on-auth-failure", authFailurePolicy, "what a 401 or 403 from a host with target auth does: fail, or refresh (log in again or re-read the credentials and retry)")
+	flag.IntVar(&authRefreshLimit, "auth-refresh-limit", authRefreshLimit, "credential refreshes allowed per host and run with -on-auth-failure refresh")
+	flag.StringVar(&secretsFilePath, "secrets", secretsFilePath, `JSON object of named secrets that target "auth" settings and serve's -api-keys reference as "secret:<name>"`)
+	flag.StringVar(&seenSetKind, "seen-set", seenSetKind, "how discovered URLs are de-duplicated: map (exact, every URL in memory) or compact (Bloom filter plus 64-bit fingerprints, for multi-million-URL crawls)")
+	flag.IntVar(&seenSetCapacity, "seen-capacity", seenSetCapacity, "URLs the compact seen-set is sized for")
+	flag.StringVar(&frontierPath, "frontier", frontierPath, "keep the listing crawl's pending and visited pages, with depth and the page that led to each, in this SQLite database, so an interrupted crawl resumes without fetching visited pages again; inspect it with the frontier subcommand or sqlite3, also while the crawl runs (empty disables)")
+	flag.StringVar(&discoveredListPath, "discovered", discoveredListPath, "write the documents discovered by scrape and discover, with link text and category, to this JSON artifact; review or edit it, then fetch it with download (empty disables)")
+	flag.StringVar(&seenSetFile, "seen-file", seenSetFile, "keep the compact seen-set in this file between runs, so an interrupted crawl skips links it already collected (empty keeps it in memory)")
+	flag.StringVar(&discoveryDebugDir, "discovery-debug-dir", discoveryDebugDir, "where listing pages are saved when they yield no document links; the run then exits with status 3")
+	flag.StringVar(&pageCacheFilePath, "page-cache", pageCacheFilePath, "cache of links extracted from listing pages, reused while a page's content hash is unchanged (empty disables)")
+	flag.IntVar(&maxRedirects, "max-redirects", maxRedirects, "maximum redirect hops followed per request")
+	flag.StringVar(&crossDomainRedirects, "cross-domain-redirects", crossDomainRedirects, "follow redirects to other domains: allow or deny")
+	flag.StringVar(&linkResolverHosts, "link-resolvers", linkResolverHosts, "comma-separated hosts besides the common URL shorteners whose links are followed to the document they lead to, e.g. a newsletter's click tracker; *.example.com matches its subdomains")
+	flag.StringVar(&redirectAllowedHosts, "redirect-allow-hosts", redirectAllowedHosts, "comma-separated domains redirects may go to even with -cross-domain-redirects=deny")
+	flag.StringVar(&downloadAllowedHosts, "download-hosts", downloadAllowedHosts, "comma-separated hosts documents may be downloaded from besides the targets' own, e.g. a vendor's CDN; *.example.com allows its subdomains; links and redirects to other hosts are reported as disallowed-host and never fetched (empty allows any host)")
+	flag.Func("header", `extra "Name: value" header sent with every request; repeatable`, addHeaderOption)
+	flag.StringVar(&progressSocketPath, "progress-socket", progressSocketPath, "serve live progress as JSON lines on this Unix socket, for GUIs and scripts (empty disables)")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", otlpEndpoint, "export request traces (dns, connect, tls, ttfb, transfer) to this OTLP/HTTP collector, e.g. http://localhost:4318 (defaults to OTEL_EXPORTER_OTLP_ENDPOINT; empty disables)")
+	flag.StringVar(&traceServiceName, "trace-service-name", traceServiceName, "service.name reported with exported traces")
+	flag.StringVar(&auditLogPath, "audit-log", auditLogPath, "append a JSON record of every network request (URL, status, bytes, duration, outcome, run ID) to this file (empty disables)")
+	flag.StringVar(&bandwidthLogPath, "bandwidth-log", bandwidthLogPath, "append a JSON bandwidth report for every run to this file (empty disables)")
+	flag.StringVar(&preRunHook, "pre-run", preRunHook, "shell command run before every run, e.g. to mount a network drive; POOLSEASON_RUN_ID and the manifest, output and report paths are in its environment, and a failure aborts the run")
+	flag.StringVar(&postRunHook, "post-run", postRunHook, "shell command run after every run, e.g. to trigger a sync; besides what -pre-run gets, POOLSEASON_DISCOVERED, _DOWNLOADED, _SKIPPED, _FAILED, _REMAINING and _REJECTED describe the run")
+	flag.DurationVar(&hookTimeout, "hook-timeout", hookTimeout, "longest -pre-run or -post-run may take before it is killed")
+	flag.StringVar(&junitReportPath, "junit", junitReportPath, "write a JUnit XML report with one test per document to this file, for CI dashboards")
+	flag.StringVar(&inventoryFilePath, "inventory", inventoryFilePath, "CSV of on-site products to cross-reference with the downloaded sheets")
+	flag.StringVar(&downloadOrder, "order", downloadOrder, "download queue order: page, smallest-first, newest-first (by Last-Modified) or category")
+	flag.StringVar(&enabledCategories, "categories", enabledCategories, `comma-separated page headings whose documents are downloaded, e.g. "Chlorine,Test Kits"; documents under other headings are dropped during discovery and never fetched (empty downloads every category; a target's "categories" replaces it)`)
+	flag.StringVar(&disabledCategories, "skip-categories", disabledCategories, `comma-separated page headings whose documents are dropped during discovery and never fetched, e.g. "Pool Closing Kits"; adds to a target's "skip_categories"`)
+	flag.StringVar(&categoryOrder, "category-order", categoryOrder, "comma-separated categories to download first with -order category; other categories follow in page order")
+	flag.BoolVar(&checkChanges, "check-changes", checkChanges, "send conditional HEAD requests for archived documents and re-download the ones that changed upstream first, keeping their previous copies as revisions")
+	flag.StringVar(&skipBy, "skip-by", skipBy, "how already-downloaded documents are recognized: path (a file exists under the expected name) or hash (the manifest's recorded hash is still in the archive, under any name)")
+	flag.BoolVar(&gitCommitRuns, "git-commit", gitCommitRuns, "treat the local archive directory as a git repository and commit its changes after every run, with the run summary as the message")
+	flag.StringVar(&gitPushRemote, "git-push", gitPushRemote, "remote to push each archive commit to with -git-commit, e.g. origin (empty doesn't push)")
+	flag.BoolVar(&recordIntegrity, "integrity-log", recordIntegrity, "append the Merkle root of every saved manifest to <manifest>.integrity.jsonl, hash-chained to the previous root; check it with verify-integrity")
+	flag.BoolVar(&stableManifest, "stable-manifest", stableManifest, "keep last_seen times in <manifest>.seen.json so runs over unchanged content leave the manifest byte-identical")
+	flag.IntVar(&maxFilenameLength, "max-filename-length", maxFilenameLength, "shorten file names derived from links to this many bytes, ending them in a hash of the URL so they stay unique; the full name is kept in the manifest (0 disables)")
+	flag.StringVar(&dateOrder, "date-order", dateOrder, "how all-numeric revision dates such as 05/06/2015 are read when either part could be the month: auto (day first for sheets in languages other than US English, and for 26.05.2015), mdy or dmy")
+	flag.Func("filename-chain", "name files from the last segment of their URL, query string included, by running it through these comma-separated transformers in order instead of the built-in naming: lowercase, strip-query, slugify, prefix-date and template=<pattern> with {name}, {stem}, {ext}, {host} and {date}; e.g. strip-query,slugify,template={host}_{name}", setFilenameChain)
+	flag.StringVar(&fileNaming, "naming", fileNaming, "how downloaded files are named: url (from the link) or product (<product slug>_rev<revision date>.pdf read from the sheet, so revisions sort together)")
+	flag.StringVar(&outputDirectories, "directories", outputDirectories, "whether files are nested in a directory per domain: auto (when a run's documents span several domains), flat or domain; migrate-layout moves an existing archive")
+	flag.BoolVar(&sdsOnly, "sds-only", sdsOnly, "only download documents classified as Safety Data Sheets by name, link text and first-page text; undetermined documents are kept")
+	flag.StringVar(&documentProfiles, "profiles", documentProfiles, "comma-separated document types to download: sds, labels (product labels, stored in -labels-dir) and other; classified by URL, link text and first-page text, undetermined documents are kept (empty downloads everything)")
+	flag.StringVar(&labelOutputDir, "labels-dir", labelOutputDir, "directory product labels are stored in when -profiles includes labels")
+	flag.BoolVar(&inventoryOnly, "inventory-only", inventoryOnly, "only download documents matching a product in -inventory")
+	flag.StringVar(&acceptLanguages, "languages", acceptLanguages, "comma-separated Accept-Language tags; each tag is downloaded as its own language-tagged variant")
 	// Check if the PDF output directory exists using helper function
 	if !directoryExists(pdfOutputDir) {
 		// If it doesn't exist, create the directory with permission 755
@@ -30,35 +160,394 @@ func init() {
 		// If not, create it with the same permissions
 		createDirectory(zipOutputDir, 0o755)
 	}
+	if !directoryExists(docOutputDir) { // Word and RTF files sniffed from PDF links
+		createDirectory(docOutputDir, 0o755)
+	}
 }
 
 func main() {
-	// List of URLs from which to scrape download information
-	remoteAPIURL := []string{
-		"https://www.poolseason.com/safety-data-sheets/",
+	beginRun(newRunID())  // Correlates every log line, manifest entry and report of this invocation
+	if len(os.Args) > 1 { // Subcommands work on the existing archive instead of scraping
+		if run, found := subcommands[os.Args[1]]; found {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatalln(err) // Report the failure and exit non-zero
+			}
+			return
+		}
 	}
-	var getData []string                        // Slice to store raw HTML content from all URLs
-	for _, remoteAPIURL := range remoteAPIURL { // Iterate over each page URL
-		getData = append(getData, getDataFromURL(remoteAPIURL)) // Scrape and append HTML content
+	if err := runScrapeCommand(os.Args[1:]); err != nil { // Without a subcommand the whole run is performed
+		log.Fatalln(err)
 	}
-	// Combine all scraped HTML data into one string and extract all PDF links from it
-	finalPDFList := extractPDFUrls(strings.Join(getData, "\n"))
-	var downloadPDFURLSlice []string   // Slice to store all .pdf URLs
-	for _, doc := range finalPDFList { // Iterate over each PDF link found
-		downloadPDFURLSlice = appendToSlice(downloadPDFURLSlice, doc) // Append link to final download list
+}
+
+// Runs the scrape subcommand, also performed when no subcommand is given: discovers every document, downloads the
+// new ones and updates the manifest
+func runScrapeCommand(args []string) error {
+	if err := stageFlags("scrape").Parse(args); err != nil {
+		return err
 	}
-	downloadPDFURLSlice = removeDuplicatesFromSlice(downloadPDFURLSlice) // Remove duplicate entries from slice
-	remoteDomain := "https://www.poolseason.com"                         // Define base domain for relative links
+	return performRun()
+}
 
-	for _, urls := range downloadPDFURLSlice { // Loop through all cleaned and unique PDF links
-		domain := getDomainFromURL(urls) // Extract domain from each URL to check if it's relative or absolute
-		if domain == "" {
-			urls = remoteDomain + urls // If relative, prepend base domain
+// Performs a run with the options already parsed and reports its outcome, exiting with a distinct status when it
+// found nothing or its downloads were rejected
+func performRun() error {
+	if err := prepareRun(); err != nil {
+		return err
+	}
+	lock, err := acquireRunLock(lockFilePath) // Keep overlapping cron runs from racing on the output directory
+	if err != nil {
+		return err
+	}
+	if progressSocketPath != "" {
+		if progress, err = startProgressSocket(progressSocketPath); err != nil {
+			lock.release()
+			return err
 		}
-		if isUrlValid(urls) { // Ensure URL is syntactically valid
-			downloadPDF(urls, pdfOutputDir) // Download the PDF and save it to disk
+		defer progress.close()
+	}
+	defer lock.release()   // Free the lock once the run is over
+	summary := runScrape() // Discover and download every document
+	if summary.Aborted != "" {
+		return fmt.Errorf("run %s didn't start: %s", summary.RunID, summary.Aborted)
+	}
+	log.Printf("Run %s finished: %d documents discovered, %d downloaded", summary.RunID, summary.Discovered, summary.Downloaded)
+	if len(summary.Skips) > 0 { // Why the rest weren't downloaded
+		log.Printf("Skipped: %s", formatSkipReasons(summary.Skips))
+	}
+	if summary.Remaining > 0 { // A partial run; the next one picks up the queue
+		log.Printf("Partial run: the %s deadline ran out with %d documents left; they are saved to %s and fetched first next run", runDeadline, summary.Remaining, remainingQueuePath)
+	}
+	for _, event := range summary.Throttled { // List every throttling pause
+		log.Printf("Throttled at %s by %s (%s): paused %s", event.At.Format(time.RFC3339), event.URL, event.Status, event.Wait)
+	}
+	skippedBytes, skippedFiles := summary.Bandwidth.skippedBytes()
+	log.Printf("Bandwidth: downloaded %s (PDFs %s, pages %s, discarded %s); skipped %d files worth %s",
+		formatBytes(summary.Bandwidth.downloadedBytes()), formatBytes(summary.Bandwidth.PDFBytes), formatBytes(summary.Bandwidth.PageBytes),
+		formatBytes(summary.Bandwidth.DiscardedBytes), skippedFiles, formatBytes(skippedBytes))
+	log.Printf("Requests: %d sent, %d without a response, %s received in %s", summary.Stats.Requests, summary.Stats.RequestErrors,
+		formatBytes(summary.Stats.BytesReceived), summary.Stats.Elapsed.Round(time.Millisecond))
+	for _, host := range summary.OpenHosts {
+		log.Printf("Circuit still open for %s; its documents were skipped", host)
+	}
+	for _, item := range summary.MissingFromInventory { // Products on site without a sheet
+		log.Printf("No SDS found for inventory product %q", item.Name)
+	}
+	if summary.NoLinks { // A run that found nothing must not look successful to cron or CI
+		log.Printf("No documents were discovered; see %s for the listing pages as fetched", discoveryDebugDir)
+		lock.release()
+		if progress != nil {
+			progress.close()
 		}
+		os.Exit(exitNoDocuments)
 	}
+	if summary.Rejected != "" { // Nothing was promoted, which cron or CI should notice too
+		lock.release()
+		if progress != nil {
+			progress.close()
+		}
+		os.Exit(exitStagingRejected)
+	}
+	return nil
+}
+
+// Checks the options and opens the archive, targets and inventory a run works with
+func prepareRun() error {
+	validators := []func() error{
+		validateRedirectPolicy, validateDownloadOrder, validateSkipBy, validateFileNaming, validateOutputDirectories, validateMaxFilenameLength,
+		validateTempRetention, validateIPVersion, validateClamd, validateStaging, validateWorkers, validateAuthFailurePolicy,
+		validateSeenSet, validateQuotaAction, validateProfiles, validateDownloadHosts, validateDateOrder, validateLinkResolvers,
+		startTracing,
+	}
+	for _, validate := range validators {
+		if err := validate(); err != nil {
+			return err
+		}
+	}
+	storage, err := newStorage(storageBackend, storageURL) // Open the configured archive backend
+	if err != nil {
+		return err
+	}
+	archiveStorage = storage // Downloads are persisted through the selected backend
+	if scrapeTargets, err = loadTargets(targetsFilePath); err != nil {
+		return err
+	}
+	if inventoryFilePath != "" {
+		if inventoryItems, err = loadInventory(inventoryFilePath); err != nil {
+			return err
+		}
+	} else if inventoryOnly {
+		return fmt.Errorf("-inventory-only requires -inventory")
+	}
+	return nil
+}
+
+// Summarizes what a scrape run discovered and downloaded
+type runSummary struct {
+	RunID      string             // UUID of the run, also used as the log prefix
+	Started    time.Time          // When the run began
+	Discovered int                // Unique PDF links found on the listing pages
+	Downloaded int                // Documents newly written to disk
+	Throttled  []throttleEvent    // Pauses caused by 429/503 responses
+	Bandwidth  bandwidthReport    // Bytes downloaded and skipped
+	OpenHosts  []string           // Hosts whose circuit was open when the run finished
+	Documents  []documentOutcome  // What happened to every document the run tried to archive
+	Skips      map[skipReason]int // Skipped documents by reason
+	NoLinks    bool               // The listing pages yielded no document links at all
+	Rejected   string             // Why a staged run's downloads weren't promoted into the archive
+	Remaining  int                // Documents left for the next run when -run-deadline ran out
+	Stats      statsSnapshot      // Counters the workers reported, as of the end of the pipeline
+	Aborted    string             // Why the run stopped before discovery, e.g. its pre_run hook failed
+
+	MissingFromInventory []inventoryItem // Inventory products with no matching document
+}
+
+// Scrapes the listing pages, downloads every new PDF and updates the manifest
+func runScrape() runSummary {
+	started := time.Now().UTC() // Reported as the start of the run
+	runStats.reset(started)     // Workers report into fresh counters
+	if err := runHook("pre_run", preRunHook, runSummary{RunID: runID(), Started: started}); err != nil {
+		log.Printf("Not starting the run: %v", err) // The archive may not even be mounted
+		return runSummary{RunID: runID(), Started: started, Aborted: err.Error()}
+	}
+	runDeadlineAt = time.Time{}
+	if runDeadline > 0 { // The budget covers discovery too
+		runDeadlineAt = started.Add(runDeadline)
+	}
+	runTrace = startSpan(nil, "scrape run", spanKindInternal)
+	runTrace.set("run.id", runID())
+	tracePhase = startSpan(nil, "discover", spanKindInternal) // Listing page requests are traced under discovery
+	documents := plannedDocuments                             // The download subcommand brings its own list
+	if documents == nil {
+		documents = discoverDocuments(scrapeTargets) // Find, normalize and de-duplicate every PDF link
+		if discoveredListPath != "" {
+			if err := saveDiscoveryArtifact(discoveredListPath, documents); err != nil {
+				log.Printf("Failed to save the discovery artifact %s: %v", discoveredListPath, err)
+			}
+		}
+	}
+	tracePhase.set("documents", len(documents))
+	tracePhase.finish()
+	tracePhase = nil
+	noLinks := len(documents) == 0 // Nothing found before any filtering
+	discovered := len(documents)
+	var filtered []documentOutcome // Documents dropped before downloading
+	if inventoryOnly {             // Skip sheets for products we don't stock
+		documents = slices.DeleteFunc(documents, func(document pdfDocument) bool {
+			if !inventoryIncludes(inventoryItems, document.URL) {
+				outcome := skippedOutcome(skipFiltered, "not in the inventory")
+				outcome.URL = document.URL
+				filtered = append(filtered, outcome)
+				return true
+			}
+			return false
+		})
+	}
+	if sdsOnly || len(profileTypes) > 0 { // Brochures and labels are recognizable by name before downloading them
+		documents = slices.DeleteFunc(documents, func(document pdfDocument) bool {
+			if documentType := classifyDocumentName(document.URL, document.Anchor); !wantsDocumentType(documentType) {
+				log.Printf("Skipping %s: classified as %s by name", document.URL, documentType)
+				outcome := skippedOutcome(skipFiltered, "classified as "+documentType+" by name")
+				outcome.URL = document.URL
+				filtered = append(filtered, outcome)
+				return true
+			}
+			return false
+		})
+	}
+	documents = slices.DeleteFunc(documents, func(document pdfDocument) bool { // Links to third-party hosts are reported, never fetched
+		if reason := disallowedDownloadHost(document.URL); reason != "" {
+			log.Printf("Not downloading %s: %s", document.URL, reason)
+			outcome := skippedOutcome(skipDisallowedHost, reason)
+			outcome.URL = document.URL
+			filtered = append(filtered, outcome)
+			return true
+		}
+		return false
+	})
+
+	var absolutePDFURLs []string         // Slice to store the absolute form of every PDF link
+	for _, document := range documents { // Collect the URLs to work out which domains are involved
+		absolutePDFURLs = append(absolutePDFURLs, document.URL)
+	}
+	multiDomain := namespacedByDomain(absolutePDFURLs) // Namespace output per domain when links span several vendors, or as -directories says
+
+	summary := runSummary{RunID: runID(), Started: started, Discovered: discovered, NoLinks: noLinks} // Start the summary with what was found
+	languages := downloadLanguages()                                                                  // Language variants to request for each document
+	tagLanguages := len(languages) > 1                                                                // Only tag filenames when several variants are saved side by side
+	recoverStaging(archiveStorage)                                                                    // Finish or discard what a crashed staged run left behind
+	documentManifest := loadManifest(manifestFilePath)                                                // Load the manifest from previous runs
+	baseStorage := archiveStorage                                                                     // Canonical archive, written directly unless the run is staged
+	var staging *stagingStorage
+	if stageRuns {
+		var err error
+		if staging, err = newStagingStorage(baseStorage); err != nil {
+			log.Printf("Not staging the run: %v", err)
+		} else {
+			archiveStorage = staging // Downloads stay out of the archive until the run validates
+			defer func() { archiveStorage = baseStorage }()
+		}
+	}
+	if staging == nil { // A staged run's manifest is only saved by its promotion, so there is nothing to journal
+		if err := documentManifest.openJournal(manifestFilePath); err != nil { // Journal updates so a crash can't lose them
+			log.Printf("Not journaling manifest updates: %v", err)
+		}
+	}
+	documents = orderDocuments(documents, downloadOrder, documentManifest) // Most important documents first in case the run is interrupted
+	archiveUsage.reset(documentManifest)                                   // Starting point for the size quota
+	seenAt := time.Now().UTC()                                             // Every discovered document counts as seen now
+	for _, document := range documents {
+		if _, known := documentManifest.lookup(document.URL, ""); !known && eventSink != nil {
+			publishEvent(archiveEvent{Type: eventDocumentDiscovered, URL: document.URL, Category: document.Category})
+		}
+		documentManifest.markSeen(document.URL, seenAt) // Drives retention of documents removed from the site
+	}
+	documentManifest.noteDiscovery(documents, languages) // New documents, and archived ones no longer listed
+	var jobs []pipelineJob                               // Every document and language variant to fetch
+	for _, document := range documents {                 // Loop through every absolute PDF link
+		if isUrlValid(document.URL) { // Ensure URL is syntactically valid
+			outputDir := domainOutputDir(pdfOutputDir, getDomainFromURL(document.URL), multiDomain) // Pick the directory for this vendor
+			for _, language := range languages {                                                    // Fetch every requested language variant
+				jobs = append(jobs, pipelineJob{Document: document, OutputDir: outputDir, Language: language})
+			}
+		}
+	}
+	if checkChanges { // Changed documents jump the queue
+		jobs = prioritizeUpstreamChanges(jobs, documentManifest)
+	}
+	jobs = applyRevalidationFindings(jobs)                                // So do those the daemon's sweep found changed
+	jobs = resumeRemainingQueue(jobs)                                     // What the last run ran out of time for comes first
+	summary.Documents = runPipeline(jobs, tagLanguages, documentManifest) // Download and process, in queue order
+	documentManifest.noteSuperseded()                                     // Sheets revised under a new URL
+	summary.Documents = append(summary.Documents, filtered...)
+	summary.Skips = countSkipReasons(summary.Documents)
+	summary.Remaining = saveRemainingQueue(summary.Documents)
+	clearRevalidationFindings(summary.Documents)
+	for _, outcome := range summary.Documents {
+		if outcome.Status == outcomeDownloaded {
+			summary.Downloaded++ // Count successful downloads
+		}
+	}
+	summary.Stats = runStats.snapshot()
+	if writeChecksums { // Refresh the companion checksum files
+		writeChecksumFiles(documentManifest.list())
+	}
+	if writeMetadataSidecars { // Refresh the extracted fields, keeping what people entered
+		writeMetadataSidecarFiles(documentManifest.list())
+	}
+	if staging != nil {
+		if err := staging.validate(documentManifest, summary.Documents); err != nil {
+			log.Printf("Not promoting run %s; the archive is unchanged: %v", summary.RunID, err)
+			staging.discard()
+			summary.Rejected = err.Error()
+			documentManifest = loadManifest(manifestFilePath) // Report on the archive as it still is
+		} else if err := staging.promote(documentManifest); err != nil {
+			log.Printf("Promotion of run %s is incomplete; the next run finishes it: %v", summary.RunID, err)
+		}
+		archiveStorage = baseStorage
+	} else {
+		documentManifest.save(manifestFilePath)         // Persist the manifest for the next run
+		documentManifest.closeJournal(manifestFilePath) // Everything journaled is now saved
+	}
+	writeCASIndex(casIndexFilePath, buildCASIndex(documentManifest.list())) // Refresh the chemical lookup index
+	summary.Throttled = requestThrottle.drainEvents()                       // Report every pause the server asked for
+	summary.Bandwidth = runBandwidth.drain()                                // Bytes transferred and avoided
+	summary.OpenHosts = hostBreakers.openHosts()                            // Hosts that were given up on
+	appendBandwidthLog(bandwidthLogPath, summary.Bandwidth)
+	if junitReportPath != "" {
+		if err := writeJUnitReport(junitReportPath, summary); err != nil {
+			log.Printf("Failed to write JUnit report %s: %v", junitReportPath, err)
+		}
+	}
+	cleanupTempFiles() // Apply the retention policy to failed attempts
+	if gitCommitRuns {
+		if err := commitArchive(summary); err != nil {
+			log.Printf("Failed to commit the archive: %v", err)
+		}
+	}
+	completed := archiveEvent{Type: eventRunCompleted, Discovered: summary.Discovered, Downloaded: summary.Downloaded}
+	for _, outcome := range summary.Documents {
+		if outcome.Status == outcomeFailed {
+			completed.Failed++
+		}
+	}
+	publishEvent(completed)
+	emitProgress(progressEvent{Type: progressRunFinished})
+	runTrace.set("documents.downloaded", summary.Downloaded)
+	runTrace.finish()
+	flushTraces()
+	for _, match := range crossReferenceInventory(inventoryItems, documentManifest.list()) {
+		if len(match.Entries) == 0 {
+			summary.MissingFromInventory = append(summary.MissingFromInventory, match.Item)
+		}
+	}
+	if err := runHook("post_run", postRunHook, summary); err != nil {
+		log.Println(err) // The run itself succeeded
+	}
+	return summary // Report what the run did
+}
+
+// Runs the vendor adapter of each target and returns the documents they find, normalized and de-duplicated
+func discoverDocuments(targets []scrapeTarget) []pdfDocument {
+	var documents []pdfDocument                                                                       // Documents in the order they were found
+	seen := newURLSet()                                                                               // Normalized URLs already collected
+	run := &discoveryRun{pageCache: loadPageCache(pageCacheFilePath), excluded: make(map[string]int)} // Links extracted by earlier runs
+	run.frontier = openCrawlFrontier(frontierPath)                                                    // Resumes an interrupted crawl
+	for _, adapter := range adaptersFor(targets, run) {                                               // Each vendor's adapter in the order its targets appear
+		found := 0
+		for _, document := range adapter.Discover(context.Background()) {
+			if !seen.add(document.URL) { // Variants of the same link collapse to one document
+				continue
+			}
+			documents = append(documents, document)
+			found++
+		}
+		run.found += found
+		log.Printf("The %s adapter found %d documents", adapter.Name(), found)
+	}
+	for _, category := range slices.Sorted(maps.Keys(run.excluded)) {
+		label := category
+		if label == "" {
+			label = "(no heading)"
+		}
+		log.Printf("Skipped %d documents listed under %q: the category is disabled", run.excluded[category], label)
+	}
+	run.frontier.close(true) // Every page was crawled, so the next run starts over
+	savePageCache(pageCacheFilePath, run.pageCache)
+	saveURLSet(seen) // Lets an interrupted crawl resume its de-duplication
+	if len(documents) == 0 {
+		reportEmptyDiscovery(run.emptyPages) // Explain the silence instead of finishing as if all was well
+	}
+	return documents // Return the unique documents
+}
+
+// Strips a leading "www." so www.example.com and example.com share one namespace
+func normalizeDomain(domain string) string {
+	return strings.TrimPrefix(strings.ToLower(domain), "www.") // Lowercase and drop the www prefix
+}
+
+// Counts how many distinct domains appear in a list of absolute URLs
+func countDomains(urls []string) int {
+	domains := make(map[string]bool) // Set of domains seen so far
+	for _, rawURL := range urls {    // Check each URL
+		if domain := normalizeDomain(getDomainFromURL(rawURL)); domain != "" {
+			domains[domain] = true // Record the domain
+		}
+	}
+	return len(domains) // Return the number of unique domains
+}
+
+// Returns the output directory for a domain, nesting it under baseDir when namespacing is enabled
+func domainOutputDir(baseDir string, domain string, namespaced bool) string {
+	domain = normalizeDomain(domain) // Use the normalized domain as the directory name
+	if !namespaced || domain == "" { // Single-vendor runs keep the flat layout
+		return baseDir
+	}
+	outputDir := filepath.Join(baseDir, domain) // Build the per-domain directory path
+	if !directoryExists(outputDir) {            // Create it on first use
+		createDirectory(outputDir, 0o755)
+	}
+	return outputDir // Return the namespaced directory
 }
 
 // Extract domain name from a URL string (like speedybee.com)
@@ -77,27 +566,41 @@ func getFileNameOnly(content string) string {
 	return path.Base(content) // Return last segment of the path
 }
 
-// Converts a raw URL into a safe filename by cleaning and normalizing it
+// Converts a raw URL into a safe filename, shortened to -max-filename-length
 func urlToFilename(rawURL string) string {
-	lowercaseURL := strings.ToLower(rawURL)       // Convert to lowercase for normalization
-	ext := getFileExtension(lowercaseURL)         // Get file extension (e.g., .pdf or .zip)
-	baseFilename := getFileNameOnly(lowercaseURL) // Extract base file name
-
-	nonAlphanumericRegex := regexp.MustCompile(`[^a-z0-9]+`)                 // Match everything except a-z and 0-9
-	safeFilename := nonAlphanumericRegex.ReplaceAllString(baseFilename, "_") // Replace invalid chars
+	return truncateFilename(untruncatedURLFilename(rawURL), rawURL)
+}
 
-	collapseUnderscoresRegex := regexp.MustCompile(`_+`)                        // Collapse multiple underscores into one
-	safeFilename = collapseUnderscoresRegex.ReplaceAllString(safeFilename, "_") // Normalize underscores
+// Converts a raw URL into a filename with -filename-chain when one is configured, else with the built-in naming
+func untruncatedURLFilename(rawURL string) string {
+	if len(filenameChain) > 0 {
+		return chainedFilename(rawURL)
+	}
+	return builtinURLFilename(rawURL)
+}
 
-	if trimmed, found := strings.CutPrefix(safeFilename, "_"); found { // Trim starting underscore if present
-		safeFilename = trimmed
+// Converts a raw URL into a safe filename by cleaning and normalizing it
+func builtinURLFilename(rawURL string) string {
+	if parsedURL, err := url.Parse(rawURL); err == nil {
+		rawURL = latin1ToUTF8(parsedURL.Path) // Name files after the decoded path only; query strings and fragments would corrupt the extension
 	}
+	lowercaseURL := strings.ToLower(rawURL) // Convert to lowercase for normalization
+	ext := sanitizeFilename(getFileExtension(lowercaseURL))
+	if ext != "" {
+		ext = "." + strings.Trim(ext, "_") // Get file extension (e.g., .pdf or .zip)
+	}
+	baseFilename := getFileNameOnly(lowercaseURL) // Extract base file name
+
+	safeFilename := sanitizeFilename(baseFilename) // Unicode-aware: accents folded, other separators become "_"
 
 	var invalidSubstrings = []string{"_pdf", "_zip"} // Remove these redundant endings
 
 	for _, invalidPre := range invalidSubstrings { // Iterate over each unwanted suffix
 		safeFilename = removeSubstring(safeFilename, invalidPre) // Remove it from file name
 	}
+	if ext != "" {
+		safeFilename = strings.TrimSuffix(safeFilename, "_"+strings.TrimPrefix(ext, ".")) // Other types -accept adds, e.g. _xlsx
+	}
 
 	safeFilename = safeFilename + ext // Add the proper file extension
 
@@ -124,61 +627,395 @@ func fileExists(filename string) bool {
 	return !info.IsDir() // Return true only if it's not a directory
 }
 
-// Downloads and writes a PDF file from the URL to the specified directory
-func downloadPDF(finalURL, outputDir string) bool {
-	filename := strings.ToLower(urlToFilename(finalURL)) // Generate sanitized filename
-	filePath := filepath.Join(outputDir, filename)       // Build full path
+// Downloads a PDF file from the URL into memory for the processing stage; documents that are skipped or can't be
+// downloaded return their outcome and false instead
+func downloadPDF(ctx context.Context, document pdfDocument, outputDir string, language string, tagLanguage bool, changed bool, documentManifest *manifest) (downloadedPDF, documentOutcome, bool) {
+	finalURL := document.URL            // Absolute URL of the file to download
+	filename := urlToFilename(finalURL) // Generate sanitized filename; the built-in naming is already lowercase
+	if tagLanguage {
+		filename = languageTaggedFilename(filename, language) // Keep language variants apart on disk
+	}
+	filePath := filepath.ToSlash(filepath.Join(outputDir, filename)) // Build the storage key
+	if previous, found := documentManifest.lookup(finalURL, language); found {
+		filePath = previous.File // Sniffing may have routed an earlier download to another directory
+	}
 
-	if fileExists(filePath) { // Skip if already downloaded
+	var exists bool // Whether an earlier download can be reused
+	var err error
+	if skipBy == "hash" { // Trust the manifest's URL → hash record instead of file names
+		exists = archivedByHash(documentManifest, finalURL, language)
+	} else {
+		exists, err = archiveStorage.Exists(filePath) // Check the archive for an earlier download
+		if err != nil {
+			log.Printf("Failed to check storage for %s: %v", filePath, err)
+			return downloadedPDF{}, failedOutcome(fmt.Errorf("checking storage for %s: %w", filePath, err)), false
+		}
+	}
+	if exists && !changed { // Skip if already downloaded, unless it changed upstream
+		previous, _ := documentManifest.lookup(finalURL, language) // Size from the manifest, zero when unknown
+		if previous.File != "" {
+			filePath = previous.File // Hash-based skips may have found the file under a new name
+		}
 		log.Printf("File already exists, skipping: %s", filePath)
-		return false
+		runBandwidth.addSkipped("already-archived", previous.Size)
+		outcome := skippedOutcome(skipExists, "already archived")
+		outcome.File = filePath
+		return downloadedPDF{}, outcome, false
+	}
+	if archiveUsage.exhausted() { // -quota-action stop ended downloading for this run
+		return downloadedPDF{}, skippedOutcome(skipQuota, errQuotaExceeded.Error()), false
+	}
+	if now := time.Now(); !scrapeAllowed(now) { // The daemon's scrape window closed mid-run
+		return downloadedPDF{}, skippedOutcome(skipOutsideWindow, scrapeWindowMessage(now)), false
+	}
+	if runDeadlinePassed(time.Now()) { // Downloads already under way finish; nothing new starts
+		return downloadedPDF{}, skippedOutcome(skipDeadline, runDeadlineMessage()), false
 	}
 
-	client := &http.Client{Timeout: 3 * time.Minute} // Create HTTP client with 3-minute timeout to avoid hanging
+	client := httpClient() // Shared client with per-phase timeouts
+	if previous, found := documentManifest.lookup(finalURL, language); found && wantsDeltaTransfer(previous) {
+		fetched, err := fetchDelta(ctx, client, finalURL, previous) // Only the changed blocks of a big ZIP
+		if err == nil {
+			return downloadedPDF{FilePath: filePath, Fetched: fetched}, documentOutcome{}, true
+		}
+		if !errors.Is(err, errNoDeltaControl) {
+			log.Printf("Delta transfer of %s failed; downloading it whole: %v", finalURL, err)
+		}
+	}
 
-	resp, err := client.Get(finalURL) // Perform HTTP GET request to download the file
-	if err != nil {                   // Check if an error occurred during request
-		log.Printf("Failed to download %s: %v", finalURL, err) // Log the error with context
-		return false                                           // Exit function if request failed
+	throttledRetries := 0                                         // Times this download was paused by 429/503 responses
+	authRetried := false                                          // Whether credentials were refreshed for this download
+	var partial *fetchedPDF                                       // Interrupted transfer the next attempt resumes
+	var lastErr error                                             // Why the latest attempt failed
+	for attempt := 1; attempt <= maxDownloadAttempts; attempt++ { // Retry downloads that fail verification
+		sentAt := time.Now()
+		fetched, retry, err := fetchPDF(ctx, client, finalURL, language, partial) // Download and verify the body
+		var open *circuitOpenError
+		if errors.As(err, &open) { // The host is being skipped for now
+			previous, _ := documentManifest.lookup(finalURL, language)
+			runBandwidth.addSkipped("circuit-open", previous.Size)
+			log.Printf("Skipping %s: %v", finalURL, err)
+			return downloadedPDF{}, skippedOutcome(skipCircuitOpen, err.Error()), false
+		}
+		if err != nil && throttledRetries < maxThrottleRetries && requestThrottle.handle(finalURL, err) {
+			throttledRetries++ // Wait out the pause without using up a download attempt
+			attempt--
+			continue
+		}
+		if err != nil && !authRetried && sessionRefresher.handle(finalURL, sentAt, err) {
+			authRetried = true // A second rejection means this document itself is off limits
+			attempt--
+			continue
+		}
+		if err != nil { // Download or verification failed
+			log.Printf("Attempt %d/%d for %s failed: %v", attempt, maxDownloadAttempts, finalURL, err)
+			saveFailedAttempt(finalURL, attempt, fetched, err) // Keep what arrived for debugging, if configured
+			lastErr = err
+			if !retry { // Permanent failures aren't worth retrying
+				if reason := skipReasonFor(err); reason != "" {
+					return downloadedPDF{}, skippedOutcome(reason, err.Error()), false // Not a document we want, rather than a failure
+				}
+				return downloadedPDF{}, failedOutcome(err), false
+			}
+			partial = nil
+			if fetched.Resumable {
+				partial = &fetched // Continue from the received bytes instead of starting over
+			}
+			continue // Try again
+		}
+		return downloadedPDF{FilePath: filePath, Fetched: fetched}, documentOutcome{}, true
 	}
-	defer resp.Body.Close() // Ensure the response body is closed after reading
+	log.Printf("Giving up on %s after %d attempts", finalURL, maxDownloadAttempts) // Every attempt failed
+	return downloadedPDF{}, failedOutcome(fmt.Errorf("giving up after %d attempts: %w", maxDownloadAttempts, lastErr)), false
+}
 
-	if resp.StatusCode != http.StatusOK { // Check for HTTP 200 OK status
-		log.Printf("Download failed for %s: %s", finalURL, resp.Status) // Log failure reason
-		return false                                                    // Exit if status is not OK
+// Classifies, names and stores a downloaded document, then records it in the manifest; storage failures are
+// retried with the bytes already in memory
+func processPDF(document pdfDocument, language string, tagLanguage bool, downloaded downloadedPDF, documentManifest *manifest) documentOutcome {
+	finalURL, filePath, fetched := document.URL, downloaded.FilePath, downloaded.Fetched
+	data := fetched.Data                                           // Verified file contents
+	documentType := classifyDocument(document, fetched.Kind, data) // SDS, label or something else, judged from the contents
+	if !wantsDocumentType(documentType) {
+		runBandwidth.discard(int64(len(data))) // Received but not kept
+		log.Printf("Discarding %s: its contents are classified as %s", finalURL, documentType)
+		return skippedOutcome(skipFiltered, "contents classified as "+documentType)
+	}
+	if err := scanDownload(finalURL, filePath, data); err != nil { // Nothing reaches the archive unscanned when -clamd is set
+		runBandwidth.discard(int64(len(data))) // Received but not kept
+		if errors.Is(err, errInfected) {
+			return skippedOutcome(skipInfected, err.Error())
+		}
+		log.Printf("Not storing %s: %v", finalURL, err)
+		return failedOutcome(err)
+	}
+	filePath = routeByKind(filePath, fetched.Kind) // ZIPs and Word files served from PDF links go to their own directory
+	filePath = routeByType(filePath, documentType) // Labels go to their own directory with the labels profile
+	var metadata sdsMetadata                       // Read from the sheet itself
+	if fetched.Kind == "pdf" {
+		metadata = extractSDSMetadata(filePath, data, language) // Revision date and hazards printed on the sheet
+		if fileNaming == "product" {
+			filePath = productFilePath(filePath, metadata, language, tagLanguage) // Revisions of one product sort together
+			filePath = uniqueProductPath(documentManifest, filePath, finalURL, language)
+		}
+	}
+	if owner, found := documentManifest.contentOwner(sha256Hex(data), finalURL); found { // Another URL serves the same file
+		runBandwidth.discard(int64(len(data))) // Received but not kept
+		log.Printf("Not storing %s: same contents as %s in %s", finalURL, owner.URL, owner.File)
+		outcome := skippedOutcome(skipDuplicateContent, "same contents as "+owner.URL)
+		outcome.File = owner.File
+		return outcome
+	}
+	previous, _ := documentManifest.lookup(finalURL, language) // Replaced by this download
+	replaced := previous.Size                                  // Bytes freed by replacing the previous copy
+	var revision manifestRevision                              // Previous copy kept alongside the new one
+	keptRevision := false
+	if previous.SHA256 != "" && previous.SHA256 != sha256Hex(data) { // The contents changed since the last download
+		if revision, keptRevision = keepPreviousRevision(previous, filePath); keptRevision {
+			replaced = 0 // The previous copy stays in the archive
+		}
+	}
+	if err := archiveUsage.reserve(documentManifest, filePath, int64(len(data)), replaced); err != nil {
+		runBandwidth.discard(int64(len(data))) // Received but not kept
+		if keptRevision && revision.File != previous.File {
+			archiveStorage.Delete(revision.File) // The current copy wasn't replaced after all
+		}
+		return skippedOutcome(skipQuota, err.Error())
+	}
+	var err error
+	for attempt := 1; attempt <= maxDownloadAttempts; attempt++ { // Retry storage without downloading again
+		if err = archiveStorage.Put(filePath, data); err == nil { // Store the verified data
+			break
+		}
+		log.Printf("Storing attempt %d/%d for %s failed: %v", attempt, maxDownloadAttempts, finalURL, err)
+		saveFailedAttempt(finalURL, attempt, fetched, err)
+	}
+	if err != nil {
+		runBandwidth.discard(int64(len(data))) // Received but not kept
+		archiveUsage.release(int64(len(data)) - replaced)
+		if keptRevision && revision.File != previous.File {
+			archiveStorage.Delete(revision.File) // The current copy wasn't replaced after all
+		}
+		log.Printf("Giving up on storing %s after %d attempts", finalURL, maxDownloadAttempts)
+		return failedOutcome(fmt.Errorf("giving up after %d attempts: %w", maxDownloadAttempts, err))
 	}
 
-	contentType := resp.Header.Get("Content-Type")         // Retrieve the content type from HTTP headers
-	if !strings.Contains(contentType, "application/pdf") { // Ensure it's a PDF
-		log.Printf("Invalid content type for %s: %s (expected application/pdf)", finalURL, contentType)
-		return false // Skip if it's not a PDF
+	entry := manifestEntry{ // Remember where this document came from
+		URL:          finalURL,
+		Language:     language,
+		Category:     document.Category,
+		Domain:       normalizeDomain(getDomainFromURL(finalURL)),
+		File:         filePath,
+		Size:         int64(len(data)),
+		SHA256:       sha256Hex(data),
+		LastModified: parseHTTPTime(fetched.Header.Get("Last-Modified")),
+		DownloadedAt: time.Now().UTC(),
+		Redirects:    fetched.Redirects,
+		Resolution:   document.Resolution,
+		ETag:         fetched.Header.Get("ETag"),
+		Type:         documentType,
+		RunID:        runID(),
+	}
+	entry.SDS = metadata
+	entry.Group = translationGroup(entry) // Translations of one sheet share a group
+	if original := untruncatedURLFilename(finalURL); original != urlToFilename(finalURL) {
+		entry.OriginalName = original // The name the link would have given the file, too long for the filesystem
+	}
+	if keptRevision {
+		entry.Revisions = append([]manifestRevision{revision}, previous.Revisions...) // Newest first
+	}
+	if tracked, found := documentManifest.tracked(finalURL, language); found { // Continue the document's lifecycle
+		entry.State, entry.StateChangedAt, entry.Transitions = tracked.State, tracked.StateChangedAt, tracked.Transitions
+	}
+	if previous.SHA256 != "" && previous.SHA256 != entry.SHA256 {
+		entry.moveTo(stateDownloaded, entry.DownloadedAt, "changed upstream")
+	} else {
+		entry.moveTo(stateDownloaded, entry.DownloadedAt, "")
+	}
+	if fetched.Kind == "zip" && extractZips { // Unpack bundles next to the ZIP, within the safety limits
+		destination := strings.TrimSuffix(filePath, getFileExtension(filePath))
+		if keys, err := extractZIP(data, destination); err != nil {
+			log.Printf("Not extracting %s: %v", filePath, err)
+		} else {
+			log.Printf("Extracted %d files from %s into %s", len(keys), filePath, destination)
+		}
+	}
+	documentManifest.record(entry)
+	stored := archiveEvent{Type: eventDocumentDownloaded, URL: finalURL, Language: language, Category: document.Category, File: filePath, Size: entry.Size, SHA256: entry.SHA256}
+	publishEvent(stored)
+	if previous.SHA256 != "" && previous.SHA256 != entry.SHA256 {
+		stored.Type, stored.PreviousSHA256 = eventDocumentChanged, previous.SHA256
+		publishEvent(stored)
 	}
 
-	var buf bytes.Buffer                     // Create buffer to temporarily hold the file data
-	written, err := io.Copy(&buf, resp.Body) // Copy response body into buffer
-	if err != nil {                          // Handle error while reading response
-		log.Printf("Failed to read PDF data from %s: %v", finalURL, err)
-		return false
+	log.Printf("Successfully downloaded %d bytes: %s → %s", len(data), finalURL, filePath) // Log successful download
+	return documentOutcome{Status: outcomeDownloaded, File: filePath}                      // Return success
+}
+
+// A verified download waiting for the processing stage
+type downloadedPDF struct {
+	FilePath string     // Storage key derived from the URL, before routing and product naming
+	Fetched  fetchedPDF // Contents and response headers
+}
+
+// Holds a verified download together with the response headers it arrived with; failed attempts carry whatever
+// was received
+type fetchedPDF struct {
+	Data      []byte      // Verified file contents
+	Header    http.Header // Response headers sent by the server
+	Kind      string      // Document kind sniffed from the contents, e.g. pdf or zip
+	Redirects []string    // Redirect chain that led to the file, if any
+	Resumable bool        // Data is an intact prefix of the file that a ranged request can complete
+}
+
+// Fetches a PDF into memory and verifies it, reporting whether a failure is worth retrying; a partial transfer
+// from an earlier attempt is resumed when the file hasn't changed since
+func fetchPDF(ctx context.Context, client *http.Client, finalURL string, language string, partial *fetchedPDF) (fetchedPDF, bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, finalURL, nil) // Build the GET request for the file
+	if err != nil {
+		return fetchedPDF{}, false, fmt.Errorf("failed to build request: %w", err)
+	}
+	setAcceptLanguage(request, language) // Ask for the requested language variant
+	if partial != nil {
+		setResumeHeaders(request, *partial)
+	}
+
+	host := getDomainFromURL(finalURL)               // Circuit breakers are kept per host
+	if err := hostBreakers.allow(host); err != nil { // Don't contact hosts that keep failing
+		return fetchedPDF{}, false, err
+	}
+	request, cancel := withFileDeadline(request) // Bound the whole transfer, body included
+	defer cancel()
+
+	requestThrottle.wait()          // Honour any pipeline-wide pause
+	resp, err := client.Do(request) // Perform HTTP GET request to download the file
+	if err != nil {                 // Check if an error occurred during request
+		if errors.Is(err, errRedirectNotAllowed) {
+			return fetchedPDF{}, false, err // Policy violations won't change on retry
+		}
+		hostBreakers.failure(host)
+		return fetchedPDF{}, true, fmt.Errorf("failed to download: %w", err) // Network errors may be transient
+	}
+	resp.Body = newIdleTimeoutReader(resp.Body, bodyIdleTimeout, cancel) // Abort transfers that stall
+	defer resp.Body.Close()                                              // Ensure the response body is closed after reading
+
+	recordHostResponse(host, resp.StatusCode) // Server errors count towards the host's circuit
+	redirects := redirectChain(resp)          // Kept in the manifest for provenance
+	if redirects != nil {
+		log.Printf("Followed redirects: %s", strings.Join(redirects, " → "))
+	}
+	if err := checkThrottled(resp); err != nil { // The caller pauses the pipeline and retries
+		return fetchedPDF{}, true, err
+	}
+	if err := checkAuthRejected(resp); err != nil { // The caller may refresh the host's credentials and retry
+		return fetchedPDF{Header: resp.Header}, false, err
+	}
+
+	if served := resp.Header.Get("Content-Language"); language != "" && served != "" && !strings.EqualFold(served, language) {
+		log.Printf("Requested %s for %s but server returned %s", language, finalURL, served) // Server fell back to another language
+	}
+
+	header := resp.Header               // Headers describing the whole file
+	contentLength := resp.ContentLength // Size of the whole file, when known
+	decoded := resp.Uncompressed        // The transport gunzipped the body, so digests and ranges of the sent bytes don't apply
+	var prefix []byte                   // Bytes received by the interrupted attempt
+	switch {
+	case partial != nil && resp.StatusCode == http.StatusPartialContent:
+		if contentLength, err = resumedSize(resp, int64(len(partial.Data))); err != nil {
+			return fetchedPDF{}, true, err // Start over on the next attempt
+		}
+		prefix, header = partial.Data, partial.Header // Checksums and type were sent with the original response
+		log.Printf("Resuming %s at byte %d of %d", finalURL, len(prefix), contentLength)
+	case partial != nil && resp.StatusCode == http.StatusOK:
+		log.Printf("%s changed since the interrupted attempt or can't be resumed; downloading it again", finalURL)
+	case resp.StatusCode != http.StatusOK: // Check for HTTP 200 OK status
+		return fetchedPDF{Header: resp.Header}, false, fmt.Errorf("download failed: %s", resp.Status)
+	}
+
+	if maxFileSize > 0 && contentLength > maxFileSize { // Known to be too large before reading any of it
+		runBandwidth.addSkipped(string(skipTooLarge), contentLength)
+		return fetchedPDF{Header: resp.Header}, false, fmt.Errorf("%w: %s is over the %s limit", errFileTooLarge, formatBytes(contentLength), formatBytes(maxFileSize))
+	}
+	body := bufio.NewReaderSize(resp.Body, sniffLength) // Buffer the start of the body for sniffing
+	head, _ := body.Peek(sniffLength)                   // Short bodies return what there is
+	if prefix != nil {
+		head = append(slices.Clip(prefix), head...) // The file starts with the bytes already received
+	}
+	kind := sniffDocumentKind(head)           // Servers often send octet-stream or text/html for real PDFs
+	contentType := header.Get("Content-Type") // What the server claims
+	if kind == "" {
+		kind = declaredKind(contentType) // Types without a signature that -accept lists are taken at the server's word
+	}
+	expected, supported := documentKinds[kind]
+	if !supported || !acceptsKind(kind) {
+		if prefix == nil { // A removed document's replacement page is worth telling apart from other unwanted content
+			page, _ := io.ReadAll(io.LimitReader(body, softNotFoundScanLength))
+			if isSoftNotFound(kind, contentType, page) {
+				return fetchedPDF{Data: page, Header: header}, false, fmt.Errorf("%w (Content-Type %s)", errSoftNotFound, contentType)
+			}
+		}
+		return fetchedPDF{Data: head, Header: header}, false, fmt.Errorf("%w (sniffed %q, Content-Type %s)", errUnrecognizedContent, kind, contentType)
+	}
+	if !strings.Contains(contentType, expected.MIME) {
+		log.Printf("%s was sent as %q but contains a %s", finalURL, contentType, kind)
+	}
+
+	var data []byte                // File contents
+	var written int64              // Bytes received
+	if canDownloadInChunks(resp) { // Large files on servers that accept ranges are fetched in parallel
+		data, written, err = fetchInChunks(client, request, resp, body)
+		if err != nil {
+			runBandwidth.addPDF(written, true) // Chunks already received are thrown away
+			return fetchedPDF{Header: resp.Header}, true, fmt.Errorf("chunked download failed: %w", err)
+		}
+	} else {
+		var buf bytes.Buffer // Create buffer to temporarily hold the file data
+		buf.Write(prefix)    // Resumed downloads continue after the received bytes
+		var reader io.Reader = body
+		if maxFileSize > 0 {
+			reader = io.LimitReader(body, maxFileSize-int64(len(prefix))+1) // One byte past the limit is enough to reject it
+		}
+		written, err = io.Copy(&buf, reader) // Copy response body into buffer
+		if err != nil {                      // Handle error while reading response
+			runBandwidth.addPDF(written, true) // A partial body is thrown away unless the next attempt resumes it
+			hostBreakers.failure(host)
+			return fetchedPDF{Data: buf.Bytes(), Header: header, Resumable: !decoded && canResume(header)}, true, fmt.Errorf("failed to read PDF data: %w", err)
+		}
+		data = buf.Bytes()
+	}
+	if maxFileSize > 0 && int64(len(data)) > maxFileSize { // Servers that don't send Content-Length
+		runBandwidth.addPDF(written, true)
+		return fetchedPDF{Header: header}, false, fmt.Errorf("%w: %s is over the %s limit", errFileTooLarge, formatBytes(int64(len(data))), formatBytes(maxFileSize))
 	}
 	if written == 0 { // If nothing was read (empty file)
-		log.Printf("Downloaded 0 bytes for %s; not creating file", finalURL)
-		return false
+		return fetchedPDF{}, false, fmt.Errorf("downloaded 0 bytes; not creating file")
 	}
 
-	out, err := os.Create(filePath) // Create file on disk at the specified location
-	if err != nil {                 // Handle file creation error
-		log.Printf("Failed to create file for %s: %v", finalURL, err)
-		return false
+	if err := verifyDownload(header, contentLength, data, decoded); err != nil { // Check length and checksums
+		runBandwidth.addPDF(written, true)
+		return fetchedPDF{Data: data, Header: header}, true, fmt.Errorf("verification failed: %w", err) // Truncated or corrupted transfers are retried
+	}
+	runBandwidth.addPDF(written, false)
+	if kind = refineOfficeKind(kind, data); !acceptedKinds[kind] { // Word or Excel, now that the whole container is here
+		return fetchedPDF{Data: data, Header: header}, false, fmt.Errorf("%w (%s, Content-Type %s)", errUnrecognizedContent, kind, contentType)
 	}
-	defer out.Close() // Ensure file is closed after writing
+	return fetchedPDF{Data: data, Header: header, Kind: kind, Redirects: redirects}, false, nil // Return the verified data
+}
 
-	if _, err := buf.WriteTo(out); err != nil { // Write buffer contents to file
-		log.Printf("Failed to write PDF to file for %s: %v", finalURL, err)
-		return false
+// Parses an HTTP date header, returning the zero time when it is missing or malformed
+func parseHTTPTime(value string) time.Time {
+	parsed, err := http.ParseTime(value) // Accepts every date format allowed by HTTP
+	if err != nil {
+		return time.Time{} // Missing or malformed header
 	}
+	return parsed.UTC() // Store times in UTC
+}
 
-	log.Printf("Successfully downloaded %d bytes: %s → %s", written, finalURL, filePath) // Log successful download
-	return true                                                                          // Return success
+// Writes data to filePath and removes the file again if it ends up incomplete
+func writeFileVerified(filePath string, data []byte) error {
+	if err := writeFileAtomic(filePath, data); err != nil { // Never leave a partial file, even on a network share
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil // File written completely
 }
 
 // Checks if a directory exists at the given path
@@ -217,38 +1054,171 @@ func removeDuplicatesFromSlice(slice []string) []string {
 	return newReturnSlice // Return cleaned slice
 }
 
-// Extracts all URLs ending in .pdf found in href attributes from given HTML content
-func extractPDFUrls(input string) []string {
-	re := regexp.MustCompile(`href="([^"]+\.pdf)"`) // Regex to find href links ending in .pdf
-	matches := re.FindAllStringSubmatch(input, -1)  // Get all matches
+// Attributes that can point at a document, keyed by the tag they belong to
+var pdfLinkAttributes = map[string][]string{
+	"a":      {"href"}, // Ordinary links
+	"iframe": {"src"},  // Inline PDF viewers
+	"embed":  {"src"},  // Embedded PDF plugins
+	"object": {"data"}, // <object data="...pdf">
+}
 
-	var pdfUrls []string // Store extracted links
-	for _, match := range matches {
-		if len(match) > 1 { // Ensure match contains the full URL
-			pdfUrls = append(pdfUrls, match[1]) // Add only the link (not the whole match)
+// Data attributes CMS themes use on arbitrary elements to carry document links
+var pdfDataAttributes = []string{"data-href", "data-file"}
+
+// Reports whether a link's path ends in .pdf, or in the extension of another type -accept lists, ignoring case,
+// query string and fragment
+func isPDFLink(link string) bool {
+	parsed, err := url.Parse(strings.TrimSpace(link)) // Separate the path from query and fragment
+	if err != nil {
+		return false // Unparseable links can't be downloaded anyway
+	}
+	extension := strings.ToLower(path.Ext(parsed.Path))             // Match .pdf and .PDF alike
+	return extension == ".pdf" || acceptedLinkExtensions[extension] // Other types are mostly served from PDF links
+}
+
+// Walks the HTML in document order and calls visit for every PDF link with the heading it appears under
+func walkPDFLinks(input string, visit func(link string, category string)) {
+	tokenizer := html.NewTokenizer(strings.NewReader(input)) // Tolerant HTML5 tokenizer
+	currentCategory := ""                                    // Heading most recently seen
+	var heading strings.Builder                              // Text of the heading being read
+	inHeading := false                                       // Whether we're inside an <h1>…<h6>
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken: // io.EOF or a fatal parse error ends the walk
+			return
+		case html.TextToken:
+			if inHeading {
+				heading.Write(tokenizer.Text()) // Collect heading text, including nested markup
+				heading.WriteByte(' ')
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if inHeading && isHeadingTag(string(name)) { // Heading finished; it becomes the current category
+				currentCategory = strings.Join(strings.Fields(heading.String()), " ")
+				inHeading = false
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token() // Decoded tag name and attributes
+			if isHeadingTag(token.Data) {
+				inHeading = true
+				heading.Reset()
+				continue
+			}
+			for _, attribute := range token.Attr { // Check every attribute that may hold a document link
+				if (slices.Contains(pdfLinkAttributes[token.Data], attribute.Key) || slices.Contains(pdfDataAttributes, attribute.Key)) && (isPDFLink(attribute.Val) || isWrappedLink(attribute.Val)) {
+					visit(strings.TrimSpace(attribute.Val), currentCategory)
+				}
+			}
 		}
 	}
+}
+
+// Reports whether a tag name is one of h1 through h6
+func isHeadingTag(name string) bool {
+	return len(name) == 2 && name[0] == 'h' && name[1] >= '1' && name[1] <= '6'
+}
+
+// Extracts every PDF link from <a>, <iframe>, <embed> and <object> tags and data-href/data-file attributes, along
+// with shortened and tracking links that may lead to one
+func extractPDFUrls(input string) []string {
+	var pdfUrls []string // Store extracted links
+	walkPDFLinks(input, func(link string, _ string) {
+		pdfUrls = append(pdfUrls, link) // Add the link in page order
+	})
 	return pdfUrls // Return list of extracted PDF URLs
 }
 
-// Appends a string to a slice and returns the updated slice
-func appendToSlice(slice []string, content string) []string {
-	slice = append(slice, content) // Add content to slice
-	return slice                   // Return updated slice
+// Maps every PDF link to the text of the closest heading above it, which the site uses as the product category
+func extractPDFCategories(input string) map[string]string {
+	categories := make(map[string]string) // Link → category
+	walkPDFLinks(input, func(link string, category string) {
+		if _, seen := categories[link]; !seen { // Keep the first heading a link appears under
+			categories[link] = category
+		}
+	})
+	return categories // Return the link → category map
+}
+
+// Maps every PDF link to the text it is shown with, falling back to its title attribute
+func extractPDFAnchors(input string) map[string]string {
+	anchors := make(map[string]string) // Link → anchor text
+	document, err := html.Parse(strings.NewReader(input))
+	if err != nil {
+		return anchors
+	}
+	var visit func(node *html.Node)
+	visit = func(node *html.Node) {
+		if node.Type == html.ElementNode && node.Data == "a" {
+			href, found := nodeAttribute(node, "href")
+			href = strings.TrimSpace(href)
+			if _, seen := anchors[href]; found && !seen && (isPDFLink(href) || isWrappedLink(href)) { // Keep the first text a link appears with
+				text := strings.Join(strings.Fields(nodeText(node)), " ")
+				if text == "" {
+					title, _ := nodeAttribute(node, "title") // Icon-only links
+					text = strings.TrimSpace(title)
+				}
+				anchors[href] = text
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			visit(child)
+		}
+	}
+	visit(document)
+	return anchors // Return the link → text map
 }
 
 // Sends HTTP GET request to given URL and returns the response body as string
 func getDataFromURL(uri string) string {
-	log.Println("Scraping", uri)   // Log the URL being scraped
-	response, err := http.Get(uri) // Make GET request
+	return fetchPage(httpClient(), uri)
+}
+
+// Fetches a listing page with the given client, returning "" on failure
+func fetchPage(client *http.Client, uri string) string {
+	log.Println("Scraping", uri)                              // Log the URL being scraped
+	request, err := http.NewRequest(http.MethodGet, uri, nil) // Build the GET request
 	if err != nil {
-		log.Println(err) // Log error if request failed
+		log.Println(err) // Log error if the URL can't be requested
+		return ""
+	}
+	setAcceptLanguage(request, strings.Join(parseLanguages(acceptLanguages), ",")) // Prefer the configured languages
+	host := getDomainFromURL(uri)
+	if err := hostBreakers.allow(host); err != nil { // Don't contact hosts that keep failing
+		log.Println(err)
+		return ""
+	}
+	var response *http.Response
+	var cancel context.CancelFunc
+	for throttledRetries := 0; ; throttledRetries++ { // Retry while the server asks us to back off
+		var attempt *http.Request
+		attempt, cancel = withFileDeadline(request) // Each attempt gets the full page deadline
+		requestThrottle.wait()                      // Honour any pipeline-wide pause
+		response, err = client.Do(attempt)          // Make GET request
+		if err != nil {
+			cancel()
+			if !errors.Is(err, errRedirectNotAllowed) { // Policy rejections say nothing about the host's health
+				hostBreakers.failure(host)
+			}
+			log.Println(err) // Log error if request failed
+			return ""
+		}
+		throttleErr := checkThrottled(response) // 429 and 503 pause the whole pipeline
+		if throttleErr == nil || throttledRetries >= maxThrottleRetries {
+			break
+		}
+		response.Body.Close()
+		cancel()
+		requestThrottle.handle(uri, throttleErr)
 	}
+	defer cancel()                                                               // Release the deadline once the page is read
+	recordHostResponse(host, response.StatusCode)                                // Server errors count towards the host's circuit
+	response.Body = newIdleTimeoutReader(response.Body, bodyIdleTimeout, cancel) // Abort pages that stall
 
 	body, err := io.ReadAll(response.Body) // Read the body of the response
 	if err != nil {
 		log.Println(err) // Log error if read failed
 	}
+	runBandwidth.addPage(len(body)) // Listing pages count towards the run's traffic
 
 	err = response.Body.Close() // Close the response body after reading
 	if err != nil {
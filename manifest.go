@@ -0,0 +1,326 @@
+package main // Manifest handling lives alongside the scraper in the main package
+
+import (
+	"bytes"         // Buffers the canonical encoding
+	"encoding/json" // Encodes and decodes the manifest as JSON
+	"errors"        // Recognizes manifests written by a newer version
+	"log"           // Logs manifest read and write problems
+	"os"            // Reads and writes the manifest file on disk
+	"path/filepath" // Derives the last-seen file name
+	"slices"        // Filters the archived entries
+	"sort"          // Keeps manifest entries in a predictable order
+	"strings"       // Derives the last-seen file name
+	"sync"          // Guards the manifest against concurrent updates
+	"time"          // Stamps entries with the time they were recorded
+)
+
+var (
+	manifestFilePath = "manifest.json" // Location of the manifest describing every downloaded document
+	stableManifest   = false           // Keep last_seen in a separate file so unchanged content leaves the manifest byte-identical
+)
+
+// Describes a single downloaded document and where it came from
+type manifestEntry struct {
+	URL            string             `json:"url"`                       // Absolute URL the document was fetched from
+	Language       string             `json:"language,omitempty"`        // Accept-Language variant that was requested, if any
+	Category       string             `json:"category,omitempty"`        // Page heading the document was listed under
+	Domain         string             `json:"domain"`                    // Source domain the document belongs to
+	File           string             `json:"file,omitempty"`            // Local path the document was saved to; empty while it isn't archived
+	Size           int64              `json:"size,omitempty"`            // Number of bytes written to disk
+	SHA256         string             `json:"sha256,omitempty"`          // Hex-encoded SHA-256 digest of the file contents
+	LastModified   time.Time          `json:"last_modified,omitzero"`    // Last-Modified time reported by the server, if any
+	ETag           string             `json:"etag,omitempty"`            // ETag reported by the server, if any
+	DownloadedAt   time.Time          `json:"downloaded_at,omitzero"`    // Time the document was downloaded
+	RunID          string             `json:"run_id,omitempty"`          // Run that downloaded the document
+	LastSeen       time.Time          `json:"last_seen,omitzero"`        // Last run that found the document on the site
+	Revisions      []manifestRevision `json:"revisions,omitempty"`       // Superseded copies kept in the archive, newest first
+	Type           string             `json:"type,omitempty"`            // Classification: sds, label, other, or empty when undetermined
+	SDS            sdsMetadata        `json:"sds,omitzero"`              // Metadata read from the document contents
+	Redirects      []string           `json:"redirects,omitempty"`       // Redirect chain followed to fetch the document, ending with the final URL
+	Resolution     []string           `json:"resolution,omitempty"`      // Shortened or tracking link the document was listed as and every hop to URL, ending with URL
+	Group          string             `json:"group,omitempty"`           // Key shared by translations of the same sheet
+	OriginalName   string             `json:"original_name,omitempty"`   // Name derived from the URL before -max-filename-length shortened it
+	State          string             `json:"state,omitempty"`           // Lifecycle state; see lifecycle.go
+	StateChangedAt time.Time          `json:"state_changed_at,omitzero"` // When the document entered its state
+	Transitions    []stateTransition  `json:"transitions,omitempty"`     // Recent state changes, oldest first
+}
+
+// Describes a superseded copy of a document that is still kept in the archive
+type manifestRevision struct {
+	File         string    `json:"file"`          // Local path of the superseded copy
+	Size         int64     `json:"size"`          // Number of bytes in the copy
+	SHA256       string    `json:"sha256"`        // Hex-encoded SHA-256 digest of the copy
+	DownloadedAt time.Time `json:"downloaded_at"` // Time the copy was downloaded
+}
+
+// Returns the SDS revision date, falling back to the server's Last-Modified time
+func (e manifestEntry) revisionDate() time.Time {
+	if revision := e.SDS.revisionTime(); !revision.IsZero() {
+		return revision // Prefer the date printed on the sheet
+	}
+	return e.LastModified
+}
+
+// Returns the last time the document was seen on the site, falling back to its download time
+func (e manifestEntry) lastSeen() time.Time {
+	if e.LastSeen.IsZero() {
+		return e.DownloadedAt // Entries written before last_seen was tracked
+	}
+	return e.LastSeen
+}
+
+// Holds every known manifest entry keyed by source URL and language
+type manifest struct {
+	mu         sync.Mutex               // Protects entries from concurrent access
+	entries    map[string]manifestEntry // Entries keyed by their source URL
+	journal    *os.File                 // Write-ahead journal of updates since the last save, when open
+	replayed   int                      // Journaled operations recovered from an interrupted run
+	rolledBack int                      // Incomplete journaled operations that were ignored
+	unrecorded bool                     // The manifest on disk didn't match the last root in the integrity log
+}
+
+// Returns the map key for an entry; language variants of one URL get separate keys
+func (e manifestEntry) key() string {
+	if e.Language == "" {
+		return e.URL // Untagged downloads are keyed by URL alone
+	}
+	return e.URL + "#lang=" + e.Language // Append the language so variants don't overwrite each other
+}
+
+// Creates an empty manifest ready to receive entries
+func newManifest() *manifest {
+	return &manifest{entries: make(map[string]manifestEntry)} // Initialise the entry map
+}
+
+// Loads the manifest from disk, returning an empty one if it doesn't exist yet
+func loadManifest(filePath string) *manifest {
+	loaded := newManifest()            // Start from an empty manifest
+	data, err := os.ReadFile(filePath) // Read the manifest file
+	if err != nil {                    // Missing or unreadable manifest
+		if !os.IsNotExist(err) { // Only log unexpected errors
+			log.Println(err)
+		}
+		loaded.unrecorded = !matchesRecordedRoot(filePath, nil) // A deleted manifest counts as a change too
+		loaded.recoverJournal(filePath)                         // A first run may have crashed before its first save
+		return loaded                                           // Fall back to the empty manifest
+	}
+	entries, _, err := decodeManifest(data) // Entries as stored on disk, in any supported schema version
+	if errors.Is(err, errNewerSchema) {
+		log.Fatalf("Manifest %s is %v; upgrade the scraper rather than overwrite it", filePath, err)
+	}
+	if err != nil {
+		log.Printf("Failed to parse manifest %s: %v", filePath, err)
+		return loaded // Ignore a corrupt manifest rather than aborting the run
+	}
+	seen := loadLastSeen(lastSeenFilePath(filePath)) // Kept apart by -stable-manifest runs
+	for _, entry := range entries {                  // Index each entry by URL
+		if at, found := seen[entry.key()]; found && at.After(entry.LastSeen) {
+			entry.LastSeen = at
+		}
+		loaded.entries[entry.key()] = entry
+	}
+	loaded.unrecorded = !matchesRecordedRoot(filePath, loaded.all()) // Checked before the journal adds this tool's own updates
+	loaded.recoverJournal(filePath)
+	return loaded // Return the populated manifest
+}
+
+// Returns where -stable-manifest keeps last_seen times for a manifest, e.g. manifest.seen.json
+func lastSeenFilePath(manifestPath string) string {
+	return strings.TrimSuffix(manifestPath, filepath.Ext(manifestPath)) + ".seen.json"
+}
+
+// Reads the last_seen times kept next to a manifest, keyed like manifest entries; missing files yield none
+func loadLastSeen(filePath string) map[string]time.Time {
+	seen := make(map[string]time.Time)
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println(err)
+		}
+		return seen
+	}
+	if err := json.Unmarshal(data, &seen); err != nil {
+		log.Printf("Failed to parse %s: %v", filePath, err)
+	}
+	return seen
+}
+
+// Records or replaces the entry for a downloaded document, keeping any revisions already tracked
+func (m *manifest) record(entry manifestEntry) {
+	m.mu.Lock()         // Lock before touching the map
+	defer m.mu.Unlock() // Release the lock when done
+	previous, found := m.entries[entry.key()]
+	if found && entry.Revisions == nil {
+		entry.Revisions = previous.Revisions // Don't lose the revision history
+	}
+	if found && entry.Transitions == nil {
+		entry.State, entry.StateChangedAt, entry.Transitions = previous.State, previous.StateChangedAt, previous.Transitions // Nor the lifecycle
+	}
+	if entry.LastSeen.IsZero() {
+		entry.LastSeen = entry.DownloadedAt // A fresh download was just seen on the site
+	}
+	m.entries[entry.key()] = entry // Store the entry under its URL and language
+	m.writeJournal(journalOperation{Op: journalRecord, Entry: &entry})
+}
+
+// Marks every variant of a URL as seen on the site at the given time
+func (m *manifest) markSeen(rawURL string, at time.Time) {
+	m.mu.Lock()         // Lock before touching the map
+	defer m.mu.Unlock() // Release the lock when done
+	for key, entry := range m.entries {
+		if entry.URL == rawURL {
+			entry.LastSeen = at
+			m.entries[key] = entry
+		}
+	}
+	m.writeJournal(journalOperation{Op: journalSeen, URL: rawURL, At: at})
+}
+
+// Removes an entry from the manifest
+func (m *manifest) remove(entry manifestEntry) {
+	m.mu.Lock()                    // Lock before touching the map
+	defer m.mu.Unlock()            // Release the lock when done
+	delete(m.entries, entry.key()) // Drop the entry
+	m.writeJournal(journalOperation{Op: journalRemove, Entry: &entry})
+}
+
+// Returns the entry recorded for an archived URL and language variant, if any
+func (m *manifest) lookup(rawURL string, language string) (manifestEntry, bool) {
+	entry, found := m.tracked(rawURL, language)
+	if !entry.archived() {
+		return manifestEntry{}, false // Known, but there is no copy to reuse
+	}
+	return entry, found
+}
+
+// Returns the entry of a URL and language variant whether or not it's archived, if any
+func (m *manifest) tracked(rawURL string, language string) (manifestEntry, bool) {
+	m.mu.Lock()                                                                     // Lock before reading the map
+	defer m.mu.Unlock()                                                             // Release the lock when done
+	entry, found := m.entries[manifestEntry{URL: rawURL, Language: language}.key()] // Look up the entry
+	return entry, found                                                             // Return the entry and whether it exists
+}
+
+// Returns the entry whose current file is stored under file, if any
+func (m *manifest) fileOwner(file string) (manifestEntry, bool) {
+	m.mu.Lock()         // Lock before reading the map
+	defer m.mu.Unlock() // Release the lock when done
+	for _, entry := range m.entries {
+		if entry.File == file {
+			return entry, true
+		}
+	}
+	return manifestEntry{}, false
+}
+
+// Returns an entry for another URL whose file is still archived with the given contents, if any
+func (m *manifest) contentOwner(digest string, rawURL string) (manifestEntry, bool) {
+	m.mu.Lock() // Lock before reading the map
+	var candidates []manifestEntry
+	for _, entry := range m.entries {
+		if entry.SHA256 == digest && entry.URL != rawURL {
+			candidates = append(candidates, entry)
+		}
+	}
+	m.mu.Unlock() // Storage is checked without holding the lock
+	for _, entry := range candidates {
+		if stored, err := archiveStorage.Hash(entry.File); err == nil && stored == digest {
+			return entry, true
+		}
+	}
+	return manifestEntry{}, false
+}
+
+// Returns the entries of archived documents sorted by URL
+func (m *manifest) list() []manifestEntry {
+	entries := m.all()
+	return slices.DeleteFunc(entries, func(entry manifestEntry) bool { return !entry.archived() })
+}
+
+// Returns every entry sorted by URL, including documents that aren't archived
+func (m *manifest) all() []manifestEntry {
+	m.mu.Lock()                                         // Lock before reading the map
+	defer m.mu.Unlock()                                 // Release the lock when done
+	entries := make([]manifestEntry, 0, len(m.entries)) // Preallocate the result slice
+	for _, entry := range m.entries {                   // Copy every entry out of the map
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { // Sort so the file is stable between runs
+		if entries[i].URL != entries[j].URL {
+			return entries[i].URL < entries[j].URL
+		}
+		return entries[i].Language < entries[j].Language // Language variants of one URL sort by tag
+	})
+	return entries // Return the sorted entries
+}
+
+// Encodes a manifest file as canonical JSON in the current schema version: sorted entries, two-space indentation,
+// UTC times, unescaped URLs and a final newline, so equal manifests are equal byte for byte
+func encodeManifest(entries []manifestEntry) ([]byte, error) {
+	return canonicalJSON(manifestDocument{SchemaVersion: manifestSchemaVersion, Entries: canonicalEntries(entries)})
+}
+
+// Encodes manifest entries alone as a canonical JSON array, independent of the file's schema version
+func encodeManifestEntries(entries []manifestEntry) ([]byte, error) {
+	return canonicalJSON(canonicalEntries(entries))
+}
+
+// Returns copies of entries with every time in UTC
+func canonicalEntries(entries []manifestEntry) []manifestEntry {
+	canonical := make([]manifestEntry, len(entries))
+	for i, entry := range entries {
+		entry.LastModified, entry.DownloadedAt, entry.LastSeen = entry.LastModified.UTC(), entry.DownloadedAt.UTC(), entry.LastSeen.UTC()
+		entry.StateChangedAt = entry.StateChangedAt.UTC()
+		entry.Revisions = append([]manifestRevision(nil), entry.Revisions...) // Don't modify the caller's entries
+		for j := range entry.Revisions {
+			entry.Revisions[j].DownloadedAt = entry.Revisions[j].DownloadedAt.UTC()
+		}
+		canonical[i] = entry
+	}
+	return canonical
+}
+
+// Encodes a value with two-space indentation, unescaped URLs and a final newline; map keys are sorted by the encoder
+func canonicalJSON(value any) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false) // Keep & in query strings readable
+	encoder.SetIndent("", "  ")  // Indent so the file is readable and diffable
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Writes the manifest to disk as canonical JSON; with -stable-manifest, or once a last-seen file exists, last_seen
+// times go to their own file
+func (m *manifest) save(filePath string) {
+	entries := m.all() // Sorted entries, documents that aren't archived included
+	if stableManifest || fileExists(lastSeenFilePath(filePath)) {
+		seen := make(map[string]time.Time, len(entries))
+		for i := range entries {
+			seen[entries[i].key()] = entries[i].LastSeen.UTC()
+			entries[i].LastSeen = time.Time{} // Changes every run even when nothing else does
+		}
+		if data, err := canonicalJSON(seen); err != nil {
+			log.Println(err)
+		} else if err := writeFileAtomic(lastSeenFilePath(filePath), data); err != nil {
+			log.Printf("Failed to write %s: %v", lastSeenFilePath(filePath), err)
+		}
+	}
+	data, err := encodeManifest(entries) // Encode the sorted entries
+	if err != nil {                      // Encoding should never fail, but check anyway
+		log.Println(err)
+		return
+	}
+	if err := writeFileAtomic(filePath, data); err != nil { // Replace the manifest file in one step
+		log.Printf("Failed to write manifest %s: %v", filePath, err)
+		return // Keep the journal so the updates can still be recovered
+	}
+	m.mu.Lock()
+	unrecorded := m.unrecorded
+	m.unrecorded = false // Noted once, by the record below
+	m.mu.Unlock()
+	appendIntegrityRecord(filePath, entries, unrecorded) // Commit to the saved state
+	m.clearJournal(filePath)                             // Everything journaled is now in the manifest
+}
@@ -0,0 +1,153 @@
+package main // YAML metadata files next to each document, with fields people can fill in by hand
+
+import (
+	"bytes"         // Compares old and new sidecar contents
+	"encoding/json" // Quotes YAML scalars; JSON strings are valid YAML
+	"log"           // Reports write failures
+	"path"          // Names the document a sidecar describes
+	"strconv"       // Formats the size
+	"strings"       // Builds and parses sidecars
+	"time"          // Formats timestamps
+)
+
+var writeMetadataSidecars = false // Whether to write a <file>.meta.yaml next to every document
+
+const metadataSidecarSuffix = ".meta.yaml" // Appended to a document's key to name its metadata sidecar
+
+// User-editable fields written under "user:" in new sidecars, with the comment explaining each
+var metadataUserFields = [][2]string{
+	{"location", "where the product is stored on site, e.g. Chemical shed, shelf B"},
+	{"product_code", "internal product or stock code"},
+}
+
+// Reports whether a storage key names a checksum or metadata sidecar rather than a document
+func isSidecarKey(key string) bool {
+	key = strings.TrimSuffix(key, gzipSuffix) // Compressed sidecars too
+	return strings.HasSuffix(key, ".sha256") || strings.HasSuffix(key, metadataSidecarSuffix)
+}
+
+// Returns the lines of a sidecar's user block, i.e. the indented lines below "user:", exactly as written so edits,
+// extra keys and comments survive; reports false when the sidecar has no user block
+func sidecarUserBlock(sidecar string) ([]string, bool) {
+	var block []string
+	inBlock, found := false, false
+	for _, line := range strings.Split(sidecar, "\n") {
+		if inBlock {
+			if line == "" || line[0] == ' ' || line[0] == '\t' {
+				block = append(block, line)
+				continue
+			}
+			inBlock = false
+		}
+		if key, _, _ := strings.Cut(line, ":"); strings.TrimSpace(key) == "user" && line[0] != ' ' {
+			inBlock, found = true, true
+		}
+	}
+	for len(block) > 0 && strings.TrimSpace(block[len(block)-1]) == "" {
+		block = block[:len(block)-1] // Blank lines before the next key belong to no one
+	}
+	return block, found
+}
+
+// Returns a YAML scalar for a string, quoted whenever plain style could be misread
+func yamlString(value string) string {
+	quoted, _ := json.Marshal(value)
+	return string(quoted)
+}
+
+// Renders a document's sidecar: the extracted fields, refreshed on every run, followed by the user block
+func renderMetadataSidecar(entry manifestEntry, userBlock []string) []byte {
+	var out strings.Builder
+	out.WriteString("# Metadata for " + path.Base(entry.File) + "\n")
+	out.WriteString("# Everything above \"user:\" is rewritten from the document on every run; edit the fields below it.\n")
+	field := func(key string, value string) {
+		if value != "" {
+			out.WriteString(key + ": " + yamlString(value) + "\n")
+		}
+	}
+	list := func(key string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		out.WriteString(key + ":\n")
+		for _, value := range values {
+			out.WriteString("  - " + yamlString(value) + "\n")
+		}
+	}
+	field("url", entry.URL)
+	field("file", entry.File)
+	field("type", entry.Type)
+	field("category", entry.Category)
+	field("product", entry.SDS.Product)
+	field("brand", entry.SDS.brand())
+	list("companies", entry.SDS.Companies)
+	field("language", entry.documentLanguage())
+	field("revision_date", entry.SDS.RevisionDate)
+	list("cas_numbers", entry.SDS.CASNumbers)
+	list("pictograms", entry.SDS.Pictograms)
+	if transport := entry.SDS.Transport; transport != nil {
+		field("transport_regulation", transport.Regulation)
+		field("un_number", transport.UNNumber)
+		field("shipping_name", transport.ShippingName)
+		field("hazard_class", transport.HazardClass)
+		field("packing_group", transport.PackingGroup)
+	}
+	field("sha256", entry.SHA256)
+	out.WriteString("size: " + strconv.FormatInt(entry.Size, 10) + "\n")
+	field("downloaded_at", entry.DownloadedAt.UTC().Format(time.RFC3339))
+	out.WriteString("user:\n")
+	if userBlock == nil {
+		for _, userField := range metadataUserFields {
+			out.WriteString("  # " + userField[1] + "\n  " + userField[0] + ": \"\"\n")
+		}
+	}
+	for _, line := range userBlock {
+		out.WriteString(line + "\n")
+	}
+	return []byte(out.String())
+}
+
+// Writes or refreshes the sidecar of every document, keeping whatever was entered under "user:"; sidecars whose
+// contents wouldn't change aren't rewritten
+func writeMetadataSidecarFiles(entries []manifestEntry) {
+	written := 0
+	for _, entry := range entries {
+		sidecar := entry.File + metadataSidecarSuffix
+		var userBlock []string
+		existing, found, err := readArtifact(archiveStorage, sidecar) // Compressed or not, whatever -compress-artifacts was
+		if err != nil {
+			log.Printf("Not refreshing %s: %v", sidecar, err) // Never overwrite edits that couldn't be read
+			continue
+		}
+		if found {
+			if block, found := sidecarUserBlock(string(existing)); found {
+				userBlock = append([]string{}, block...) // Empty but present stays empty
+			}
+		}
+		data := renderMetadataSidecar(entry, userBlock)
+		current, err := archiveStorage.Exists(artifactName(sidecar))
+		if err != nil {
+			log.Printf("Failed to check %s: %v", artifactName(sidecar), err)
+			continue
+		}
+		if current && bytes.Equal(data, existing) {
+			continue
+		}
+		encoded, err := encodeArtifact(data)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if err := archiveStorage.Put(artifactName(sidecar), encoded); err != nil {
+			log.Printf("Failed to write %s: %v", artifactName(sidecar), err)
+			continue
+		}
+		if err := archiveStorage.Delete(otherArtifactName(sidecar)); err != nil { // Converted to the configured form
+			log.Printf("Failed to remove %s: %v", otherArtifactName(sidecar), err)
+		}
+		written++
+	}
+	if written > 0 {
+		log.Printf("Wrote %d metadata sidecars", written)
+	}
+}
@@ -0,0 +1,71 @@
+package main // Request and response middleware for every HTTP request the scraper sends
+
+import (
+	"fmt"      // Builds -header errors
+	"net/http" // Requests, responses and transports
+	"strings"  // Parses -header values
+	"sync"     // Guards the registered middleware
+)
+
+// Handles one request on the scraper's behalf, e.g. to log, sign, cache or rewrite it; next sends it on down the
+// chain and eventually to the network. Like a RoundTripper it must not modify the request it was given.
+type httpMiddleware func(request *http.Request, next http.RoundTripper) (*http.Response, error)
+
+var (
+	middlewareMu sync.RWMutex     // Protects middlewares
+	middlewares  []httpMiddleware // Registered middleware, outermost first
+)
+
+// Adds middleware to every request the scraper sends, inside any added earlier. It runs after target credentials
+// are set and before the request reaches the network, and can be added at any time; requests already in flight
+// keep the chain they started with.
+func useHTTPMiddleware(added ...httpMiddleware) {
+	middlewareMu.Lock()
+	defer middlewareMu.Unlock()
+	middlewares = append(middlewares, added...)
+}
+
+// Returns middleware that lets intercept change a copy of each request before it is sent; an error fails the request
+func requestInterceptor(intercept func(request *http.Request) error) httpMiddleware {
+	return func(request *http.Request, next http.RoundTripper) (*http.Response, error) {
+		request = request.Clone(request.Context())
+		if err := intercept(request); err != nil {
+			return nil, err
+		}
+		return next.RoundTrip(request)
+	}
+}
+
+// Registers middleware setting a "Name: value" header on every request, for the repeatable -header option
+func addHeaderOption(option string) error {
+	name, value, found := strings.Cut(option, ":")
+	if name = strings.TrimSpace(name); !found || name == "" {
+		return fmt.Errorf("-header %q must look like Name: value", option)
+	}
+	value = strings.TrimSpace(value)
+	useHTTPMiddleware(requestInterceptor(func(request *http.Request) error {
+		request.Header.Set(name, value)
+		return nil
+	}))
+	return nil
+}
+
+// Runs the registered middleware around the next transport
+type middlewareTransport struct {
+	next http.RoundTripper // Transport the innermost middleware calls
+}
+
+// Builds the chain for this request and sends the request through it
+func (t middlewareTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	middlewareMu.RLock()
+	chain := middlewares
+	middlewareMu.RUnlock()
+	handler := t.next
+	for i := len(chain) - 1; i >= 0; i-- {
+		middleware, next := chain[i], handler
+		handler = roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+			return middleware(request, next)
+		})
+	}
+	return handler.RoundTrip(request)
+}
@@ -0,0 +1,415 @@
+package main // Migrate-layout subcommand moving an existing archive to another naming scheme or storage layout
+
+import (
+	"errors"  // Tells skipped documents from failures
+	"fmt"     // Prints the migration plan
+	"log"     // Reports moved files
+	"path"    // Builds storage keys
+	"slices"  // Copies revision lists
+	"strings" // Rewrites key prefixes
+)
+
+// A file that moves to another storage key, another layout, or both
+type layoutMove struct {
+	From   string // Storage key in the current layout
+	To     string // Storage key in the new layout
+	SHA256 string // Expected digest of the file; empty when the manifest doesn't know it
+}
+
+// An archived document and the files of it that move
+type layoutPlan struct {
+	File  string        // Current key of the document's file
+	Entry manifestEntry // Entry as it reads after the migration
+	Moves []layoutMove  // The document first, when it moves, then its kept revisions
+}
+
+// Reports whether the document's own file moves, which takes its sidecars and extracted files along
+func (plan layoutPlan) movesDocument() bool {
+	return len(plan.Moves) > 0 && plan.Moves[0].From == plan.File
+}
+
+// Runs the migrate-layout subcommand: renames the archived files to the names the current -naming, -directories,
+// -filename-chain and -languages options would give them and moves them from -from-layout to -layout, rewriting the
+// manifest as each document is moved, so switching schemes doesn't need a full re-download
+func runMigrateLayout(args []string) error {
+	flags := stageFlags("migrate-layout")
+	fromLayout := flags.String("from-layout", "", "layout the archive is stored in now: path or content (defaults to -layout, which names the layout to move to)")
+	manifestPath := flags.String("manifest", manifestFilePath, "manifest describing the archive")
+	assumeYes := flags.Bool("yes", false, "move without asking for confirmation")
+	dryRun := flags.Bool("dry-run", false, "only print what would be moved")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	for _, validate := range []func() error{validateFileNaming, validateOutputDirectories, validateMaxFilenameLength, validateProfiles} {
+		if err := validate(); err != nil {
+			return err
+		}
+	}
+	if *fromLayout == "" {
+		*fromLayout = storageLayout
+	}
+	source, target, err := openLayoutStorages(*fromLayout, storageLayout)
+	if err != nil {
+		return err
+	}
+
+	lock, err := acquireRunLock(lockFilePath) // Held from planning to saving, so no scrape moves files or changes the manifest meanwhile
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	documentManifest := loadManifest(*manifestPath)
+	plans := planLayoutMigration(documentManifest, *fromLayout != storageLayout)
+	if len(plans) == 0 {
+		log.Println("Every archived file already has its name in the requested layout")
+		return nil
+	}
+	files := 0
+	for _, plan := range plans { // Show the plan before touching anything
+		for _, move := range plan.Moves {
+			if move.From == move.To {
+				fmt.Printf("move %s (%s → %s layout)\n", move.From, *fromLayout, storageLayout)
+			} else {
+				fmt.Printf("move %s → %s\n", move.From, move.To)
+			}
+			files++
+		}
+	}
+	fmt.Printf("%d files of %d documents; companion sidecars move with them\n", files, len(plans))
+	if *dryRun {
+		fmt.Printf("Dry run: %d files would be moved\n", files)
+		return nil
+	}
+	if !*assumeYes && !confirm(fmt.Sprintf("Move %d files?", files)) {
+		return fmt.Errorf("migrate-layout cancelled")
+	}
+	if err := documentManifest.openJournal(*manifestPath); err != nil { // Documents moved before a crash keep their new names
+		return err
+	}
+	defer documentManifest.closeJournal(*manifestPath)
+
+	pending := make(map[string]int) // Storage key → plan whose file is still stored under it
+	for index, plan := range plans {
+		for _, move := range plan.Moves {
+			pending[move.From] = index
+		}
+	}
+	done := make([]bool, len(plans))
+	var moved []layoutPlan // Plans carried out, whose sidecars follow
+	var failure error      // Stops the migration; the documents moved so far stay moved
+	for progress := true; progress && failure == nil; {
+		progress = false
+		for index, plan := range plans {
+			if done[index] || blockedMove(plan, index, pending) {
+				continue // Its new key still holds another document's file, which moves first
+			}
+			done[index], progress = true, true
+			var skip layoutSkip
+			if err := migrateDocument(source, target, plan); errors.As(err, &skip) {
+				log.Printf("Not moving %s: %v", plan.Entry.URL, err) // Its files stay pending, so nothing overwrites them
+				continue
+			} else if err != nil {
+				failure = err
+				break
+			}
+			documentManifest.record(plan.Entry)
+			for _, move := range plan.Moves {
+				delete(pending, move.From)
+			}
+			moved = append(moved, plan)
+		}
+	}
+	for index, plan := range plans {
+		if !done[index] && failure == nil {
+			log.Printf("Not moving %s: its new name %s is taken by a document that can't move first", plan.Entry.URL, plan.Entry.File)
+		}
+	}
+	if failure == nil {
+		failure = refreshCompanions(source, target, moved, documentManifest.list())
+	}
+	documentManifest.save(*manifestPath)
+	if failure != nil {
+		return failure
+	}
+	fmt.Printf("Moved %d of %d documents\n", len(moved), len(plans))
+	return nil
+}
+
+// Opens the archive in its current layout and in the layout it moves to; both share the backend, and only one of
+// them is the content layout, so its index isn't loaded twice
+func openLayoutStorages(from string, to string) (Storage, Storage, error) {
+	backend, err := newStorageBackend(storageBackend, storageURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	open := func(layout string) (Storage, error) {
+		switch layout {
+		case "path":
+			return backend, nil
+		case "content":
+			return newObjectStorage(backend)
+		}
+		return nil, fmt.Errorf("unknown layout %q (expected path or content)", layout)
+	}
+	source, err := open(from)
+	if err != nil {
+		return nil, nil, err
+	}
+	if from == to {
+		return source, source, nil
+	}
+	target, err := open(to)
+	return source, target, err
+}
+
+// Works out the new key of every archived document and its revisions; documents whose files already have their
+// names stay out of the plan unless the layout changes
+func planLayoutMigration(documentManifest *manifest, layoutChanges bool) []layoutPlan {
+	entries := documentManifest.list()
+	var urls []string
+	for _, entry := range entries {
+		urls = append(urls, entry.URL)
+	}
+	namespaced := namespacedByDomain(urls)
+	tagLanguages := len(downloadLanguages()) > 1
+	claimed := make(map[string]string) // New key → manifest key of the document given it
+	var plans []layoutPlan
+	for _, entry := range entries {
+		file := layoutFileKey(entry, namespaced, tagLanguages)
+		if owner, taken := claimed[file]; taken && owner != entry.key() { // e.g. two URLs with the same last segment
+			extension := getFileExtension(file)
+			file = strings.TrimSuffix(file, extension) + "_" + sha256Hex([]byte(entry.key()))[:8] + extension
+		}
+		claimed[file] = entry.key()
+
+		plan := layoutPlan{File: entry.File, Entry: entry}
+		plan.Entry.File, plan.Entry.OriginalName = file, ""
+		if original := untruncatedURLFilename(entry.URL); original != urlToFilename(entry.URL) {
+			plan.Entry.OriginalName = original
+		}
+		if file != entry.File || layoutChanges {
+			plan.Moves = append(plan.Moves, layoutMove{From: entry.File, To: file, SHA256: entry.SHA256})
+		}
+		plan.Entry.Revisions = slices.Clone(entry.Revisions)
+		for index, revision := range entry.Revisions {
+			revisionFile := revisionKey(file, revision.DownloadedAt)
+			if revision.File == entry.File {
+				plan.Entry.Revisions[index].File = file // Shares the current copy
+				continue
+			}
+			if revisionFile != revision.File || layoutChanges {
+				plan.Moves = append(plan.Moves, layoutMove{From: revision.File, To: revisionFile, SHA256: revision.SHA256})
+			}
+			plan.Entry.Revisions[index].File = revisionFile
+		}
+		if len(plan.Moves) > 0 {
+			plans = append(plans, plan)
+		}
+	}
+	return plans
+}
+
+// Returns the storage key a run with the current options would give a document: the URL-derived name in the PDF
+// directory or its domain subdirectory, routed by kind and type, and renamed by product with -naming product
+func layoutFileKey(entry manifestEntry, namespaced bool, tagLanguage bool) string {
+	dir := strings.TrimSuffix(pdfOutputDir, "/")
+	if namespaced && entry.Domain != "" {
+		dir = path.Join(dir, entry.Domain)
+	}
+	name := urlToFilename(entry.URL)
+	if tagLanguage {
+		name = languageTaggedFilename(name, entry.Language)
+	}
+	kind := storedKind(entry.File)
+	file := routeByType(routeByKind(dir+"/"+name, kind), entry.Type)
+	if kind == "pdf" && fileNaming == "product" {
+		file = productFilePath(file, entry.SDS, entry.Language, tagLanguage)
+	}
+	return file
+}
+
+// Returns the kind of document a stored file holds, judged from the extension sniffing gave it
+func storedKind(file string) string {
+	extension := strings.ToLower(path.Ext(file))
+	for name, kind := range documentKinds {
+		if kind.Extension == extension {
+			return name
+		}
+	}
+	return "pdf" // Files whose URL had no usable extension
+}
+
+// Reports whether a document's new keys still hold files of other documents that haven't moved yet
+func blockedMove(plan layoutPlan, index int, pending map[string]int) bool {
+	for _, move := range plan.Moves {
+		if owner, found := pending[move.To]; found && owner != index {
+			return true
+		}
+	}
+	return false
+}
+
+// A problem with one document that doesn't stop the migration of the others
+type layoutSkip struct {
+	reason string // What keeps the document where it is
+}
+
+// Returns the reason
+func (skip layoutSkip) Error() string {
+	return skip.reason
+}
+
+// Copies every file of a document to its new key and layout, checking each copy against the manifest, then removes
+// the old copies; nothing is removed unless every file was copied
+func migrateDocument(source Storage, target Storage, plan layoutPlan) error {
+	contents := make([][]byte, len(plan.Moves))
+	for index, move := range plan.Moves {
+		data, err := source.Get(move.From)
+		if err != nil {
+			return layoutSkip{fmt.Sprintf("reading %s: %v", move.From, err)}
+		}
+		if move.SHA256 != "" && sha256Hex(data) != move.SHA256 {
+			return layoutSkip{fmt.Sprintf("%s doesn't match the manifest; run verify", move.From)}
+		}
+		if digest, err := target.Hash(move.To); err == nil && digest != sha256Hex(data) && !movesFrom(plan, move.To) {
+			return layoutSkip{fmt.Sprintf("%s already holds a file the manifest doesn't know", move.To)}
+		}
+		contents[index] = data
+	}
+	for index, move := range plan.Moves {
+		if err := target.Put(move.To, contents[index]); err != nil {
+			return fmt.Errorf("failed to store %s: %w", move.To, err)
+		}
+		if digest, err := target.Hash(move.To); err != nil || digest != sha256Hex(contents[index]) {
+			return fmt.Errorf("%s doesn't read back as written", move.To)
+		}
+	}
+	for _, move := range plan.Moves {
+		if move.From != move.To && movesTo(plan, move.From) {
+			continue // Another file of the document took its place
+		}
+		if err := releaseKey(source, target, move.From, move.To); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", move.From, err)
+		}
+		if move.From == move.To {
+			log.Printf("Moved %s into the %s layout", move.To, storageLayout)
+		} else {
+			log.Printf("Moved %s → %s", move.From, move.To)
+		}
+	}
+	return moveExtractedFiles(source, target, plan)
+}
+
+// Reports whether a key is one the document itself moves away from
+func movesFrom(plan layoutPlan, key string) bool {
+	for _, move := range plan.Moves {
+		if move.From == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether another file of the document moves to a key
+func movesTo(plan layoutPlan, key string) bool {
+	for _, move := range plan.Moves {
+		if move.To == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Removes the old copy of a moved file. A key kept across a layout change is left alone where the new copy took
+// its place: the content layout's link replaced the file, or the file replaced the link
+func releaseKey(source Storage, target Storage, from string, to string) error {
+	if from != to || source == target {
+		return source.Delete(from)
+	}
+	if objects, ok := source.(*objectStorage); ok {
+		return objects.forget(from) // The readable key now holds the file itself
+	}
+	if objects, ok := target.(*objectStorage); ok {
+		if _, linked := objects.inner.(storageLinker); linked {
+			return nil // The link replaced the file
+		}
+	}
+	return source.Delete(from)
+}
+
+// Moves the files extracted from a ZIP document, stored in the directory named after it, along with it
+func moveExtractedFiles(source Storage, target Storage, plan layoutPlan) error {
+	if !plan.movesDocument() || storedKind(plan.File) != "zip" {
+		return nil
+	}
+	from := strings.TrimSuffix(plan.File, getFileExtension(plan.File)) + "/"
+	to := strings.TrimSuffix(plan.Entry.File, getFileExtension(plan.Entry.File)) + "/"
+	keys, err := source.List(from)
+	if err != nil {
+		return fmt.Errorf("listing %s: %w", from, err)
+	}
+	for _, key := range keys {
+		if err := moveStoredFile(source, target, key, to+strings.TrimPrefix(key, from)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Moves one file the manifest doesn't track, such as a sidecar, when it's stored
+func moveStoredFile(source Storage, target Storage, from string, to string) error {
+	data, err := source.Get(from)
+	if err != nil {
+		return nil // Not stored
+	}
+	if err := target.Put(to, data); err != nil {
+		return fmt.Errorf("failed to store %s: %w", to, err)
+	}
+	if err := releaseKey(source, target, from, to); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", from, err)
+	}
+	return nil
+}
+
+// Moves the checksum and metadata sidecars of the moved documents and rewrites them for the new names, keeping
+// whatever was entered in the metadata sidecars, and rewrites SHA256SUMS over the archived documents when there is one
+func refreshCompanions(source Storage, target Storage, moved []layoutPlan, archived []manifestEntry) error {
+	var described []manifestEntry // Moved documents with a metadata sidecar, refreshed for their new names
+	listPath := path.Join(strings.TrimSuffix(pdfOutputDir, "/"), checksumListName)
+	hadList, _ := source.Exists(listPath)
+	for _, plan := range moved {
+		if !plan.movesDocument() {
+			continue // The sidecars name the current file, which stays
+		}
+		entry := plan.Entry
+		if exists, _ := source.Exists(plan.File + ".sha256"); exists {
+			if err := target.Put(entry.File+".sha256", []byte(checksumLine(entry.SHA256, path.Base(entry.File)))); err != nil {
+				return fmt.Errorf("failed to store %s.sha256: %w", entry.File, err)
+			}
+			if err := releaseKey(source, target, plan.File+".sha256", entry.File+".sha256"); err != nil {
+				return fmt.Errorf("failed to remove %s.sha256: %w", plan.File, err)
+			}
+		}
+		for _, suffix := range []string{metadataSidecarSuffix, metadataSidecarSuffix + gzipSuffix} {
+			if exists, _ := source.Exists(plan.File + suffix); exists {
+				if err := moveStoredFile(source, target, plan.File+suffix, entry.File+suffix); err != nil {
+					return err
+				}
+				described = append(described, entry)
+			}
+		}
+	}
+	archiveStorage = target // The sidecar writers store through the archive storage
+	if len(described) > 0 {
+		writeMetadataSidecarFiles(slices.CompactFunc(described, func(a, b manifestEntry) bool { return a.key() == b.key() }))
+	}
+	if !hadList {
+		return nil
+	}
+	writeChecksumFiles(archived) // Lists the new names
+	if source != target {
+		return releaseKey(source, target, listPath, listPath)
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package main // Mirror subcommand generating a static SDS website
+
+import (
+	"flag"          // Parses the mirror options
+	"fmt"           // Builds error messages
+	"html/template" // Renders the site pages
+	"log"           // Reports progress
+	"os"            // Writes the site to disk
+	"path/filepath" // Builds output paths
+	"sort"          // Orders categories and documents
+	"strings"       // Builds URL-safe slugs
+	"time"          // Stamps the generated pages
+)
+
+const mirrorMarkerFile = ".sds-mirror" // Marks a directory as generated so it can be safely replaced
+
+// Shared page layout; every page sets Title, Root and one of the content sections
+var mirrorTemplate = template.Must(template.New("page").Funcs(template.FuncMap{"pictogramName": pictogramName}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} — PoolSeason Safety Data Sheets</title>
+<style>body{font-family:sans-serif;margin:2em;max-width:70em}table{border-collapse:collapse}th,td{border:1px solid #ccc;padding:4px 8px;text-align:left;vertical-align:top}.ghs{display:inline-block;margin:1px;padding:1px 4px;border:2px solid #c00;border-radius:3px;font-size:smaller;white-space:nowrap}nav{margin-bottom:1em}</style>
+</head>
+<body>
+<nav><a href="{{.Root}}index.html">All categories</a></nav>
+<h1>{{.Title}}</h1>
+{{if .Categories}}<ul>
+{{range .Categories}}<li><a href="categories/{{.Slug}}.html">{{.Name}}</a> ({{len .Groups}})</li>
+{{end}}</ul>{{end}}
+{{if .Groups}}<table>
+<tr><th>Product</th><th>Languages</th><th>Revision date</th><th>Hazards</th><th>PDF</th></tr>
+{{range .Groups}}{{$first := index .Documents 0}}<tr><td><a href="{{$.Root}}documents/{{$first.Slug}}.html">{{.Product}}</a></td><td>{{range $i, $document := .Documents}}{{if $i}}, {{end}}<a href="{{$.Root}}documents/{{$document.Slug}}.html">{{$document.LanguageName}}</a>{{end}}</td><td>{{$first.RevisionDate}}</td><td>{{range $first.Entry.SDS.Pictograms}}<span class="ghs">{{pictogramName .}}</span>{{end}}</td><td>{{range $i, $document := .Documents}}{{if $i}}, {{end}}<a href="{{$.Root}}{{$document.PDF}}">{{$document.LanguageName}}</a>{{end}}</td></tr>
+{{end}}</table>{{end}}
+{{with .Document}}<p><a href="{{$.Root}}{{.PDF}}">Open the PDF</a></p>
+<table>
+<tr><th>Category</th><td>{{.Entry.Category}}</td></tr>
+<tr><th>Companies</th><td>{{range $i, $company := .Entry.SDS.Companies}}{{if $i}}, {{end}}{{$company}}{{end}}</td></tr>
+<tr><th>Language</th><td>{{.LanguageName}}</td></tr>
+{{if .Translations}}<tr><th>Translations</th><td>{{range $i, $translation := .Translations}}{{if $i}}, {{end}}<a href="{{$.Root}}documents/{{$translation.Slug}}.html">{{$translation.LanguageName}}</a>{{end}}</td></tr>{{end}}
+<tr><th>Revision date</th><td>{{.RevisionDate}}</td></tr>
+<tr><th>Hazards</th><td>{{range .Entry.SDS.Pictograms}}<span class="ghs">{{pictogramName .}}</span>{{end}}</td></tr>
+<tr><th>CAS numbers</th><td>{{range $i, $cas := .Entry.SDS.CASNumbers}}{{if $i}}, {{end}}{{$cas}}{{end}}</td></tr>
+<tr><th>Size</th><td>{{.Entry.Size}} bytes</td></tr>
+<tr><th>SHA-256</th><td><code>{{.Entry.SHA256}}</code></td></tr>
+<tr><th>Downloaded</th><td>{{.Entry.DownloadedAt.Format "2006-01-02"}}</td></tr>
+<tr><th>Source</th><td><a href="{{.Entry.URL}}">{{.Entry.URL}}</a></td></tr>
+</table>{{end}}
+<p><small>Generated {{.GeneratedAt.Format "2006-01-02 15:04 MST"}}</small></p>
+</body>
+</html>
+`))
+
+// A document as shown on the site
+type mirrorDocument struct {
+	Entry        manifestEntry    // Manifest entry with SDS metadata filled in
+	Product      string           // Readable product name
+	Slug         string           // Base name of the document page
+	PDF          string           // Site-relative path of the copied PDF
+	RevisionDate string           // Revision date as YYYY-MM-DD, or empty
+	LanguageName string           // Language the sheet is written in, or "Unknown"
+	Translations []mirrorDocument // Other language versions of the same sheet
+}
+
+// Translations of one sheet, shown as a single row
+type mirrorGroup struct {
+	Product   string           // Readable product name shared by the translations
+	Documents []mirrorDocument // One document per language, ordered by language
+}
+
+// A category index page
+type mirrorCategory struct {
+	Name      string           // Heading the documents were listed under
+	Slug      string           // Base name of the category page
+	Documents []mirrorDocument // Documents in the category
+	Groups    []mirrorGroup    // Documents grouped by translation
+}
+
+// Data passed to mirrorTemplate for one page
+type mirrorPage struct {
+	Title       string           // Page heading
+	Root        string           // Relative path back to the site root
+	Categories  []mirrorCategory // Set on the home page
+	Groups      []mirrorGroup    // Set on category pages
+	Document    *mirrorDocument  // Set on document pages
+	GeneratedAt time.Time        // Generation time shown in the footer
+}
+
+// Runs the mirror subcommand with its own command-line options
+func runMirror(args []string) error {
+	flags := flag.NewFlagSet("mirror", flag.ExitOnError) // Options specific to mirror
+	outputDir := flags.String("output", "sds-site", "directory the static site is written to; replaced on every run")
+	manifestPath := flags.String("manifest", manifestFilePath, "manifest describing the archive")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if directoryExists(*outputDir) && !fileExists(filepath.Join(*outputDir, mirrorMarkerFile)) {
+		return fmt.Errorf("%s exists and was not generated by mirror; choose another -output", *outputDir)
+	}
+
+	buildDir := *outputDir + ".tmp" // Build next to the target so the swap is a rename
+	if err := os.RemoveAll(buildDir); err != nil {
+		return err
+	}
+	categories, err := buildMirrorSite(buildDir, loadManifest(*manifestPath).list())
+	if err != nil {
+		os.RemoveAll(buildDir)
+		return err
+	}
+	if err := os.RemoveAll(*outputDir); err != nil { // Replace the previous site in one step
+		return err
+	}
+	if err := os.Rename(buildDir, *outputDir); err != nil {
+		return err
+	}
+	log.Printf("Mirrored %d categories to %s", len(categories), *outputDir)
+	return nil
+}
+
+// Copies every archived PDF into dir and renders the home, category and document pages
+func buildMirrorSite(dir string, entries []manifestEntry) ([]mirrorCategory, error) {
+	for _, subdirectory := range []string{"pdf", "categories", "documents"} {
+		if err := os.MkdirAll(filepath.Join(dir, subdirectory), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	generatedAt := time.Now().UTC()
+	var present []manifestEntry
+	for _, entry := range entries {
+		if !fileExists(entry.File) {
+			log.Printf("Skipping %s: %s is missing", entry.URL, entry.File)
+			continue // Only documents present locally can be mirrored
+		}
+		present = append(present, withSDSMetadata(entry))
+	}
+
+	byCategory := make(map[string]*mirrorCategory)
+	for _, translations := range groupTranslations(present) {
+		documents := make([]mirrorDocument, 0, len(translations))
+		for _, entry := range translations {
+			document, err := copyMirrorDocument(dir, entry)
+			if err != nil {
+				return nil, err
+			}
+			documents = append(documents, document)
+		}
+		perLanguage := make(map[string]int)
+		for _, document := range documents {
+			perLanguage[document.LanguageName]++
+		}
+		for i, document := range documents {
+			if perLanguage[document.LanguageName] > 1 && document.RevisionDate != "" {
+				documents[i].LanguageName += " (" + document.RevisionDate + ")" // Tell revisions in one language apart
+			}
+		}
+		for i := range documents {
+			document := documents[i]
+			for j, translation := range documents {
+				if j != i {
+					document.Translations = append(document.Translations, translation)
+				}
+			}
+			if err := renderMirrorPage(filepath.Join(dir, "documents", document.Slug+".html"), mirrorPage{Title: document.Product, Root: "../", Document: &document, GeneratedAt: generatedAt}); err != nil {
+				return nil, err
+			}
+		}
+
+		name := translations[0].Category // Translations are listed under the first language's heading
+		if name == "" {
+			name = "Uncategorized"
+		}
+		if byCategory[name] == nil {
+			byCategory[name] = &mirrorCategory{Name: name, Slug: mirrorSlug(name)}
+		}
+		byCategory[name].Documents = append(byCategory[name].Documents, documents...)
+		group := mirrorGroup{Product: translations[0].SDS.Product, Documents: documents}
+		if group.Product == "" {
+			group.Product = documents[0].Product // Fall back to the file name when the sheet names no product
+		}
+		byCategory[name].Groups = append(byCategory[name].Groups, group)
+	}
+
+	if len(byCategory) == 0 {
+		return nil, fmt.Errorf("no archived documents found to mirror")
+	}
+	categories := make([]mirrorCategory, 0, len(byCategory))
+	for _, category := range byCategory {
+		sort.SliceStable(category.Groups, func(i, j int) bool { return category.Groups[i].Product < category.Groups[j].Product })
+		if err := renderMirrorPage(filepath.Join(dir, "categories", category.Slug+".html"), mirrorPage{Title: category.Name, Root: "../", Groups: category.Groups, GeneratedAt: generatedAt}); err != nil {
+			return nil, err
+		}
+		categories = append(categories, *category)
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].Name < categories[j].Name })
+	if err := renderMirrorPage(filepath.Join(dir, "index.html"), mirrorPage{Title: "Safety Data Sheets", Categories: categories, GeneratedAt: generatedAt}); err != nil {
+		return nil, err
+	}
+	return categories, os.WriteFile(filepath.Join(dir, mirrorMarkerFile), []byte(generatedAt.Format(time.RFC3339)+"\n"), 0o644)
+}
+
+// Copies one archived PDF into the site and describes it for the templates
+func copyMirrorDocument(dir string, entry manifestEntry) (mirrorDocument, error) {
+	data, err := os.ReadFile(entry.File)
+	if err != nil {
+		return mirrorDocument{}, err
+	}
+	relative := strings.TrimPrefix(filepath.ToSlash(entry.File), pdfOutputDir) // Keep domain subdirectories but not the archive root
+	slug := mirrorSlug(strings.TrimSuffix(relative, filepath.Ext(relative)))   // Archive paths are unique, so slugs are too
+	document := mirrorDocument{
+		Entry:        entry,
+		Product:      productNameFromFile(entry.File),
+		Slug:         slug,
+		PDF:          "pdf/" + slug + ".pdf",
+		LanguageName: languageName(entry.documentLanguage()),
+	}
+	if revision := entry.revisionDate(); !revision.IsZero() {
+		document.RevisionDate = revision.Format("2006-01-02")
+	}
+	return document, os.WriteFile(filepath.Join(dir, filepath.FromSlash(document.PDF)), data, 0o644)
+}
+
+// Renders one page of the site
+func renderMirrorPage(filePath string, page mirrorPage) error {
+	out, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if err := mirrorTemplate.Execute(out, page); err != nil {
+		out.Close()
+		return fmt.Errorf("rendering %s: %w", filePath, err)
+	}
+	return out.Close()
+}
+
+// Turns a name or path into a lowercase, URL-safe slug
+func mirrorSlug(name string) string {
+	var slug strings.Builder
+	dash := false // Collapse runs of separators into one dash
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			slug.WriteRune(r)
+			dash = false
+		} else if !dash && slug.Len() > 0 {
+			slug.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(slug.String(), "-")
+}
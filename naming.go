@@ -0,0 +1,187 @@
+package main // Stable file names derived from the product a sheet covers
+
+import (
+	"crypto/sha256" // Hashes the URL of a shortened name
+	"encoding/hex"  // Encodes that hash
+	"fmt"           // Builds validation errors
+	"path"          // Replaces the file name in a storage key
+	"regexp"        // Finds the product identifier
+	"strings"       // Builds slugs
+	"sync"          // Guards the names claimed during a run
+	"unicode"       // Keeps letters and digits of any script in file names
+	"unicode/utf8"  // Shortens names on character boundaries
+)
+
+var fileNaming = "url" // How downloaded files are named: url (from the link) or product (<slug>_rev<date> from the contents)
+
+var outputDirectories = "auto" // Whether files are nested in a directory per domain: auto (when a run spans several domains), flat or domain
+
+var maxFilenameLength = 150 // Longest URL-derived file name in bytes, leaving room below the usual 255-byte limit for language tags and sidecar suffixes; 0 disables shortening
+
+const filenameHashLength = 8 // Hex digits of the URL hash that keep shortened names unique
+
+// Product file names handed out in this process but possibly not yet in the manifest, so parallel processors don't
+// pick the same name
+var productClaims = struct {
+	mu     sync.Mutex
+	owners map[string]string // Storage key → manifest key of the document it was given to
+}{owners: make(map[string]string)}
+
+var (
+	productNameRegex  = regexp.MustCompile(`(?im)^[ \t]*(?:product identifier|product name|trade name|identificador (?:de|del) producto|nombre (?:de|del) producto)[ \t]*[:.]?[ \t]*(.+)$`) // Labelled product name in Section 1
+	productBleedRegex = regexp.MustCompile(`(?i)\t|[ ]{2,}|\s(?:synonyms|other means|otros medios|de identificaci[oó]n|sin[oó]nimos)\b|\s\w+:`)                                             // Start of a neighbouring column or field on the same line
+	slugRegex         = regexp.MustCompile(`[^a-z0-9]+`)                                                                                                                                    // Runs of characters dropped from slugs
+)
+
+// Checks the -naming option
+func validateFileNaming() error {
+	fileNaming = strings.ToLower(strings.TrimSpace(fileNaming))
+	if fileNaming != "url" && fileNaming != "product" {
+		return fmt.Errorf("unknown -naming %q (expected url or product)", fileNaming)
+	}
+	return nil
+}
+
+// Checks the -directories option
+func validateOutputDirectories() error {
+	outputDirectories = strings.ToLower(strings.TrimSpace(outputDirectories))
+	if outputDirectories != "auto" && outputDirectories != "flat" && outputDirectories != "domain" {
+		return fmt.Errorf("unknown -directories %q (expected auto, flat or domain)", outputDirectories)
+	}
+	return nil
+}
+
+// Reports whether files of documents at these URLs are nested in a directory per domain
+func namespacedByDomain(urls []string) bool {
+	switch outputDirectories {
+	case "flat":
+		return false
+	case "domain":
+		return true
+	}
+	return countDomains(urls) > 1 // Namespace output per domain when links span several vendors
+}
+
+// Checks the -max-filename-length option
+func validateMaxFilenameLength() error {
+	if maxFilenameLength != 0 && maxFilenameLength < 32 {
+		return fmt.Errorf("-max-filename-length must be 0 or at least 32")
+	}
+	return nil
+}
+
+// Shortens a file name longer than -max-filename-length, keeping its extension and appending a hash of the URL it
+// came from, so two long names sharing a prefix stay apart
+func truncateFilename(name string, rawURL string) string {
+	if maxFilenameLength == 0 || len(name) <= maxFilenameLength {
+		return name
+	}
+	ext := getFileExtension(name)
+	stem := strings.TrimSuffix(name, ext)
+	keep := max(maxFilenameLength-len(ext)-1-filenameHashLength, 1)
+	for keep > 0 && !utf8.RuneStart(stem[keep]) { // Never split a character of another script
+		keep--
+	}
+	digest := sha256.Sum256([]byte(rawURL))
+	return strings.TrimRight(stem[:keep], "_") + "_" + hex.EncodeToString(digest[:])[:filenameHashLength] + ext
+}
+
+// Returns the product name printed in the title block or identification section, or "" when the sheet doesn't label
+// one
+func findProductName(text string) string {
+	match := productNameRegex.FindStringSubmatch(identificationText(text))
+	if match == nil {
+		return ""
+	}
+	name := match[1]
+	if location := productBleedRegex.FindStringIndex(name); location != nil {
+		name = name[:location[0]] // PDF text merges table columns into one line
+	}
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// Turns a product name into a lowercase slug such as pool_season_ph_up; accents are folded and trademark signs and
+// punctuation are dropped
+func productSlug(name string) string {
+	return strings.Trim(slugRegex.ReplaceAllString(foldDiacritics(name), "_"), "_")
+}
+
+// ASCII spellings of accented Latin letters, so "Fiche sécurité" becomes fiche_securite rather than fiche_s_curit
+var diacriticFolds = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a", 'æ': "ae",
+	'ç': "c", 'ć': "c", 'č': "c", 'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ę': "e", 'ě': "e", 'ğ': "g",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'ı': "i", 'ł': "l",
+	'ñ': "n", 'ń': "n", 'ň': "n", 'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ő': "o", 'œ': "oe",
+	'ř': "r", 'ś': "s", 'š': "s", 'ş': "s", 'ß': "ss", 'ť': "t", 'ţ': "t", 'þ': "th",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u", 'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// Lowercases s and replaces accented Latin letters with their ASCII spelling
+func foldDiacritics(s string) string {
+	var folded strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if ascii, found := diacriticFolds[r]; found {
+			folded.WriteString(ascii)
+		} else {
+			folded.WriteRune(r)
+		}
+	}
+	return folded.String()
+}
+
+// Turns a decoded file name into lowercase words joined by single underscores: accents are folded, letters and digits
+// of other scripts are kept, and everything else separates words
+func sanitizeFilename(name string) string {
+	var sanitized strings.Builder
+	separator := false // Collapse runs of dropped characters into one underscore
+	for _, r := range foldDiacritics(name) {
+		if r < unicode.MaxASCII && (r >= 'a' && r <= 'z' || r >= '0' && r <= '9') || r > unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			if separator && sanitized.Len() > 0 {
+				sanitized.WriteByte('_')
+			}
+			sanitized.WriteRune(r)
+			separator = false
+		} else {
+			separator = true
+		}
+	}
+	if separator && sanitized.Len() > 0 {
+		sanitized.WriteByte('_') // Keep a trailing separator so suffixes like _pdf are still recognized
+	}
+	return sanitized.String()
+}
+
+// Renames a storage key to <slug>_rev<YYYY-MM-DD> so every revision of a product sorts together; keys are left
+// unchanged when the sheet names no product
+func productFilePath(filePath string, metadata sdsMetadata, language string, tagLanguage bool) string {
+	slug := productSlug(metadata.Product)
+	if slug == "" {
+		return filePath // Unreadable sheets keep their URL-derived name
+	}
+	name := slug
+	if metadata.RevisionDate != "" {
+		name += "_rev" + metadata.RevisionDate
+	}
+	name += getFileExtension(filePath)
+	if tagLanguage {
+		name = languageTaggedFilename(name, language) // Language variants of one revision stay apart
+	}
+	return path.Join(path.Dir(filePath), name)
+}
+
+// Keeps a product file name from overwriting another document's file, e.g. the English and Spanish sheets of one
+// product revision, by appending a short hash of the URL
+func uniqueProductPath(documentManifest *manifest, filePath string, rawURL string, language string) string {
+	claimant := manifestEntry{URL: rawURL, Language: language}.key()
+	productClaims.mu.Lock()
+	defer productClaims.mu.Unlock()
+	claimed, claimedNow := productClaims.owners[filePath]
+	owner, taken := documentManifest.fileOwner(filePath)
+	if (claimedNow && claimed != claimant) || (!claimedNow && taken && owner.key() != claimant) {
+		extension := getFileExtension(filePath)
+		filePath = strings.TrimSuffix(filePath, extension) + "_" + sha256Hex([]byte(rawURL))[:8] + extension
+	}
+	productClaims.owners[filePath] = claimant
+	return filePath
+}
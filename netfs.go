@@ -0,0 +1,74 @@
+package main // Writes that stay safe when the archive lives on a network share used by several machines
+
+import (
+	"fmt"           // Wraps retry errors
+	"log"           // Reports retried operations
+	"os"            // Creates, renames and removes files
+	"path/filepath" // Places temporary files next to their destination
+	"time"          // Backs off between retries
+)
+
+var (
+	sharingRetries    = 6                      // Attempts for an operation refused because another client has the file open
+	sharingRetryDelay = 250 * time.Millisecond // Wait before the first retry; doubles with every attempt
+)
+
+// Runs op, retrying with backoff while it fails because another process or machine has the file open, as SMB
+// servers and Windows report for files being read, scanned or indexed
+func retrySharingViolation(description string, op func() error) error {
+	delay := sharingRetryDelay
+	var err error
+	for attempt := 1; attempt <= sharingRetries; attempt++ {
+		if err = op(); err == nil || !isSharingViolation(err) {
+			return err
+		}
+		if attempt < sharingRetries {
+			log.Printf("%s: file in use (%v); retrying in %s", description, err, delay)
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return fmt.Errorf("%s: file still in use after %d attempts: %w", description, sharingRetries, err)
+}
+
+// Writes data to filePath through a uniquely named, synced temporary file in the same directory followed by a
+// rename, so readers on any machine see the old or the new contents but never a partial write, and two writers
+// never share a temporary file. The temporary file stays on the same share, keeping the rename atomic
+func writeFileAtomic(filePath string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	temporary := file.Name()
+	fail := func(err error) error {
+		file.Close()
+		os.Remove(temporary)
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		return fail(err)
+	}
+	if err := file.Sync(); err != nil { // Network clients may cache writes until told to flush
+		return fail(err)
+	}
+	if err := file.Close(); err != nil {
+		return fail(err)
+	}
+	if err := verifyFileSize(temporary, int64(len(data))); err != nil { // Make sure every byte reached the share
+		os.Remove(temporary)
+		return err
+	}
+	if err := os.Chmod(temporary, 0o644); err != nil { // CreateTemp makes files private to the owner
+		log.Println(err)
+	}
+	if err := retrySharingViolation("replacing "+filePath, func() error { return os.Rename(temporary, filePath) }); err != nil {
+		os.Remove(temporary)
+		return err
+	}
+	return nil
+}
+
+// Removes a file, retrying while another client has it open
+func removeShared(filePath string) error {
+	return retrySharingViolation("removing "+filePath, func() error { return os.Remove(filePath) })
+}
@@ -0,0 +1,109 @@
+package main // URL normalization applied before de-duplication
+
+import (
+	"log"          // Reports links that needed repair
+	"net/url"      // Parses, resolves and re-encodes URLs
+	"path"         // Cleans dot segments out of URL paths
+	"strings"      // Lowercases and matches query parameter names
+	"unicode/utf8" // Detects links that aren't valid UTF-8
+)
+
+// Query parameters added by marketing and analytics tools that never change the document served
+var trackingQueryParams = map[string]bool{
+	"gclid":   true,
+	"fbclid":  true,
+	"msclkid": true,
+	"yclid":   true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_ga":     true,
+	"_gl":     true,
+}
+
+// Resolves a link against the page it was found on and returns its canonical form
+func normalizeURL(pageURL string, link string) (string, error) {
+	base, err := url.Parse(pageURL) // The page provides scheme, host and directory for relative links
+	if err != nil {
+		return "", err
+	}
+	repaired := repairURLEscapes(strings.TrimSpace(link)) // Links often carry stray whitespace
+	if repaired != strings.TrimSpace(link) {
+		log.Printf("Repaired link %q as %q", link, repaired)
+	}
+	if !utf8.ValidString(repaired) { // Usually Latin-1 text on an older page
+		log.Printf("Link %q on %s isn't valid UTF-8; requesting its bytes percent-encoded", link, pageURL)
+	}
+	reference, err := url.Parse(repaired)
+	if err != nil {
+		return "", err
+	}
+	resolved := base.ResolveReference(reference) // Handles /a.pdf, ./a.pdf, ../a.pdf and absolute links alike
+
+	resolved.Scheme = strings.ToLower(resolved.Scheme) // Scheme and host are case-insensitive
+	resolved.Host = strings.ToLower(resolved.Host)
+	if port := resolved.Port(); (resolved.Scheme == "http" && port == "80") || (resolved.Scheme == "https" && port == "443") {
+		resolved.Host = resolved.Hostname() // Drop default ports
+	}
+	resolved.Fragment = "" // Fragments never reach the server
+	resolved.RawFragment = ""
+	if resolved.Path == "" {
+		resolved.Path = "/"
+	} else {
+		cleaned := path.Clean(resolved.Path) // Collapse duplicate slashes and leftover dot segments
+		if strings.HasSuffix(resolved.Path, "/") && cleaned != "/" {
+			cleaned += "/" // Keep a meaningful trailing slash
+		}
+		resolved.Path = cleaned
+		resolved.RawPath = "" // Re-derive the escaped form from the cleaned path
+	}
+
+	query := resolved.Query() // Parsed query parameters
+	for name := range query {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "utm_") || trackingQueryParams[lower] {
+			query.Del(name) // Tracking parameters don't identify the document
+		}
+	}
+	resolved.RawQuery = query.Encode() // Encode sorts parameters by name
+	resolved.ForceQuery = false        // Don't keep a bare trailing "?"
+	return resolved.String(), nil      // Return the canonical URL
+}
+
+// Makes a link written by hand parse as a URL: a "%" that doesn't start an escape such as %20 is escaped as %25.
+// Spaces, parentheses, unicode and bytes that aren't valid UTF-8 are percent-encoded as they are when the URL is
+// rebuilt, so the server still receives the bytes the page linked to
+func repairURLEscapes(link string) string {
+	if !strings.Contains(link, "%") {
+		return link
+	}
+	var repaired strings.Builder
+	for i := 0; i < len(link); i++ {
+		if link[i] == '%' && (i+2 >= len(link) || !isHexDigit(link[i+1]) || !isHexDigit(link[i+2])) {
+			repaired.WriteString("%25") // A literal percent sign, e.g. "100%.pdf"
+			continue
+		}
+		repaired.WriteByte(link[i])
+	}
+	return repaired.String()
+}
+
+// Reports whether b is a hexadecimal digit
+func isHexDigit(b byte) bool {
+	return '0' <= b && b <= '9' || 'a' <= b && b <= 'f' || 'A' <= b && b <= 'F'
+}
+
+// Re-encodes the bytes of s that aren't part of valid UTF-8 sequences as the Latin-1 characters they stand for, so
+// file names read from older pages keep their accented letters
+func latin1ToUTF8(s string) string {
+	var converted strings.Builder
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		if r == utf8.RuneError && size == 1 {
+			converted.WriteRune(rune(s[0])) // Latin-1 maps bytes straight to code points
+		} else {
+			converted.WriteString(s[:size])
+		}
+		s = s[size:]
+	}
+	return converted.String()
+}
@@ -0,0 +1,192 @@
+package main // Content-addressable storage layout with a human-readable index
+
+import (
+	"encoding/json" // Reads and writes the index
+	"fmt"           // Builds error messages
+	"log"           // Reports links that could not be created
+	"os"            // Reads and writes the index file
+	"path"          // Builds object keys
+	"sort"          // Lists keys in order
+	"strings"       // Matches key prefixes
+	"sync"          // Guards the index
+)
+
+var (
+	storageLayout   = "path"               // How documents are laid out in storage: path or content
+	objectIndexPath = "objects-index.json" // Local file mapping readable keys to content-addressed objects
+)
+
+const objectsPrefix = "objects/" // Objects live below objects/<first two hex digits>/<rest of the digest><ext>
+
+// Backends that can expose a readable key as a link to an object, so the archive stays browsable
+type storageLinker interface {
+	Link(key string, target string) error // Makes key point at the object stored under target
+}
+
+// Stores every document once under its SHA-256 digest and maps readable keys such as "PDFs/product.pdf" to those
+// objects; identical downloads share one object and an object's name is its expected hash
+type objectStorage struct {
+	inner Storage           // Backend holding the objects
+	mu    sync.Mutex        // Protects index and its file
+	index map[string]string // Readable key to object key
+}
+
+// Wraps a backend in the content-addressable layout, loading the index written by earlier runs
+func newObjectStorage(inner Storage) (*objectStorage, error) {
+	storage := &objectStorage{inner: inner, index: make(map[string]string)}
+	data, err := os.ReadFile(objectIndexPath)
+	if os.IsNotExist(err) {
+		return storage, nil // First run with this layout
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &storage.index); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", objectIndexPath, err)
+	}
+	return storage, nil
+}
+
+// Returns the object key for a digest, keeping the readable key's extension so objects open in a viewer
+func objectKey(digest string, key string) string {
+	return objectsPrefix + digest[:2] + "/" + digest[2:] + strings.ToLower(path.Ext(key))
+}
+
+// Writes the index; callers hold mu
+func (s *objectStorage) saveIndex() error {
+	data, err := json.MarshalIndent(s.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(objectIndexPath, data)
+}
+
+// Reports whether any readable key still points at object; callers hold mu
+func (s *objectStorage) referenced(object string) bool {
+	for _, target := range s.index {
+		if target == object {
+			return true
+		}
+	}
+	return false
+}
+
+// Stores data as an object unless identical content is already stored, then points key at it
+func (s *objectStorage) Put(key string, data []byte) error {
+	object := objectKey(sha256Hex(data), key)
+	exists, err := s.inner.Exists(object)
+	if err != nil {
+		return err
+	}
+	if !exists { // Duplicates only add an index entry
+		if err := s.inner.Put(object, data); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.index[key]
+	s.index[key] = object
+	if err := s.saveIndex(); err != nil {
+		return err
+	}
+	if linker, ok := s.inner.(storageLinker); ok {
+		if err := linker.Link(key, object); err != nil {
+			log.Printf("Failed to link %s to %s: %v", key, object, err) // The index still records the mapping
+		}
+	}
+	if previous != "" && previous != object && !s.referenced(previous) { // Replaced content nobody else shares
+		return s.inner.Delete(previous)
+	}
+	return nil
+}
+
+// Reads the object key points at
+func (s *objectStorage) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	object, found := s.index[key]
+	s.mu.Unlock()
+	if !found {
+		return nil, fmt.Errorf("%s: %w", key, os.ErrNotExist)
+	}
+	return s.inner.Get(object)
+}
+
+// Reports whether key is indexed and its object is stored
+func (s *objectStorage) Exists(key string) (bool, error) {
+	s.mu.Lock()
+	object, found := s.index[key]
+	s.mu.Unlock()
+	if !found {
+		return false, nil
+	}
+	return s.inner.Exists(object)
+}
+
+// Hashes the object key points at
+func (s *objectStorage) Hash(key string) (string, error) {
+	s.mu.Lock()
+	object, found := s.index[key]
+	s.mu.Unlock()
+	if !found {
+		return "", fmt.Errorf("%s: %w", key, os.ErrNotExist)
+	}
+	return s.inner.Hash(object)
+}
+
+// Lists the readable keys starting with prefix
+func (s *objectStorage) List(prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.index {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Removes key from the index, and its object once no other key points at it
+func (s *objectStorage) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, found := s.index[key]
+	if !found {
+		return nil // Already gone
+	}
+	delete(s.index, key)
+	if err := s.saveIndex(); err != nil {
+		return err
+	}
+	if _, ok := s.inner.(storageLinker); ok {
+		if err := s.inner.Delete(key); err != nil { // Drop the readable link
+			return err
+		}
+	}
+	if s.referenced(object) {
+		return nil
+	}
+	return s.inner.Delete(object)
+}
+
+// Removes key from the index, and its object once no other key points at it, leaving whatever the backend stores
+// under key itself, e.g. the file that replaced its link when the archive moved to the path layout
+func (s *objectStorage) forget(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, found := s.index[key]
+	if !found {
+		return nil // Already gone
+	}
+	delete(s.index, key)
+	if err := s.saveIndex(); err != nil {
+		return err
+	}
+	if s.referenced(object) {
+		return nil
+	}
+	return s.inner.Delete(object)
+}
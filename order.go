@@ -0,0 +1,119 @@
+package main // Download queue ordering so the most important documents land first
+
+import (
+	"fmt"      // Builds validation errors
+	"log"      // Reports probe failures
+	"net/http" // Probes unknown documents with HEAD requests
+	"slices"   // Sorts the queue
+	"strings"  // Parses the category order
+	"time"     // Compares Last-Modified times
+)
+
+var (
+	downloadOrder = "page" // Queue order: page, smallest-first, newest-first or category
+	categoryOrder = ""     // Comma-separated categories downloaded first with -order category, in this order
+)
+
+// Orders the download queue can be sorted in
+var downloadOrders = []string{"page", "smallest-first", "newest-first", "category"}
+
+// Size and age used to order one document
+type documentPriority struct {
+	Size         int64     // Bytes, or -1 when unknown
+	LastModified time.Time // Server Last-Modified time, zero when unknown
+}
+
+// Checks the -order and -category-order options
+func validateDownloadOrder() error {
+	if !slices.Contains(downloadOrders, downloadOrder) {
+		return fmt.Errorf("unknown -order %q (expected one of %s)", downloadOrder, strings.Join(downloadOrders, ", "))
+	}
+	if categoryOrder != "" && downloadOrder != "category" {
+		return fmt.Errorf("-category-order requires -order category")
+	}
+	return nil
+}
+
+// Returns the documents in the configured download order; ties keep page order
+func orderDocuments(documents []pdfDocument, order string, documentManifest *manifest) []pdfDocument {
+	ordered := slices.Clone(documents)
+	switch order {
+	case "smallest-first", "newest-first":
+		priorities := make(map[string]documentPriority, len(ordered))
+		for _, document := range ordered {
+			priorities[document.URL] = lookupPriority(httpClient(), document.URL, documentManifest)
+		}
+		slices.SortStableFunc(ordered, func(a pdfDocument, b pdfDocument) int {
+			first, second := priorities[a.URL], priorities[b.URL]
+			if order == "smallest-first" {
+				return compareKnownFirst(first.Size >= 0, second.Size >= 0, func() int { return int(min(max(first.Size-second.Size, -1), 1)) })
+			}
+			return compareKnownFirst(!first.LastModified.IsZero(), !second.LastModified.IsZero(), func() int { return second.LastModified.Compare(first.LastModified) })
+		})
+	case "category":
+		rank := categoryRanks(ordered, categoryOrder)
+		slices.SortStableFunc(ordered, func(a pdfDocument, b pdfDocument) int { return rank[a.Category] - rank[b.Category] })
+	}
+	return ordered
+}
+
+// Compares two values, placing unknown ones after known ones
+func compareKnownFirst(firstKnown bool, secondKnown bool, compare func() int) int {
+	switch {
+	case firstKnown && secondKnown:
+		return compare()
+	case firstKnown:
+		return -1
+	case secondKnown:
+		return 1
+	}
+	return 0
+}
+
+// Ranks categories: those listed in the order option first, the rest in the order they appear on the page
+func categoryRanks(documents []pdfDocument, order string) map[string]int {
+	rank := make(map[string]int)
+	for _, category := range strings.Split(order, ",") {
+		category = strings.TrimSpace(category)
+		for _, document := range documents { // Headings are matched case-insensitively
+			if _, ranked := rank[document.Category]; !ranked && category != "" && strings.EqualFold(document.Category, category) {
+				rank[document.Category] = len(rank)
+			}
+		}
+	}
+	for _, document := range documents {
+		if _, ranked := rank[document.Category]; !ranked {
+			rank[document.Category] = len(rank)
+		}
+	}
+	return rank
+}
+
+// Returns a document's size and age from the manifest, probing the server with a HEAD request when it isn't known yet
+func lookupPriority(client *http.Client, documentURL string, documentManifest *manifest) documentPriority {
+	if entry, found := documentManifest.lookup(documentURL, ""); found {
+		return documentPriority{Size: entry.Size, LastModified: entry.LastModified}
+	}
+	priority := documentPriority{Size: -1}
+	request, err := http.NewRequest(http.MethodHead, documentURL, nil)
+	if err != nil {
+		return priority
+	}
+	if err := hostBreakers.allow(getDomainFromURL(documentURL)); err != nil {
+		return priority // Don't probe hosts that keep failing
+	}
+	request, cancel := withFileDeadline(request)
+	defer cancel()
+	response, err := client.Do(request)
+	if err != nil {
+		log.Printf("Failed to probe %s for ordering: %v", documentURL, err)
+		return priority
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return priority // Servers that reject HEAD give no usable headers
+	}
+	priority.Size = response.ContentLength // -1 when the server doesn't say
+	priority.LastModified = parseHTTPTime(response.Header.Get("Last-Modified"))
+	return priority
+}
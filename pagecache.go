@@ -0,0 +1,88 @@
+package main // Cache of links extracted from listing pages, keyed by page content hash
+
+import (
+	"encoding/json" // Stores the cache on disk
+	"log"           // Reports cache problems
+	"os"            // Reads and writes the cache file
+	"time"          // Records when a page was last extracted
+)
+
+var pageCacheFilePath = "page-cache.json" // Where extracted links are cached between runs; empty disables caching
+
+const pageExtractorVersion = 3 // Bumped whenever link extraction changes so cached results are redone
+
+// Links extracted from one listing page together with the hash of the HTML they came from
+type pageCacheEntry struct {
+	SHA256      string            `json:"sha256"`               // Hash of the page body
+	Extractor   int               `json:"extractor"`            // pageExtractorVersion the links were extracted with
+	Container   string            `json:"container,omitempty"`  // Target selector or XPath the links were restricted to
+	Extensions  string            `json:"extensions,omitempty"` // Link extensions besides .pdf that -accept added
+	Links       []string          `json:"links"`                // PDF links in page order, as written in the HTML
+	Categories  map[string]string `json:"categories,omitempty"` // Heading each link appeared under
+	Anchors     map[string]string `json:"anchors,omitempty"`    // Text each link was shown with
+	ExtractedAt time.Time         `json:"extracted_at"`         // When the links were extracted
+}
+
+// Loads the page cache, returning an empty one when it is missing, disabled or unreadable
+func loadPageCache(filePath string) map[string]pageCacheEntry {
+	cache := make(map[string]pageCacheEntry)
+	if filePath == "" {
+		return cache
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println(err)
+		}
+		return cache
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		log.Printf("Ignoring corrupt page cache %s: %v", filePath, err)
+		return make(map[string]pageCacheEntry)
+	}
+	return cache
+}
+
+// Writes the page cache to disk
+func savePageCache(filePath string, cache map[string]pageCacheEntry) {
+	if filePath == "" {
+		return
+	}
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := writeFileAtomic(filePath, data); err != nil {
+		log.Printf("Failed to write page cache %s: %v", filePath, err)
+	}
+}
+
+// Returns the links, categories and anchor texts of a page, reusing the cached extraction when the HTML, the target's
+// container restriction and the accepted link extensions haven't changed
+func extractPageLinks(cache map[string]pageCacheEntry, pageURL string, target scrapeTarget, pageHTML string) ([]string, map[string]string, map[string]string) {
+	hash := sha256Hex([]byte(pageHTML))
+	if cached, found := cache[pageURL]; found && cached.SHA256 == hash && cached.Extractor == pageExtractorVersion && cached.Container == target.containerKey() && cached.Extensions == acceptedLinkExtensionsKey() {
+		log.Printf("Listing page %s unchanged since %s; reusing %d links", pageURL, cached.ExtractedAt.Format(time.RFC3339), len(cached.Links))
+		return cached.Links, cached.Categories, cached.Anchors
+	}
+	linkHTML := pageHTML
+	if target.container != nil && pageHTML != "" { // Only look inside the configured containers
+		selected, containers, err := selectContainers(pageHTML, target.container)
+		if err != nil {
+			log.Printf("Failed to parse %s for %s: %v", pageURL, target.containerKey(), err)
+			return nil, nil, nil
+		}
+		if containers == 0 {
+			log.Printf("No element on %s matches %s; the page layout may have changed", pageURL, target.containerKey())
+		}
+		linkHTML = selected
+	}
+	links := extractPDFUrls(linkHTML)            // Every PDF link in the selected part of the page
+	categories := extractPDFCategories(linkHTML) // Map each PDF link to the heading it appears under
+	anchors := extractPDFAnchors(linkHTML)       // Link texts feed the SDS classifier
+	if pageHTML != "" {                          // Failed fetches aren't worth remembering
+		cache[pageURL] = pageCacheEntry{SHA256: hash, Extractor: pageExtractorVersion, Container: target.containerKey(), Extensions: acceptedLinkExtensionsKey(), Links: links, Categories: categories, Anchors: anchors, ExtractedAt: time.Now().UTC()}
+	}
+	return links, categories, anchors
+}
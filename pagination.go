@@ -0,0 +1,124 @@
+package main // Paginated listing pages: URL templates with page ranges and "next page" links
+
+import (
+	"fmt"     // Builds template errors
+	"regexp"  // Finds page ranges in URL templates
+	"strconv" // Parses page numbers
+	"strings" // Expands templates and matches link text
+
+	"golang.org/x/net/html" // Parses pages to find the next link
+)
+
+const (
+	defaultMaxPages  = 25   // Pages followed per target when max_pages isn't set
+	maxTemplatePages = 1000 // Largest page range a URL template may expand to
+)
+
+var pageRangeRegex = regexp.MustCompile(`\{(\d+)\.\.(\d+)\}`) // {first..last} in a target URL
+
+// Link texts used for "next page" links when no selector is configured
+var nextLinkTexts = map[string]bool{"next": true, "next page": true, "next ›": true, "next »": true, "next >": true, "›": true, "»": true, "siguiente": true}
+
+// Selector for links that mark themselves as the next page
+var nextLinkSelector, _ = compileCSSSelector("link[rel~=next], a[rel~=next], a.next")
+
+// Expands a URL template such as https://example.com/sds?page={1..20} into one URL per page
+func expandPageTemplate(rawURL string) ([]string, error) {
+	match := pageRangeRegex.FindStringSubmatchIndex(rawURL)
+	if match == nil {
+		return []string{rawURL}, nil
+	}
+	if pageRangeRegex.MatchString(rawURL[match[1]:]) {
+		return nil, fmt.Errorf("url %s: only one {first..last} range is supported", rawURL)
+	}
+	first, err := strconv.Atoi(rawURL[match[2]:match[3]])
+	if err != nil {
+		return nil, err
+	}
+	last, err := strconv.Atoi(rawURL[match[4]:match[5]])
+	if err != nil {
+		return nil, err
+	}
+	if last < first || last-first >= maxTemplatePages {
+		return nil, fmt.Errorf("url %s: page range must be ascending and at most %d pages", rawURL, maxTemplatePages)
+	}
+	width := 0 // {01..20} keeps the zero padding
+	if digits := rawURL[match[2]:match[3]]; len(digits) > 1 && digits[0] == '0' {
+		width = len(digits)
+	}
+	urls := make([]string, 0, last-first+1)
+	for page := first; page <= last; page++ {
+		urls = append(urls, rawURL[:match[0]]+fmt.Sprintf("%0*d", width, page)+rawURL[match[1]:])
+	}
+	return urls, nil
+}
+
+// Returns the href of the page's "next page" link, or "" when there is none; a configured selector takes precedence
+// over rel="next", class="next" and link texts like "Next »"
+func findNextPageLink(pageHTML string, selector nodeSelector) string {
+	document, err := html.Parse(strings.NewReader(pageHTML))
+	if err != nil {
+		return ""
+	}
+	var byText string // First link whose text reads like "next", used when nothing is marked up
+	var found string
+	var visit func(node *html.Node)
+	visit = func(node *html.Node) {
+		if found != "" {
+			return
+		}
+		if node.Type == html.ElementNode {
+			href, hasHref := nodeAttribute(node, "href")
+			switch {
+			case selector != nil && selector.matches(node):
+				if !hasHref {
+					href, hasHref = firstDescendantHref(node) // The selector may point at the list item around the link
+				}
+				if hasHref {
+					found = href
+				}
+				return
+			case selector == nil && hasHref && nextLinkSelector.matches(node):
+				found = href
+				return
+			case selector == nil && hasHref && byText == "" && node.Data == "a" && nextLinkTexts[strings.ToLower(strings.Join(strings.Fields(nodeText(node)), " "))]:
+				byText = href
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			visit(child)
+		}
+	}
+	visit(document)
+	if found == "" {
+		found = byText
+	}
+	return strings.TrimSpace(found)
+}
+
+// Returns the href of the first link inside node
+func firstDescendantHref(node *html.Node) (string, bool) {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode {
+			if href, found := nodeAttribute(child, "href"); found {
+				return href, true
+			}
+			if href, found := firstDescendantHref(child); found {
+				return href, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Returns the text content of a node and its descendants
+func nodeText(node *html.Node) string {
+	if node.Type == html.TextNode {
+		return node.Data
+	}
+	var text strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		text.WriteString(nodeText(child))
+	}
+	return text.String()
+}
@@ -0,0 +1,792 @@
+package main // Minimal PDF text extraction for reading SDS contents
+
+import (
+	"bytes"            // Scans raw PDF bytes
+	"compress/zlib"    // Inflates FlateDecode streams
+	"encoding/ascii85" // Decodes ASCII85Decode streams
+	"encoding/hex"     // Decodes hex strings and ASCIIHexDecode streams
+	"errors"           // Reports PDFs without readable pages
+	"io"               // Bounds inflated stream sizes
+	"math"             // Measures distances between text runs
+	"regexp"           // Finds object headers
+	"strconv"          // Parses numbers and object IDs
+	"strings"          // Builds extracted text
+	"unicode/utf16"    // Decodes ToUnicode destination strings
+)
+
+const (
+	maxInflatedStreamSize = 32 << 20 // Upper bound on a single decoded stream so malformed files can't exhaust memory
+	maxFormDepth          = 5        // Nesting limit for form XObjects drawn from content streams
+)
+
+// PDF object model used by the extractor
+type (
+	pdfName   string            // /Name
+	pdfString []byte            // (literal) or <hex> string
+	pdfDict   map[string]any    // << /Key value >>
+	pdfArray  []any             // [ values ]
+	pdfRef    struct{ num int } // N G R; generation numbers are ignored
+	pdfObject struct {          // An indirect object with its optional stream data
+		value  any    // Parsed object value
+		stream []byte // Raw (still encoded) stream bytes, if any
+	}
+	pdfKeyword string // Bare operator or keyword such as Tj or obj
+)
+
+// Holds every indirect object in a PDF, keyed by object number
+type pdfFile struct {
+	objects map[int]pdfObject // Objects by number; later definitions win
+	fonts   map[int]*pdfFont  // Decoded fonts cached by object number
+}
+
+var objectHeaderRegex = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`) // Start of an indirect object
+
+// Extracts the text of every page in reading order
+func extractPDFPages(data []byte) ([]string, error) {
+	file := &pdfFile{objects: make(map[int]pdfObject), fonts: make(map[int]*pdfFont)}
+	file.loadObjects(data)
+	file.loadObjectStreams()
+
+	var pages []string
+	for _, page := range file.pageList() {
+		var text strings.Builder
+		resources, _ := file.resolve(page["Resources"]).(pdfDict)
+		for _, content := range file.contentStreams(page["Contents"]) {
+			file.showContent(&text, content, resources, 0)
+		}
+		pages = append(pages, strings.TrimSpace(text.String()))
+	}
+	if len(pages) == 0 {
+		return nil, errors.New("no pages found in PDF")
+	}
+	return pages, nil
+}
+
+// Finds every "N G obj" in the file and parses the object that follows, including its stream
+func (f *pdfFile) loadObjects(data []byte) {
+	for _, location := range objectHeaderRegex.FindAllSubmatchIndex(data, -1) {
+		num, _ := strconv.Atoi(string(data[location[2]:location[3]]))
+		lexer := &pdfLexer{data: data, pos: location[1]}
+		value := lexer.parseValue()
+		object := pdfObject{value: value}
+		if dict, isDict := value.(pdfDict); isDict {
+			lexer.skipSpace()
+			if bytes.HasPrefix(data[lexer.pos:], []byte("stream")) {
+				object.stream = streamData(data, lexer.pos+len("stream"), dict)
+			}
+		}
+		f.objects[num] = object
+	}
+}
+
+// Returns the raw bytes of a stream starting after the "stream" keyword
+func streamData(data []byte, start int, dict pdfDict) []byte {
+	if start < len(data) && data[start] == '\r' {
+		start++
+	}
+	if start < len(data) && data[start] == '\n' {
+		start++
+	}
+	if length, ok := dict["Length"].(float64); ok { // Trust a direct /Length when endstream follows it
+		end := start + int(length)
+		if end <= len(data) && end >= start && bytes.HasPrefix(bytes.TrimLeft(data[end:min(end+16, len(data))], "\r\n \t"), []byte("endstream")) {
+			return data[start:end]
+		}
+	}
+	end := bytes.Index(data[start:], []byte("endstream")) // Fall back to searching for the terminator
+	if end < 0 {
+		return nil
+	}
+	return bytes.TrimRight(data[start:start+end], "\r\n")
+}
+
+// Unpacks objects stored inside compressed object streams (PDF 1.5+)
+func (f *pdfFile) loadObjectStreams() {
+	for _, object := range f.objects {
+		dict, _ := object.value.(pdfDict)
+		if name, _ := dict["Type"].(pdfName); name != "ObjStm" {
+			continue
+		}
+		decoded := f.decodeStream(object)
+		first, _ := f.resolve(dict["First"]).(float64)
+		count, _ := f.resolve(dict["N"]).(float64)
+		header := &pdfLexer{data: decoded}
+		for i := 0; i < int(count); i++ {
+			num, _ := header.parseValue().(float64)
+			offset, _ := header.parseValue().(float64)
+			position := int(first) + int(offset)
+			if position < 0 || position >= len(decoded) {
+				continue
+			}
+			if _, exists := f.objects[int(num)]; exists {
+				continue // Keep objects defined directly in the file
+			}
+			f.objects[int(num)] = pdfObject{value: (&pdfLexer{data: decoded, pos: position}).parseValue()}
+		}
+	}
+}
+
+// Follows indirect references until a direct value is reached
+func (f *pdfFile) resolve(value any) any {
+	for depth := 0; depth < 16; depth++ { // Guard against reference cycles
+		ref, isRef := value.(pdfRef)
+		if !isRef {
+			return value
+		}
+		value = f.objects[ref.num].value
+	}
+	return nil
+}
+
+// Returns the object a value refers to, including its stream
+func (f *pdfFile) object(value any) pdfObject {
+	if ref, isRef := value.(pdfRef); isRef {
+		return f.objects[ref.num]
+	}
+	return pdfObject{value: value}
+}
+
+// Decodes a stream according to its /Filter entries; unsupported filters yield nil
+func (f *pdfFile) decodeStream(object pdfObject) []byte {
+	dict, _ := object.value.(pdfDict)
+	var filters []any
+	switch filter := f.resolve(dict["Filter"]).(type) {
+	case pdfName:
+		filters = []any{filter}
+	case pdfArray:
+		filters = filter
+	}
+	data := object.stream
+	for _, filter := range filters {
+		switch f.resolve(filter) {
+		case pdfName("FlateDecode"), pdfName("Fl"):
+			inflater, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil
+			}
+			data, _ = io.ReadAll(io.LimitReader(inflater, maxInflatedStreamSize)) // Keep whatever inflated before an error
+		case pdfName("ASCIIHexDecode"), pdfName("AHx"):
+			data = decodeHexString(data)
+		case pdfName("ASCII85Decode"), pdfName("A85"):
+			trimmed := bytes.TrimSuffix(bytes.TrimSpace(bytes.TrimPrefix(bytes.TrimSpace(data), []byte("<~"))), []byte("~>"))
+			decoded := make([]byte, 4*len(trimmed)/5+4)
+			n, _, err := ascii85.Decode(decoded, trimmed, true)
+			if err != nil {
+				return nil
+			}
+			data = decoded[:n]
+		default:
+			return nil // Image and other filters never contain text
+		}
+	}
+	return data
+}
+
+// Walks the page tree from the document catalog, falling back to every /Page object
+func (f *pdfFile) pageList() []pdfDict {
+	var pages []pdfDict
+	visited := make(map[int]bool) // Objects already walked, so a node listing itself or an ancestor is taken once
+	var walk func(node any, depth int)
+	walk = func(node any, depth int) {
+		if ref, isRef := node.(pdfRef); isRef {
+			if visited[ref.num] {
+				return
+			}
+			visited[ref.num] = true
+		}
+		dict, _ := f.resolve(node).(pdfDict)
+		if dict == nil || depth > 32 {
+			return
+		}
+		switch dict["Type"] {
+		case pdfName("Pages"):
+			kids, _ := f.resolve(dict["Kids"]).(pdfArray)
+			for _, kid := range kids {
+				if kidDict, _ := f.resolve(kid).(pdfDict); kidDict != nil && kidDict["Resources"] == nil && dict["Resources"] != nil {
+					kidDict["Resources"] = dict["Resources"] // Resources are inherited down the tree
+				}
+				walk(kid, depth+1)
+			}
+		case pdfName("Page"):
+			pages = append(pages, dict)
+		}
+	}
+	for _, object := range f.objects {
+		if dict, _ := object.value.(pdfDict); dict["Type"] == pdfName("Catalog") {
+			walk(dict["Pages"], 0)
+			break
+		}
+	}
+	if len(pages) == 0 { // Damaged catalog; take pages in object order
+		for num := 0; num <= len(f.objects)*4 && len(pages) < len(f.objects); num++ {
+			if dict, _ := f.objects[num].value.(pdfDict); dict["Type"] == pdfName("Page") {
+				pages = append(pages, dict)
+			}
+		}
+	}
+	return pages
+}
+
+// Returns the decoded content streams of a page
+func (f *pdfFile) contentStreams(contents any) [][]byte {
+	var streams [][]byte
+	if array, isArray := f.resolve(contents).(pdfArray); isArray {
+		for _, item := range array {
+			streams = append(streams, f.decodeStream(f.object(item)))
+		}
+		return streams
+	}
+	return [][]byte{f.decodeStream(f.object(contents))}
+}
+
+// Tracks the text position so runs on one baseline join into a line and gaps become spaces
+type pdfTextState struct {
+	font        *pdfFont // Current font selected by Tf
+	fontSize    float64  // Size given to Tf
+	charSpacing float64  // Tc
+	wordSpacing float64  // Tw
+	leading     float64  // TL, used by T*, ' and "
+	scaleX      float64  // Horizontal scale of the text matrix
+	scaleY      float64  // Vertical scale of the text matrix
+	x, y        float64  // Current text position in user space
+	lineX       float64  // Start of the current line in user space
+	lineY       float64  // Baseline of the current line in user space
+	shown       bool     // Whether any text has been written yet
+	lastX       float64  // Where the previously shown text ended
+	lastY       float64  // Baseline of the previously shown text
+}
+
+// Moves to a new line offset from the start of the current one, in text space units
+func (s *pdfTextState) moveLine(tx float64, ty float64) {
+	s.lineX += tx * s.scaleX
+	s.lineY += ty * s.scaleY
+	s.x, s.y = s.lineX, s.lineY
+}
+
+// Writes a newline or space when the next text doesn't continue where the last one ended
+func (s *pdfTextState) separate(out *strings.Builder) {
+	if !s.shown {
+		return
+	}
+	size := math.Max(math.Abs(s.fontSize*s.scaleY), 1) // Rendered font size
+	switch {
+	case math.Abs(s.y-s.lastY) > size*0.5:
+		out.WriteByte('\n') // Different baseline
+	case s.x-s.lastX > size*0.15 || s.lastX-s.x > size*2:
+		out.WriteByte(' ') // Visible gap, or a jump back such as a new table column
+	}
+}
+
+// Shows a string at the current position and advances past it
+func (s *pdfTextState) show(out *strings.Builder, value any) {
+	raw, isString := value.(pdfString)
+	if !isString {
+		return
+	}
+	s.separate(out)
+	advance := s.font.appendText(out, raw, s.fontSize, s.charSpacing, s.wordSpacing)
+	s.x += advance * s.scaleX
+	s.shown, s.lastX, s.lastY = true, s.x, s.y
+}
+
+// Interprets the text operators of a content stream and appends the shown text
+func (f *pdfFile) showContent(out *strings.Builder, content []byte, resources pdfDict, depth int) {
+	fontResources, _ := f.resolve(resources["Font"]).(pdfDict)
+	xObjects, _ := f.resolve(resources["XObject"]).(pdfDict)
+	state := &pdfTextState{fontSize: 1, scaleX: 1, scaleY: 1}
+	var operands []any
+	number := func(index int) float64 { // Operand counted from the end, or 0 when missing
+		if index > len(operands) {
+			return 0
+		}
+		value, _ := operands[len(operands)-index].(float64)
+		return value
+	}
+	lexer := &pdfLexer{data: content}
+	for {
+		token := lexer.parseValue()
+		if token == nil && lexer.pos >= len(lexer.data) {
+			return
+		}
+		operator, isOperator := token.(pdfKeyword)
+		if !isOperator {
+			operands = append(operands, token)
+			continue
+		}
+		switch operator {
+		case "BT": // Text object starts at the identity matrix
+			state.scaleX, state.scaleY, state.lineX, state.lineY, state.x, state.y = 1, 1, 0, 0, 0, 0
+		case "Tf":
+			if len(operands) >= 2 {
+				name, _ := operands[len(operands)-2].(pdfName)
+				state.font = f.font(fontResources[string(name)])
+				state.fontSize = number(1)
+			}
+		case "Tc":
+			state.charSpacing = number(1)
+		case "Tw":
+			state.wordSpacing = number(1)
+		case "TL":
+			state.leading = number(1)
+		case "Tm":
+			if len(operands) >= 6 {
+				state.scaleX, state.scaleY = number(6), number(3)
+				if state.scaleX == 0 { // Rotated text; keep distances meaningful
+					state.scaleX = math.Hypot(number(6), number(5))
+				}
+				if state.scaleY == 0 {
+					state.scaleY = math.Hypot(number(4), number(3))
+				}
+				state.lineX, state.lineY = number(2), number(1)
+				state.x, state.y = state.lineX, state.lineY
+			}
+		case "Td":
+			state.moveLine(number(2), number(1))
+		case "TD":
+			state.leading = -number(1)
+			state.moveLine(number(2), number(1))
+		case "T*":
+			state.moveLine(0, -state.leading)
+		case "Tj":
+			if len(operands) >= 1 {
+				state.show(out, operands[len(operands)-1])
+			}
+		case "'", "\"":
+			if operator == "\"" && len(operands) >= 3 {
+				state.wordSpacing, state.charSpacing = number(3), number(2)
+			}
+			state.moveLine(0, -state.leading)
+			if len(operands) >= 1 {
+				state.show(out, operands[len(operands)-1])
+			}
+		case "TJ":
+			if len(operands) >= 1 {
+				items, _ := operands[len(operands)-1].(pdfArray)
+				for _, item := range items {
+					if adjustment, isNumber := item.(float64); isNumber {
+						state.x -= adjustment / 1000 * state.fontSize * state.scaleX // Kerning moves the position left
+						continue
+					}
+					state.show(out, item)
+				}
+			}
+		case "Do":
+			if len(operands) >= 1 && depth < maxFormDepth {
+				name, _ := operands[len(operands)-1].(pdfName)
+				form := f.object(xObjects[string(name)])
+				if dict, _ := form.value.(pdfDict); dict["Subtype"] == pdfName("Form") {
+					formResources, _ := f.resolve(dict["Resources"]).(pdfDict)
+					if formResources == nil {
+						formResources = resources
+					}
+					out.WriteByte('\n')
+					f.showContent(out, f.decodeStream(form), formResources, depth+1)
+					out.WriteByte('\n')
+				}
+			}
+		case "ID": // Inline image data runs until EI
+			if end := bytes.Index(lexer.data[lexer.pos:], []byte("EI")); end >= 0 {
+				lexer.pos += end + 2
+			} else {
+				lexer.pos = len(lexer.data)
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+// Maps character codes of one font to Unicode text and glyph widths
+type pdfFont struct {
+	codeWidth    int                // Bytes per character code (1 for simple fonts, usually 2 for Type0)
+	toUnicode    map[uint32]string  // Mapping from the font's ToUnicode CMap
+	widths       map[uint32]float64 // Glyph widths in thousandths of the font size
+	defaultWidth float64            // Width of glyphs missing from widths
+}
+
+// Loads and caches the font referenced from a resource dictionary
+func (f *pdfFile) font(value any) *pdfFont {
+	ref, isRef := value.(pdfRef)
+	if isRef {
+		if cached, found := f.fonts[ref.num]; found {
+			return cached
+		}
+	}
+	dict, _ := f.resolve(value).(pdfDict)
+	font := &pdfFont{codeWidth: 1, toUnicode: make(map[uint32]string), widths: make(map[uint32]float64), defaultWidth: 500}
+	if dict["Subtype"] == pdfName("Type0") {
+		font.codeWidth = 2 // Identity-H and most CJK CMaps use two-byte codes
+		font.defaultWidth = 1000
+		descendants, _ := f.resolve(dict["DescendantFonts"]).(pdfArray)
+		if len(descendants) > 0 {
+			f.loadCIDWidths(font, f.resolve(descendants[0]))
+		}
+	} else {
+		first, _ := f.resolve(dict["FirstChar"]).(float64)
+		widths, _ := f.resolve(dict["Widths"]).(pdfArray)
+		for i, width := range widths {
+			if value, isNumber := f.resolve(width).(float64); isNumber {
+				font.widths[uint32(int(first)+i)] = value
+			}
+		}
+	}
+	if cmap := f.decodeStream(f.object(dict["ToUnicode"])); cmap != nil {
+		parseToUnicode(cmap, font)
+	}
+	if isRef {
+		f.fonts[ref.num] = font
+	}
+	return font
+}
+
+// Reads /DW and the /W array of a CID font: "c [w1 w2 ...]" and "cFirst cLast w" forms
+func (f *pdfFile) loadCIDWidths(font *pdfFont, descendant any) {
+	dict, _ := descendant.(pdfDict)
+	if defaultWidth, isNumber := f.resolve(dict["DW"]).(float64); isNumber {
+		font.defaultWidth = defaultWidth
+	}
+	widths, _ := f.resolve(dict["W"]).(pdfArray)
+	for i := 0; i+1 < len(widths); {
+		first, _ := f.resolve(widths[i]).(float64)
+		if list, isArray := f.resolve(widths[i+1]).(pdfArray); isArray {
+			for offset, width := range list {
+				if value, isNumber := f.resolve(width).(float64); isNumber {
+					font.widths[uint32(int(first)+offset)] = value
+				}
+			}
+			i += 2
+			continue
+		}
+		if i+2 >= len(widths) {
+			return
+		}
+		last, _ := f.resolve(widths[i+1]).(float64)
+		width, _ := f.resolve(widths[i+2]).(float64)
+		for code := first; code <= last && code-first <= 0xFFFF; code++ {
+			font.widths[uint32(code)] = width
+		}
+		i += 3
+	}
+}
+
+// Appends the Unicode text of a PDF string shown with this font and returns its advance in text space
+func (font *pdfFont) appendText(out *strings.Builder, raw pdfString, fontSize float64, charSpacing float64, wordSpacing float64) float64 {
+	if font == nil {
+		font = &pdfFont{codeWidth: 1, defaultWidth: 500} // Text shown before any Tf
+	}
+	advance := 0.0
+	for i := 0; i+font.codeWidth <= len(raw); i += font.codeWidth {
+		code := uint32(0)
+		for _, b := range raw[i : i+font.codeWidth] {
+			code = code<<8 | uint32(b)
+		}
+		if text, found := font.toUnicode[code]; found {
+			out.WriteString(text)
+		} else if font.codeWidth == 1 {
+			out.WriteRune(rune(code)) // No mapping; assume Latin-1
+		}
+		width, known := font.widths[code]
+		if !known {
+			width = font.defaultWidth
+		}
+		advance += width/1000*fontSize + charSpacing
+		if font.codeWidth == 1 && code == ' ' {
+			advance += wordSpacing // Tw only applies to single-byte spaces
+		}
+	}
+	return advance
+}
+
+// Parses bfchar and bfrange sections of a ToUnicode CMap
+func parseToUnicode(cmap []byte, font *pdfFont) {
+	lexer := &pdfLexer{data: cmap}
+	var operands []any
+	for lexer.pos < len(lexer.data) {
+		token := lexer.parseValue()
+		keyword, isKeyword := token.(pdfKeyword)
+		if !isKeyword {
+			if token != nil {
+				operands = append(operands, token)
+			}
+			continue
+		}
+		switch keyword {
+		case "endcodespacerange":
+			if len(operands) >= 1 {
+				if low, _ := operands[0].(pdfString); len(low) > 0 {
+					font.codeWidth = len(low) // The code space defines the code length
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				source, _ := operands[i].(pdfString)
+				destination, _ := operands[i+1].(pdfString)
+				font.toUnicode[codeValue(source)] = utf16BEString(destination)
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				low, _ := operands[i].(pdfString)
+				high, _ := operands[i+1].(pdfString)
+				start, end := codeValue(low), codeValue(high)
+				if end < start || end-start > 0xFFFF {
+					continue // Malformed range
+				}
+				switch destination := operands[i+2].(type) {
+				case pdfString:
+					base := []rune(utf16BEString(destination))
+					for code := start; code <= end && len(base) > 0; code++ {
+						shifted := append([]rune{}, base...)
+						shifted[len(shifted)-1] += rune(code - start) // Ranges increment the last character
+						font.toUnicode[code] = string(shifted)
+					}
+				case pdfArray:
+					for offset, item := range destination {
+						if text, isString := item.(pdfString); isString {
+							font.toUnicode[start+uint32(offset)] = utf16BEString(text)
+						}
+					}
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+// Interprets a hex string from a CMap as a big-endian character code
+func codeValue(code pdfString) uint32 {
+	value := uint32(0)
+	for _, b := range code {
+		value = value<<8 | uint32(b)
+	}
+	return value
+}
+
+// Decodes UTF-16BE text from a CMap destination
+func utf16BEString(data pdfString) string {
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// Tokenizer and parser for PDF object syntax and content streams
+type pdfLexer struct {
+	data []byte // Input being parsed
+	pos  int    // Current offset
+}
+
+// Reports whether b is PDF whitespace
+func isPDFSpace(b byte) bool {
+	return b == ' ' || b == '\n' || b == '\r' || b == '\t' || b == '\f' || b == 0
+}
+
+// Reports whether b ends a name or keyword
+func isPDFDelimiter(b byte) bool {
+	return isPDFSpace(b) || strings.IndexByte("()<>[]{}/%", b) >= 0
+}
+
+// Skips whitespace and comments
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		switch {
+		case isPDFSpace(l.data[l.pos]):
+			l.pos++
+		case l.data[l.pos] == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Parses the next value, resolving "N G R" into references; returns nil at the end of input
+func (l *pdfLexer) parseValue() any {
+	value := l.parseToken()
+	number, isNumber := value.(float64)
+	if !isNumber {
+		return value
+	}
+	saved := l.pos // Look ahead for "G R"
+	generation, isGeneration := l.parseToken().(float64)
+	if isGeneration && generation >= 0 {
+		if keyword, _ := l.parseToken().(pdfKeyword); keyword == "R" {
+			return pdfRef{num: int(number)}
+		}
+	}
+	l.pos = saved
+	return number
+}
+
+// Parses a single token or composite value without reference resolution
+func (l *pdfLexer) parseToken() any {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil
+	}
+	switch c := l.data[l.pos]; {
+	case c == '/':
+		l.pos++
+		start := l.pos
+		for l.pos < len(l.data) && !isPDFDelimiter(l.data[l.pos]) {
+			l.pos++
+		}
+		return pdfName(decodeNameEscapes(l.data[start:l.pos]))
+	case c == '(':
+		return l.parseLiteralString()
+	case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<':
+		l.pos += 2
+		dict := pdfDict{}
+		for {
+			l.skipSpace()
+			if l.pos >= len(l.data) {
+				return dict
+			}
+			if bytes.HasPrefix(l.data[l.pos:], []byte(">>")) {
+				l.pos += 2
+				return dict
+			}
+			key, isName := l.parseToken().(pdfName)
+			if !isName {
+				continue // Skip garbage until the next key
+			}
+			dict[string(key)] = l.parseValue()
+		}
+	case c == '<':
+		end := bytes.IndexByte(l.data[l.pos:], '>')
+		if end < 0 {
+			l.pos = len(l.data)
+			return pdfString(nil)
+		}
+		raw := l.data[l.pos+1 : l.pos+end]
+		l.pos += end + 1
+		return pdfString(decodeHexString(raw))
+	case c == '[':
+		l.pos++
+		var array pdfArray
+		for {
+			l.skipSpace()
+			if l.pos >= len(l.data) {
+				return array
+			}
+			if l.data[l.pos] == ']' {
+				l.pos++
+				return array
+			}
+			array = append(array, l.parseValue())
+		}
+	case c == ']' || c == '>' || c == ')' || c == '{' || c == '}':
+		l.pos++ // Stray delimiter
+		return pdfKeyword(string(c))
+	default:
+		start := l.pos
+		for l.pos < len(l.data) && !isPDFDelimiter(l.data[l.pos]) {
+			l.pos++
+		}
+		word := string(l.data[start:l.pos])
+		if number, err := strconv.ParseFloat(word, 64); err == nil {
+			return number
+		}
+		switch word {
+		case "true":
+			return true
+		case "false":
+			return false
+		case "null":
+			return nil
+		}
+		return pdfKeyword(word)
+	}
+}
+
+// Parses a (literal string) with nested parentheses and escape sequences
+func (l *pdfLexer) parseLiteralString() pdfString {
+	l.pos++ // Skip the opening parenthesis
+	var out []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return out
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return out
+			}
+			escaped := l.data[l.pos]
+			l.pos++
+			switch escaped {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r', '\n': // Line continuation
+				if escaped == '\r' && l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+			default:
+				if escaped >= '0' && escaped <= '7' { // Up to three octal digits
+					value := int(escaped - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						value = value*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					out = append(out, byte(value))
+				} else {
+					out = append(out, escaped)
+				}
+			}
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// Decodes #xx escapes in names
+func decodeNameEscapes(raw []byte) string {
+	if bytes.IndexByte(raw, '#') < 0 {
+		return string(raw)
+	}
+	var out []byte
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '#' && i+3 <= len(raw) { // Two hex digits follow, up to the end of the name
+			if decoded, err := hex.DecodeString(string(raw[i+1 : i+3])); err == nil {
+				out = append(out, decoded[0])
+				i += 2
+				continue
+			}
+		}
+		out = append(out, raw[i])
+	}
+	return string(out)
+}
+
+// Decodes hex digits, ignoring whitespace and padding an odd final digit with zero
+func decodeHexString(raw []byte) []byte {
+	digits := make([]byte, 0, len(raw))
+	for _, b := range raw {
+		if (b >= '0' && b <= '9') || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F') {
+			digits = append(digits, b)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	decoded := make([]byte, len(digits)/2)
+	hex.Decode(decoded, digits)
+	return decoded
+}
@@ -0,0 +1,117 @@
+package main // Download and processing stages connected by channels so parsing never holds up the network
+
+import (
+	"context" // Carries each document's trace span to its requests
+	"fmt"     // Builds validation errors
+	"log"     // Reports where adaptive concurrency ended
+	"runtime" // Sizes the processing stage
+	"sync"    // Waits for the workers of each stage
+	"time"    // Measures each document
+)
+
+var (
+	downloadWorkers = 1                // Documents downloaded at the same time
+	processWorkers  = runtime.NumCPU() // Downloaded documents classified, parsed, stored and recorded at the same time
+)
+
+// One document and language variant queued for the pipeline
+type pipelineJob struct {
+	Index     int         // Position in the download queue, used to report outcomes in queue order
+	Document  pdfDocument // Document to fetch
+	OutputDir string      // Directory the document is stored under
+	Language  string      // Language variant to request
+	Changed   bool        // The archived copy changed upstream and is downloaded again
+	Span      *traceSpan  // Traces the document's download and processing, nil when tracing is disabled
+}
+
+// A job that made it through the download stage
+type processingJob struct {
+	pipelineJob
+	Downloaded downloadedPDF // Verified contents
+	Began      time.Time     // When the download started
+}
+
+// Checks the -download-workers and -process-workers options
+func validateWorkers() error {
+	if downloadWorkers < 1 || processWorkers < 1 {
+		return fmt.Errorf("-download-workers and -process-workers must be at least 1")
+	}
+	return nil
+}
+
+// Runs every job through the download stage and then the processing stage and returns the outcomes in queue order;
+// downloads continue while earlier documents are still being processed, up to one waiting document per processor
+func runPipeline(jobs []pipelineJob, tagLanguage bool, documentManifest *manifest) []documentOutcome {
+	queue := make(chan pipelineJob)                        // Jobs waiting for a downloader
+	processing := make(chan processingJob, processWorkers) // Downloads waiting for a processor
+	outcomes := make([]documentOutcome, len(jobs))         // Indexed by queue position; each job writes only its own slot
+	finish := func(job pipelineJob, outcome documentOutcome, began time.Time) {
+		outcome.URL, outcome.Language, outcome.Duration = job.Document.URL, job.Language, time.Since(began)
+		outcomes[job.Index] = outcome
+		job.Span.set("document.status", outcome.Status)
+		job.Span.set("document.file", outcome.File)
+		if outcome.Status == outcomeFailed {
+			job.Span.fail(fmt.Errorf("%s", outcome.Message))
+		}
+		job.Span.finish()
+		runStats.documentFinished(outcome.Status)
+		documentManifest.noteOutcome(job.Document, job.Language, outcome)
+		emitProgress(progressEvent{Type: progressDocumentFinished, URL: outcome.URL, Language: outcome.Language, Status: outcome.Status, File: outcome.File, Message: outcome.Message})
+	}
+
+	var limiter *adaptiveLimiter // Caps the downloaders below -download-workers; nil lets all of them run
+	if autoConcurrency && downloadWorkers > 1 {
+		limiter = newAdaptiveLimiter(downloadWorkers)
+		activeLimiter.Store(limiter)
+		defer activeLimiter.Store(nil)
+	}
+
+	var downloaders, processors sync.WaitGroup
+	for range downloadWorkers {
+		downloaders.Add(1)
+		go func() {
+			defer downloaders.Done()
+			for job := range queue {
+				limiter.acquire()
+				began := time.Now()
+				runStats.documentStarted()
+				emitProgress(progressEvent{Type: progressDocumentStarted, URL: job.Document.URL, Language: job.Language})
+				job.Span = startSpan(nil, "document", spanKindInternal)
+				job.Span.set("url.full", job.Document.URL)
+				job.Span.set("document.language", job.Language)
+				downloaded, outcome, ok := downloadPDF(withSpan(context.Background(), job.Span), job.Document, job.OutputDir, job.Language, tagLanguage, job.Changed, documentManifest)
+				limiter.release(!ok && outcome.Status == outcomeFailed)
+				if !ok {
+					finish(job, outcome, began) // Skipped or failed; nothing to process
+					continue
+				}
+				processing <- processingJob{pipelineJob: job, Downloaded: downloaded, Began: began}
+			}
+		}()
+	}
+	for range processWorkers {
+		processors.Add(1)
+		go func() {
+			defer processors.Done()
+			for job := range processing {
+				finish(job.pipelineJob, processPDF(job.Document, job.Language, tagLanguage, job.Downloaded, documentManifest), job.Began)
+			}
+		}()
+	}
+
+	runStats.documentsQueued(len(jobs))
+	emitProgress(progressEvent{Type: progressRunStarted, Total: len(jobs)})
+	for index, job := range jobs {
+		job.Index = index
+		queue <- job
+	}
+	close(queue)
+	downloaders.Wait() // Every download has been handed over
+	close(processing)
+	processors.Wait()
+	if limiter != nil {
+		current, lowest := limiter.levels()
+		log.Printf("Download concurrency ended at %d of %d (lowest %d)", current, downloadWorkers, lowest)
+	}
+	return outcomes
+}
@@ -0,0 +1,111 @@
+package main // Live progress as JSON lines on a local socket, for wrapper GUIs and orchestration scripts
+
+import (
+	"encoding/json" // Encodes progress events
+	"log"           // Reports socket problems
+	"net"           // Listens on the Unix socket
+	"os"            // Removes stale socket files
+	"sync"          // Guards the connected clients
+	"time"          // Stamps events and bounds writes
+)
+
+var progressSocketPath = "" // Unix socket live progress is served on; empty disables it
+
+// Progress event types
+const (
+	progressRunStarted       = "run_started"       // Discovery finished; Total documents are queued
+	progressDocumentStarted  = "document_started"  // A document is being downloaded
+	progressDocumentFinished = "document_finished" // A document was stored, skipped or failed
+	progressRunFinished      = "run_finished"      // The run is over
+)
+
+// One progress event, sent as a single JSON line
+type progressEvent struct {
+	Type     string    `json:"type"`               // One of the progress* constants
+	RunID    string    `json:"run_id"`             // Run the event belongs to
+	At       time.Time `json:"at"`                 // When it happened
+	URL      string    `json:"url,omitempty"`      // Document URL
+	Language string    `json:"language,omitempty"` // Language variant of the document
+	Status   string    `json:"status,omitempty"`   // downloaded, skipped or failed, for document_finished
+	File     string    `json:"file,omitempty"`     // Storage key of the document
+	Message  string    `json:"message,omitempty"`  // Why the document was skipped or failed
+	Done     int       `json:"done"`               // Documents finished so far
+	Total    int       `json:"total"`              // Documents queued in the run
+}
+
+// Accepts clients on the progress socket and sends every event to all of them
+type progressHub struct {
+	mu       sync.Mutex            // Protects clients, done and total
+	listener net.Listener          // Socket clients connect to
+	clients  map[net.Conn]struct{} // Connected clients
+	done     int                   // Documents finished in the current run
+	total    int                   // Documents queued in the current run
+}
+
+var progress *progressHub // Open hub, nil when -progress-socket isn't set
+
+// Listens on the Unix socket at path, replacing a stale socket file left by an earlier process
+func startProgressSocket(path string) (*progressHub, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	hub := &progressHub{listener: listener, clients: make(map[net.Conn]struct{})}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return // Closed
+			}
+			hub.mu.Lock()
+			hub.clients[conn] = struct{}{}
+			hub.mu.Unlock()
+		}
+	}()
+	log.Printf("Serving progress on %s", path)
+	return hub, nil
+}
+
+// Stops accepting clients, disconnects the connected ones and removes the socket file
+func (h *progressHub) close() {
+	h.listener.Close()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for conn := range h.clients {
+		conn.Close()
+	}
+	clear(h.clients)
+}
+
+// Sends an event to every client, counting finished documents; clients that can't keep up are disconnected so a
+// stalled GUI never slows the run
+func emitProgress(event progressEvent) {
+	if progress == nil {
+		return
+	}
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	switch event.Type {
+	case progressRunStarted:
+		progress.done, progress.total = 0, event.Total
+	case progressDocumentFinished:
+		progress.done++
+	}
+	event.RunID, event.At, event.Done, event.Total = runID(), time.Now().UTC(), progress.done, progress.total
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	line = append(line, '\n')
+	for conn := range progress.clients {
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write(line); err != nil {
+			conn.Close()
+			delete(progress.clients, conn)
+		}
+	}
+}
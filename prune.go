@@ -0,0 +1,155 @@
+package main // Prune subcommand applying the archive retention policy
+
+import (
+	"bufio"   // Reads the confirmation answer
+	"flag"    // Parses the prune subcommand options
+	"fmt"     // Prints the deletion plan
+	"log"     // Reports deletions
+	"os"      // Reads confirmation from standard input
+	"slices"  // Drops deleted revisions
+	"sort"    // Orders revisions newest first
+	"strings" // Normalizes the confirmation answer
+	"time"    // Computes the unseen cut-off
+)
+
+// A file the retention policy wants to delete, with the reason shown to the user
+type pruneAction struct {
+	File     string // Storage key to delete
+	Reason   string // Why the file is being removed
+	Revision bool   // A kept revision; otherwise the document's current copy, which drops its entry
+}
+
+// The files one manifest entry loses, revisions first so the entry goes last
+type prunePlan struct {
+	Entry   manifestEntry // Entry as it is before pruning
+	Actions []pruneAction // Files to delete
+}
+
+// Runs the prune subcommand with its own command-line options
+func runPrune(args []string) error {
+	flags := flag.NewFlagSet("prune", flag.ExitOnError) // Options specific to prune
+	keepRevisions := flags.Int("keep-revisions", 3, "superseded revisions to keep per document (-1 keeps all)")
+	unseenDays := flags.Int("unseen-days", 0, "delete documents not seen on the site for this many days (0 disables)")
+	assumeYes := flags.Bool("yes", false, "delete without asking for confirmation")
+	dryRun := flags.Bool("dry-run", false, "only print what would be deleted")
+	manifestPath := flags.String("manifest", manifestFilePath, "manifest describing the archive")
+	addStorageFlags(flags) // Prune deletes through the same backend downloads use
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	storage, err := newStorage(storageBackend, storageURL)
+	if err != nil {
+		return err
+	}
+	lock, err := acquireRunLock(lockFilePath) // Held from planning to saving, so no scrape changes the manifest meanwhile
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	documentManifest := loadManifest(*manifestPath)
+	if err := documentManifest.openJournal(*manifestPath); err != nil { // Files deleted before a crash stay out of the manifest
+		return err
+	}
+	defer documentManifest.closeJournal(*manifestPath)
+	now := time.Now().UTC()
+	var plans []prunePlan // Every entry losing files
+	files := 0
+	for _, entry := range documentManifest.list() {
+		plan := prunePlan{Entry: entry}
+		if *unseenDays > 0 && now.Sub(entry.lastSeen()) > time.Duration(*unseenDays)*24*time.Hour {
+			reason := fmt.Sprintf("not seen on the site since %s", entry.lastSeen().Format("2006-01-02"))
+			for _, revision := range entry.Revisions {
+				plan.Actions = append(plan.Actions, pruneAction{File: revision.File, Reason: reason, Revision: true})
+			}
+			plan.Actions = append(plan.Actions, pruneAction{File: entry.File, Reason: reason})
+		} else if *keepRevisions >= 0 && len(entry.Revisions) > *keepRevisions {
+			revisions := slices.Clone(entry.Revisions)
+			sort.Slice(revisions, func(i, j int) bool { // Newest first so the oldest are dropped
+				return revisions[i].DownloadedAt.After(revisions[j].DownloadedAt)
+			})
+			for _, revision := range revisions[*keepRevisions:] {
+				plan.Actions = append(plan.Actions, pruneAction{File: revision.File, Reason: fmt.Sprintf("revision from %s exceeds -keep-revisions %d", revision.DownloadedAt.Format("2006-01-02"), *keepRevisions), Revision: true})
+			}
+		}
+		if len(plan.Actions) > 0 {
+			plans = append(plans, plan)
+			files += len(plan.Actions)
+		}
+	}
+
+	if len(plans) == 0 {
+		log.Println("Nothing to prune")
+		return nil
+	}
+	for _, plan := range plans { // Show the plan before touching anything
+		for _, action := range plan.Actions {
+			fmt.Printf("delete %s (%s)\n", action.File, action.Reason)
+		}
+	}
+	if *dryRun {
+		fmt.Printf("Dry run: %d files would be deleted\n", files)
+		return nil
+	}
+	if !*assumeYes && !confirm(fmt.Sprintf("Delete %d files?", files)) {
+		return fmt.Errorf("prune cancelled")
+	}
+
+	for _, plan := range plans {
+		if err := prunePlanFiles(storage, plan, documentManifest); err != nil { // The manifest follows every file deleted
+			documentManifest.save(*manifestPath) // Keep the entries of the files deleted so far out of it
+			return err
+		}
+	}
+	documentManifest.save(*manifestPath)
+	return nil
+}
+
+// Deletes the files of one plan with their companion sidecars, then drops the deleted revisions from the entry, or
+// the entry itself once its current copy is gone; a failure still records the files deleted before it
+func prunePlanFiles(storage Storage, plan prunePlan, documentManifest *manifest) error {
+	entry, removed := plan.Entry, false
+	var failure error
+	for _, action := range plan.Actions {
+		deleted := false                                                                                                                                                // The file itself is gone, whatever happens to its sidecars
+		for index, key := range []string{action.File, action.File + ".sha256", action.File + metadataSidecarSuffix, action.File + metadataSidecarSuffix + gzipSuffix} { // Remove companion sidecars too
+			if err := storage.Delete(key); err != nil {
+				failure = fmt.Errorf("failed to delete %s: %w", key, err)
+				break
+			}
+			deleted = deleted || index == 0
+		}
+		if deleted {
+			log.Printf("Deleted %s", action.File)
+			if action.Revision {
+				entry.Revisions = slices.DeleteFunc(slices.Clone(entry.Revisions), func(revision manifestRevision) bool { return revision.File == action.File })
+			} else {
+				removed = true
+			}
+		}
+		if failure != nil {
+			break
+		}
+	}
+	switch {
+	case removed:
+		documentManifest.remove(entry)
+	case len(entry.Revisions) != len(plan.Entry.Revisions):
+		documentManifest.record(entry)
+	}
+	return failure
+}
+
+// Asks a yes/no question on standard input and reports whether the answer was yes; scripts without a terminal
+// get "no" and must pass -yes
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n') // EOF counts as "no"
+	if err != nil && strings.TrimSpace(answer) == "" {
+		fmt.Println()
+		log.Println("No answer on standard input; pass -yes to go ahead without one")
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,175 @@
+package main // Archive size quota so an unattended daemon can't fill the volume it writes to
+
+import (
+	"errors"  // Marks quota refusals
+	"fmt"     // Builds errors and messages
+	"log"     // Reports warnings and pruning
+	"slices"  // Orders revisions oldest first
+	"strconv" // Parses sizes
+	"strings" // Parses size units
+	"sync"    // Guards the running total
+	"time"    // Orders revisions
+)
+
+var (
+	maxArchiveSize int64 = 0      // Largest archive size in bytes; 0 disables the quota
+	quotaAction          = "stop" // What happens when a download would exceed the quota: stop, prune or warn
+)
+
+var errQuotaExceeded = errors.New("archive size quota reached") // Reported for documents that weren't stored
+
+// Parses the -max-archive-size option: bytes, or a number with a KB, MB, GB or TB (powers of 1000) or KiB, MiB, GiB
+// or TiB suffix
+func setMaxArchiveSize(value string) error {
+	size, ok := parseByteSize(value)
+	if !ok {
+		return fmt.Errorf("-max-archive-size must be a size such as 500MB or 20GiB")
+	}
+	maxArchiveSize = size
+	return nil
+}
+
+// Parses a size such as 500MB, 20GiB or a plain byte count
+func parseByteSize(value string) (int64, bool) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		bytes  int64
+	}{{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40}, {"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12}, {"B", 1}} {
+		if strings.HasSuffix(value, unit.suffix) {
+			value, multiplier = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix)), unit.bytes
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, false
+	}
+	return int64(number * float64(multiplier)), true
+}
+
+// Checks the -quota-action option
+func validateQuotaAction() error {
+	quotaAction = strings.ToLower(strings.TrimSpace(quotaAction))
+	if quotaAction != "stop" && quotaAction != "prune" && quotaAction != "warn" {
+		return fmt.Errorf("unknown -quota-action %q (expected stop, prune or warn)", quotaAction)
+	}
+	return nil
+}
+
+// Running total of the archive's size, checked before every document is stored
+type archiveQuota struct {
+	mu      sync.Mutex // Protects used and stopped
+	used    int64      // Bytes held by current documents and their revisions
+	stopped bool       // The quota was reached with -quota-action stop; remaining downloads are skipped
+}
+
+var archiveUsage = &archiveQuota{} // Shared by every download in the run
+
+// Sums the sizes recorded in the manifest at the start of a run
+func (q *archiveQuota) reset(documentManifest *manifest) {
+	var used int64
+	for _, entry := range documentManifest.list() {
+		used += entry.Size
+		for _, revision := range entry.Revisions {
+			used += revision.Size
+		}
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.used, q.stopped = used, false
+	if maxArchiveSize > 0 {
+		log.Printf("Archive holds %s of the %s quota", formatBytes(used), formatBytes(maxArchiveSize))
+	}
+}
+
+// Reports whether downloads should be skipped because the quota was reached
+func (q *archiveQuota) exhausted() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.stopped
+}
+
+// Accounts for storing size bytes under file, replacing replaced bytes, and returns errQuotaExceeded when that would
+// exceed the quota and -quota-action doesn't allow it; prune frees space by deleting the oldest superseded revisions
+func (q *archiveQuota) reserve(documentManifest *manifest, file string, size int64, replaced int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	projected := q.used - replaced + size
+	if maxArchiveSize <= 0 || projected <= maxArchiveSize {
+		q.used = projected
+		return nil
+	}
+	switch quotaAction {
+	case "warn":
+		log.Printf("Warning: storing %s brings the archive to %s, over the %s quota", file, formatBytes(projected), formatBytes(maxArchiveSize))
+		q.used = projected
+		return nil
+	case "prune":
+		freed := pruneOldestRevisions(documentManifest, projected-maxArchiveSize)
+		q.used -= freed
+		if projected -= freed; projected <= maxArchiveSize {
+			q.used = projected
+			return nil
+		}
+	}
+	q.stopped = true
+	log.Printf("Storing %s would bring the archive to %s, over the %s quota; skipping the remaining downloads", file, formatBytes(projected), formatBytes(maxArchiveSize))
+	return errQuotaExceeded
+}
+
+// Gives back bytes reserved for a document that couldn't be stored after all
+func (q *archiveQuota) release(size int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.used -= size
+}
+
+// Deletes superseded revisions, oldest first, until needed bytes are freed or none are left, and returns the bytes
+// freed; current documents are never deleted
+func pruneOldestRevisions(documentManifest *manifest, needed int64) int64 {
+	type candidate struct {
+		Entry    manifestEntry    // Document the revision belongs to
+		Revision manifestRevision // Superseded copy
+	}
+	var candidates []candidate
+	for _, entry := range documentManifest.list() {
+		for _, revision := range entry.Revisions {
+			candidates = append(candidates, candidate{Entry: entry, Revision: revision})
+		}
+	}
+	slices.SortStableFunc(candidates, func(a candidate, b candidate) int {
+		return a.Revision.DownloadedAt.Compare(b.Revision.DownloadedAt)
+	})
+
+	var freed int64
+	deleted := make(map[string]bool) // Files of the revisions deleted
+	for _, candidate := range candidates {
+		if freed >= needed {
+			break
+		}
+		if err := archiveStorage.Delete(candidate.Revision.File); err != nil {
+			log.Printf("Failed to prune %s: %v", candidate.Revision.File, err)
+			continue
+		}
+		log.Printf("Pruned %s (revision from %s) to stay within the archive quota", candidate.Revision.File, candidate.Revision.DownloadedAt.Format(time.DateOnly))
+		deleted[candidate.Revision.File] = true
+		freed += candidate.Revision.Size
+	}
+	for _, candidate := range candidates {
+		if !deleted[candidate.Revision.File] {
+			continue
+		}
+		entry, found := documentManifest.lookup(candidate.Entry.URL, candidate.Entry.Language)
+		if !found {
+			continue
+		}
+		entry.Revisions = slices.DeleteFunc(slices.Clone(entry.Revisions), func(revision manifestRevision) bool { return deleted[revision.File] })
+		if entry.Revisions == nil {
+			entry.Revisions = []manifestRevision{} // nil would make record keep the old list
+		}
+		documentManifest.record(entry)
+	}
+	return freed
+}
@@ -0,0 +1,50 @@
+package main // Read-through fetching of archived documents that are missing locally when a client asks for them
+
+import (
+	"bytes"   // Serves restored documents from memory
+	"context" // Bounds the upstream fetch by the client's request
+	"errors"  // Declares the changed-upstream error
+	"fmt"     // Describes failed restores
+	"io"      // Returns the document as a reader
+	"log"     // Reports restored documents
+	"os"      // Opens the local copy
+	"sync"    // Serializes restores of the same document
+)
+
+var (
+	readThrough      = true   // Whether documents in the manifest but missing locally are fetched again when requested
+	readThroughLocks sync.Map // Per-file mutexes, so concurrent requests for a missing document fetch it once
+
+	errUpstreamChanged = errors.New("upstream document changed") // The site now serves a different revision than the manifest records
+)
+
+// Opens the archived copy of a manifest entry; when the manifest references a file that is gone, it is fetched from
+// its URL, checked against the recorded digest and stored again first
+func openArchivedDocument(ctx context.Context, entry manifestEntry) (io.ReadCloser, error) {
+	file, err := os.Open(entry.File)
+	if err == nil {
+		return file, nil
+	}
+	if !os.IsNotExist(err) || !readThrough {
+		return nil, err
+	}
+	lock, _ := readThroughLocks.LoadOrStore(entry.File, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+	if file, err := os.Open(entry.File); err == nil { // Another request restored it while this one waited
+		return file, nil
+	}
+	fetched, _, err := fetchPDF(ctx, httpClient(), entry.URL, entry.Language, nil)
+	if err != nil {
+		return nil, fmt.Errorf("restoring %s from %s: %w", entry.File, entry.URL, err)
+	}
+	if digest := sha256Hex(fetched.Data); entry.SHA256 != "" && digest != entry.SHA256 {
+		return nil, fmt.Errorf("%w: %s no longer matches the archived copy of %s (sha256 %s, archived %s); run a scrape to archive the new revision", errUpstreamChanged, entry.URL, entry.File, digest, entry.SHA256)
+	}
+	if err := archiveStorage.Put(entry.File, fetched.Data); err != nil {
+		log.Printf("Failed to store restored %s: %v", entry.File, err) // Still serve what was fetched
+	} else {
+		log.Printf("Restored missing %s from %s", entry.File, entry.URL)
+	}
+	return io.NopCloser(bytes.NewReader(fetched.Data)), nil
+}
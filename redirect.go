@@ -0,0 +1,63 @@
+package main // Redirect policy for the shared HTTP client
+
+import (
+	"errors"   // Reports rejected redirects
+	"fmt"      // Describes rejected redirects
+	"net/http" // Redirect hook and request chain
+	"strings"  // Parses the host allowlist
+)
+
+var (
+	maxRedirects          = 10      // Redirect hops allowed per request
+	crossDomainRedirects  = "allow" // "allow" follows redirects to other domains, "deny" rejects them
+	redirectAllowedHosts  = ""      // Comma-separated domains that may be redirected to even when cross-domain redirects are denied
+	errRedirectNotAllowed = errors.New("redirect not allowed")
+)
+
+// Enforces the hop limit and the cross-domain policy; installed as the client's CheckRedirect
+func checkRedirect(request *http.Request, via []*http.Request) error {
+	if len(via) > maxRedirects {
+		return fmt.Errorf("%w: more than %d redirects starting at %s", errRedirectNotAllowed, maxRedirects, via[0].URL)
+	}
+	if !downloadHostAllowed(request.URL.Hostname()) { // The allowlist holds wherever a link leads
+		return fmt.Errorf("%w: %s redirected to %s, whose host isn't in -download-hosts", errRedirectNotAllowed, via[0].URL, request.URL)
+	}
+	origin := normalizeDomain(via[0].URL.Hostname())
+	target := normalizeDomain(request.URL.Hostname())
+	if origin == target || crossDomainRedirects != "deny" {
+		return nil
+	}
+	for _, allowed := range strings.Split(redirectAllowedHosts, ",") {
+		if normalizeDomain(strings.TrimSpace(allowed)) == target {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s redirected to another domain (%s)", errRedirectNotAllowed, via[0].URL, request.URL)
+}
+
+// Validates the -cross-domain-redirects value
+func validateRedirectPolicy() error {
+	if crossDomainRedirects != "allow" && crossDomainRedirects != "deny" {
+		return fmt.Errorf("invalid -cross-domain-redirects %q (expected allow or deny)", crossDomainRedirects)
+	}
+	if maxRedirects < 0 {
+		return fmt.Errorf("-max-redirects must not be negative")
+	}
+	return nil
+}
+
+// Returns every URL a response was redirected through, ending with the final URL; nil when there were no redirects
+func redirectChain(response *http.Response) []string {
+	var chain []string
+	for request := response.Request; request != nil; {
+		chain = append([]string{request.URL.String()}, chain...)
+		if request.Response == nil {
+			break // Reached the original request
+		}
+		request = request.Response.Request
+	}
+	if len(chain) < 2 {
+		return nil
+	}
+	return chain
+}
@@ -0,0 +1,82 @@
+package main // Report subcommands summarizing the archive
+
+import (
+	"flag"    // Parses the report options
+	"fmt"     // Prints the report
+	"sort"    // Orders report rows
+	"strings" // Lists the available reports
+	"time"    // Computes document ages
+)
+
+// Reports available under the report subcommand
+var reports = map[string]func(args []string) error{
+	"expiring":  runExpiringReport,  // Sheets whose revision date is older than a threshold
+	"inventory": runInventoryReport, // On-site products with and without a downloaded sheet
+	"sizes":     runSizesReport,     // Size histogram and the largest documents
+	"transport": runTransportReport, // UN numbers, shipping names, classes and packing groups as CSV
+	"lifecycle": runLifecycleReport, // Every known document with its lifecycle state, archived or not
+}
+
+// Dispatches "report <name>" to the matching report
+func runReport(args []string) error {
+	names := make([]string, 0, len(reports)) // Listed in usage errors
+	for name := range reports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(args) == 0 {
+		return fmt.Errorf("usage: report <%s> [options]", strings.Join(names, "|"))
+	}
+	report, found := reports[args[0]]
+	if !found {
+		return fmt.Errorf("unknown report %q (expected one of %s)", args[0], strings.Join(names, ", "))
+	}
+	return report(args[1:])
+}
+
+// Lists sheets whose SDS revision date is older than the configured age, plus sheets with no known date
+func runExpiringReport(args []string) error {
+	flags := flag.NewFlagSet("report expiring", flag.ExitOnError) // Options specific to this report
+	maxAgeYears := flags.Float64("max-age-years", 3, "flag sheets whose revision date is older than this many years")
+	manifestPath := flags.String("manifest", manifestFilePath, "manifest describing the archive")
+	failOnExpired := flags.Bool("fail-on-expired", false, "exit with an error when any sheet is expired, for use in CI")
+	include := addStateFilter(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cutoff := time.Now().UTC().Add(-time.Duration(*maxAgeYears * 365.25 * 24 * float64(time.Hour))) // Sheets revised before this are expired
+	var expired, unknown []manifestEntry
+	for _, entry := range loadManifest(*manifestPath).list() {
+		if !include(entry) {
+			continue
+		}
+		entry = withSDSMetadata(entry) // Entries downloaded before revision dates were extracted
+		revision := entry.SDS.revisionTime()
+		switch {
+		case revision.IsZero():
+			unknown = append(unknown, entry)
+		case revision.Before(cutoff):
+			expired = append(expired, entry)
+		}
+	}
+	sort.SliceStable(expired, func(i, j int) bool { // Oldest sheets first
+		return expired[i].SDS.RevisionDate < expired[j].SDS.RevisionDate
+	})
+
+	fmt.Printf("Sheets revised before %s (older than %g years): %d\n", cutoff.Format("2006-01-02"), *maxAgeYears, len(expired))
+	for _, entry := range expired {
+		age := time.Since(entry.SDS.revisionTime()).Hours() / 24 / 365.25
+		fmt.Printf("  %s  %4.1fy  %-12s  %s\n", entry.SDS.RevisionDate, age, entry.SDS.RevisionSource, entry.File)
+	}
+	if len(unknown) > 0 {
+		fmt.Printf("Sheets with no revision date: %d\n", len(unknown))
+		for _, entry := range unknown {
+			fmt.Printf("  %s\n", entry.File)
+		}
+	}
+	if *failOnExpired && len(expired) > 0 {
+		return fmt.Errorf("%d sheets are older than %g years", len(expired), *maxAgeYears)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main // Restore subcommand rebuilding the archive as it was on an earlier date from the kept revisions
+
+import (
+	"flag"          // Parses the restore options
+	"fmt"           // Reports the restored set
+	"log"           // Reports restored files
+	"os"            // Writes the restored files
+	"path/filepath" // Places files below the target directory
+	"time"          // Parses the as-of date
+)
+
+// Runs the restore subcommand: writes every document as it was archived at the as-of time into a directory,
+// together with a manifest of the restored copies
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError) // Options specific to restore
+	asOf := flags.String("as-of", "", "date or time to restore to, e.g. 2024-06-01 (the end of that day, UTC) or 2024-06-01T12:00:00Z")
+	targetDir := flags.String("o", "", "directory the documents are restored into; must be new or empty")
+	manifestPath := flags.String("manifest", manifestFilePath, "manifest describing the archive")
+	assumeYes := flags.Bool("yes", false, "restore without asking for confirmation")
+	dryRun := flags.Bool("dry-run", false, "only print what would be restored")
+	addStorageFlags(flags) // Revisions are read from the backend downloads are written to
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	cutoff, err := parseAsOf(*asOf)
+	if err != nil {
+		return err
+	}
+	if *targetDir == "" {
+		return fmt.Errorf("-o is required")
+	}
+	if existing, err := os.ReadDir(*targetDir); err == nil && len(existing) > 0 {
+		return fmt.Errorf("%s isn't empty; restore into a new directory so the archive can't be overwritten", *targetDir)
+	}
+	var entries []manifestEntry     // Documents with a copy that old
+	var versions []manifestRevision // The copy of each to restore
+	var total int64                 // Bytes to restore
+	later := 0
+	for _, entry := range loadManifest(*manifestPath).list() {
+		version, found := revisionAsOf(entry, cutoff)
+		if !found {
+			later++ // Archived after the date, or its older copies were pruned
+			continue
+		}
+		if !filepath.IsLocal(filepath.FromSlash(entry.File)) { // A tampered manifest mustn't write outside -o
+			return fmt.Errorf("refusing to restore %q: the manifest names a file outside the archive", entry.File)
+		}
+		entries, versions = append(entries, entry), append(versions, version)
+		total += version.Size
+	}
+	if len(entries) == 0 {
+		fmt.Printf("No document has a copy as old as %s; %d were archived later\n", cutoff.Format(time.RFC3339), later)
+		return nil
+	}
+	for index, entry := range entries { // Show the plan before writing anything
+		fmt.Printf("restore %s (downloaded %s)\n", entry.File, versions[index].DownloadedAt.Format(time.RFC3339))
+	}
+	fmt.Printf("%d documents (%s) as of %s into %s; %d have no copy that old\n", len(entries), formatBytes(total), cutoff.Format(time.RFC3339), *targetDir, later)
+	if *dryRun {
+		fmt.Printf("Dry run: %d documents would be restored\n", len(entries))
+		return nil
+	}
+	if !*assumeYes && !confirm(fmt.Sprintf("Restore %d documents into %s?", len(entries), *targetDir)) {
+		return fmt.Errorf("restore cancelled")
+	}
+	if err := os.MkdirAll(*targetDir, 0o755); err != nil {
+		return err
+	}
+	storage, err := newStorage(storageBackend, storageURL)
+	if err != nil {
+		return err
+	}
+
+	var restored []manifestEntry
+	for index, entry := range entries {
+		version := versions[index]
+		data, err := storage.Get(version.File)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", version.File, err)
+		}
+		if digest := sha256Hex(data); digest != version.SHA256 {
+			return fmt.Errorf("%s doesn't match its recorded sha256 %s (got %s)", version.File, version.SHA256, digest)
+		}
+		filePath := filepath.Join(*targetDir, filepath.FromSlash(entry.File)) // Under the document's name, not the revision's
+		if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filePath, data); err != nil {
+			return err
+		}
+		if version.File != entry.File || !version.DownloadedAt.Equal(entry.DownloadedAt) { // Fields read from the current copy don't describe an older one
+			entry.SDS, entry.LastModified, entry.ETag = sdsMetadata{}, time.Time{}, ""
+		}
+		entry.Size, entry.SHA256, entry.DownloadedAt, entry.Revisions = version.Size, version.SHA256, version.DownloadedAt, nil
+		restored = append(restored, entry)
+		log.Printf("Restored %s", entry.File)
+	}
+	data, err := encodeManifest(restored)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(*targetDir, "manifest.json"), data); err != nil {
+		return err
+	}
+	fmt.Printf("Restored %d documents as of %s into %s; %d have no copy that old\n", len(restored), cutoff.Format(time.RFC3339), *targetDir, later)
+	return nil
+}
+
+// Parses the -as-of option: a date means the end of that day in UTC
+func parseAsOf(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("-as-of is required")
+	}
+	if day, err := time.Parse(time.DateOnly, value); err == nil {
+		return day.Add(24*time.Hour - time.Nanosecond), nil
+	}
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("-as-of must be a date such as 2024-06-01 or a time such as 2024-06-01T12:00:00Z")
+	}
+	return at.UTC(), nil
+}
+
+// Returns the copy of a document that was current at a time: the newest one, the current copy or a kept revision,
+// downloaded by then
+func revisionAsOf(entry manifestEntry, at time.Time) (manifestRevision, bool) {
+	best := manifestRevision{}
+	found := false
+	candidates := append([]manifestRevision{{File: entry.File, Size: entry.Size, SHA256: entry.SHA256, DownloadedAt: entry.DownloadedAt}}, entry.Revisions...)
+	for _, candidate := range candidates {
+		if !candidate.DownloadedAt.After(at) && (!found || candidate.DownloadedAt.After(best.DownloadedAt)) {
+			best, found = candidate, true
+		}
+	}
+	return best, found
+}
@@ -0,0 +1,35 @@
+package main // Resuming interrupted downloads without splicing different versions of a file
+
+import (
+	"fmt"      // Builds Range headers and errors
+	"net/http" // Inspects and sets range headers
+	"strings"  // Inspects Accept-Ranges values
+)
+
+// Reports whether a body cut off after its response headers can be completed with a ranged request: the server
+// accepts byte ranges, the bytes are the file itself rather than an encoding of it, and a validator pins the version
+func canResume(header http.Header) bool {
+	return strings.Contains(strings.ToLower(header.Get("Accept-Ranges")), "bytes") &&
+		header.Get("Content-Encoding") == "" &&
+		rangeValidator(header) != ""
+}
+
+// Asks for the rest of the file after the received bytes, but only if it is still the version they came from; a
+// changed file makes the server send it whole instead
+func setResumeHeaders(request *http.Request, partial fetchedPDF) {
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-", len(partial.Data)))
+	request.Header.Set("If-Range", rangeValidator(partial.Header))
+}
+
+// Checks that a 206 response continues exactly where the partial data stops and returns the file's full size
+func resumedSize(response *http.Response, offset int64) (int64, error) {
+	var start, end, size int64
+	contentRange := response.Header.Get("Content-Range")
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &size); err != nil {
+		return 0, fmt.Errorf("unusable Content-Range %q: %w", contentRange, err)
+	}
+	if start != offset || end != size-1 {
+		return 0, fmt.Errorf("server resumed with range %q but %d bytes were already received", contentRange, offset)
+	}
+	return size, nil
+}
@@ -0,0 +1,209 @@
+package main // Rolling re-validation of the archive against its source URLs while the daemon runs
+
+import (
+	"crypto/sha256" // Hashes bodies the server sends in full
+	"encoding/hex"  // Encodes digests as hex
+	"encoding/json" // Reads and writes the sweep state
+	"fmt"           // Describes changed documents
+	"io"            // Streams bodies into the hasher
+	"log"           // Reports each slice
+	"maps"          // Merges a slice's results into the state
+	"net/http"      // Sends conditional GET requests
+	"os"            // Reads the sweep state
+	"slices"        // Orders documents by when they were last checked
+	"sync"          // Serializes the sweep and runs on the state file
+	"time"          // Schedules the slices
+)
+
+var (
+	revalidateCycle     time.Duration         // Time in which the daemon re-validates the whole archive; 0 disables the sweep
+	revalidateStatePath = "revalidation.json" // When each document was last re-validated, and which ones changed upstream
+	revalidateInterval  = time.Hour           // How often the sweep checks its next slice of the archive
+	revalidateMu        sync.Mutex            // Serializes the sweep and runs reading and writing the state
+)
+
+// Progress of the rolling re-validation, kept across daemon restarts
+type revalidationState struct {
+	Checked map[string]time.Time `json:"checked"`           // When each document, by manifest key, was last re-validated
+	Changed map[string]string    `json:"changed,omitempty"` // Documents found changed, with why, until a run downloads them again
+}
+
+// Reads the sweep state; a missing or unreadable file starts the sweep over
+func loadRevalidationState() revalidationState {
+	state := revalidationState{Checked: make(map[string]time.Time), Changed: make(map[string]string)}
+	data, err := os.ReadFile(revalidateStatePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println(err)
+		}
+		return state
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Printf("Ignoring %s: %v", revalidateStatePath, err)
+	}
+	if state.Checked == nil {
+		state.Checked = make(map[string]time.Time)
+	}
+	if state.Changed == nil {
+		state.Changed = make(map[string]string)
+	}
+	return state
+}
+
+// Writes the sweep state
+func (state revalidationState) save() {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := writeFileAtomic(revalidateStatePath, data); err != nil {
+		log.Printf("Failed to save the re-validation state %s: %v", revalidateStatePath, err)
+	}
+}
+
+// Re-validates a slice of the archive every interval, sized so every document is checked once per cycle; slices are
+// skipped while busy reports a run in progress
+func runRevalidationSweep(busy func() bool) {
+	ticker := time.NewTicker(revalidateInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if busy() {
+			continue // The run checks or downloads the documents itself
+		}
+		revalidateSlice()
+	}
+}
+
+// Sends conditional GETs for the documents checked longest ago, as many as the cycle calls for per interval
+func revalidateSlice() {
+	entries := loadManifest(manifestFilePath).list()
+	if len(entries) == 0 {
+		return
+	}
+	revalidateMu.Lock()
+	state := loadRevalidationState()
+	revalidateMu.Unlock()
+	slices.SortStableFunc(entries, func(a manifestEntry, b manifestEntry) int { // Never-checked documents come first
+		return state.Checked[a.key()].Compare(state.Checked[b.key()])
+	})
+	size := int((int64(len(entries))*int64(revalidateInterval) + int64(revalidateCycle) - 1) / int64(revalidateCycle))
+	checked := make(map[string]time.Time)
+	changed := make(map[string]string)
+	client := httpClient()
+	for _, entry := range entries[:min(size, len(entries))] {
+		reason, err := revalidateDocument(client, entry)
+		if err != nil {
+			log.Printf("Failed to re-validate %s: %v", entry.URL, err)
+			continue // Checked again in the next slice
+		}
+		checked[entry.key()] = time.Now().UTC()
+		if reason != "" {
+			log.Printf("%s changed upstream (%s); the next run downloads it again", entry.URL, reason)
+			changed[entry.key()] = reason
+		}
+	}
+
+	revalidateMu.Lock() // A run may have cleared findings meanwhile
+	defer revalidateMu.Unlock()
+	state = loadRevalidationState()
+	maps.Copy(state.Checked, checked)
+	maps.Copy(state.Changed, changed)
+	archived := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		archived[entry.key()] = true
+	}
+	maps.DeleteFunc(state.Checked, func(key string, _ time.Time) bool { // Forget documents no longer in the archive
+		return !archived[key]
+	})
+	maps.DeleteFunc(state.Changed, func(key string, _ string) bool {
+		return !archived[key]
+	})
+	state.save()
+	log.Printf("Re-validated %d of %d archived documents, %d changed upstream; the archive is covered every %s", len(checked), len(entries), len(changed), revalidateCycle)
+}
+
+// Asks the server for a document unless it's unchanged, using its ETag and Last-Modified time; a body the server
+// sends anyway is hashed and compared with the archived copy. Returns why it's considered changed, or ""
+func revalidateDocument(client *http.Client, entry manifestEntry) (string, error) {
+	request, err := http.NewRequest(http.MethodGet, entry.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	setAcceptLanguage(request, entry.Language)
+	if entry.ETag != "" {
+		request.Header.Set("If-None-Match", entry.ETag)
+	}
+	if !entry.LastModified.IsZero() {
+		request.Header.Set("If-Modified-Since", entry.LastModified.UTC().Format(http.TimeFormat))
+	}
+	host := getDomainFromURL(entry.URL)
+	if err := hostBreakers.allow(host); err != nil {
+		return "", err
+	}
+	request, cancel := withFileDeadline(request)
+	defer cancel()
+	requestThrottle.wait()
+	response, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	recordHostResponse(host, response.StatusCode)
+	switch response.StatusCode {
+	case http.StatusNotModified:
+		return "", nil
+	case http.StatusOK:
+	default:
+		return "", fmt.Errorf("server returned %s", response.Status)
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, response.Body); err != nil {
+		return "", err
+	}
+	if digest := hex.EncodeToString(hasher.Sum(nil)); digest != entry.SHA256 {
+		return fmt.Sprintf("sha256 changed from %s to %s", entry.SHA256, digest), nil
+	}
+	return "", nil // The server ignored the conditions but sent the same bytes
+}
+
+// Marks the jobs the sweep found changed upstream for re-download and moves them to the front of the queue
+func applyRevalidationFindings(jobs []pipelineJob) []pipelineJob {
+	revalidateMu.Lock()
+	state := loadRevalidationState()
+	revalidateMu.Unlock()
+	if len(state.Changed) == 0 {
+		return jobs
+	}
+	flagged := 0
+	for i, job := range jobs {
+		if _, found := state.Changed[manifestEntry{URL: job.Document.URL, Language: job.Language}.key()]; found {
+			jobs[i].Changed = true
+			flagged++
+		}
+	}
+	moveChangedFirst(jobs)
+	log.Printf("Re-downloading %d documents the re-validation sweep found changed upstream", flagged)
+	return jobs
+}
+
+// Clears the findings for documents a run downloaded again, or found unchanged after all
+func clearRevalidationFindings(outcomes []documentOutcome) {
+	revalidateMu.Lock()
+	defer revalidateMu.Unlock()
+	state := loadRevalidationState()
+	if len(state.Changed) == 0 {
+		return
+	}
+	cleared := 0
+	for _, outcome := range outcomes {
+		key := manifestEntry{URL: outcome.URL, Language: outcome.Language}.key()
+		if _, found := state.Changed[key]; found && (outcome.Status == outcomeDownloaded || outcome.Reason == skipExists || outcome.Reason == skipDuplicateContent) {
+			delete(state.Changed, key)
+			cleared++
+		}
+	}
+	if cleared > 0 {
+		state.save()
+	}
+}
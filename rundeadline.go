@@ -0,0 +1,103 @@
+package main // Time budget for a whole run, with the unfinished queue kept for the next run
+
+import (
+	"encoding/json" // Reads and writes the remaining queue
+	"fmt"           // Describes the deadline
+	"log"           // Reports the resumed queue
+	"os"            // Reads and removes the remaining queue
+	"slices"        // Moves resumed jobs to the front
+	"time"          // Measures the budget
+)
+
+var (
+	runDeadline        time.Duration            // Time after the start of a run when no further downloads begin; 0 disables the budget
+	remainingQueuePath = "remaining-queue.json" // Documents a run left unfetched when its budget ran out, fetched first by the next run
+	runDeadlineAt      time.Time                // When the current run's budget runs out, zero without one
+)
+
+// Documents a run didn't get to before its deadline, in the order they were queued
+type remainingQueue struct {
+	RunID     string               `json:"run_id"`    // Run that ran out of time
+	Deadline  time.Time            `json:"deadline"`  // When its budget ran out
+	Documents []remainingQueueItem `json:"documents"` // What it didn't start
+}
+
+// A document and language variant left in the queue
+type remainingQueueItem struct {
+	URL      string `json:"url"`                // Document URL
+	Language string `json:"language,omitempty"` // Language variant, if one was requested
+}
+
+// Reports whether the run's budget is used up, so no further downloads should start
+func runDeadlinePassed(now time.Time) bool {
+	return !runDeadlineAt.IsZero() && !now.Before(runDeadlineAt)
+}
+
+// Explains a skip caused by the run deadline
+func runDeadlineMessage() string {
+	return fmt.Sprintf("run deadline of %s reached at %s", runDeadline, runDeadlineAt.Format(time.RFC3339))
+}
+
+// Moves the documents an earlier run left in its queue to the front, in the order they had, so they are fetched
+// before anything else; documents no longer discovered are dropped
+func resumeRemainingQueue(jobs []pipelineJob) []pipelineJob {
+	data, err := os.ReadFile(remainingQueuePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println(err)
+		}
+		return jobs
+	}
+	var queue remainingQueue
+	if err := json.Unmarshal(data, &queue); err != nil {
+		log.Printf("Ignoring %s: %v", remainingQueuePath, err)
+		return jobs
+	}
+	position := make(map[string]int, len(queue.Documents))
+	for i, item := range queue.Documents {
+		position[item.URL+"\x00"+item.Language] = i
+	}
+	rank := func(job pipelineJob) int {
+		if i, found := position[job.Document.URL+"\x00"+job.Language]; found {
+			return i
+		}
+		return len(position) // Everything else keeps its order behind the resumed documents
+	}
+	slices.SortStableFunc(jobs, func(a pipelineJob, b pipelineJob) int {
+		return rank(a) - rank(b)
+	})
+	resumed := 0
+	for _, job := range jobs {
+		if rank(job) < len(position) {
+			resumed++
+		}
+	}
+	log.Printf("Resuming %d of the %d documents run %s left unfetched", resumed, len(queue.Documents), queue.RunID)
+	return jobs
+}
+
+// Writes the documents skipped for the deadline to the remaining queue, or removes the queue once a run got
+// through all of them; returns how many were left
+func saveRemainingQueue(outcomes []documentOutcome) int {
+	queue := remainingQueue{RunID: runID(), Deadline: runDeadlineAt}
+	for _, outcome := range outcomes {
+		if outcome.Reason == skipDeadline {
+			queue.Documents = append(queue.Documents, remainingQueueItem{URL: outcome.URL, Language: outcome.Language})
+		}
+	}
+	if len(queue.Documents) == 0 {
+		if err := os.Remove(remainingQueuePath); err != nil && !os.IsNotExist(err) {
+			log.Println(err)
+		}
+		return 0
+	}
+	data, err := json.MarshalIndent(queue, "", "  ")
+	if err != nil {
+		log.Println(err)
+		return len(queue.Documents)
+	}
+	if err := writeFileAtomic(remainingQueuePath, data); err != nil {
+		log.Printf("Failed to save the remaining queue %s: %v", remainingQueuePath, err)
+	}
+	return len(queue.Documents)
+}
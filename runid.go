@@ -0,0 +1,39 @@
+package main // Run IDs correlating the logs, manifest entries and reports of one run
+
+import (
+	"crypto/rand" // Random bits for the UUID
+	"fmt"         // Formats the UUID
+	"log"         // Prefixes every log line with the run ID
+	"sync"        // Guards the current run ID
+)
+
+var (
+	runIDMu      sync.Mutex // Protects currentRunID
+	currentRunID string     // UUID of the run in progress
+)
+
+// Returns a random (version 4) UUID
+func newRunID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	id[6] = id[6]&0x0f | 0x40 // Version 4
+	id[8] = id[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
+
+// Makes id the current run ID and adds it to every log line
+func beginRun(id string) {
+	runIDMu.Lock()
+	defer runIDMu.Unlock()
+	currentRunID = id
+	log.SetPrefix("run=" + id + " ")
+}
+
+// Returns the ID of the run in progress
+func runID() string {
+	runIDMu.Lock()
+	defer runIDMu.Unlock()
+	return currentRunID
+}
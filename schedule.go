@@ -0,0 +1,121 @@
+package main // Scrape windows and blackout dates that keep the daemon off the network during business hours
+
+import (
+	"fmt"     // Builds errors
+	"strings" // Splits the option lists
+	"time"    // Reads clock times and dates
+)
+
+// A daily period in local time during which scraping is allowed; end before start wraps past midnight
+type scrapeWindow struct {
+	start time.Duration // Offset from midnight the window opens at
+	end   time.Duration // Offset from midnight the window closes at
+}
+
+var (
+	scrapeWindows []scrapeWindow          // Allowed periods; empty allows any time
+	blackoutDates = make(map[string]bool) // Local dates as YYYY-MM-DD, or MM-DD for every year, when no scraping happens
+)
+
+// Parses the -scrape-window option: comma-separated HH:MM-HH:MM periods in local time, e.g. 01:00-05:00,22:30-23:30
+func setScrapeWindows(value string) error {
+	scrapeWindows = nil
+	for _, period := range strings.Split(value, ",") {
+		period = strings.TrimSpace(period)
+		if period == "" {
+			continue
+		}
+		from, to, found := strings.Cut(period, "-")
+		start, startErr := time.Parse("15:04", strings.TrimSpace(from))
+		end, endErr := time.Parse("15:04", strings.TrimSpace(to))
+		if !found || startErr != nil || endErr != nil || start.Equal(end) {
+			return fmt.Errorf("-scrape-window %q must be HH:MM-HH:MM with different start and end times", period)
+		}
+		scrapeWindows = append(scrapeWindows, scrapeWindow{start: sinceMidnight(start), end: sinceMidnight(end)})
+	}
+	return nil
+}
+
+// Parses the -blackout-dates option: comma-separated YYYY-MM-DD dates, or MM-DD for dates that recur every year
+func setBlackoutDates(value string) error {
+	for _, date := range strings.Split(value, ",") {
+		date = strings.TrimSpace(date)
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			if _, err := time.Parse("01-02", date); err != nil {
+				return fmt.Errorf("-blackout-dates %q must be YYYY-MM-DD or MM-DD", date)
+			}
+		}
+		blackoutDates[date] = true
+	}
+	return nil
+}
+
+// Returns the time of day as an offset from midnight
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+// Reports whether the local date of t is a blackout date
+func blackedOut(t time.Time) bool {
+	return blackoutDates[t.Format("2006-01-02")] || blackoutDates[t.Format("01-02")]
+}
+
+// Reports whether scraping is allowed at t
+func scrapeAllowed(t time.Time) bool {
+	t = t.Local()
+	if blackedOut(t) {
+		return false
+	}
+	if len(scrapeWindows) == 0 {
+		return true
+	}
+	now := sinceMidnight(t)
+	for _, window := range scrapeWindows {
+		if window.start < window.end && now >= window.start && now < window.end {
+			return true
+		}
+		if window.start > window.end && (now >= window.start || now < window.end) { // Wraps past midnight
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the next time at or after t when scraping is allowed, or the zero time when blackout dates rule out the
+// next year
+func nextScrapeTime(t time.Time) time.Time {
+	t = t.Local()
+	if scrapeAllowed(t) {
+		return t
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	for day := 0; day <= 366; day++ {
+		date := midnight.AddDate(0, 0, day)
+		candidates := []time.Time{date} // Windows wrapping past midnight are open at the start of the day
+		for _, window := range scrapeWindows {
+			candidates = append(candidates, date.Add(window.start))
+		}
+		var earliest time.Time
+		for _, candidate := range candidates {
+			if candidate.After(t) && scrapeAllowed(candidate) && (earliest.IsZero() || candidate.Before(earliest)) {
+				earliest = candidate
+			}
+		}
+		if !earliest.IsZero() {
+			return earliest
+		}
+	}
+	return time.Time{}
+}
+
+// Describes why scraping isn't allowed at t and when it next is
+func scrapeWindowMessage(t time.Time) string {
+	next := nextScrapeTime(t)
+	if next.IsZero() {
+		return "outside the allowed scrape window; blackout dates leave no window in the next year"
+	}
+	return "outside the allowed scrape window; next opens " + next.Format("2006-01-02 15:04 MST")
+}
@@ -0,0 +1,223 @@
+package main // Versioned formats of the JSON files other tools build on: the manifest, the run report and the audit log
+
+import (
+	"bufio"         // Reads JSON-lines logs
+	"bytes"         // Tells a bare manifest array from a versioned one
+	"embed"         // Bundles the JSON Schema documents
+	"encoding/json" // Decodes and re-encodes the files
+	"errors"        // Declares the newer-schema error
+	"flag"          // Parses the migrate options
+	"fmt"           // Describes versions and migrations
+	"os"            // Reads the files
+	"sort"          // Lists schema names in errors
+	"strings"       // Builds usage errors
+)
+
+// Schema versions written by this build; readers reject anything newer. Version 1 of each format is what earlier
+// builds wrote: a bare array of entries for the manifest, and lines without schema_version for the logs
+const (
+	manifestSchemaVersion  = 2 // manifest.json: an object with schema_version and entries
+	runReportSchemaVersion = 2 // Lines of the bandwidth log, one report per run
+	auditSchemaVersion     = 2 // Lines of the audit log, one record per request
+)
+
+var errNewerSchema = errors.New("written by a newer version of the scraper") // The file's schema_version is above the one this build writes
+
+//go:embed schemas/*.json
+var schemaFiles embed.FS // JSON Schema documents describing the current version of each format
+
+// JSON Schema document of each format, by the name the schema subcommand takes
+var schemaDocuments = map[string]string{
+	"manifest":   "schemas/manifest.v2.schema.json",
+	"run-report": "schemas/run-report.v2.schema.json",
+	"audit":      "schemas/audit-record.v2.schema.json",
+}
+
+// The manifest file as written since schema version 2
+type manifestDocument struct {
+	SchemaVersion int             `json:"schema_version"` // manifestSchemaVersion
+	Entries       []manifestEntry `json:"entries"`        // Entries sorted by key
+}
+
+// Decodes a manifest file of any supported version, returning its entries and the version it was written with
+func decodeManifest(data []byte) ([]manifestEntry, int, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var entries []manifestEntry // Version 1
+		err := json.Unmarshal(trimmed, &entries)
+		return entries, 1, err
+	}
+	var document manifestDocument
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, 0, err
+	}
+	if document.SchemaVersion > manifestSchemaVersion {
+		return nil, document.SchemaVersion, fmt.Errorf("%w: schema version %d, this build reads up to %d", errNewerSchema, document.SchemaVersion, manifestSchemaVersion)
+	}
+	return document.Entries, document.SchemaVersion, nil
+}
+
+// Prints the JSON Schema of a format
+func runSchema(args []string) error {
+	names := make([]string, 0, len(schemaDocuments))
+	for name := range schemaDocuments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(args) != 1 {
+		return fmt.Errorf("usage: schema <%s>", strings.Join(names, "|"))
+	}
+	documentPath, found := schemaDocuments[args[0]]
+	if !found {
+		return fmt.Errorf("unknown format %q (expected one of %s)", args[0], strings.Join(names, ", "))
+	}
+	data, err := schemaFiles.ReadFile(documentPath)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// Upgrades the manifest, bandwidth log and audit log to the schema versions this build writes
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError) // Options specific to migrate
+	manifestPath := flags.String("manifest", manifestFilePath, "manifest to upgrade")
+	bandwidthPath := flags.String("bandwidth-log", bandwidthLogPath, "bandwidth log to upgrade (empty skips it)")
+	auditPath := flags.String("audit-log", auditLogPath, "audit log to upgrade (empty skips it)")
+	dryRun := flags.Bool("dry-run", false, "report what would be upgraded without writing anything")
+	assumeYes := flags.Bool("yes", false, "upgrade without asking for confirmation")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	lock, err := acquireRunLock(lockFilePath) // Runs append to the logs and rewrite the manifest
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	outdated, err := migrateFiles(*manifestPath, *bandwidthPath, *auditPath, true) // Show the plan before writing anything
+	if err != nil || outdated == 0 {
+		return err
+	}
+	if *dryRun {
+		fmt.Printf("Dry run: %d files would be upgraded\n", outdated)
+		return nil
+	}
+	if !*assumeYes && !confirm(fmt.Sprintf("Upgrade %d files?", outdated)) {
+		return fmt.Errorf("migrate cancelled")
+	}
+	if _, err := migrateFiles(*manifestPath, *bandwidthPath, *auditPath, false); err != nil {
+		return err
+	}
+	fmt.Printf("Upgraded %d files\n", outdated)
+	return nil
+}
+
+// Upgrades the manifest and logs, or with dryRun prints what would be upgraded; returns how many files are behind
+func migrateFiles(manifestPath string, bandwidthPath string, auditPath string, dryRun bool) (int, error) {
+	outdated := 0
+	if data, err := os.ReadFile(manifestPath); err == nil {
+		entries, version, err := decodeManifest(data)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", manifestPath, err)
+		}
+		switch {
+		case version == manifestSchemaVersion && dryRun:
+			fmt.Printf("%s is already at schema version %d\n", manifestPath, version)
+		case version == manifestSchemaVersion:
+		case dryRun:
+			fmt.Printf("%s: schema version %d → %d, %d entries\n", manifestPath, version, manifestSchemaVersion, len(entries))
+			outdated++
+		default:
+			loadManifest(manifestPath).save(manifestPath) // Saving writes the current version, and records the change in the integrity log
+			outdated++
+		}
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+	upgraded, err := migrateJSONLines(bandwidthPath, runReportSchemaVersion, dryRun, func(line []byte) ([]byte, error) {
+		var report bandwidthReport
+		if err := json.Unmarshal(line, &report); err != nil {
+			return nil, err
+		}
+		report.SchemaVersion = runReportSchemaVersion
+		return json.Marshal(report)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if upgraded {
+		outdated++
+	}
+	upgraded, err = migrateJSONLines(auditPath, auditSchemaVersion, dryRun, func(line []byte) ([]byte, error) {
+		var record auditRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, err
+		}
+		record.SchemaVersion = auditSchemaVersion
+		return json.Marshal(record)
+	})
+	if upgraded {
+		outdated++
+	}
+	return outdated, err
+}
+
+// Rewrites the lines of a JSON-lines log that are below the current schema version with upgrade, leaving current
+// lines untouched, or with dryRun prints how many would be; reports whether any were. Fails on lines written by a
+// newer version
+func migrateJSONLines(filePath string, current int, dryRun bool, upgrade func(line []byte) ([]byte, error)) (bool, error) {
+	if filePath == "" {
+		return false, nil
+	}
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	var output bytes.Buffer
+	upgraded, total := 0, 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		total++
+		var versioned struct {
+			SchemaVersion int `json:"schema_version"`
+		}
+		if err := json.Unmarshal(line, &versioned); err != nil {
+			return false, fmt.Errorf("%s line %d: %w", filePath, total, err)
+		}
+		if versioned.SchemaVersion > current {
+			return false, fmt.Errorf("%s line %d: %w: schema version %d, this build reads up to %d", filePath, total, errNewerSchema, versioned.SchemaVersion, current)
+		}
+		if versioned.SchemaVersion < current {
+			if line, err = upgrade(line); err != nil {
+				return false, fmt.Errorf("%s line %d: %w", filePath, total, err)
+			}
+			upgraded++
+		}
+		output.Write(line)
+		output.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	if upgraded == 0 {
+		if dryRun {
+			fmt.Printf("%s is already at schema version %d\n", filePath, current)
+		}
+		return false, nil
+	}
+	if dryRun {
+		fmt.Printf("%s: %d of %d lines would be upgraded to schema version %d\n", filePath, upgraded, total, current)
+		return true, nil
+	}
+	return true, writeFileAtomic(filePath, output.Bytes())
+}
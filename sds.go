@@ -0,0 +1,208 @@
+package main // Metadata extracted from the contents of Safety Data Sheets
+
+import (
+	"bytes"         // Searches raw PDF data for streams
+	"compress/zlib" // Inflates compressed PDF streams when searching for metadata
+	"io"            // Bounds inflated stream sizes
+	"os"            // Reads local copies when backfilling metadata
+	"path"          // Reads dates embedded in file names
+	"regexp"        // Finds labelled dates in text and metadata
+	"strings"       // Normalizes month names
+	"time"          // Parses and formats dates
+)
+
+// Bumped whenever extractSDSMetadata learns new fields so older manifest entries get re-read
+const sdsMetadataVersion = 8
+
+// Fields read from an SDS that aren't available from the download itself
+type sdsMetadata struct {
+	Version         int            `json:"version,omitempty"`          // sdsMetadataVersion the fields were extracted with
+	RevisionDate    string         `json:"revision_date,omitempty"`    // Revision or issue date as YYYY-MM-DD
+	RevisionSource  string         `json:"revision_source,omitempty"`  // Where the date came from: text, filename or pdf-metadata
+	CASNumbers      []string       `json:"cas_numbers,omitempty"`      // CAS registry numbers listed in the composition section
+	Pictograms      []string       `json:"pictograms,omitempty"`       // GHS pictogram codes such as GHS05
+	PictogramSource string         `json:"pictogram_source,omitempty"` // Where the pictograms came from: code, label, embedded or hazard-statements
+	Companies       []string       `json:"companies,omitempty"`        // Distributor, manufacturer and supplier names from Section 1, in order
+	Product         string         `json:"product,omitempty"`          // Product name printed in Section 1
+	Language        string         `json:"language,omitempty"`         // Language the text is written in, such as en or es
+	Transport       *transportInfo `json:"transport,omitempty"`        // Section 14 shipping classification
+}
+
+// Returns the brand a sheet is filed under: the first company named in Section 1
+func (m sdsMetadata) brand() string {
+	if len(m.Companies) == 0 {
+		return ""
+	}
+	return m.Companies[0]
+}
+
+// Returns the revision date as a time, or the zero time when unknown
+func (m sdsMetadata) revisionTime() time.Time {
+	parsed, err := time.Parse("2006-01-02", m.RevisionDate)
+	if err != nil {
+		return time.Time{}
+	}
+	return parsed
+}
+
+// Extracts the plain text of a PDF with pages separated by form feeds
+func extractPDFText(data []byte) (string, error) {
+	pages, err := extractPDFPages(data) // Decode every page's content streams
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(pages, "\f"), nil // Keep page boundaries visible to callers
+}
+
+// Labels SDS authors put in front of the revision date, most specific first
+var revisionLabelRegex = regexp.MustCompile(`(?i)(revision date|date of revision|revised on|revised|issue date|date issued|date of issue|issued|effective date|date prepared|preparation date|` +
+	`fecha de revisi[oó]n|fecha de emisi[oó]n|date de r[ée]vision|date de mise [àa] jour|date d'[ée]mission|r[ée]vis[ée] le|` +
+	`[üu]berarbeitet am|[üu]berarbeitungsdatum|bearbeitungsdatum|ausgabedatum|data di revisione|data di emissione|` +
+	`data de revis[ãa]o|data de emiss[ãa]o|herzieningsdatum|datum van herziening|改訂日|作成日|修订日期)\s*[:：.\-]?\s*`)
+
+// Dates in file names and PDF metadata
+var (
+	fileDateRegex = regexp.MustCompile(`(?:^|_)(\d{2})_(\d{2})_(\d{4})(?:_|$)`)                  // ps_product_sds_05_26_2015
+	pdfDateRegex  = regexp.MustCompile(`/(?:ModDate|CreationDate)\s*\(D:(\d{4})(\d{2})(\d{2})`)  // /ModDate (D:20150526...)
+	xmpDateRegex  = regexp.MustCompile(`<xmp:(?:ModifyDate|CreateDate)>(\d{4})-(\d{2})-(\d{2})`) // XMP packet dates
+	streamRegex   = regexp.MustCompile(`(?s)stream\r?\n`)                                        // Start of a PDF stream
+)
+
+// Reads SDS metadata from the PDF text, falling back to the file name and the PDF's own metadata for the date;
+// requested is the Accept-Language tag the sheet was downloaded with, if any
+func extractSDSMetadata(fileName string, data []byte, requested string) sdsMetadata {
+	metadata := sdsMetadata{Version: sdsMetadataVersion}
+	text, _ := extractPDFText(data) // Unreadable PDFs still get the fallbacks below
+	metadata.CASNumbers = findCASNumbers(text)
+	metadata.Pictograms, metadata.PictogramSource = findPictograms(text, data)
+	metadata.Companies = findCompanies(text)
+	metadata.Product = findProductName(text)
+	metadata.Language = detectTextLanguage(text)
+	metadata.Transport = findTransportInfo(text)
+	metadata.RevisionDate, metadata.RevisionSource = findSheetDate(fileName, text, data, dateLocale(metadata.Language, requested))
+	return metadata
+}
+
+// Returns the locale a sheet's dates are read in: the language its text is written in, with the region of the
+// requested language when they agree, e.g. en-GB
+func dateLocale(detected string, requested string) string {
+	language, _, _ := strings.Cut(strings.ToLower(requested), "-")
+	if detected == "" || detected == language {
+		return requested
+	}
+	return detected
+}
+
+// Returns the sheet's revision date as YYYY-MM-DD and where it was found
+func findSheetDate(fileName string, text string, data []byte, locale string) (string, string) {
+	if date, found := findRevisionDate(text, locale); found {
+		return date.Format("2006-01-02"), "text"
+	}
+	base := strings.TrimSuffix(path.Base(fileName), path.Ext(fileName)) // Older sheets carry the date in the file name
+	if match := fileDateRegex.FindStringSubmatch(base); match != nil {
+		if date, ok := buildDate(match[3], match[1], match[2]); ok {
+			return date.Format("2006-01-02"), "filename"
+		}
+	}
+	if date, found := findPDFMetadataDate(data); found {
+		return date.Format("2006-01-02"), "pdf-metadata"
+	}
+	return "", ""
+}
+
+// Fills in SDS metadata from the local copy when the entry predates the current extractor
+func withSDSMetadata(entry manifestEntry) manifestEntry {
+	if entry.SDS.Version >= sdsMetadataVersion {
+		return entry // Already up to date
+	}
+	if data, err := os.ReadFile(entry.File); err == nil {
+		entry.SDS = extractSDSMetadata(entry.File, data, entry.Language)
+		entry.Group = translationGroup(entry) // The product name the group is keyed on may have changed
+	}
+	return entry
+}
+
+var casNumberRegex = regexp.MustCompile(`\b(\d{2,7})\s*-\s*(\d{2})\s*-\s*(\d)\b`) // CAS registry number such as 7778-54-3, possibly split across text runs
+
+// Returns the distinct CAS numbers in the text whose check digit is valid, in order of appearance
+func findCASNumbers(text string) []string {
+	var numbers []string
+	seen := make(map[string]bool)
+	for _, match := range casNumberRegex.FindAllStringSubmatch(text, -1) {
+		cas := match[1] + "-" + match[2] + "-" + match[3] // Drop whitespace left between text runs
+		if seen[cas] || !validCASNumber(cas) {
+			continue // Phone numbers and dates often look like CAS numbers but fail the checksum
+		}
+		seen[cas] = true
+		numbers = append(numbers, cas)
+	}
+	return numbers
+}
+
+// Verifies the check digit of a CAS registry number
+func validCASNumber(cas string) bool {
+	match := casNumberRegex.FindStringSubmatch(cas)
+	if match == nil || match[0] != cas {
+		return false
+	}
+	digits := match[1] + match[2] // Every digit except the check digit
+	sum := 0
+	for i := 0; i < len(digits); i++ {
+		sum += int(digits[len(digits)-1-i]-'0') * (i + 1) // Weighted from the right starting at 1
+	}
+	return sum%10 == int(match[3][0]-'0')
+}
+
+// Finds the first date that follows a revision or issue label in the text, read in the sheet's locale
+func findRevisionDate(text string, locale string) (time.Time, bool) {
+	for _, location := range revisionLabelRegex.FindAllStringIndex(text, -1) {
+		if date, found := parseLeadingDate(text[location[1]:], locale); found {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Builds a date from year, month and day strings, rejecting impossible dates
+func buildDate(year string, month string, day string) (time.Time, bool) {
+	if len(year) == 2 {
+		year = "20" + year // Two-digit years on SDS documents are always this century
+	}
+	date, err := time.Parse("2006-1-2", year+"-"+strings.TrimLeft(month, "0")+"-"+strings.TrimLeft(day, "0"))
+	if err != nil || date.Year() < 1980 || date.After(time.Now().AddDate(1, 0, 0)) {
+		return time.Time{}, false // Not a plausible SDS date
+	}
+	return date, true
+}
+
+// Returns the raw PDF followed by every stream that inflates, for searching metadata the text doesn't show
+func pdfSearchSources(data []byte) [][]byte {
+	sources := [][]byte{data} // Search the raw file first
+	for _, location := range streamRegex.FindAllIndex(data, -1) {
+		end := bytes.Index(data[location[1]:], []byte("endstream"))
+		if end < 0 {
+			continue
+		}
+		inflater, err := zlib.NewReader(bytes.NewReader(data[location[1] : location[1]+end]))
+		if err != nil {
+			continue // Not a FlateDecode stream
+		}
+		inflated, _ := io.ReadAll(io.LimitReader(inflater, 4<<20)) // Bound memory for huge streams
+		sources = append(sources, inflated)
+	}
+	return sources
+}
+
+// Looks for ModDate/CreationDate in the PDF info dictionary or XMP packet, including compressed streams
+func findPDFMetadataDate(data []byte) (time.Time, bool) {
+	for _, source := range pdfSearchSources(data) {
+		for _, pattern := range []*regexp.Regexp{pdfDateRegex, xmpDateRegex} {
+			if match := pattern.FindSubmatch(source); match != nil {
+				if date, ok := buildDate(string(match[1]), string(match[2]), string(match[3])); ok {
+					return date, true
+				}
+			}
+		}
+	}
+	return time.Time{}, false
+}
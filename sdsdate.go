@@ -0,0 +1,133 @@
+package main // Localized parsing of the revision dates printed on Safety Data Sheets
+
+import (
+	"fmt"     // Reports invalid options
+	"regexp"  // Matches the date layouts
+	"strconv" // Parses numeric date parts
+	"strings" // Normalizes month names and locale tags
+	"time"    // Builds the parsed date
+)
+
+var dateOrder = "auto" // How all-numeric dates such as 05/06/2015 are read: auto (from the sheet's language), mdy or dmy
+
+// Validates the -date-order value
+func validateDateOrder() error {
+	if dateOrder != "auto" && dateOrder != "mdy" && dateOrder != "dmy" {
+		return fmt.Errorf("invalid -date-order %q (expected auto, mdy or dmy)", dateOrder)
+	}
+	return nil
+}
+
+// Date layouts found after revision labels, tried in order
+var (
+	yearFirstDateRegex = regexp.MustCompile(`^(\d{4})[/.\-](\d{1,2})[/.\-](\d{1,2})\b`)                                                                   // 2015-05-26, 2015/5/26
+	cjkDateRegex       = regexp.MustCompile(`^(\d{4})\s*年\s*(\d{1,2})\s*月\s*(\d{1,2})\s*日`)                                                               // 2015年5月26日
+	numericDateRegex   = regexp.MustCompile(`^(\d{1,2})([/.\-])(\d{1,2})[/.\-](\d{4}|\d{2})\b`)                                                           // 05/26/2015, 26.05.2015
+	monthFirstRegex    = regexp.MustCompile(`(?i)^(\p{L}{3,})\.?\s+(\d{1,2})(?:st|nd|rd|th)?,?\s+(\d{4})\b`)                                              // May 26, 2015
+	dayFirstRegex      = regexp.MustCompile(`(?i)^(\d{1,2})(?:st|nd|rd|th|er|º|\.)?(?:\s+de)?[\s\-/.]+(\p{L}{3,})\.?(?:\s+de)?[\s\-/.,]+(\d{4}|\d{2})\b`) // 26 May 2015, 26. Mai 2015, 26 de mayo de 2015, 26-MAY-15
+)
+
+// Month names in the languages sheets are published in, by month; abbreviations match when they begin exactly one
+// month's names
+var monthNames = [12][]string{
+	{"january", "enero", "janvier", "januar", "jänner", "gennaio", "janeiro", "januari"},
+	{"february", "febrero", "février", "fevrier", "februar", "febbraio", "fevereiro", "februari"},
+	{"march", "marzo", "mars", "märz", "maerz", "marz", "março", "marco", "maart", "mrt"},
+	{"april", "abril", "avril", "aprile"},
+	{"may", "mayo", "mai", "maggio", "maio", "mei"},
+	{"june", "junio", "juin", "juni", "giugno", "junho"},
+	{"july", "julio", "juillet", "juli", "luglio", "julho"},
+	{"august", "agosto", "août", "aout", "augustus"},
+	{"september", "septiembre", "setiembre", "septembre", "settembre", "setembro"},
+	{"october", "octubre", "octobre", "oktober", "ottobre", "outubro"},
+	{"november", "noviembre", "novembre", "novembro"},
+	{"december", "diciembre", "décembre", "decembre", "dezember", "dicembre", "dezembro"},
+}
+
+// Parses a date at the very start of s in any of the supported layouts; locale is the sheet's language, which
+// decides whether an all-numeric date puts the month or the day first
+func parseLeadingDate(s string, locale string) (time.Time, bool) {
+	if match := yearFirstDateRegex.FindStringSubmatch(s); match != nil {
+		return buildDate(match[1], match[2], match[3])
+	}
+	if match := cjkDateRegex.FindStringSubmatch(s); match != nil {
+		return buildDate(match[1], match[2], match[3])
+	}
+	if match := numericDateRegex.FindStringSubmatch(s); match != nil {
+		first, separator, second, year := match[1], match[2], match[3], match[4]
+		if dayFirstNumeric(first, second, separator, locale) {
+			return buildDate(year, second, first)
+		}
+		return buildDate(year, first, second)
+	}
+	if match := monthFirstRegex.FindStringSubmatch(s); match != nil {
+		if month, found := monthNumber(match[1]); found {
+			return buildDate(match[3], month, match[2])
+		}
+	}
+	if match := dayFirstRegex.FindStringSubmatch(s); match != nil {
+		if month, found := monthNumber(match[2]); found {
+			return buildDate(match[3], month, match[1])
+		}
+	}
+	return time.Time{}, false
+}
+
+// Reports whether an all-numeric date puts the day first: when a part can only be a day, when -date-order says so,
+// when dots separate the parts as in 26.05.2015, and otherwise unless the locale writes month/day/year as US
+// English does
+func dayFirstNumeric(first string, second string, separator string, locale string) bool {
+	firstNumber, _ := strconv.Atoi(first)
+	secondNumber, _ := strconv.Atoi(second)
+	switch {
+	case firstNumber > 12 && secondNumber <= 12:
+		return true
+	case secondNumber > 12 && firstNumber <= 12:
+		return false
+	case dateOrder != "auto":
+		return dateOrder == "dmy"
+	case separator == ".":
+		return true
+	}
+	return !monthFirstLocale(locale)
+}
+
+// Reports whether a locale writes numeric dates month first: English without a region, or with a region that does,
+// e.g. en-US; an unknown locale is taken as US English, which most sheets are written in
+func monthFirstLocale(locale string) bool {
+	language, region, _ := strings.Cut(strings.ToLower(strings.ReplaceAll(locale, "_", "-")), "-")
+	if language == "" {
+		return true
+	}
+	if language != "en" {
+		return false
+	}
+	switch region {
+	case "", "us", "ph", "ca", "fm", "mh", "pw", "as", "gu", "mp", "pr", "vi", "um":
+		return true
+	}
+	return false
+}
+
+// Converts a month name or abbreviation in any of the known languages to its number
+func monthNumber(name string) (string, bool) {
+	name = strings.ToLower(name)
+	found := 0
+	for index, names := range monthNames {
+		for _, candidate := range names {
+			if candidate == name {
+				return strconv.Itoa(index + 1), true
+			}
+			if len([]rune(name)) >= 3 && strings.HasPrefix(candidate, name) {
+				if found != 0 && found != index+1 {
+					return "", false // The abbreviation begins names of two months
+				}
+				found = index + 1
+			}
+		}
+	}
+	if found == 0 {
+		return "", false
+	}
+	return strconv.Itoa(found), true
+}
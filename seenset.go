@@ -0,0 +1,218 @@
+package main // Seen-URL sets for de-duplicating discovered links, including a compact one for multi-million-URL crawls
+
+import (
+	"bufio"           // Buffers the persisted set
+	"encoding/binary" // Encodes the persisted set
+	"errors"          // Recognizes a missing set file
+	"fmt"             // Builds validation errors
+	"hash/fnv"        // Fingerprints URLs
+	"io"              // Reads the persisted set
+	"log"             // Reports persistence problems
+	"math"            // Sizes the Bloom filter
+	"os"              // Reads and writes the persisted set
+	"slices"          // Keeps fingerprints sorted
+)
+
+var (
+	seenSetKind     = "map"      // How discovered URLs are de-duplicated: map (exact strings) or compact (Bloom filter and fingerprints)
+	seenSetCapacity = 10_000_000 // URLs the compact set is sized for
+	seenSetFile     = ""         // Where the compact set is kept between runs, so interrupted crawls resume; empty keeps it in memory
+)
+
+const (
+	seenFalsePositiveRate = 0.01      // Bloom filter false positives, each costing one binary search
+	seenOverflowLimit     = 1 << 16   // Fingerprints collected before they are merged into the sorted array
+	seenSetMagic          = "PSSEEN1" // First bytes of a persisted compact set
+)
+
+// A set of URLs that reports whether an added URL is new
+type urlSet interface {
+	add(rawURL string) bool // Adds the URL, returning false when it was already present
+}
+
+// Exact set of URL strings; simple and fast, but every URL stays in memory
+type mapURLSet map[string]bool
+
+// Adds the URL, returning false when it was already present
+func (s mapURLSet) add(rawURL string) bool {
+	if s[rawURL] {
+		return false
+	}
+	s[rawURL] = true
+	return true
+}
+
+// Compact set holding 64-bit fingerprints instead of URLs: a Bloom filter answers for most new URLs, and its
+// positives are confirmed against a sorted fingerprint array plus an exact overflow of recent additions
+type compactURLSet struct {
+	bits     []uint64            // Bloom filter bits
+	hashes   int                 // Bloom filter probes per URL
+	sorted   []uint64            // Merged fingerprints in ascending order
+	overflow map[uint64]struct{} // Fingerprints added since the last merge
+}
+
+// Checks the -seen-set options
+func validateSeenSet() error {
+	if seenSetKind != "map" && seenSetKind != "compact" {
+		return fmt.Errorf("unknown -seen-set %q (expected map or compact)", seenSetKind)
+	}
+	if seenSetKind == "compact" && seenSetCapacity <= 0 {
+		return fmt.Errorf("-seen-capacity must be positive")
+	}
+	if seenSetFile != "" && seenSetKind != "compact" {
+		return fmt.Errorf("-seen-file requires -seen-set compact")
+	}
+	return nil
+}
+
+// Returns the configured set, loading a persisted compact set when there is one
+func newURLSet() urlSet {
+	if seenSetKind != "compact" {
+		return mapURLSet{}
+	}
+	if seenSetFile != "" {
+		set, err := loadCompactURLSet(seenSetFile)
+		if err == nil {
+			log.Printf("Resuming with %d seen URLs from %s", len(set.sorted), seenSetFile)
+			return set
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Ignoring seen-URL set %s: %v", seenSetFile, err)
+		}
+	}
+	return newCompactURLSet(seenSetCapacity, seenFalsePositiveRate)
+}
+
+// Sizes a Bloom filter for capacity URLs at the given false positive rate
+func newCompactURLSet(capacity int, falsePositiveRate float64) *compactURLSet {
+	bitCount := math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	hashes := max(1, int(math.Round(bitCount/float64(capacity)*math.Ln2)))
+	return &compactURLSet{bits: make([]uint64, int(bitCount)/64+1), hashes: hashes, overflow: make(map[uint64]struct{})}
+}
+
+// Returns the 64-bit fingerprint of a URL
+func urlFingerprint(rawURL string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(rawURL))
+	return hash.Sum64()
+}
+
+// Returns the Bloom filter bit positions of a fingerprint, derived by double hashing
+func (s *compactURLSet) positions(fingerprint uint64) []uint64 {
+	size := uint64(len(s.bits) * 64)
+	first, second := fingerprint, (fingerprint>>33|fingerprint<<31)*0x9e3779b97f4a7c15|1
+	positions := make([]uint64, s.hashes)
+	for i := range positions {
+		positions[i] = (first + uint64(i)*second) % size
+	}
+	return positions
+}
+
+// Adds the URL, returning false when it was already present
+func (s *compactURLSet) add(rawURL string) bool {
+	fingerprint := urlFingerprint(rawURL)
+	positions := s.positions(fingerprint)
+	maybe := true
+	for _, position := range positions {
+		if s.bits[position/64]&(1<<(position%64)) == 0 {
+			maybe = false
+			break
+		}
+	}
+	if maybe && s.contains(fingerprint) {
+		return false
+	}
+	for _, position := range positions {
+		s.bits[position/64] |= 1 << (position % 64)
+	}
+	s.overflow[fingerprint] = struct{}{}
+	if len(s.overflow) >= seenOverflowLimit {
+		s.merge()
+	}
+	return true
+}
+
+// Reports whether the fingerprint was added before
+func (s *compactURLSet) contains(fingerprint uint64) bool {
+	if _, found := s.overflow[fingerprint]; found {
+		return true
+	}
+	_, found := slices.BinarySearch(s.sorted, fingerprint)
+	return found
+}
+
+// Moves the overflow into the sorted array
+func (s *compactURLSet) merge() {
+	for fingerprint := range s.overflow {
+		s.sorted = append(s.sorted, fingerprint)
+	}
+	slices.Sort(s.sorted)
+	clear(s.overflow)
+}
+
+// Writes the set to disk so a later run continues the crawl's de-duplication
+func (s *compactURLSet) save(filePath string) error {
+	s.merge()
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	writer.WriteString(seenSetMagic)
+	for _, value := range []any{uint32(s.hashes), uint64(len(s.bits)), s.bits, uint64(len(s.sorted)), s.sorted} {
+		binary.Write(writer, binary.LittleEndian, value)
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Reads a set written by save
+func loadCompactURLSet(filePath string) (*compactURLSet, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	magic := make([]byte, len(seenSetMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != seenSetMagic {
+		return nil, fmt.Errorf("not a seen-URL set")
+	}
+	var hashes uint32
+	var bitWords, fingerprints uint64
+	if err := binary.Read(reader, binary.LittleEndian, &hashes); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &bitWords); err != nil {
+		return nil, err
+	}
+	set := &compactURLSet{bits: make([]uint64, bitWords), hashes: int(hashes), overflow: make(map[uint64]struct{})}
+	if err := binary.Read(reader, binary.LittleEndian, set.bits); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &fingerprints); err != nil {
+		return nil, err
+	}
+	set.sorted = make([]uint64, fingerprints)
+	if err := binary.Read(reader, binary.LittleEndian, set.sorted); err != nil {
+		return nil, err
+	}
+	if set.hashes < 1 || len(set.bits) == 0 {
+		return nil, fmt.Errorf("corrupt seen-URL set")
+	}
+	return set, nil
+}
+
+// Persists a compact set when -seen-file is given
+func saveURLSet(set urlSet) {
+	compact, ok := set.(*compactURLSet)
+	if !ok || seenSetFile == "" {
+		return
+	}
+	if err := compact.save(seenSetFile); err != nil {
+		log.Printf("Failed to save seen-URL set %s: %v", seenSetFile, err)
+	}
+}
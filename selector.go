@@ -0,0 +1,319 @@
+package main // CSS and XPath selectors restricting link extraction to part of a page
+
+import (
+	"fmt"     // Describes selector syntax errors
+	"regexp"  // Tokenizes XPath steps
+	"slices"  // Checks class lists
+	"strings" // Parses selector syntax
+
+	"golang.org/x/net/html" // Parsed page tree the selectors run against
+)
+
+// One step of a selector: a node test plus how it relates to the previous step
+type selectorStep struct {
+	child bool                  // The node must be a direct child of the previous step's node instead of any descendant
+	test  func(*html.Node) bool // Tag, id, class and attribute conditions
+}
+
+// A compiled selector: alternatives of step chains, any of which may match
+type nodeSelector [][]selectorStep
+
+// Reports whether the node matches any alternative of the selector
+func (s nodeSelector) matches(node *html.Node) bool {
+	for _, steps := range s {
+		if matchSteps(node, steps) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches a chain right to left: the node satisfies the last step and its ancestors satisfy the rest
+func matchSteps(node *html.Node, steps []selectorStep) bool {
+	last := steps[len(steps)-1]
+	if node.Type != html.ElementNode || !last.test(node) {
+		return false
+	}
+	if len(steps) == 1 {
+		return !last.child || node.Parent == nil || node.Parent.Type == html.DocumentNode // A leading child step anchors at the root
+	}
+	if last.child {
+		return node.Parent != nil && matchSteps(node.Parent, steps[:len(steps)-1])
+	}
+	for ancestor := node.Parent; ancestor != nil; ancestor = ancestor.Parent { // Try every ancestor so later steps can backtrack
+		if matchSteps(ancestor, steps[:len(steps)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the value of an attribute, or false when the node doesn't have it
+func nodeAttribute(node *html.Node, name string) (string, bool) {
+	for _, attribute := range node.Attr {
+		if attribute.Key == name {
+			return attribute.Val, true
+		}
+	}
+	return "", false
+}
+
+// Combines node tests that must all pass
+func allTests(tests []func(*html.Node) bool) func(*html.Node) bool {
+	return func(node *html.Node) bool {
+		for _, test := range tests {
+			if !test(node) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Tests the element name; "*" and "" accept any element
+func tagTest(name string) func(*html.Node) bool {
+	name = strings.ToLower(name)
+	return func(node *html.Node) bool { return name == "" || name == "*" || node.Data == name }
+}
+
+// Tests an attribute: present when op is empty, equal for "=", word in a list for "~=", substring for "*="
+func attributeTest(name string, op string, value string) func(*html.Node) bool {
+	name = strings.ToLower(name)
+	return func(node *html.Node) bool {
+		actual, found := nodeAttribute(node, name)
+		switch {
+		case !found:
+			return false
+		case op == "":
+			return true
+		case op == "~=":
+			return slices.Contains(strings.Fields(actual), value)
+		case op == "*=":
+			return strings.Contains(actual, value)
+		case op == "^=":
+			return strings.HasPrefix(actual, value)
+		case op == "$=":
+			return strings.HasSuffix(actual, value)
+		default:
+			return actual == value
+		}
+	}
+}
+
+// Compiles a CSS selector: type, #id, .class and [attr], [attr=value], [attr~=value], [attr*=value], [attr^=value] and
+// [attr$=value] conditions, joined by descendant (space) or child (>) combinators; commas separate alternatives
+func compileCSSSelector(selector string) (nodeSelector, error) {
+	var compiled nodeSelector
+	for _, alternative := range splitTopLevel(selector, ",") {
+		fields := cssFields(alternative)
+		var steps []selectorStep
+		child := false
+		for _, field := range fields {
+			if field == ">" {
+				if len(steps) == 0 || child {
+					return nil, fmt.Errorf("css selector %q: misplaced '>'", selector)
+				}
+				child = true
+				continue
+			}
+			test, err := compileCSSCompound(field)
+			if err != nil {
+				return nil, fmt.Errorf("css selector %q: %w", selector, err)
+			}
+			steps = append(steps, selectorStep{child: child, test: test})
+			child = false
+		}
+		if len(steps) == 0 || child {
+			return nil, fmt.Errorf("css selector %q: empty or incomplete alternative", selector)
+		}
+		compiled = append(compiled, steps)
+	}
+	return compiled, nil
+}
+
+// Marks the bytes of a selector outside quoted strings, attribute conditions and predicates, where separators and
+// combinators count
+func topLevelBytes(selector string) []bool {
+	outside := make([]bool, len(selector))
+	quote, depth := byte(0), 0
+	for i := 0; i < len(selector); i++ {
+		switch c := selector[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case (c == ']' || c == ')') && depth > 0:
+			depth--
+		case depth == 0:
+			outside[i] = true
+		}
+	}
+	return outside
+}
+
+// Splits a selector at every separator outside quotes, brackets and parentheses, so a quoted attribute value may
+// contain it, e.g. a[title="a, b"]
+func splitTopLevel(selector string, separator string) []string {
+	outside := topLevelBytes(selector)
+	var parts []string
+	start := 0
+	for i := range len(selector) {
+		if outside[i] && strings.HasPrefix(selector[i:], separator) {
+			parts = append(parts, selector[start:i])
+			start = i + len(separator)
+		}
+	}
+	return append(parts, selector[start:])
+}
+
+// Splits one CSS alternative into compound selectors and ">" combinators at whitespace and '>' outside quotes and
+// attribute conditions
+func cssFields(alternative string) []string {
+	outside := topLevelBytes(alternative)
+	var fields []string
+	var field strings.Builder
+	flush := func() {
+		if field.Len() > 0 {
+			fields = append(fields, field.String())
+			field.Reset()
+		}
+	}
+	for i := range len(alternative) {
+		switch c := alternative[i]; {
+		case outside[i] && c == '>':
+			flush()
+			fields = append(fields, ">") // The child combinator is its own field
+		case outside[i] && (c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'):
+			flush()
+		default:
+			field.WriteByte(c)
+		}
+	}
+	flush()
+	return fields
+}
+
+var cssTagRegex = regexp.MustCompile(`^(?:[A-Za-z][-\w]*|\*)?$`) // Element name, * or nothing
+
+var cssAttributeRegex = regexp.MustCompile(`^\[\s*([A-Za-z_:][-\w:.]*)\s*(?:([~*^$]?=)\s*(?:"([^"]*)"|'([^']*)'|([^\]\s]*)))?\s*\]`) // [attr op value]
+
+// Compiles one compound CSS selector such as table.sds#list[data-kind=msds]
+func compileCSSCompound(compound string) (func(*html.Node) bool, error) {
+	end := strings.IndexAny(compound, "#.[")
+	if end < 0 {
+		end = len(compound)
+	}
+	if !cssTagRegex.MatchString(compound[:end]) {
+		return nil, fmt.Errorf("unsupported syntax in %q", compound)
+	}
+	tests := []func(*html.Node) bool{tagTest(compound[:end])}
+	rest := compound[end:]
+	for rest != "" {
+		switch rest[0] {
+		case '#', '.':
+			nameEnd := strings.IndexAny(rest[1:], "#.[") + 1
+			if nameEnd == 0 {
+				nameEnd = len(rest)
+			}
+			name := rest[1:nameEnd]
+			if name == "" {
+				return nil, fmt.Errorf("empty name in %q", compound)
+			}
+			if rest[0] == '#' {
+				tests = append(tests, attributeTest("id", "=", name))
+			} else {
+				tests = append(tests, attributeTest("class", "~=", name))
+			}
+			rest = rest[nameEnd:]
+		case '[':
+			match := cssAttributeRegex.FindStringSubmatch(rest)
+			if match == nil {
+				return nil, fmt.Errorf("bad attribute condition in %q", compound)
+			}
+			tests = append(tests, attributeTest(match[1], match[2], match[3]+match[4]+match[5]))
+			rest = rest[len(match[0]):]
+		default:
+			return nil, fmt.Errorf("unsupported syntax in %q", compound)
+		}
+	}
+	return allTests(tests), nil
+}
+
+var (
+	xpathStepRegex      = regexp.MustCompile(`^(//?)([A-Za-z][-\w]*|\*)((?:\[[^\]]*\])*)`)                                                                             // /tag[...] or //tag[...]
+	xpathPredicateRegex = regexp.MustCompile(`\[\s*(?:@([-\w:]+)\s*(?:=\s*(?:"([^"]*)"|'([^']*)'))?|contains\(\s*@([-\w:]+)\s*,\s*(?:"([^"]*)"|'([^']*)')\s*\))\s*\]`) // [@a], [@a='v'], [contains(@a,'v')]
+)
+
+// Compiles an XPath location path using the child (/) and descendant (//) axes, element names or *, and
+// [@attr], [@attr='value'] and [contains(@attr,'value')] predicates; | separates alternatives
+func compileXPathSelector(selector string) (nodeSelector, error) {
+	var compiled nodeSelector
+	for _, alternative := range splitTopLevel(selector, "|") {
+		rest := strings.TrimSpace(alternative)
+		if !strings.HasPrefix(rest, "/") {
+			rest = "//" + rest // Relative paths may match anywhere in the page
+		}
+		var steps []selectorStep
+		for rest != "" {
+			match := xpathStepRegex.FindStringSubmatch(rest)
+			if match == nil {
+				return nil, fmt.Errorf("xpath %q: unsupported syntax at %q", selector, rest)
+			}
+			tests := []func(*html.Node) bool{tagTest(match[2])}
+			predicates := match[3]
+			for predicates != "" {
+				predicate := xpathPredicateRegex.FindStringSubmatch(predicates)
+				if predicate == nil || !strings.HasPrefix(predicates, predicate[0]) {
+					return nil, fmt.Errorf("xpath %q: unsupported predicate in %q", selector, match[0])
+				}
+				switch {
+				case predicate[1] != "" && strings.Contains(predicate[0], "="):
+					tests = append(tests, attributeTest(predicate[1], "=", predicate[2]+predicate[3]))
+				case predicate[1] != "":
+					tests = append(tests, attributeTest(predicate[1], "", ""))
+				default:
+					tests = append(tests, attributeTest(predicate[4], "*=", predicate[5]+predicate[6]))
+				}
+				predicates = predicates[len(predicate[0]):]
+			}
+			steps = append(steps, selectorStep{child: match[1] == "/", test: allTests(tests)})
+			rest = rest[len(match[0]):]
+		}
+		compiled = append(compiled, steps)
+	}
+	return compiled, nil
+}
+
+// Returns a page reduced to the headings outside the selected containers plus the containers themselves, in page
+// order, so link extraction still sees the heading each link is listed under
+func selectContainers(pageHTML string, selector nodeSelector) (string, int, error) {
+	document, err := html.Parse(strings.NewReader(pageHTML))
+	if err != nil {
+		return "", 0, err
+	}
+	var selected strings.Builder
+	containers := 0
+	var visit func(node *html.Node) error
+	visit = func(node *html.Node) error {
+		if node.Type == html.ElementNode && (selector.matches(node) || isHeadingTag(node.Data)) {
+			if selector.matches(node) {
+				containers++
+			}
+			return html.Render(&selected, node) // Keep the whole subtree; nested matches are already inside
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if err := visit(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := visit(document); err != nil {
+		return "", 0, err
+	}
+	return selected.String(), containers, nil
+}
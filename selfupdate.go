@@ -0,0 +1,258 @@
+package main // Selfupdate subcommand replacing the running binary with the latest signed GitHub release
+
+import (
+	"bufio"           // Reads SHA256SUMS
+	"bytes"           // Parses downloaded files
+	"crypto/ed25519"  // Verifies the checksum signature
+	"crypto/sha256"   // Hashes the downloaded binary
+	"encoding/base64" // Decodes keys and text signatures
+	"encoding/hex"    // Compares digests
+	"encoding/json"   // Reads the releases API
+	"flag"            // Parses the selfupdate options
+	"fmt"             // Builds errors and messages
+	"io"              // Reads responses
+	"log"             // Reports progress
+	"net/http"        // Talks to GitHub
+	"os"              // Replaces the executable
+	"path/filepath"   // Builds the temporary file paths
+	"runtime"         // Picks the asset for this platform
+	"slices"          // Orders release versions
+	"strconv"         // Parses release version numbers
+	"strings"         // Parses checksum lines and tags
+)
+
+var (
+	buildVersion     = "dev" // Release tag this binary was built from; set with -ldflags "-X main.buildVersion=v1.2.3"
+	releasePublicKey = ""    // Base64 Ed25519 key release checksums are signed with; set with -ldflags "-X main.releasePublicKey=..."
+)
+
+const (
+	releaseChecksumsName = "SHA256SUMS"     // Release asset listing the digest of every binary
+	releaseSignatureName = "SHA256SUMS.sig" // Ed25519 signature of the checksum file, raw or base64
+	releaseVersionPrefix = "# version "     // SHA256SUMS line naming the release it belongs to, so the signature covers the tag
+	maxReleaseAssetSize  = 256 << 20        // Largest asset downloaded, so a bad release can't fill the disk
+)
+
+// The parts of a GitHub release the updater needs
+type githubRelease struct {
+	TagName string `json:"tag_name"` // Version of the release, e.g. v1.4.0
+	Assets  []struct {
+		Name string `json:"name"`                 // File name of the asset
+		URL  string `json:"browser_download_url"` // Where the asset is downloaded from
+	} `json:"assets"`
+}
+
+// Returns the download URL of the named asset
+func (r githubRelease) assetURL(name string) (string, error) {
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			return asset.URL, nil
+		}
+	}
+	return "", fmt.Errorf("release %s has no %s asset", r.TagName, name)
+}
+
+// Returns the release asset name of the binary for this platform, e.g. poolseason-scraper_linux_amd64
+func releaseAssetName() string {
+	name := fmt.Sprintf("poolseason-scraper_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// Downloads a URL into memory, refusing bodies over the asset size limit
+func fetchReleaseFile(rawURL string) ([]byte, error) {
+	request, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/vnd.github+json, application/octet-stream")
+	request, cancel := withFileDeadline(request)
+	defer cancel()
+	response, err := httpClient().Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", rawURL, response.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxReleaseAssetSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxReleaseAssetSize {
+		return nil, fmt.Errorf("%s is larger than %s", rawURL, formatBytes(maxReleaseAssetSize))
+	}
+	return data, nil
+}
+
+// Checks the checksum file's signature and that it belongs to release tag, and returns the digest it lists for the asset
+func verifiedChecksum(sums []byte, signature []byte, publicKey string, tag string, asset string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("release public key must be a base64 Ed25519 key")
+	}
+	if len(signature) != ed25519.SignatureSize { // Text signatures are base64
+		if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature))); err == nil {
+			signature = decoded
+		}
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), sums, signature) {
+		return "", fmt.Errorf("%s signature doesn't verify; refusing to update", releaseChecksumsName)
+	}
+	version, digest := "", ""
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if rest, found := strings.CutPrefix(line, releaseVersionPrefix); found {
+			version = strings.TrimSpace(rest)
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == asset {
+			digest = strings.ToLower(fields[0])
+		}
+	}
+	if version != tag { // Otherwise an older release's signed files could be replayed under a newer tag
+		return "", fmt.Errorf("signed %s is for release %q, not %s; refusing to update", releaseChecksumsName, version, tag)
+	}
+	if digest == "" {
+		return "", fmt.Errorf("%s lists no digest for %s", releaseChecksumsName, asset)
+	}
+	return digest, nil
+}
+
+// Parses a release tag like v1.4.0 into its major, minor and patch numbers; pre-release tags aren't published
+func parseReleaseTag(tag string) ([3]int, bool) {
+	var version [3]int
+	parts := strings.Split(strings.TrimPrefix(tag, "v"), ".")
+	if !strings.HasPrefix(tag, "v") || len(parts) != 3 {
+		return version, false
+	}
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 || strconv.Itoa(number) != part {
+			return version, false
+		}
+		version[i] = number
+	}
+	return version, true
+}
+
+// Refuses a release tag older than the running version; development builds accept any well-formed tag
+func checkNotDowngrade(tag string) error {
+	latest, ok := parseReleaseTag(tag)
+	if !ok {
+		return fmt.Errorf("release tag %q isn't a vMAJOR.MINOR.PATCH version; refusing to update", tag)
+	}
+	if running, ok := parseReleaseTag(buildVersion); ok && slices.Compare(latest[:], running[:]) < 0 {
+		return fmt.Errorf("latest release %s is older than the running %s; refusing to downgrade", tag, buildVersion)
+	}
+	return nil
+}
+
+// Replaces the executable at path with data: the new binary is written next to it and renamed over it, so a failed
+// update never leaves a half-written binary behind
+func replaceExecutable(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	dir, name := filepath.Split(path)
+	staged := filepath.Join(dir, "."+name+".new")
+	if err := os.WriteFile(staged, data, info.Mode().Perm()|0o100); err != nil {
+		return err
+	}
+	previous := filepath.Join(dir, "."+name+".old")
+	os.Remove(previous)
+	if err := os.Rename(path, previous); err != nil { // Windows can rename a running executable but not overwrite it
+		os.Remove(staged)
+		return err
+	}
+	if err := os.Rename(staged, path); err != nil {
+		os.Rename(previous, path) // Put the old binary back
+		os.Remove(staged)
+		return err
+	}
+	os.Remove(previous) // Fails harmlessly on Windows while the old binary is still running
+	return nil
+}
+
+// Runs the selfupdate subcommand: finds the latest GitHub release, verifies the signed checksum of this platform's
+// binary and replaces the running executable with it
+func runSelfUpdate(args []string) error {
+	flags := flag.NewFlagSet("selfupdate", flag.ExitOnError) // Options specific to selfupdate
+	repository := flags.String("repo", "Strong-Foundation/poolseason-com-documentation", "GitHub repository publishing the releases, as owner/name")
+	apiURL := flags.String("api-url", "https://api.github.com", "GitHub API base URL, e.g. for GitHub Enterprise")
+	publicKey := flags.String("public-key", releasePublicKey, "base64 Ed25519 public key the release checksums are signed with")
+	checkOnly := flags.Bool("check", false, "only report whether a newer release exists")
+	force := flags.Bool("force", false, "install the latest release even if it matches the running version")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *publicKey == "" && !*checkOnly {
+		return fmt.Errorf("no release signing key: pass -public-key or build with -ldflags \"-X main.releasePublicKey=...\"")
+	}
+
+	data, err := fetchReleaseFile(strings.TrimSuffix(*apiURL, "/") + "/repos/" + *repository + "/releases/latest")
+	if err != nil {
+		return fmt.Errorf("looking up the latest release: %w", err)
+	}
+	var release githubRelease
+	if err := json.Unmarshal(data, &release); err != nil {
+		return fmt.Errorf("reading the latest release: %w", err)
+	}
+	if err := checkNotDowngrade(release.TagName); err != nil {
+		return err
+	}
+	if release.TagName == buildVersion && !*force {
+		fmt.Printf("Already running the latest release %s\n", buildVersion)
+		return nil
+	}
+	fmt.Printf("Running %s; latest release is %s\n", buildVersion, release.TagName)
+	if *checkOnly {
+		return nil
+	}
+
+	asset := releaseAssetName()
+	urls := make(map[string]string) // Asset name → download URL
+	for _, name := range []string{asset, releaseChecksumsName, releaseSignatureName} {
+		if urls[name], err = release.assetURL(name); err != nil {
+			return err
+		}
+	}
+	sums, err := fetchReleaseFile(urls[releaseChecksumsName])
+	if err != nil {
+		return err
+	}
+	signature, err := fetchReleaseFile(urls[releaseSignatureName])
+	if err != nil {
+		return err
+	}
+	expected, err := verifiedChecksum(sums, signature, *publicKey, release.TagName, asset)
+	if err != nil {
+		return err
+	}
+	binary, err := fetchReleaseFile(urls[asset])
+	if err != nil {
+		return err
+	}
+	if digest := sha256.Sum256(binary); hex.EncodeToString(digest[:]) != expected {
+		return fmt.Errorf("%s doesn't match its signed checksum; refusing to update", asset)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+		executable = resolved // Replace the binary, not a symlink pointing at it
+	}
+	if err := replaceExecutable(executable, binary); err != nil {
+		return fmt.Errorf("replacing %s: %w", executable, err)
+	}
+	log.Printf("Updated %s from %s to %s", executable, buildVersion, release.TagName)
+	return nil
+}
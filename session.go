@@ -0,0 +1,159 @@
+package main // Session logins for protected hosts and refreshing them when the host starts rejecting requests
+
+import (
+	"context"       // Marks login requests so stale credentials aren't added to them
+	"encoding/json" // Reads tokens from login responses
+	"errors"        // Detects rejected requests
+	"fmt"           // Builds errors
+	"io"            // Reads login responses
+	"log"           // Reports refreshes
+	"net/http"      // Sends the login request
+	"net/url"       // Encodes the login form
+	"strings"       // Joins cookies and checks references
+	"sync"          // Serializes refreshes
+	"time"          // Tells stale rejections from new ones
+)
+
+var (
+	authFailurePolicy = "refresh" // What a 401 or 403 from a host with configured auth does: fail, or refresh the credentials and retry
+	authRefreshLimit  = 3         // Credential refreshes allowed per host and run
+)
+
+// Returned by fetches when the server answered 401 or 403
+type authRejectedError struct {
+	status string // Status line of the response
+}
+
+// Describes the rejection
+func (e *authRejectedError) Error() string {
+	return fmt.Sprintf("download failed: %s", e.status)
+}
+
+// Returns an authRejectedError for 401 and 403 responses and nil for everything else
+func checkAuthRejected(response *http.Response) error {
+	if response.StatusCode != http.StatusUnauthorized && response.StatusCode != http.StatusForbidden {
+		return nil
+	}
+	return &authRejectedError{status: response.Status}
+}
+
+// Checks the -on-auth-failure option
+func validateAuthFailurePolicy() error {
+	authFailurePolicy = strings.ToLower(strings.TrimSpace(authFailurePolicy))
+	if authFailurePolicy != "fail" && authFailurePolicy != "refresh" {
+		return fmt.Errorf("unknown -on-auth-failure %q (expected fail or refresh)", authFailurePolicy)
+	}
+	return nil
+}
+
+// Context key marking requests that must go out without the host's registered credentials
+type withoutTargetAuthKey struct{}
+
+// Logs in with the target's form and returns the header that carries the session: a bearer token read from the
+// JSON response when TokenField is set, otherwise the cookies the login response set
+func (a targetAuth) login() (authHeader, error) {
+	if a.LoginURL == "" {
+		return authHeader{}, fmt.Errorf("auth type session needs a login_url")
+	}
+	form := url.Values{}
+	for field, value := range a.Form {
+		if strings.HasPrefix(value, "env:") || strings.HasPrefix(value, "secret:") {
+			resolved, err := resolveCredential(value)
+			if err != nil {
+				return authHeader{}, err
+			}
+			value = resolved
+		}
+		form.Set(field, value)
+	}
+	request, err := http.NewRequest(http.MethodPost, a.LoginURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return authHeader{}, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request = request.WithContext(context.WithValue(request.Context(), withoutTargetAuthKey{}, true))
+	request, cancel := withFileDeadline(request)
+	defer cancel()
+
+	client := *httpClient()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse // Logins often answer with a redirect that carries the session cookie
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return authHeader{}, fmt.Errorf("login to %s: %w", a.LoginURL, err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return authHeader{}, fmt.Errorf("login to %s: %w", a.LoginURL, err)
+	}
+	if response.StatusCode >= http.StatusBadRequest {
+		return authHeader{}, fmt.Errorf("login to %s: %s", a.LoginURL, response.Status)
+	}
+	if a.TokenField != "" {
+		var fields map[string]any
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return authHeader{}, fmt.Errorf("login to %s: reading token: %w", a.LoginURL, err)
+		}
+		token, _ := fields[a.TokenField].(string)
+		if token == "" {
+			return authHeader{}, fmt.Errorf("login to %s: response has no %q token", a.LoginURL, a.TokenField)
+		}
+		return authHeader{name: "Authorization", value: "Bearer " + token}, nil
+	}
+	var cookies []string
+	for _, cookie := range response.Cookies() {
+		cookies = append(cookies, cookie.Name+"="+cookie.Value)
+	}
+	if len(cookies) == 0 {
+		return authHeader{}, fmt.Errorf("login to %s: response set no cookies", a.LoginURL)
+	}
+	return authHeader{name: "Cookie", value: strings.Join(cookies, "; ")}, nil
+}
+
+// Refreshes the credentials of hosts that start rejecting requests mid-run, at most once per burst of rejections
+type authRefresher struct {
+	mu        sync.Mutex           // Serializes refreshes so parallel downloads log in once
+	refreshed map[string]time.Time // Host → when its credentials were last refreshed
+	count     map[string]int       // Host → refreshes this run
+}
+
+var sessionRefresher = &authRefresher{refreshed: make(map[string]time.Time), count: make(map[string]int)} // Shared by every download
+
+// Refreshes the credentials of the request's host after a 401 or 403 and reports whether the request should be
+// retried; requests sent before another worker's refresh are retried without logging in again
+func (r *authRefresher) handle(requestURL string, sentAt time.Time, err error) bool {
+	var rejected *authRejectedError
+	if authFailurePolicy != "refresh" || !errors.As(err, &rejected) {
+		return false
+	}
+	host := normalizeDomain(getDomainFromURL(requestURL))
+	hostAuthMu.RLock()
+	auth, configured := hostLogins[host]
+	hostAuthMu.RUnlock()
+	if !configured {
+		return false // Nothing to refresh; the document really is off limits
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.refreshed[host].After(sentAt) {
+		return true // Already refreshed since this request went out
+	}
+	if r.count[host] >= authRefreshLimit {
+		return false
+	}
+	r.count[host]++
+	log.Printf("%s rejected %s (%s); refreshing credentials (%d/%d)", host, requestURL, rejected.status, r.count[host], authRefreshLimit)
+	header, err := auth.header()
+	if err != nil {
+		log.Printf("Failed to refresh credentials for %s: %v", host, err)
+		return false
+	}
+	hostAuthMu.Lock()
+	hostAuth[host] = header
+	hostAuthMu.Unlock()
+	r.refreshed[host] = time.Now()
+	return true
+}
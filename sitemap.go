@@ -0,0 +1,268 @@
+package main // Daemon recrawls triggered by lastmod changes in the sites' sitemaps
+
+import (
+	"encoding/json" // Reads and writes the sitemap state
+	"encoding/xml"  // Parses sitemaps and sitemap indexes
+	"fmt"           // Formats errors
+	"io"            // Bounds sitemap bodies
+	"log"           // Reports polls and triggered runs
+	"net/http"      // Fetches sitemaps with conditional GETs
+	"net/url"       // Derives default sitemap URLs and matches sections
+	"os"            // Reads the state file
+	"slices"        // Picks the changed sections
+	"strings"       // Splits the option and compares paths
+	"time"          // Schedules polls
+)
+
+var (
+	sitemapPollInterval = time.Duration(0)     // How often the daemon checks the sitemaps; 0 disables sitemap-triggered runs
+	sitemapURLs         []string               // Sitemaps to poll; empty polls /sitemap.xml on the host of every target
+	sitemapStatePath    = "sitemap-state.json" // Last lastmod seen for every sitemap URL, and the validators of each sitemap
+)
+
+const maxSitemapSize = 50 << 20 // Bytes read from one sitemap; the protocol allows 50MB uncompressed
+
+// Parses the -sitemap option: comma-separated sitemap or sitemap index URLs
+func setSitemapURLs(value string) error {
+	sitemapURLs = nil
+	for _, rawURL := range strings.Split(value, ",") {
+		if rawURL = strings.TrimSpace(rawURL); rawURL == "" {
+			continue
+		}
+		if parsed, err := url.Parse(rawURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("-sitemap %q must be an http or https URL", rawURL)
+		}
+		sitemapURLs = append(sitemapURLs, rawURL)
+	}
+	return nil
+}
+
+// What the daemon knows of the sitemaps between polls, kept across restarts
+type sitemapState struct {
+	LastMod  map[string]string            `json:"lastmod"`            // lastmod of every page URL listed, as written in the sitemap
+	Sitemaps map[string]sitemapValidators `json:"sitemaps,omitempty"` // Validators of every sitemap fetched, for conditional GETs
+}
+
+// Lets a sitemap be fetched only when it changed
+type sitemapValidators struct {
+	ETag         string `json:"etag,omitempty"`          // ETag of the last response
+	LastModified string `json:"last_modified,omitempty"` // Last-Modified header of the last response
+	LastMod      string `json:"lastmod,omitempty"`       // lastmod the sitemap index gave this sitemap
+}
+
+// A sitemap or sitemap index; only one of the lists is filled
+type sitemapDocument struct {
+	URLs     []sitemapLocation `xml:"url"`     // Pages of a urlset
+	Sitemaps []sitemapLocation `xml:"sitemap"` // Sitemaps of a sitemapindex
+}
+
+// One <url> or <sitemap> element
+type sitemapLocation struct {
+	Loc     string `xml:"loc"`     // Absolute URL
+	LastMod string `xml:"lastmod"` // W3C datetime of its last change, if given
+}
+
+// Reads the sitemap state; a missing file means the first poll only records a baseline
+func loadSitemapState() (sitemapState, bool) {
+	state := sitemapState{LastMod: make(map[string]string), Sitemaps: make(map[string]sitemapValidators)}
+	data, err := os.ReadFile(sitemapStatePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println(err)
+		}
+		return state, false
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Printf("Ignoring %s: %v", sitemapStatePath, err)
+		return sitemapState{LastMod: make(map[string]string), Sitemaps: make(map[string]sitemapValidators)}, false
+	}
+	if state.LastMod == nil {
+		state.LastMod = make(map[string]string)
+	}
+	if state.Sitemaps == nil {
+		state.Sitemaps = make(map[string]sitemapValidators)
+	}
+	return state, true
+}
+
+// Writes the sitemap state
+func (state sitemapState) save() {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := writeFileAtomic(sitemapStatePath, data); err != nil {
+		log.Printf("Failed to save the sitemap state %s: %v", sitemapStatePath, err)
+	}
+}
+
+// Returns the sitemaps to poll: -sitemap, or /sitemap.xml on the host of every target
+func pollSitemapURLs(targets []scrapeTarget) []string {
+	if len(sitemapURLs) > 0 {
+		return sitemapURLs
+	}
+	var defaults []string
+	seen := make(map[string]bool)
+	for _, target := range targets {
+		parsed, err := url.Parse(target.URL)
+		if err != nil || parsed.Host == "" {
+			continue
+		}
+		sitemap := parsed.Scheme + "://" + parsed.Host + "/sitemap.xml"
+		if !seen[sitemap] {
+			seen[sitemap] = true
+			defaults = append(defaults, sitemap)
+		}
+	}
+	return defaults
+}
+
+// Polls the sitemaps every interval and starts a run of the targets whose pages changed, while no run is in
+// progress; changes stay pending until a run of them could be started
+func runSitemapWatch(server *scraperServer) {
+	ticker := time.NewTicker(sitemapPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if server.running() {
+			continue // The run crawls the sections itself
+		}
+		pollSitemaps(server)
+	}
+}
+
+// Checks the sitemaps once and triggers a run of the changed sections
+func pollSitemaps(server *scraperServer) {
+	state, known := loadSitemapState()
+	changed := make(map[string]string) // Page URL → new lastmod
+	client := httpClient()
+	for _, sitemap := range pollSitemapURLs(scrapeTargets) {
+		if err := readSitemap(client, sitemap, "", state, changed, 0); err != nil {
+			log.Printf("Failed to poll sitemap %s: %v", sitemap, err)
+		}
+	}
+	if !known {
+		for page, lastMod := range changed {
+			state.LastMod[page] = lastMod
+		}
+		state.save()
+		log.Printf("Recorded %d sitemap entries as the baseline; later changes trigger runs of their sections", len(changed))
+		return
+	}
+	if len(changed) == 0 {
+		state.save() // Validators may have changed
+		return
+	}
+	sections := changedSections(scrapeTargets, changed)
+	if len(sections) == 0 {
+		for page, lastMod := range changed { // Pages outside every target, e.g. the blog
+			state.LastMod[page] = lastMod
+		}
+		state.save()
+		return
+	}
+	run, err := server.startRun("", sections)
+	if err != nil {
+		log.Printf("Not starting a run of %d sections changed in the sitemap yet: %v", len(sections), err)
+		return // Polled again next time; the validators aren't saved, so the changes are seen again
+	}
+	for page, lastMod := range changed {
+		state.LastMod[page] = lastMod
+	}
+	state.save()
+	log.Printf("Started run %s of %d sections whose sitemap lastmod changed: %s", run.ID, len(sections), strings.Join(sections, ", "))
+}
+
+// Fetches a sitemap unless it's unchanged since the last poll and collects the pages whose lastmod differs from the
+// state; sitemap indexes are followed into the sitemaps whose own lastmod changed
+func readSitemap(client *http.Client, sitemap string, indexLastMod string, state sitemapState, changed map[string]string, depth int) error {
+	validators := state.Sitemaps[sitemap]
+	if indexLastMod != "" && indexLastMod == validators.LastMod {
+		return nil // The index says it hasn't changed
+	}
+	request, err := http.NewRequest(http.MethodGet, sitemap, nil)
+	if err != nil {
+		return err
+	}
+	if validators.ETag != "" {
+		request.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		request.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+	request, cancel := withFileDeadline(request)
+	defer cancel()
+	requestThrottle.wait()
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusNotModified:
+		return nil
+	case http.StatusOK:
+	default:
+		return fmt.Errorf("server returned %s", response.Status)
+	}
+	var document sitemapDocument
+	if err := xml.NewDecoder(io.LimitReader(response.Body, maxSitemapSize)).Decode(&document); err != nil {
+		return fmt.Errorf("parsing: %w", err)
+	}
+	for _, child := range document.Sitemaps {
+		if depth > 0 {
+			break // Indexes don't nest
+		}
+		if err := readSitemap(client, strings.TrimSpace(child.Loc), strings.TrimSpace(child.LastMod), state, changed, depth+1); err != nil {
+			log.Printf("Failed to poll sitemap %s: %v", child.Loc, err)
+		}
+	}
+	for _, page := range document.URLs {
+		loc, lastMod := strings.TrimSpace(page.Loc), strings.TrimSpace(page.LastMod)
+		if lastMod != "" && state.LastMod[loc] != lastMod {
+			changed[loc] = lastMod
+		}
+	}
+	state.Sitemaps[sitemap] = sitemapValidators{ETag: response.Header.Get("ETag"), LastModified: response.Header.Get("Last-Modified"), LastMod: indexLastMod}
+	return nil
+}
+
+// Returns the URLs of the targets a changed page belongs to: the listing page itself, or a page under the listing
+// page's directory on the same host
+func changedSections(targets []scrapeTarget, changed map[string]string) []string {
+	var sections []string
+	for _, target := range targets {
+		section, err := url.Parse(target.URL)
+		if err != nil {
+			continue
+		}
+		prefix := section.Path[:strings.LastIndex(section.Path, "/")+1] // Directory of the listing page
+		for page := range changed {
+			parsed, err := url.Parse(page)
+			if err != nil || !strings.EqualFold(parsed.Host, section.Host) {
+				continue
+			}
+			if page == target.URL || parsed.Path == section.Path || (prefix != "/" && strings.HasPrefix(parsed.Path, prefix)) {
+				sections = append(sections, target.URL)
+				break
+			}
+		}
+	}
+	return sections
+}
+
+// Limits the targets to the sections with the given URLs for one run and returns the function restoring them;
+// documents of other sections aren't marked removed-from-site by that run
+func narrowTargets(sections []string) func() {
+	all := scrapeTargets
+	var narrowed []scrapeTarget
+	for _, target := range all {
+		if slices.Contains(sections, target.URL) {
+			narrowed = append(narrowed, target)
+		}
+	}
+	scrapeTargets, partialDiscovery = narrowed, true
+	return func() {
+		scrapeTargets, partialDiscovery = all, false
+	}
+}
@@ -0,0 +1,90 @@
+package main // Size distribution report, for spotting unintended huge downloads after a site change
+
+import (
+	"flag"    // Parses the report options
+	"fmt"     // Prints the report
+	"sort"    // Orders files by size
+	"strings" // Draws the histogram bars
+	"time"    // Filters by download time
+)
+
+// Upper bounds of the histogram buckets; each bucket holds files up to its bound, the last one everything larger
+var sizeBuckets = []int64{64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20}
+
+const sizeHistogramWidth = 40 // Characters in the longest histogram bar
+
+// Prints a histogram of document sizes and the largest documents
+func runSizesReport(args []string) error {
+	flags := flag.NewFlagSet("report sizes", flag.ExitOnError) // Options specific to this report
+	manifestPath := flags.String("manifest", manifestFilePath, "manifest describing the archive")
+	top := flags.Int("top", 10, "number of largest documents to list")
+	since := flags.Duration("since", 0, "only include documents downloaded within this long, e.g. 168h (0 includes all)")
+	run := flags.String("run", "", "only include documents downloaded by this run ID")
+	failAbove := flags.String("fail-above", "", "exit with an error when any included document is larger than this, e.g. 20MB, for use in CI")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	var limit int64
+	if *failAbove != "" {
+		size, ok := parseByteSize(*failAbove)
+		if !ok {
+			return fmt.Errorf("-fail-above must be a size such as 20MB or 1GiB")
+		}
+		limit = size
+	}
+
+	var entries []manifestEntry
+	var total int64
+	cutoff := time.Now().UTC().Add(-*since)
+	for _, entry := range loadManifest(*manifestPath).list() {
+		if (*since > 0 && entry.DownloadedAt.Before(cutoff)) || (*run != "" && entry.RunID != *run) {
+			continue
+		}
+		entries = append(entries, entry)
+		total += entry.Size
+	}
+	if len(entries) == 0 {
+		fmt.Println("No documents match")
+		return nil
+	}
+	sort.SliceStable(entries, func(i, j int) bool { // Largest first
+		return entries[i].Size > entries[j].Size
+	})
+
+	counts := make([]int, len(sizeBuckets)+1)
+	for _, entry := range entries {
+		bucket := sort.Search(len(sizeBuckets), func(i int) bool { return entry.Size <= sizeBuckets[i] })
+		counts[bucket]++
+	}
+	largest := 0
+	for _, count := range counts {
+		largest = max(largest, count)
+	}
+	median := entries[len(entries)/2].Size
+	fmt.Printf("Documents: %d, total %s, median %s, largest %s\n", len(entries), formatBytes(total), formatBytes(median), formatBytes(entries[0].Size))
+	for i, count := range counts {
+		label := "> " + formatBytes(sizeBuckets[len(sizeBuckets)-1])
+		if i < len(sizeBuckets) {
+			label = "≤ " + formatBytes(sizeBuckets[i])
+		}
+		bar := strings.Repeat("#", (count*sizeHistogramWidth+largest-1)/largest) // Any non-empty bucket gets a mark
+		fmt.Println(strings.TrimRight(fmt.Sprintf("  %-11s %6d  %s", label, count, bar), " "))
+	}
+
+	fmt.Printf("Largest %d:\n", min(*top, len(entries)))
+	for _, entry := range entries[:min(*top, len(entries))] {
+		fmt.Printf("  %10s  %s  %s\n", formatBytes(entry.Size), entry.DownloadedAt.Format("2006-01-02"), entry.File)
+	}
+	if limit > 0 {
+		var over []string
+		for _, entry := range entries {
+			if entry.Size > limit {
+				over = append(over, entry.File)
+			}
+		}
+		if len(over) > 0 {
+			return fmt.Errorf("%d documents are larger than %s: %s", len(over), *failAbove, strings.Join(over, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main // Deciding whether a discovered document is already archived
+
+import (
+	"fmt"     // Builds validation errors
+	"log"     // Reports relocated and unverifiable files
+	"strings" // Normalizes the option
+	"sync"    // Builds the archive hash index once
+)
+
+var skipBy = "path" // How already-archived documents are recognized: path (a file exists under the expected name) or hash
+
+// Checks the -skip-by option
+func validateSkipBy() error {
+	skipBy = strings.ToLower(strings.TrimSpace(skipBy))
+	if skipBy != "path" && skipBy != "hash" {
+		return fmt.Errorf("unknown -skip-by %q (expected path or hash)", skipBy)
+	}
+	return nil
+}
+
+// Maps content hashes to archive keys, built on first use so only hash-based runs pay for the scan
+type archiveHashIndex struct {
+	once   sync.Once           // Guards the scan
+	byHash map[string][]string // SHA-256 → keys holding that content
+}
+
+var archiveHashes = &archiveHashIndex{} // Shared by every download in the run
+
+// Returns the archive keys whose contents have the given hash
+func (i *archiveHashIndex) lookup(hash string) []string {
+	i.once.Do(func() {
+		i.byHash = make(map[string][]string)
+		for _, prefix := range archiveDirs() {
+			keys, err := archiveStorage.List(prefix)
+			if err != nil {
+				log.Printf("Failed to list %s for the hash index: %v", prefix, err)
+				continue
+			}
+			for _, key := range keys {
+				if isSidecarKey(key) {
+					continue // Checksum and metadata sidecars aren't documents
+				}
+				if digest, err := archiveStorage.Hash(key); err == nil {
+					i.byHash[digest] = append(i.byHash[digest], key)
+				}
+			}
+		}
+	})
+	return i.byHash[hash]
+}
+
+// Reports whether the manifest's copy of a URL is still in the archive with the recorded hash, wherever it is now
+// stored; a copy found under a new name is recorded there
+func archivedByHash(documentManifest *manifest, rawURL string, language string) bool {
+	entry, found := documentManifest.lookup(rawURL, language)
+	if !found || entry.SHA256 == "" {
+		return false // Nothing recorded for this URL; it has to be downloaded
+	}
+	if digest, err := archiveStorage.Hash(entry.File); err == nil && digest == entry.SHA256 {
+		return true // Still where the manifest says, unchanged
+	}
+	keys := archiveHashes.lookup(entry.SHA256)
+	if len(keys) == 0 {
+		return false // Missing or modified; download it again
+	}
+	log.Printf("%s was moved from %s to %s; updating the manifest", rawURL, entry.File, keys[0])
+	entry.File = keys[0]
+	documentManifest.record(entry)
+	return true
+}
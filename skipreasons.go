@@ -0,0 +1,88 @@
+package main // Enumerated reasons a discovered document wasn't downloaded, totalled in the run report
+
+import (
+	"errors"  // Declares the errors that turn into skips
+	"fmt"     // Formats the totals
+	"strings" // Joins the totals
+)
+
+// Why a document wasn't downloaded
+type skipReason string
+
+// Skip reasons, in the order the run report lists them
+const (
+	skipExists           skipReason = "exists"            // Already archived and unchanged
+	skipDuplicateContent skipReason = "duplicate-content" // The same bytes are already archived for another URL
+	skipFiltered         skipReason = "filtered"          // Excluded by -inventory-only, -sds-only or -profiles
+	skipDisallowedHost   skipReason = "disallowed-host"   // Its host isn't in -download-hosts
+	skipMissing          skipReason = "missing"           // The server sent a "not found" page with a 200 status: the document was removed
+	skipNonPDF           skipReason = "non-pdf"           // The server sent something that isn't a supported document
+	skipTooLarge         skipReason = "too-large"         // Larger than -max-file-size
+	skipQuota            skipReason = "quota"             // Storing it would exceed -max-archive-size
+	skipOutsideWindow    skipReason = "outside-window"    // The scrape window closed during the run
+	skipCircuitOpen      skipReason = "circuit-open"      // Its host kept failing
+	skipInfected         skipReason = "infected"          // Flagged by clamd and quarantined
+	skipDeadline         skipReason = "deadline"          // -run-deadline ran out before it was reached; queued for the next run
+)
+
+var skipReasons = []skipReason{skipExists, skipDuplicateContent, skipFiltered, skipDisallowedHost, skipMissing, skipNonPDF, skipTooLarge, skipQuota, skipOutsideWindow, skipCircuitOpen, skipInfected, skipDeadline}
+
+var (
+	errUnrecognizedContent = errors.New("unrecognized content") // The body isn't a PDF or another supported document
+	errFileTooLarge        = errors.New("file too large")       // The body is larger than -max-file-size
+)
+
+var maxFileSize int64 = 0 // Documents larger than this many bytes are skipped; 0 allows any size
+
+// Parses the -max-file-size option, e.g. 50MB
+func setMaxFileSize(value string) error {
+	size, ok := parseByteSize(value)
+	if !ok {
+		return fmt.Errorf("-max-file-size must be a size such as 50MB or 1GiB")
+	}
+	maxFileSize = size
+	return nil
+}
+
+// Returns the outcome of a document skipped for a reason
+func skippedOutcome(reason skipReason, message string) documentOutcome {
+	return documentOutcome{Status: outcomeSkipped, Reason: reason, Message: message}
+}
+
+// Returns the skip reason a permanent download error stands for, or "" when the error is a real failure
+func skipReasonFor(err error) skipReason {
+	switch {
+	case errors.Is(err, errSoftNotFound):
+		return skipMissing
+	case errors.Is(err, errUnrecognizedContent):
+		return skipNonPDF
+	case errors.Is(err, errFileTooLarge):
+		return skipTooLarge
+	}
+	return ""
+}
+
+// Counts the skipped outcomes by reason
+func countSkipReasons(outcomes []documentOutcome) map[skipReason]int {
+	counts := make(map[skipReason]int)
+	for _, outcome := range outcomes {
+		if outcome.Status == outcomeSkipped {
+			counts[outcome.Reason]++
+		}
+	}
+	return counts
+}
+
+// Formats skip totals in report order, e.g. "exists 12, filtered 3"
+func formatSkipReasons(counts map[skipReason]int) string {
+	var parts []string
+	for _, reason := range skipReasons {
+		if counts[reason] > 0 {
+			parts = append(parts, fmt.Sprintf("%s %d", reason, counts[reason]))
+		}
+	}
+	if counts[""] > 0 { // Outcomes recorded without a reason
+		parts = append(parts, fmt.Sprintf("other %d", counts[""]))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,98 @@
+package main // Content sniffing for downloaded documents
+
+import (
+	"bytes"    // Compares magic numbers
+	"net/http" // Falls back to the standard sniffing algorithm
+	"slices"   // De-duplicates directories
+	"strings"  // Normalizes detected types
+)
+
+var (
+	docOutputDir         = "DOCs/"         // Directory for Word and RTF documents served from PDF links
+	spreadsheetOutputDir = "Spreadsheets/" // Directory for Excel workbooks
+)
+
+// Where a kind of document is stored and the extension it is saved with
+type documentKind struct {
+	Dir       *string // Output root; a pointer so flags and tests can move it
+	Extension string  // Extension the saved file gets; empty keeps the one from the URL
+	MIME      string  // Substring of the Content-Type servers should send for this kind
+}
+
+// Every kind a run can store, keyed by the sniffed kind or, for types -accept adds, the MIME type
+var documentKinds = map[string]documentKind{
+	"pdf":  {&pdfOutputDir, ".pdf", "pdf"},
+	"zip":  {&zipOutputDir, ".zip", "zip"},
+	"docx": {&docOutputDir, ".docx", "wordprocessingml"},
+	"xlsx": {&spreadsheetOutputDir, ".xlsx", "spreadsheetml"},
+	"doc":  {&docOutputDir, ".doc", "msword"},
+	"rtf":  {&docOutputDir, ".rtf", "rtf"},
+}
+
+// Number of leading bytes inspected; PDF allows junk before the %PDF- header within the first 1024 bytes
+const sniffLength = 1024
+
+// Identifies a document from its first bytes: pdf, zip, docx, xlsx, doc, rtf, html, or "" when unrecognized
+func sniffDocumentKind(head []byte) string {
+	if len(head) > sniffLength {
+		head = head[:sniffLength]
+	}
+	switch {
+	case bytes.Contains(head, []byte("%PDF-")):
+		return "pdf"
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		if bytes.Contains(head, []byte("xl/")) {
+			return "xlsx"
+		}
+		if bytes.Contains(head, []byte("word/")) || bytes.Contains(head, []byte("[Content_Types].xml")) {
+			return "docx" // Office Open XML documents are ZIP containers; refineOfficeKind tells them apart once complete
+		}
+		return "zip"
+	case bytes.HasPrefix(head, []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}):
+		return "doc" // OLE2 compound file used by legacy Word
+	case bytes.HasPrefix(head, []byte(`{\rtf`)):
+		return "rtf"
+	}
+	if strings.HasPrefix(http.DetectContentType(head), "text/html") {
+		return "html" // Usually an error or login page served with a 200
+	}
+	return ""
+}
+
+// Returns every directory documents are stored under, sorted: each kind's directory and the labels
+func archiveDirs() []string {
+	dirs := []string{labelOutputDir}
+	for _, entry := range documentKinds { // The PDF kind points at the PDF tree
+		dirs = append(dirs, *entry.Dir)
+	}
+	slices.Sort(dirs)
+	return slices.Compact(dirs)
+}
+
+// Tells Word documents and Excel workbooks apart from the part names in the complete ZIP container, which the
+// first bytes often don't include
+func refineOfficeKind(kind string, data []byte) string {
+	if kind != "docx" && kind != "xlsx" {
+		return kind
+	}
+	switch {
+	case bytes.Contains(data, []byte("xl/workbook")):
+		return "xlsx"
+	case bytes.Contains(data, []byte("word/document")):
+		return "docx"
+	}
+	return kind
+}
+
+// Moves a storage key from the PDF tree to the directory and extension of the sniffed kind
+func routeByKind(filePath string, kind string) string {
+	target, known := documentKinds[kind]
+	if !known || kind == "pdf" {
+		return filePath
+	}
+	relative := strings.TrimPrefix(filePath, strings.TrimSuffix(pdfOutputDir, "/")+"/") // Keep any domain subdirectory
+	if target.Extension != "" {
+		relative = strings.TrimSuffix(relative, getFileExtension(relative)) + target.Extension
+	}
+	return strings.TrimSuffix(*target.Dir, "/") + "/" + relative
+}
@@ -0,0 +1,46 @@
+package main // Recognizing "document not found" pages that vendors serve with a 200 status instead of a 404
+
+import (
+	"bytes"   // Searches the page text
+	"errors"  // Declares the soft-404 error
+	"regexp"  // Extracts the title and strips markup
+	"strings" // Checks the Content-Type
+)
+
+var errSoftNotFound = errors.New("not-found page served with status 200") // The document is gone, though the server didn't say so with its status
+
+const softNotFoundScanLength = 64 * 1024 // Bytes of a page read to look for not-found wording; error pages are small
+
+// Phrases that mark a page as a not-found page when they appear in its title or text, lowercased
+var softNotFoundPhrases = []string{
+	"page not found", "file not found", "document not found", "not found on this server", "no longer available",
+	"does not exist", "doesn't exist", "could not be found", "couldn't be found", "cannot be found", "can't be found",
+	"has been removed", "has been deleted", "no longer exists",
+}
+
+var (
+	htmlTitlePattern  = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)                 // Title of the page
+	htmlIgnoredBlocks = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>`) // Markup whose text isn't shown
+	htmlTagPattern    = regexp.MustCompile(`(?s)<[^>]*>`)                                    // Any tag
+)
+
+// Reports whether a body sent in place of a document is a not-found page: an HTML or text page whose title mentions
+// 404 or "not found", or whose text uses one of the not-found phrases
+func isSoftNotFound(kind string, contentType string, body []byte) bool {
+	contentType = strings.ToLower(contentType)
+	if kind != "html" && !strings.Contains(contentType, "text/html") && !strings.Contains(contentType, "text/plain") {
+		return false // Only pages can be error pages
+	}
+	lowered := bytes.ToLower(body)
+	if title := htmlTitlePattern.FindSubmatch(lowered); title != nil && (bytes.Contains(title[1], []byte("404")) || bytes.Contains(title[1], []byte("not found"))) {
+		return true
+	}
+	text := htmlTagPattern.ReplaceAll(htmlIgnoredBlocks.ReplaceAll(lowered, nil), []byte(" "))
+	text = bytes.Join(bytes.Fields(text), []byte(" ")) // Phrases may be broken across lines
+	for _, phrase := range softNotFoundPhrases {
+		if bytes.Contains(text, []byte(phrase)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package main // Subcommands running a single stage of a scrape, so discovery, downloading and verification can be scripted separately
+
+import (
+	"context"       // Resolves shortened links given by hand
+	"encoding/json" // Reads and writes discovered document lists
+	"flag"          // Copies the global options onto each stage
+	"fmt"           // Prints verification results
+	"io"            // Reads document lists from standard input
+	"log"           // Reports which discovery is downloaded
+	"os"            // Opens document lists and standard streams
+	"strings"       // Recognizes plain URL arguments
+	"time"          // Timestamps discovery artifacts
+)
+
+var (
+	plannedDocuments   []pdfDocument       // Documents the download subcommand fetches instead of discovering them; nil discovers
+	discoveredListPath = "discovered.json" // Discovery artifact written by scrape and discover and read by download; empty disables it
+)
+
+// The discovered documents of a run, saved so they can be reviewed, edited and then fetched by download
+type discoveryArtifact struct {
+	RunID        string           `json:"run_id"`        // Run that discovered them
+	DiscoveredAt time.Time        `json:"discovered_at"` // When discovery finished
+	Documents    []listedDocument `json:"documents"`     // Normalized, de-duplicated documents in discovery order
+}
+
+// A document as listed by discover and read by download
+type listedDocument struct {
+	URL        string   `json:"url"`                  // Normalized document URL
+	Category   string   `json:"category,omitempty"`   // Page heading it was listed under
+	Anchor     string   `json:"anchor,omitempty"`     // Link text
+	Resolution []string `json:"resolution,omitempty"` // Shortened or tracking link resolved to URL, and the hops between
+}
+
+// Returns a flag set for a stage subcommand holding every global option, so they are given after the subcommand
+// just as they would be without one
+func stageFlags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flag.CommandLine.VisitAll(func(option *flag.Flag) {
+		flags.Var(option.Value, option.Name, option.Usage)
+	})
+	return flags
+}
+
+// Runs the discover subcommand: crawls the listing pages and writes the document links found as JSON, for download
+// or other tools to consume
+func runDiscover(args []string) error {
+	flags := stageFlags("discover")
+	output := flags.String("o", "", `file the discovery artifact is written to ("-" writes to standard output; defaults to -discovered)`)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := prepareRun(); err != nil {
+		return err
+	}
+	lock, err := acquireRunLock(lockFilePath) // Discovery updates the page cache, frontier and seen-set
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	documents := discoverDocuments(scrapeTargets)
+	if len(documents) == 0 {
+		return fmt.Errorf("no documents were discovered; see %s for the listing pages as fetched", discoveryDebugDir)
+	}
+	if *output == "" {
+		*output = discoveredListPath
+	}
+	if *output == "" {
+		return fmt.Errorf("-o is required when -discovered is empty")
+	}
+	return saveDiscoveryArtifact(*output, documents)
+}
+
+// Writes the documents a run discovered to a discovery artifact, or to standard output for "-"
+func saveDiscoveryArtifact(filePath string, documents []pdfDocument) error {
+	artifact := discoveryArtifact{RunID: runID(), DiscoveredAt: time.Now().UTC(), Documents: []listedDocument{}}
+	for _, document := range documents {
+		artifact.Documents = append(artifact.Documents, listedDocument{URL: document.URL, Category: document.Category, Anchor: document.Anchor, Resolution: document.Resolution})
+	}
+	data, err := json.MarshalIndent(artifact, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if filePath == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return writeFileAtomic(filePath, data)
+}
+
+// Runs the download subcommand: downloads the documents in a discovery artifact, or given as URL arguments, exactly
+// as a scrape would without crawling the listing pages
+func runDownload(args []string) error {
+	flags := stageFlags("download")
+	input := flags.String("from", "", `discovery artifact written by scrape or discover, or a JSON list of {"url": ...} documents ("-" reads standard input; defaults to -discovered unless URLs are given as arguments)`)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *input == "" && flags.NArg() == 0 {
+		*input = discoveredListPath
+	}
+	var documents []pdfDocument
+	if *input != "" {
+		listed, err := readListedDocuments(*input)
+		if err != nil {
+			return err
+		}
+		for _, document := range listed {
+			documents = append(documents, pdfDocument{URL: document.URL, Category: document.Category, Anchor: document.Anchor, Resolution: document.Resolution})
+		}
+	}
+	for _, arg := range flags.Args() {
+		documents = append(documents, pdfDocument{URL: arg})
+	}
+	if len(documents) == 0 {
+		return fmt.Errorf("nothing to download: give -from, -discovered or document URLs")
+	}
+	for i, document := range documents {
+		normalized, err := normalizeURL(document.URL, document.URL) // Lists edited by hand are canonicalized like discovered links
+		if err != nil || !strings.HasPrefix(normalized, "http://") && !strings.HasPrefix(normalized, "https://") {
+			return fmt.Errorf("%q isn't an http or https URL", document.URL)
+		}
+		documents[i].URL = normalized
+		if isWrappedLink(normalized) { // Shortened links given by hand are resolved like discovered ones
+			chain, err := resolveLink(context.Background(), normalized)
+			if err != nil {
+				return fmt.Errorf("resolving %s: %w", normalized, err)
+			}
+			documents[i].URL, documents[i].Resolution = chain[len(chain)-1], chain
+		}
+	}
+	plannedDocuments = documents
+	return performRun()
+}
+
+// Reads the documents of a discovery artifact, or of a bare JSON list of them as written by hand
+func readListedDocuments(filePath string) ([]listedDocument, error) {
+	var data []byte
+	var err error
+	if filePath == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(filePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var listed []listedDocument
+	if err := json.Unmarshal(data, &listed); err == nil {
+		return listed, nil
+	}
+	var artifact discoveryArtifact
+	if err := json.Unmarshal(data, &artifact); err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	log.Printf("Downloading the %d documents run %s discovered at %s", len(artifact.Documents), artifact.RunID, artifact.DiscoveredAt.Format(time.RFC3339))
+	return artifact.Documents, nil
+}
+
+// Runs the verify subcommand: checks that every file in the manifest is still archived with the recorded SHA-256,
+// failing when any is missing or altered
+func runVerify(args []string) error {
+	flags := stageFlags("verify")
+	manifestPath := flags.String("manifest", manifestFilePath, "manifest describing the archive")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	storage, err := newStorage(storageBackend, storageURL) // Verify the archive downloads are written to
+	if err != nil {
+		return err
+	}
+	entries := loadManifest(*manifestPath).list()
+	missing, altered := 0, 0
+	for _, entry := range entries {
+		exists, err := storage.Exists(entry.File)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			fmt.Printf("missing  %s (%s)\n", entry.File, entry.URL)
+			missing++
+			continue
+		}
+		digest, err := storage.Hash(entry.File)
+		if err != nil {
+			return err
+		}
+		if digest != entry.SHA256 {
+			fmt.Printf("altered  %s: sha256 %s, manifest records %s\n", entry.File, digest, entry.SHA256)
+			altered++
+		}
+	}
+	fmt.Printf("%d documents checked: %d intact, %d missing, %d altered\n", len(entries), len(entries)-missing-altered, missing, altered)
+	if missing > 0 || altered > 0 {
+		return fmt.Errorf("the archive doesn't match %s", *manifestPath)
+	}
+	return nil
+}
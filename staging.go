@@ -0,0 +1,300 @@
+package main // Per-run staging of downloads, promoted into the archive only when the whole run validates
+
+import (
+	"encoding/json" // Reads and writes promotion records
+	"errors"        // Collects promotion failures
+	"flag"          // Registers the staging options
+	"fmt"           // Builds validation errors
+	"log"           // Reports promotions and discarded runs
+	"os"            // Moves staged files and removes staging directories
+	"path/filepath" // Builds staging paths
+	"sort"          // Lists keys in order
+	"strings"       // Matches key prefixes
+	"sync"          // Guards the staged and deleted sets
+)
+
+var (
+	stageRuns        = false      // Whether runs write to a staging directory and promote it only when the run validates
+	stagingDir       = ".staging" // Local directory holding one subdirectory per staged run
+	stageMaxFailures = 0          // Failed documents a staged run may have and still be promoted
+)
+
+const (
+	promotionRecordName = "promote.json" // Written before promotion starts, so an interrupted promotion can be finished
+	exitStagingRejected = 4              // Exit status of runs whose staged downloads weren't promoted
+)
+
+// Registers the staging options on a flag set
+func addStagingFlags(flags *flag.FlagSet) {
+	flags.BoolVar(&stageRuns, "stage", stageRuns, "download into a per-run directory below -staging-dir and move the files into the archive only once the run validates, so a failed run leaves the archive untouched")
+	flags.StringVar(&stagingDir, "staging-dir", stagingDir, "local directory holding the files of staged runs until they are promoted")
+	flags.IntVar(&stageMaxFailures, "stage-max-failures", stageMaxFailures, "failed documents a staged run may have and still be promoted")
+}
+
+// Checks the staging options
+func validateStaging() error {
+	if stageMaxFailures < 0 {
+		return fmt.Errorf("-stage-max-failures can't be negative")
+	}
+	return nil
+}
+
+// What a promotion moves into the archive, recorded before the first file is moved
+type stagingPromotion struct {
+	RunID    string          `json:"run_id"`            // Run the staged files belong to
+	Staged   []string        `json:"staged"`            // Keys written during the run
+	Deleted  []string        `json:"deleted,omitempty"` // Keys the run removed from the archive
+	Manifest []manifestEntry `json:"manifest"`          // Manifest describing the archive once promoted
+}
+
+// Collects a run's writes and deletions in a local directory and shows the run the archive as it will be once
+// promoted; nothing reaches the archive before promote
+type stagingStorage struct {
+	inner   Storage         // Canonical archive
+	dir     string          // This run's staging directory
+	files   localStorage    // Staged files below dir
+	mu      sync.Mutex      // Protects staged and deleted
+	staged  map[string]bool // Keys written during the run
+	deleted map[string]bool // Keys removed during the run and not written again
+}
+
+// Creates this run's staging directory in front of the archive
+func newStagingStorage(inner Storage) (*stagingStorage, error) {
+	dir := filepath.Join(stagingDir, runID())
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &stagingStorage{inner: inner, dir: dir, files: localStorage{root: dir}, staged: make(map[string]bool), deleted: make(map[string]bool)}, nil
+}
+
+// Stages data under key
+func (s *stagingStorage) Put(key string, data []byte) error {
+	if err := s.files.Put(key, data); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.staged[key] = true
+	delete(s.deleted, key)
+	return nil
+}
+
+// Reports whether key was staged, and whether it was removed during the run
+func (s *stagingStorage) state(key string) (bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.staged[key], s.deleted[key]
+}
+
+// Returns the staged copy of key, or the archived one
+func (s *stagingStorage) Get(key string) ([]byte, error) {
+	staged, deleted := s.state(key)
+	switch {
+	case staged:
+		return s.files.Get(key)
+	case deleted:
+		return nil, fmt.Errorf("%s: %w", key, os.ErrNotExist)
+	}
+	return s.inner.Get(key)
+}
+
+// Reports whether key is staged or archived and not removed
+func (s *stagingStorage) Exists(key string) (bool, error) {
+	staged, deleted := s.state(key)
+	switch {
+	case staged:
+		return true, nil
+	case deleted:
+		return false, nil
+	}
+	return s.inner.Exists(key)
+}
+
+// Hashes the staged copy of key, or the archived one
+func (s *stagingStorage) Hash(key string) (string, error) {
+	staged, deleted := s.state(key)
+	switch {
+	case staged:
+		return s.files.Hash(key)
+	case deleted:
+		return "", fmt.Errorf("%s: %w", key, os.ErrNotExist)
+	}
+	return s.inner.Hash(key)
+}
+
+// Lists archived keys that weren't removed together with the staged ones
+func (s *stagingStorage) List(prefix string) ([]string, error) {
+	archived, err := s.inner.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make(map[string]bool)
+	for _, key := range archived {
+		if !s.deleted[key] {
+			keys[key] = true
+		}
+	}
+	for key := range s.staged {
+		if strings.HasPrefix(key, prefix) {
+			keys[key] = true
+		}
+	}
+	listed := make([]string, 0, len(keys))
+	for key := range keys {
+		listed = append(listed, key)
+	}
+	sort.Strings(listed)
+	return listed, nil
+}
+
+// Unstages key and records its removal from the archive for the promotion
+func (s *stagingStorage) Delete(key string) error {
+	if err := s.files.Delete(key); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.staged, key)
+	s.deleted[key] = true
+	return nil
+}
+
+// Checks that the run may be promoted: no more failures than allowed, and every document it stored staged intact
+func (s *stagingStorage) validate(documentManifest *manifest, outcomes []documentOutcome) error {
+	failed := 0
+	for _, outcome := range outcomes {
+		switch outcome.Status {
+		case outcomeFailed:
+			failed++
+		case outcomeDownloaded:
+			entry, found := documentManifest.lookup(outcome.URL, outcome.Language)
+			if !found {
+				return fmt.Errorf("%s was stored but isn't in the manifest", outcome.URL)
+			}
+			if digest, err := s.Hash(entry.File); err != nil || digest != entry.SHA256 {
+				return fmt.Errorf("staged copy of %s doesn't match the manifest", entry.File)
+			}
+		}
+	}
+	if failed > stageMaxFailures {
+		return fmt.Errorf("%d documents failed (-stage-max-failures %d)", failed, stageMaxFailures)
+	}
+	return nil
+}
+
+// Moves every staged file into the archive, applies the run's deletions and saves the manifest. The plan is
+// recorded first, so a promotion cut short is finished by the next run instead of leaving a mix of old and new
+func (s *stagingStorage) promote(documentManifest *manifest) error {
+	promotion := stagingPromotion{RunID: runID(), Manifest: documentManifest.all()}
+	s.mu.Lock()
+	for key := range s.staged {
+		promotion.Staged = append(promotion.Staged, key)
+	}
+	for key := range s.deleted {
+		promotion.Deleted = append(promotion.Deleted, key)
+	}
+	s.mu.Unlock()
+	sort.Strings(promotion.Staged)
+	sort.Strings(promotion.Deleted)
+	data, err := json.Marshal(promotion)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(s.dir, promotionRecordName), data); err != nil {
+		return err
+	}
+	return applyPromotion(promotion, s.inner, s.dir)
+}
+
+// Removes the staged files of a run that won't be promoted
+func (s *stagingStorage) discard() {
+	if err := os.RemoveAll(s.dir); err != nil {
+		log.Printf("Failed to remove %s: %v", s.dir, err)
+	}
+}
+
+// Carries out a recorded promotion; files already moved by an interrupted attempt are skipped
+func applyPromotion(promotion stagingPromotion, inner Storage, dir string) error {
+	staged := localStorage{root: dir}
+	var errs []error
+	for _, key := range promotion.Staged {
+		if !fileExists(staged.path(key)) {
+			continue // Moved before the interruption
+		}
+		if err := promoteFile(staged, inner, key); err != nil {
+			errs = append(errs, fmt.Errorf("promoting %s: %w", key, err))
+		}
+	}
+	for _, key := range promotion.Deleted {
+		if err := inner.Delete(key); err != nil {
+			errs = append(errs, fmt.Errorf("deleting %s: %w", key, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...) // The record stays, so the next run retries
+	}
+	promoted := newManifest()
+	for _, entry := range promotion.Manifest {
+		promoted.entries[entry.key()] = entry
+	}
+	promoted.save(manifestFilePath)
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("Failed to remove %s: %v", dir, err)
+	}
+	log.Printf("Promoted %d staged files of run %s into the archive", len(promotion.Staged), promotion.RunID)
+	return nil
+}
+
+// Moves one staged file into the archive: a rename when both are on the same local filesystem, a copy otherwise
+func promoteFile(staged localStorage, inner Storage, key string) error {
+	if local, ok := inner.(localStorage); ok {
+		destination := local.path(key)
+		if err := os.MkdirAll(filepath.Dir(destination), 0o755); err != nil {
+			return err
+		}
+		err := retrySharingViolation("promoting "+key, func() error { return os.Rename(staged.path(key), destination) })
+		if err == nil {
+			return nil
+		}
+		log.Printf("Copying %s instead of renaming it: %v", key, err) // e.g. staging on another device
+	}
+	data, err := staged.Get(key)
+	if err != nil {
+		return err
+	}
+	if err := inner.Put(key, data); err != nil {
+		return err
+	}
+	return staged.Delete(key)
+}
+
+// Finishes promotions interrupted by a crash and removes the staging directories of runs that never got that far;
+// called with the run lock held, before the manifest is loaded
+func recoverStaging(inner Storage) {
+	runs, err := os.ReadDir(stagingDir)
+	if err != nil {
+		return // No staging directory yet
+	}
+	for _, run := range runs {
+		dir := filepath.Join(stagingDir, run.Name())
+		data, err := os.ReadFile(filepath.Join(dir, promotionRecordName))
+		if err != nil {
+			log.Printf("Discarding the staged files of interrupted run %s", run.Name())
+			if err := os.RemoveAll(dir); err != nil {
+				log.Println(err)
+			}
+			continue
+		}
+		var promotion stagingPromotion
+		if err := json.Unmarshal(data, &promotion); err != nil {
+			log.Printf("Not finishing the promotion of run %s: %v", run.Name(), err)
+			continue
+		}
+		log.Printf("Finishing the interrupted promotion of run %s", promotion.RunID)
+		if err := applyPromotion(promotion, inner, dir); err != nil {
+			log.Printf("Promotion of run %s is still incomplete: %v", promotion.RunID, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main // Live run statistics that every worker reports into, safe to update and read from any goroutine
+
+import (
+	"sync/atomic" // Lock-free counters
+	"time"        // Measures the run's elapsed time
+)
+
+// Counters of the current run. Each one is updated atomically, so downloaders, processors and the HTTP transport
+// report without sharing a lock; a snapshot is consistent per counter, not across them
+type statsCollector struct {
+	started       atomic.Int64 // Unix nanoseconds when the run began
+	queued        atomic.Int64 // Documents and language variants queued for download
+	inFlight      atomic.Int64 // Documents being downloaded or processed right now
+	finished      atomic.Int64 // Documents done with, whatever the outcome
+	downloaded    atomic.Int64 // Documents newly archived
+	skipped       atomic.Int64 // Documents skipped, for any reason
+	failed        atomic.Int64 // Documents that couldn't be archived
+	requests      atomic.Int64 // HTTP requests sent, listing pages and redirect hops included
+	requestErrors atomic.Int64 // Requests that got no response
+	bytesReceived atomic.Int64 // Response body bytes read
+}
+
+// The counters at one moment
+type statsSnapshot struct {
+	Elapsed       time.Duration // Time since the run began
+	Queued        int64         // Documents queued for download
+	InFlight      int64         // Documents being worked on
+	Finished      int64         // Documents done with
+	Downloaded    int64         // Documents newly archived
+	Skipped       int64         // Documents skipped
+	Failed        int64         // Documents that couldn't be archived
+	Requests      int64         // HTTP requests sent
+	RequestErrors int64         // Requests that got no response
+	BytesReceived int64         // Response body bytes read
+}
+
+var runStats = &statsCollector{} // Counters of the run in progress, reset when a run starts
+
+// Zeroes the counters for a run starting at the given time
+func (c *statsCollector) reset(started time.Time) {
+	for _, counter := range []*atomic.Int64{&c.queued, &c.inFlight, &c.finished, &c.downloaded, &c.skipped, &c.failed, &c.requests, &c.requestErrors, &c.bytesReceived} {
+		counter.Store(0)
+	}
+	c.started.Store(started.UnixNano())
+}
+
+// Counts documents added to the download queue
+func (c *statsCollector) documentsQueued(n int) {
+	c.queued.Add(int64(n))
+}
+
+// Counts a document a worker started on
+func (c *statsCollector) documentStarted() {
+	c.inFlight.Add(1)
+}
+
+// Counts a document a worker is done with, by its outcome status
+func (c *statsCollector) documentFinished(status string) {
+	c.inFlight.Add(-1)
+	c.finished.Add(1)
+	switch status {
+	case outcomeDownloaded:
+		c.downloaded.Add(1)
+	case outcomeSkipped:
+		c.skipped.Add(1)
+	case outcomeFailed:
+		c.failed.Add(1)
+	}
+}
+
+// Counts an HTTP request; failed marks one that got no response
+func (c *statsCollector) requestSent(failed bool) {
+	c.requests.Add(1)
+	if failed {
+		c.requestErrors.Add(1)
+	}
+}
+
+// Counts response body bytes read
+func (c *statsCollector) received(n int) {
+	c.bytesReceived.Add(int64(n))
+}
+
+// Returns the current value of every counter
+func (c *statsCollector) snapshot() statsSnapshot {
+	snapshot := statsSnapshot{
+		Queued:        c.queued.Load(),
+		InFlight:      c.inFlight.Load(),
+		Finished:      c.finished.Load(),
+		Downloaded:    c.downloaded.Load(),
+		Skipped:       c.skipped.Load(),
+		Failed:        c.failed.Load(),
+		Requests:      c.requests.Load(),
+		RequestErrors: c.requestErrors.Load(),
+		BytesReceived: c.bytesReceived.Load(),
+	}
+	if started := c.started.Load(); started != 0 {
+		snapshot.Elapsed = time.Since(time.Unix(0, started))
+	}
+	return snapshot
+}
+
+// Returns the snapshot as status fields, with the names GetRunStatus reports
+func (s statsSnapshot) fields() map[string]any {
+	return map[string]any{
+		"elapsed_seconds": s.Elapsed.Seconds(),
+		"queued":          s.Queued,
+		"in_flight":       s.InFlight,
+		"finished":        s.Finished,
+		"downloaded":      s.Downloaded,
+		"skipped":         s.Skipped,
+		"failed":          s.Failed,
+		"requests":        s.Requests,
+		"request_errors":  s.RequestErrors,
+		"bytes_received":  s.BytesReceived,
+	}
+}
@@ -0,0 +1,165 @@
+package main // Pluggable persistence for downloaded documents
+
+import (
+	"crypto/sha256" // Hashes stored files
+	"encoding/hex"  // Encodes digests as hex
+	"flag"          // Registers the storage options
+	"fmt"           // Builds error messages
+	"io"            // Streams files into the hasher
+	"io/fs"         // Walks the local archive
+	"os"            // Reads and writes local files
+	"path/filepath" // Converts between keys and local paths
+	"strings"       // Parses the storage selection
+)
+
+var (
+	storageBackend = "local" // Storage backend used for downloads: local, s3 or webdav
+	storageURL     = ""      // Location of the archive for remote backends (bucket URL or WebDAV collection)
+)
+
+// Persists documents under slash-separated keys such as "PDFs/product.pdf"
+type Storage interface {
+	Put(key string, data []byte) error    // Stores data under key, replacing any existing object
+	Get(key string) ([]byte, error)       // Returns the object stored under key
+	Exists(key string) (bool, error)      // Reports whether an object is stored under key
+	Hash(key string) (string, error)      // Returns the hex-encoded SHA-256 digest of the stored object
+	List(prefix string) ([]string, error) // Returns every key that starts with prefix
+	Delete(key string) error              // Removes the object stored under key; missing objects are not an error
+}
+
+// Registers the -storage and -storage-url options on a flag set
+func addStorageFlags(flags *flag.FlagSet) {
+	flags.StringVar(&storageBackend, "storage", storageBackend, "where downloads are stored: local, s3 or webdav")
+	flags.StringVar(&storageURL, "storage-url", storageURL, "local root directory, S3 bucket URL or WebDAV collection URL; credentials are read from AWS_* or WEBDAV_* environment variables")
+	flags.StringVar(&storageLayout, "layout", storageLayout, "storage layout: path stores files under their names, content stores each file once under objects/ by SHA-256 (content-addressed) with links and an index mapping names to it")
+	flags.StringVar(&objectIndexPath, "object-index", objectIndexPath, "index of the content layout mapping readable names to objects")
+}
+
+var archiveStorage Storage = localStorage{root: "."} // Storage used by the download pipeline
+
+// Creates the storage backend selected on the command line in the selected layout; credentials come from the environment
+func newStorage(backend string, location string) (Storage, error) {
+	storage, err := newStorageBackend(backend, location)
+	if err != nil {
+		return nil, err
+	}
+	switch storageLayout {
+	case "path":
+		return storage, nil
+	case "content":
+		return newObjectStorage(storage)
+	default:
+		return nil, fmt.Errorf("unknown -layout %q (expected path or content)", storageLayout)
+	}
+}
+
+// Creates the storage backend holding the files
+func newStorageBackend(backend string, location string) (Storage, error) {
+	switch strings.ToLower(backend) {
+	case "", "local":
+		root := location // Local archives default to the working directory
+		if root == "" {
+			root = "."
+		}
+		return localStorage{root: root}, nil
+	case "s3":
+		return newS3Storage(location)
+	case "webdav":
+		return newWebDAVStorage(location)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q (expected local, s3 or webdav)", backend)
+	}
+}
+
+// Stores documents on the local filesystem below root
+type localStorage struct {
+	root string // Directory keys are resolved against
+}
+
+// Converts a key into a path on disk
+func (s localStorage) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key)) // Keys always use forward slashes
+}
+
+// Writes data to disk and removes the file again if it ends up incomplete
+func (s localStorage) Put(key string, data []byte) error {
+	filePath := s.path(key)                                            // Destination on disk
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil { // Create parent directories on demand
+		return err
+	}
+	if info, err := os.Lstat(filePath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(filePath); err != nil { // Never write through a content layout link into the shared object
+			return err
+		}
+	}
+	return writeFileVerified(filePath, data) // Write and verify the file
+}
+
+// Reads the file stored under key
+func (s localStorage) Get(key string) ([]byte, error) {
+	return os.ReadFile(s.path(key))
+}
+
+// Reports whether a regular file exists for key
+func (s localStorage) Exists(key string) (bool, error) {
+	return fileExists(s.path(key)), nil // Directories don't count
+}
+
+// Hashes the file stored under key
+func (s localStorage) Hash(key string) (string, error) {
+	file, err := os.Open(s.path(key)) // Open the stored file
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()                           // Stream the file through SHA-256
+	if _, err := io.Copy(hasher, file); err != nil { // Avoid loading large files into memory
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil // Return the hex digest
+}
+
+// Replaces the file at key with a relative symlink to the file at target
+func (s localStorage) Link(key string, target string) error {
+	linkPath := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(linkPath), 0o755); err != nil {
+		return err
+	}
+	relative, err := filepath.Rel(filepath.Dir(linkPath), s.path(target)) // Relative links survive moving the archive
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(linkPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(relative, linkPath)
+}
+
+// Removes the file stored under key
+func (s localStorage) Delete(key string) error {
+	err := removeShared(s.path(key)) // Delete the file, waiting for other clients of a share to close it
+	if os.IsNotExist(err) {
+		return nil // Already gone
+	}
+	return err
+}
+
+// Lists every file below root whose key starts with prefix
+func (s localStorage) List(prefix string) ([]string, error) {
+	var keys []string // Matching keys
+	err := filepath.WalkDir(s.root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err // Propagate walk errors and skip directories
+		}
+		relative, err := filepath.Rel(s.root, filePath) // Key is the path relative to root
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(relative); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys, err // Return keys in lexical order
+}
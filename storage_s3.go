@@ -0,0 +1,310 @@
+package main // Amazon S3 (and S3-compatible) storage backend
+
+import (
+	"bytes"         // Wraps request bodies
+	"crypto/hmac"   // Derives the SigV4 signing key
+	"crypto/sha256" // Hashes payloads and canonical requests
+	"encoding/hex"  // Encodes digests for SigV4
+	"encoding/xml"  // Decodes ListObjectsV2 responses
+	"fmt"           // Builds error messages and headers
+	"io"            // Reads response bodies
+	"net/http"      // Talks to the S3 REST API
+	"net/url"       // Builds object URLs and query strings
+	"os"            // Reads credentials from the environment
+	"sort"          // Orders query parameters for signing
+	"strings"       // Builds canonical requests
+	"time"          // Stamps signed requests
+)
+
+// Stores documents in an S3 bucket using path-style requests signed with AWS Signature Version 4
+type s3Storage struct {
+	endpoint     *url.URL     // Bucket URL, e.g. https://s3.us-east-1.amazonaws.com/my-bucket/prefix
+	region       string       // Region used in the signing scope
+	accessKey    string       // AWS_ACCESS_KEY_ID
+	secretKey    string       // AWS_SECRET_ACCESS_KEY
+	sessionToken string       // AWS_SESSION_TOKEN for temporary credentials, if any
+	client       *http.Client // Client used for every request
+}
+
+// Creates an S3 backend for a bucket URL; credentials and region come from the standard AWS variables
+func newS3Storage(bucketURL string) (Storage, error) {
+	endpoint, err := url.Parse(bucketURL) // Bucket URL including the bucket name as the first path segment
+	if err != nil || endpoint.Host == "" || strings.Trim(endpoint.Path, "/") == "" {
+		return nil, fmt.Errorf("s3 storage needs -storage-url like https://s3.<region>.amazonaws.com/<bucket>")
+	}
+	storage := &s3Storage{
+		endpoint:     endpoint,
+		region:       os.Getenv("AWS_REGION"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       &http.Client{Timeout: 3 * time.Minute, Transport: newAuditTransport(nil)},
+	}
+	if storage.region == "" {
+		storage.region = "us-east-1" // Same default as the AWS CLI
+	}
+	if storage.accessKey == "" || storage.secretKey == "" {
+		return nil, fmt.Errorf("s3 storage needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return storage, nil
+}
+
+// Returns the URL of the object stored under key
+func (s *s3Storage) objectURL(key string) *url.URL {
+	objectURL := *s.endpoint                                              // Copy the bucket URL
+	objectURL.Path = strings.TrimSuffix(s.endpoint.Path, "/") + "/" + key // Append the key
+	objectURL.RawPath = ""                                                // Let url.URL escape the new path
+	return &objectURL
+}
+
+// Uploads data and records its SHA-256 as object metadata so Hash needn't download it
+func (s *s3Storage) Put(key string, data []byte) error {
+	request, err := http.NewRequest(http.MethodPut, s.objectURL(key).String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/pdf")
+	request.Header.Set("X-Amz-Meta-Sha256", sha256Hex(data)) // Stored alongside the object
+	response, err := s.do(request, data)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+// Downloads the object
+func (s *s3Storage) Get(key string) ([]byte, error) {
+	request, err := http.NewRequest(http.MethodGet, s.objectURL(key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := s.do(request, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	return io.ReadAll(response.Body)
+}
+
+// Reports whether the object exists using a HEAD request
+func (s *s3Storage) Exists(key string) (bool, error) {
+	response, err := s.head(key)
+	if err != nil {
+		return false, err
+	}
+	return response != nil, nil // A nil response means 404
+}
+
+// Returns the stored SHA-256 metadata, hashing the object contents when the metadata is missing
+func (s *s3Storage) Hash(key string) (string, error) {
+	response, err := s.head(key)
+	if err != nil {
+		return "", err
+	}
+	if response == nil {
+		return "", fmt.Errorf("s3 object %s does not exist", key)
+	}
+	if digest := response.Header.Get("X-Amz-Meta-Sha256"); digest != "" {
+		return digest, nil // Recorded by Put
+	}
+
+	request, err := http.NewRequest(http.MethodGet, s.objectURL(key).String(), nil) // Fall back to downloading
+	if err != nil {
+		return "", err
+	}
+	response, err = s.do(request, nil)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, response.Body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Deletes the object; S3 reports success for missing keys too
+func (s *s3Storage) Delete(key string) error {
+	request, err := http.NewRequest(http.MethodDelete, s.objectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+	response, err := s.do(request, nil)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+// Lists keys below the bucket prefix using ListObjectsV2, following continuation tokens
+func (s *s3Storage) List(prefix string) ([]string, error) {
+	bucketURL := *s.endpoint                                               // Listing is done against the bucket root
+	segments := strings.SplitN(strings.Trim(s.endpoint.Path, "/"), "/", 2) // First segment is the bucket
+	bucketURL.Path = "/" + segments[0]
+	keyPrefix := "" // Extra prefix configured in the bucket URL
+	if len(segments) == 2 {
+		keyPrefix = segments[1] + "/"
+	}
+
+	var keys []string
+	continuation := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {keyPrefix + prefix}}
+		if continuation != "" {
+			query.Set("continuation-token", continuation)
+		}
+		bucketURL.RawQuery = query.Encode()
+		request, err := http.NewRequest(http.MethodGet, bucketURL.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		response, err := s.do(request, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			keys = append(keys, strings.TrimPrefix(object.Key, keyPrefix)) // Report keys relative to the configured prefix
+		}
+		if !result.IsTruncated {
+			return keys, nil
+		}
+		continuation = result.NextContinuationToken
+	}
+}
+
+// Sends a HEAD request, returning a nil response when the object doesn't exist
+func (s *s3Storage) head(key string) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodHead, s.objectURL(key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(request, nil)
+	response, err := s.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+		return response, nil
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("s3 HEAD %s: %s", key, response.Status)
+	}
+}
+
+// Signs and sends a request, turning non-2xx responses into errors
+func (s *s3Storage) do(request *http.Request, payload []byte) (*http.Response, error) {
+	s.sign(request, payload)
+	response, err := s.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 4096)) // Include the S3 error document
+		response.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s %s", request.Method, request.URL.Path, response.Status, strings.TrimSpace(string(body)))
+	}
+	return response, nil
+}
+
+// Adds AWS Signature Version 4 headers to a request
+func (s *s3Storage) sign(request *http.Request, payload []byte) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	signedHeaders := []string{"host"} // Headers covered by the signature
+	for name := range request.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") || lower == "content-type" {
+			signedHeaders = append(signedHeaders, lower)
+		}
+	}
+	sort.Strings(signedHeaders)
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := request.URL.Host
+		if name != "host" {
+			value = strings.TrimSpace(request.Header.Get(name))
+		}
+		canonicalHeaders.WriteString(name + ":" + value + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		awsURIEncode(request.URL.Path, false),
+		canonicalQuery(request.URL.Query()),
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day) // Derive the signing key
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+// Returns HMAC-SHA256(key, data)
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Encodes query parameters in the sorted, strictly escaped form SigV4 requires
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, awsURIEncode(name, true)+"="+awsURIEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// Percent-encodes everything except unreserved characters, optionally keeping slashes
+func awsURIEncode(value string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			encoded.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			encoded.WriteByte(b)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return encoded.String()
+}
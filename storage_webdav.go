@@ -0,0 +1,233 @@
+package main // WebDAV storage backend (Nextcloud, ownCloud, Apache mod_dav, ...)
+
+import (
+	"bytes"         // Wraps request bodies
+	"crypto/sha256" // Hashes downloaded objects
+	"encoding/hex"  // Encodes digests as hex
+	"encoding/xml"  // Decodes PROPFIND multistatus responses
+	"fmt"           // Builds error messages
+	"io"            // Reads response bodies
+	"net/http"      // Talks to the WebDAV server
+	"net/url"       // Resolves collection and resource URLs
+	"os"            // Reads credentials from the environment
+	"path"          // Joins slash-separated keys
+	"strings"       // Trims and compares paths
+	"time"          // Sets the client timeout
+)
+
+// Stores documents below a WebDAV collection using HTTP basic authentication
+type webDAVStorage struct {
+	base     *url.URL     // Collection URL, e.g. https://cloud.example.com/remote.php/dav/files/user/SDS/
+	username string       // WEBDAV_USERNAME
+	password string       // WEBDAV_PASSWORD (an app password for Nextcloud)
+	client   *http.Client // Client used for every request
+}
+
+// Creates a WebDAV backend for a collection URL; credentials come from WEBDAV_USERNAME and WEBDAV_PASSWORD
+func newWebDAVStorage(collectionURL string) (Storage, error) {
+	base, err := url.Parse(collectionURL) // Collection all keys are stored below
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("webdav storage needs -storage-url like https://cloud.example.com/remote.php/dav/files/<user>/<folder>/")
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/" // Collections end with a slash so relative keys resolve inside them
+	}
+	return &webDAVStorage{
+		base:     base,
+		username: os.Getenv("WEBDAV_USERNAME"),
+		password: os.Getenv("WEBDAV_PASSWORD"),
+		client:   &http.Client{Timeout: 3 * time.Minute, Transport: newAuditTransport(nil)},
+	}, nil
+}
+
+// Returns the URL of the resource stored under key
+func (s *webDAVStorage) resourceURL(key string) string {
+	resource := *s.base                         // Copy the collection URL
+	resource.Path = path.Join(s.base.Path, key) // Append the key
+	resource.RawPath = ""                       // Let url.URL escape the new path
+	return resource.String()
+}
+
+// Creates missing parent collections and uploads the data
+func (s *webDAVStorage) Put(key string, data []byte) error {
+	if err := s.makeCollections(path.Dir(key)); err != nil { // PUT fails when the parent is missing
+		return err
+	}
+	response, err := s.request(http.MethodPut, s.resourceURL(key), bytes.NewReader(data), nil)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusCreated && response.StatusCode != http.StatusNoContent && response.StatusCode != http.StatusOK {
+		return fmt.Errorf("webdav PUT %s: %s", key, response.Status)
+	}
+	return nil
+}
+
+// Downloads the resource
+func (s *webDAVStorage) Get(key string) ([]byte, error) {
+	response, err := s.request(http.MethodGet, s.resourceURL(key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("webdav GET %s: %s", key, response.Status)
+	}
+	return io.ReadAll(response.Body)
+}
+
+// Creates every collection along dir, ignoring ones that already exist
+func (s *webDAVStorage) makeCollections(dir string) error {
+	if dir == "." || dir == "" {
+		return nil // The base collection is assumed to exist
+	}
+	current := "" // Collection path built one segment at a time
+	for _, segment := range strings.Split(dir, "/") {
+		current = path.Join(current, segment)
+		response, err := s.request("MKCOL", s.resourceURL(current)+"/", nil, nil)
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+		switch response.StatusCode {
+		case http.StatusCreated, http.StatusMethodNotAllowed: // 405 means the collection already exists
+		default:
+			return fmt.Errorf("webdav MKCOL %s: %s", current, response.Status)
+		}
+	}
+	return nil
+}
+
+// Reports whether a resource exists using a HEAD request
+func (s *webDAVStorage) Exists(key string) (bool, error) {
+	response, err := s.request(http.MethodHead, s.resourceURL(key), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("webdav HEAD %s: %s", key, response.Status)
+	}
+}
+
+// Downloads the resource and hashes it; WebDAV has no portable checksum property
+func (s *webDAVStorage) Hash(key string) (string, error) {
+	response, err := s.request(http.MethodGet, s.resourceURL(key), nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("webdav GET %s: %s", key, response.Status)
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, response.Body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Deletes the resource, treating a missing resource as success
+func (s *webDAVStorage) Delete(key string) error {
+	response, err := s.request(http.MethodDelete, s.resourceURL(key), nil, nil)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode/100 != 2 && response.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("webdav DELETE %s: %s", key, response.Status)
+	}
+	return nil
+}
+
+// Walks the collection tree with Depth: 1 PROPFIND requests and returns keys starting with prefix
+func (s *webDAVStorage) List(prefix string) ([]string, error) {
+	var keys []string
+	pending := []string{""} // Collections still to visit, relative to the base
+	for len(pending) > 0 {
+		collection := pending[0]
+		pending = pending[1:]
+		entries, err := s.propfind(collection)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			switch {
+			case entry.collection:
+				pending = append(pending, entry.key)
+			case strings.HasPrefix(entry.key, prefix):
+				keys = append(keys, entry.key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// A single resource reported by PROPFIND
+type webDAVEntry struct {
+	key        string // Key relative to the base collection
+	collection bool   // Whether the resource is a collection
+}
+
+// Lists the direct children of a collection
+func (s *webDAVStorage) propfind(collection string) ([]webDAVEntry, error) {
+	body := `<?xml version="1.0" encoding="utf-8"?><d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/></d:prop></d:propfind>`
+	target := s.base.String() // The base collection itself
+	if collection != "" {
+		target = s.resourceURL(collection) + "/"
+	}
+	response, err := s.request("PROPFIND", target, strings.NewReader(body), map[string]string{"Depth": "1", "Content-Type": "application/xml"})
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("webdav PROPFIND %s: %s", target, response.Status)
+	}
+
+	var result struct {
+		Responses []struct {
+			Href       string `xml:"href"`
+			Collection *struct {
+			} `xml:"propstat>prop>resourcetype>collection"`
+		} `xml:"response"`
+	}
+	if err := xml.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	var entries []webDAVEntry
+	for _, item := range result.Responses {
+		hrefURL, err := url.Parse(item.Href) // Servers return escaped absolute paths
+		if err != nil {
+			continue
+		}
+		key := strings.Trim(strings.TrimPrefix(hrefURL.Path, s.base.Path), "/") // Key relative to the base
+		if key == strings.Trim(collection, "/") {
+			continue // The collection lists itself first
+		}
+		entries = append(entries, webDAVEntry{key: key, collection: item.Collection != nil})
+	}
+	return entries, nil
+}
+
+// Sends an authenticated request
+func (s *webDAVStorage) request(method string, target string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	request, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	if s.username != "" {
+		request.SetBasicAuth(s.username, s.password)
+	}
+	return s.client.Do(request)
+}
@@ -0,0 +1,138 @@
+package main // Listing pages to scrape and how to find the document links on each
+
+import (
+	"encoding/json" // Reads the targets file
+	"fmt"           // Builds validation errors
+	"os"            // Opens the targets file
+)
+
+var targetsFilePath = "" // JSON file listing the pages to scrape; empty scrapes the built-in PoolSeason page
+
+// A listing page to scrape, optionally restricted to the part of the page holding the SDS links
+type scrapeTarget struct {
+	URL      string      `json:"url"`                 // Listing page address
+	Selector string      `json:"selector,omitempty"`  // CSS selector for the container(s) holding the document links
+	XPath    string      `json:"xpath,omitempty"`     // XPath for the container(s), as an alternative to Selector
+	Next     string      `json:"next,omitempty"`      // "auto" to follow rel=next and "Next" links, or a CSS selector for the next-page link
+	MaxPages int         `json:"max_pages,omitempty"` // Pages followed through next links; defaults to defaultMaxPages
+	Auth     *targetAuth `json:"auth,omitempty"`      // Credentials for the target's host, e.g. a distributor's document API
+	Adapter  string      `json:"adapter,omitempty"`   // Vendor adapter discovering the documents; defaults by host, else listing
+
+	Categories     []string `json:"categories,omitempty"`      // Page headings whose documents are downloaded; empty allows every heading
+	SkipCategories []string `json:"skip_categories,omitempty"` // Page headings whose documents are never downloaded
+
+	container nodeSelector // Compiled Selector or XPath; nil scans the whole page
+	nextLink  nodeSelector // Compiled Next selector; nil with Next set means automatic detection
+	pages     []string     // URL expanded from any {first..last} page range
+}
+
+// Pages scraped when no targets file is given
+var defaultTargets = []scrapeTarget{
+	{URL: poolSeasonListingURL},
+}
+
+var scrapeTargets = defaultTargets // Targets used by runScrape; replaced by loadTargets when -targets is given
+
+// Returns a key identifying the container restriction, so cached extractions made without it aren't reused
+func (t scrapeTarget) containerKey() string {
+	if t.XPath != "" {
+		return "xpath:" + t.XPath
+	}
+	if t.Selector != "" {
+		return "css:" + t.Selector
+	}
+	return ""
+}
+
+// Returns the listing pages to start from: the URL, or every page of its {first..last} range
+func (t scrapeTarget) startPages() []string {
+	if t.pages == nil {
+		return []string{t.URL} // Targets that were never compiled
+	}
+	return t.pages
+}
+
+// Returns how many pages may be reached by following next links
+func (t scrapeTarget) maxPages() int {
+	if t.MaxPages > 0 {
+		return t.MaxPages
+	}
+	return defaultMaxPages
+}
+
+// Compiles the target's CSS selector or XPath, next-link selector and page range
+func (t *scrapeTarget) compile() error {
+	pages, err := expandPageTemplate(t.URL)
+	if err != nil {
+		return err
+	}
+	for _, page := range pages {
+		if !isUrlValid(page) {
+			return fmt.Errorf("target %s: invalid url %q", t.URL, page)
+		}
+	}
+	t.pages = pages
+	if t.Next != "" && t.Next != "auto" {
+		if t.nextLink, err = compileCSSSelector(t.Next); err != nil {
+			return fmt.Errorf("target %s: next: %w", t.URL, err)
+		}
+	}
+	switch {
+	case t.Selector != "" && t.XPath != "":
+		return fmt.Errorf("target %s: set either selector or xpath, not both", t.URL)
+	case t.Selector != "":
+		t.container, err = compileCSSSelector(t.Selector)
+	case t.XPath != "":
+		t.container, err = compileXPathSelector(t.XPath)
+	}
+	if err != nil {
+		return fmt.Errorf("target %s: %w", t.URL, err)
+	}
+	return nil
+}
+
+// Reads the targets file, falling back to the built-in targets when no file is configured
+func loadTargets(filePath string) ([]scrapeTarget, error) {
+	targets := defaultTargets
+	if filePath != "" {
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		targets = nil
+		if err := json.Unmarshal(data, &targets); err != nil {
+			return nil, fmt.Errorf("parsing targets %s: %w", filePath, err)
+		}
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("targets %s lists no pages", filePath)
+		}
+	}
+	compiled, err := compileTargets(targets)
+	if err != nil {
+		return nil, err
+	}
+	for _, target := range compiled {
+		if err := registerTargetAuth(target); err != nil {
+			return nil, err
+		}
+	}
+	return compiled, nil
+}
+
+// Validates and compiles targets without registering their credentials
+func compileTargets(targets []scrapeTarget) ([]scrapeTarget, error) {
+	compiled := make([]scrapeTarget, len(targets))
+	for i, target := range targets {
+		if target.URL == "" {
+			return nil, fmt.Errorf("target %d: url is required", i+1)
+		}
+		if err := target.compile(); err != nil {
+			return nil, err
+		}
+		if err := validateTargetAdapter(target); err != nil {
+			return nil, err
+		}
+		compiled[i] = target
+	}
+	return compiled, nil
+}
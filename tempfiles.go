@@ -0,0 +1,109 @@
+package main // Forensic copies of failed download attempts and their retention
+
+import (
+	"encoding/json" // Writes the attempt details next to the data
+	"fmt"           // Builds file names and validation errors
+	"log"           // Reports retention problems
+	"net/http"      // Response headers of the failed attempt
+	"os"            // Writes and removes temp files
+	"path/filepath" // Builds temp paths
+	"time"          // Ages out old run directories
+)
+
+var (
+	tempDir       = filepath.Join(os.TempDir(), "poolseason-scraper") // Root for per-run directories holding failed attempts
+	tempRetention = "delete"                                          // delete, keep-on-failure or keep-days
+	tempKeepDays  = 7                                                 // Age after which keep-days removes run directories
+)
+
+// Details stored next to the bytes of a failed attempt
+type failedAttempt struct {
+	RunID   string      `json:"run_id"`           // Run the attempt belongs to
+	URL     string      `json:"url"`              // Document being downloaded
+	Attempt int         `json:"attempt"`          // Attempt number within the download
+	Error   string      `json:"error"`            // Why the attempt failed
+	Bytes   int         `json:"bytes"`            // Bytes kept in the .part file
+	Header  http.Header `json:"header,omitempty"` // Response headers, when a response arrived
+	At      time.Time   `json:"at"`               // When the attempt failed
+}
+
+// Checks the -temp-retention option
+func validateTempRetention() error {
+	switch tempRetention {
+	case "delete", "keep-on-failure", "keep-days":
+	default:
+		return fmt.Errorf("unknown -temp-retention %q (expected delete, keep-on-failure or keep-days)", tempRetention)
+	}
+	if tempRetention == "keep-days" && tempKeepDays <= 0 {
+		return fmt.Errorf("-temp-keep-days must be positive")
+	}
+	return nil
+}
+
+// Returns the directory holding this run's failed attempts
+func runTempDir() string {
+	return filepath.Join(tempDir, runID())
+}
+
+// Saves the bytes received by a failed attempt with a JSON description, unless failures are deleted anyway
+func saveFailedAttempt(rawURL string, attempt int, fetched fetchedPDF, cause error) {
+	if tempRetention == "delete" {
+		return // Nothing would survive the end of the run
+	}
+	dir := runTempDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Printf("Failed to create temp directory %s: %v", dir, err)
+		return
+	}
+	base := filepath.Join(dir, fmt.Sprintf("%s.attempt-%d", urlToFilename(rawURL), attempt))
+	details, err := json.MarshalIndent(failedAttempt{RunID: runID(), URL: rawURL, Attempt: attempt, Error: cause.Error(), Bytes: len(fetched.Data), Header: fetched.Header, At: time.Now().UTC()}, "", "  ")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := os.WriteFile(base+".json", details, 0o644); err != nil {
+		log.Printf("Failed to save failed attempt details: %v", err)
+		return
+	}
+	if len(fetched.Data) > 0 {
+		if err := os.WriteFile(base+".part", fetched.Data, 0o644); err != nil {
+			log.Printf("Failed to save failed attempt data: %v", err)
+		}
+	}
+}
+
+// Applies the retention policy after a run: delete removes this run's files, keep-on-failure leaves them, and
+// keep-days leaves them but removes run directories older than the limit
+func cleanupTempFiles() {
+	switch tempRetention {
+	case "delete":
+		if err := os.RemoveAll(runTempDir()); err != nil {
+			log.Println(err)
+		}
+	case "keep-on-failure":
+		if directoryExists(runTempDir()) {
+			log.Printf("Failed download attempts kept in %s", runTempDir())
+		}
+	case "keep-days":
+		if directoryExists(runTempDir()) {
+			log.Printf("Failed download attempts kept in %s for %d days", runTempDir(), tempKeepDays)
+		}
+		entries, err := os.ReadDir(tempDir)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Println(err)
+			}
+			return
+		}
+		cutoff := time.Now().AddDate(0, 0, -tempKeepDays)
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !entry.IsDir() || !info.ModTime().Before(cutoff) {
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(tempDir, entry.Name())); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main // Pipeline-wide backoff when a server asks us to slow down
+
+import (
+	"errors"   // Detects throttling errors returned by fetches
+	"fmt"      // Formats throttling errors
+	"log"      // Reports pauses as they happen
+	"net/http" // Status codes and Retry-After parsing
+	"strconv"  // Parses Retry-After seconds
+	"strings"  // Trims header values
+	"sync"     // Guards the shared pause state
+	"time"     // Computes pause durations
+)
+
+var (
+	defaultRetryAfter  = 30 * time.Second // Pause used when a 429/503 carries no usable Retry-After header
+	maxRetryAfter      = 10 * time.Minute // Upper bound on a single pause so a bogus header can't stall the run
+	maxThrottleRetries = 5                // Times a single request is retried after being throttled
+	requestInterval    = time.Duration(0) // Minimum time between the starts of two requests; 0 sends them as fast as the workers go
+)
+
+// Returned by fetches when the server responded 429 or 503
+type throttledError struct {
+	status string        // Status line that triggered the pause
+	wait   time.Duration // How long the server asked us to wait
+}
+
+// Describes the throttling response
+func (e *throttledError) Error() string {
+	return fmt.Sprintf("throttled by server (%s), retry after %s", e.status, e.wait)
+}
+
+// Returns a throttledError for 429 and 503 responses and nil for everything else
+func checkThrottled(response *http.Response) error {
+	if response.StatusCode != http.StatusTooManyRequests && response.StatusCode != http.StatusServiceUnavailable {
+		return nil // Not a throttling response
+	}
+	return &throttledError{status: response.Status, wait: parseRetryAfter(response.Header.Get("Retry-After"), time.Now())}
+}
+
+// Parses a Retry-After header given either as delay-seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	wait := defaultRetryAfter // Used when the header is missing or malformed
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		wait = date.Sub(now)
+		if wait < 0 {
+			wait = 0 // The date is already in the past
+		}
+	}
+	return min(wait, maxRetryAfter) // Never pause longer than the configured ceiling
+}
+
+// Records one pause for the run summary
+type throttleEvent struct {
+	At     time.Time     // When the throttling response arrived
+	URL    string        // Request that was throttled
+	Status string        // Status line of the response
+	Wait   time.Duration // Pause that was applied
+}
+
+// Pauses every request in the pipeline until the most recent Retry-After has elapsed
+type pipelineThrottle struct {
+	mu          sync.Mutex      // Protects pausedUntil and events
+	pausedUntil time.Time       // No request may start before this time
+	nextSlot    time.Time       // Earliest start of the next request under requestInterval
+	events      []throttleEvent // Every throttling response seen this run
+}
+
+var requestThrottle = &pipelineThrottle{} // Shared by scraping and downloading
+
+// Extends the pipeline-wide pause and records the event; returns false when err isn't a throttling error
+func (t *pipelineThrottle) handle(requestURL string, err error) bool {
+	var throttled *throttledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if until := now.Add(throttled.wait); until.After(t.pausedUntil) { // Never shorten an existing pause
+		t.pausedUntil = until
+	}
+	t.events = append(t.events, throttleEvent{At: now.UTC(), URL: requestURL, Status: throttled.status, Wait: throttled.wait})
+	activeLimiter.Load().throttle() // Fewer parallel downloads once the pause is over
+	log.Printf("Throttled by %s (%s); pausing all requests for %s", getDomainFromURL(requestURL), throttled.status, throttled.wait)
+	return true
+}
+
+// Blocks until the current pause, if any, is over and the request's turn under requestInterval has come
+func (t *pipelineThrottle) wait() {
+	t.mu.Lock()
+	start := time.Now()
+	if t.pausedUntil.After(start) {
+		start = t.pausedUntil
+	}
+	if requestInterval > 0 {
+		if t.nextSlot.After(start) {
+			start = t.nextSlot
+		}
+		t.nextSlot = start.Add(requestInterval) // Reserve the slot so concurrent workers queue up behind it
+	}
+	delay := time.Until(start) // Remaining pause
+	t.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// Returns and clears the events recorded so far
+func (t *pipelineThrottle) drainEvents() []throttleEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	events := t.events
+	t.events = nil
+	return events
+}
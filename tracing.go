@@ -0,0 +1,360 @@
+package main // Request tracing exported to an OpenTelemetry collector over OTLP/HTTP
+
+import (
+	"bytes"              // Buffers export requests
+	"context"            // Carries the parent span through requests
+	"crypto/rand"        // Generates trace and span IDs
+	"crypto/tls"         // Observes TLS handshakes
+	"encoding/hex"       // Encodes IDs as OTLP/JSON expects
+	"encoding/json"      // Encodes export requests
+	"fmt"                // Builds error messages
+	"io"                 // Wraps response bodies to time the transfer
+	"log"                // Reports export failures
+	"net/http"           // Sends spans to the collector
+	"net/http/httptrace" // Times DNS, connect, TLS and first byte
+	"os"                 // Reads the standard OTEL_* environment variables
+	"strconv"            // Formats timestamps and attribute values
+	"strings"            // Parses headers and builds the endpoint URL
+	"sync"               // Guards the span buffer
+	"time"               // Times spans
+)
+
+var (
+	otlpEndpoint       = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") // Collector base URL such as http://localhost:4318; empty disables tracing
+	traceServiceName   = "poolseason-scraper"                     // service.name reported with every span
+	traceExportBatch   = 256                                      // Spans buffered before they're sent
+	traceExportTimeout = 10 * time.Second                         // Limit for one export request
+)
+
+// Span kinds as numbered by OTLP
+const (
+	spanKindInternal = 1 // Work inside the scraper, such as a whole run
+	spanKindClient   = 3 // An outgoing HTTP request
+)
+
+// One timed operation; spans with the same traceID form one run's trace
+type traceSpan struct {
+	traceID    [16]byte       // Shared by every span in the run
+	spanID     [8]byte        // Unique to this span
+	parentID   [8]byte        // Zero for the run's root span
+	name       string         // Operation name such as "HTTP GET" or "dns"
+	kind       int            // spanKind* constant
+	start      time.Time      // When the operation began
+	end        time.Time      // When it finished
+	attributes map[string]any // string, int, int64 or bool values
+	errMessage string         // Set when the operation failed
+	mu         sync.Mutex     // Protects attributes and errMessage for spans ended from other goroutines
+}
+
+// Collects finished spans and sends them to the collector in batches
+type traceExporter struct {
+	mu       sync.Mutex   // Protects pending
+	endpoint string       // Full URL of the traces endpoint
+	headers  http.Header  // Extra headers, e.g. for collector authentication
+	client   *http.Client // Plain client so exports aren't traced themselves
+	pending  []*traceSpan // Finished spans not yet sent
+}
+
+var (
+	tracer     *traceExporter // Open exporter, nil when tracing is disabled
+	runTrace   *traceSpan     // Root span of the current run
+	tracePhase *traceSpan     // Phase of the run, such as discovery, that requests without a span belong to
+)
+
+type traceSpanKey struct{} // Context key for the span new spans are children of
+
+// Opens the exporter for -otlp-endpoint; OTEL_EXPORTER_OTLP_HEADERS adds headers as comma-separated name=value pairs
+func startTracing() error {
+	if otlpEndpoint == "" {
+		return nil
+	}
+	endpoint := strings.TrimSuffix(otlpEndpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint += "/v1/traces"
+	}
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return fmt.Errorf("-otlp-endpoint %q must be an http:// or https:// URL", otlpEndpoint)
+	}
+	headers := make(http.Header)
+	for _, pair := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if name, value, found := strings.Cut(pair, "="); found {
+			headers.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+	}
+	tracer = &traceExporter{endpoint: endpoint, headers: headers, client: &http.Client{Timeout: traceExportTimeout}}
+	return nil
+}
+
+// Starts a span under the span carried by ctx, or under the current phase or root span of the run; returns nil
+// when tracing is disabled
+func startSpan(ctx context.Context, name string, kind int) *traceSpan {
+	if tracer == nil {
+		return nil
+	}
+	span := &traceSpan{name: name, kind: kind, start: time.Now(), attributes: make(map[string]any)}
+	rand.Read(span.spanID[:])
+	parent := runTrace
+	if tracePhase != nil {
+		parent = tracePhase
+	}
+	if ctx != nil {
+		if carried, ok := ctx.Value(traceSpanKey{}).(*traceSpan); ok && carried != nil {
+			parent = carried
+		}
+	}
+	if parent != nil {
+		span.traceID, span.parentID = parent.traceID, parent.spanID
+	} else {
+		rand.Read(span.traceID[:]) // A new root starts a new trace
+	}
+	return span
+}
+
+// Returns a context whose requests are traced as children of span
+func withSpan(ctx context.Context, span *traceSpan) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, traceSpanKey{}, span)
+}
+
+// Sets an attribute on the span; safe on a nil span
+func (s *traceSpan) set(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes[key] = value
+}
+
+// Marks the span as failed; safe on a nil span
+func (s *traceSpan) fail(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errMessage = err.Error()
+}
+
+// Records a finished child span covering from..to, skipping phases that never happened
+func (s *traceSpan) phase(name string, from, to time.Time) {
+	if s == nil || from.IsZero() || to.IsZero() {
+		return
+	}
+	child := &traceSpan{traceID: s.traceID, parentID: s.spanID, name: name, kind: spanKindInternal, start: from, end: to, attributes: map[string]any{}}
+	rand.Read(child.spanID[:])
+	tracer.add(child)
+}
+
+// Ends the span and queues it for export; safe on a nil span
+func (s *traceSpan) finish() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	tracer.add(s)
+}
+
+// Queues a finished span, sending the buffer once a batch is full
+func (e *traceExporter) add(span *traceSpan) {
+	e.mu.Lock()
+	e.pending = append(e.pending, span)
+	var batch []*traceSpan
+	if len(e.pending) >= traceExportBatch {
+		batch, e.pending = e.pending, nil
+	}
+	e.mu.Unlock()
+	if batch != nil {
+		go e.export(batch) // Don't hold up the request that finished the batch
+	}
+}
+
+// Sends every buffered span; called when a run ends
+func flushTraces() {
+	if tracer == nil {
+		return
+	}
+	tracer.mu.Lock()
+	batch := tracer.pending
+	tracer.pending = nil
+	tracer.mu.Unlock()
+	if len(batch) > 0 {
+		tracer.export(batch)
+	}
+}
+
+// Posts spans to the collector as an OTLP/JSON ExportTraceServiceRequest; failures are logged and dropped
+func (e *traceExporter) export(spans []*traceSpan) {
+	encoded := make([]map[string]any, 0, len(spans))
+	for _, span := range spans {
+		encoded = append(encoded, span.otlp())
+	}
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": otlpAttributes(map[string]any{
+				"service.name":    traceServiceName,
+				"service.version": buildVersion,
+			})},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": traceServiceName},
+				"spans": encoded,
+			}},
+		}},
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	request, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	request.Header = e.headers.Clone()
+	request.Header.Set("Content-Type", "application/json")
+	response, err := e.client.Do(request)
+	if err != nil {
+		log.Printf("Failed to export %d spans: %v", len(spans), err)
+		return
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	if response.StatusCode/100 != 2 {
+		log.Printf("Failed to export %d spans: collector returned %s", len(spans), response.Status)
+	}
+}
+
+// Encodes the span as an OTLP/JSON Span
+func (s *traceSpan) otlp() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	encoded := map[string]any{
+		"traceId":           hex.EncodeToString(s.traceID[:]),
+		"spanId":            hex.EncodeToString(s.spanID[:]),
+		"name":              s.name,
+		"kind":              s.kind,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        otlpAttributes(s.attributes),
+	}
+	if s.parentID != [8]byte{} {
+		encoded["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+	}
+	if s.errMessage != "" {
+		encoded["status"] = map[string]any{"code": 2, "message": s.errMessage} // STATUS_CODE_ERROR
+	}
+	return encoded
+}
+
+// Encodes attributes as OTLP/JSON KeyValues
+func otlpAttributes(attributes map[string]any) []any {
+	encoded := make([]any, 0, len(attributes))
+	for key, value := range attributes {
+		var typed map[string]any
+		switch v := value.(type) {
+		case int:
+			typed = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			typed = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case bool:
+			typed = map[string]any{"boolValue": v}
+		default:
+			typed = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		encoded = append(encoded, map[string]any{"key": key, "value": typed})
+	}
+	return encoded
+}
+
+// Traces every request it sends: one client span per request with dns, connect, tls, ttfb and transfer phases
+type tracingTransport struct {
+	next http.RoundTripper // Transport that sends the request
+}
+
+// Sends the request, timing each phase when tracing is enabled
+func (t tracingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	span := startSpan(request.Context(), "HTTP "+request.Method, spanKindClient)
+	if span == nil {
+		return t.next.RoundTrip(request)
+	}
+	span.set("http.request.method", request.Method)
+	span.set("url.full", request.URL.String())
+	span.set("server.address", request.URL.Hostname())
+
+	var mu sync.Mutex // httptrace hooks may run on other goroutines
+	var dnsStart, dnsDone, connectStart, connectDone, tlsStart, tlsDone, wrote, firstByte time.Time
+	stamp := func(at *time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		if at.IsZero() {
+			*at = time.Now()
+		}
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { stamp(&dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { stamp(&dnsDone) },
+		ConnectStart:         func(string, string) { stamp(&connectStart) },
+		ConnectDone:          func(string, string, error) { stamp(&connectDone) },
+		TLSHandshakeStart:    func() { stamp(&tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { stamp(&tlsDone) },
+		GotConn:              func(info httptrace.GotConnInfo) { span.set("http.connection.reused", info.Reused) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { stamp(&wrote) },
+		GotFirstResponseByte: func() { stamp(&firstByte) },
+	}
+	response, err := t.next.RoundTrip(request.WithContext(httptrace.WithClientTrace(request.Context(), trace)))
+	recordPhases := func(transferEnd time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		span.phase("dns", dnsStart, dnsDone)
+		span.phase("connect", connectStart, connectDone)
+		span.phase("tls", tlsStart, tlsDone)
+		span.phase("ttfb", wrote, firstByte)
+		span.phase("transfer", firstByte, transferEnd)
+	}
+	if err != nil {
+		span.fail(err)
+		recordPhases(time.Time{})
+		span.finish()
+		return nil, err
+	}
+	span.set("http.response.status_code", response.StatusCode)
+	if response.StatusCode >= 400 {
+		span.fail(fmt.Errorf("%s", response.Status))
+	}
+	response.Body = &tracedBody{ReadCloser: response.Body, done: func(read int64, err error) {
+		span.set("http.response.body.size", read)
+		span.fail(err)
+		recordPhases(time.Now())
+		span.finish()
+	}}
+	return response, nil
+}
+
+// Ends a request's span once its body has been read to the end or closed
+type tracedBody struct {
+	io.ReadCloser
+	read int64                       // Bytes read so far
+	once sync.Once                   // done runs once even if both EOF and Close happen
+	done func(read int64, err error) // Finishes the span
+}
+
+// Reads from the body, finishing the span at EOF or on an error
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err == io.EOF {
+		b.once.Do(func() { b.done(b.read, nil) })
+	} else if err != nil {
+		b.once.Do(func() { b.done(b.read, err) })
+	}
+	return n, err
+}
+
+// Closes the body, finishing the span if it's still open
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b.read, nil) })
+	return err
+}
@@ -0,0 +1,144 @@
+package main // Groups language variants of the same Safety Data Sheet
+
+import (
+	"path"    // Reads the file name of a document URL
+	"sort"    // Orders groups and their translations
+	"strings" // Splits text and file names into words
+)
+
+// Common words that identify the language a sheet is written in
+var languageStopwords = map[string][]string{
+	"en": {"the", "and", "of", "with", "for", "if", "not", "to", "or", "this", "product", "eyes", "skin"},
+	"es": {"el", "la", "los", "las", "de", "del", "y", "con", "para", "si", "no", "por", "producto", "ojos", "piel"},
+	"fr": {"le", "les", "des", "du", "et", "avec", "pour", "si", "ne", "pas", "ou", "produit", "yeux", "peau"},
+}
+
+// Readable names for the languages sheets are detected in
+var languageNames = map[string]string{"en": "English", "es": "Spanish", "fr": "French"}
+
+const minLanguageWords = 20 // Stopword hits needed before a detected language is trusted
+
+// Language words vendors put in file names of translated sheets, mapped to their language code
+var fileLanguageWords = map[string]string{
+	"english": "en", "eng": "en", "en": "en",
+	"spanish": "es", "espanol": "es", "sp": "es", "es": "es",
+	"french": "fr", "francais": "fr", "fr": "fr",
+}
+
+// Returns the language a sheet's text is written in, or empty when too little text was readable
+func detectTextLanguage(text string) string {
+	counts := make(map[string]int)
+	lookup := make(map[string][]string) // Word to the languages it belongs to
+	for language, words := range languageStopwords {
+		for _, word := range words {
+			lookup[word] = append(lookup[word], language)
+		}
+	}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r > 127)
+	}) {
+		for _, language := range lookup[word] {
+			counts[language]++
+		}
+	}
+	best, bestCount := "", 0
+	for _, language := range []string{"en", "es", "fr"} { // Fixed order keeps ties deterministic
+		if counts[language] > bestCount {
+			best, bestCount = language, counts[language]
+		}
+	}
+	if bestCount < minLanguageWords {
+		return ""
+	}
+	return best
+}
+
+// Returns the language a document is written in: detected from its text, then named in its URL, then the
+// Accept-Language variant that was requested
+func (e manifestEntry) documentLanguage() string {
+	if e.SDS.Language != "" {
+		return e.SDS.Language
+	}
+	for _, word := range fileNameWords(e.URL) {
+		if language, found := fileLanguageWords[word]; found {
+			return language
+		}
+	}
+	if e.Language != "" {
+		return strings.ToLower(strings.SplitN(e.Language, "-", 2)[0]) // es-MX and es are the same translation
+	}
+	return ""
+}
+
+// Splits the file name of a URL into lowercase words
+func fileNameWords(rawURL string) []string {
+	base := path.Base(strings.SplitN(rawURL, "?", 2)[0])
+	base = strings.TrimSuffix(base, path.Ext(base))
+	return strings.FieldsFunc(strings.ToLower(base), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	})
+}
+
+// Returns the key shared by every translation of a document: its domain plus the product printed on the sheet,
+// or plus the URL file name with language words, dates and "sds" removed when the sheet names no product
+func translationGroup(entry manifestEntry) string {
+	if slug := productSlug(entry.SDS.Product); slug != "" {
+		return entry.Domain + "/" + slug
+	}
+	var kept []string
+	for _, word := range fileNameWords(entry.URL) {
+		if _, isLanguage := fileLanguageWords[word]; isLanguage || word == "sds" || isDigits(word) {
+			continue // Translations differ only in these parts of the name
+		}
+		kept = append(kept, word)
+	}
+	if len(kept) == 0 {
+		return entry.Domain + "/" + entry.URL // Nothing left to match on, so the document stands alone
+	}
+	return entry.Domain + "/" + strings.Join(kept, "_")
+}
+
+// Reports whether a word is made only of digits
+func isDigits(word string) bool {
+	for _, r := range word {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return word != ""
+}
+
+// Groups entries by translationGroup; groups are sorted by key and translations by language
+func groupTranslations(entries []manifestEntry) [][]manifestEntry {
+	byGroup := make(map[string][]manifestEntry)
+	for _, entry := range entries {
+		group := entry.Group
+		if group == "" {
+			group = translationGroup(entry) // Entries recorded before grouping existed
+		}
+		byGroup[group] = append(byGroup[group], entry)
+	}
+	keys := make([]string, 0, len(byGroup))
+	for key := range byGroup {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	groups := make([][]manifestEntry, 0, len(keys))
+	for _, key := range keys {
+		group := byGroup[key]
+		sort.SliceStable(group, func(i, j int) bool { return group[i].documentLanguage() < group[j].documentLanguage() })
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// Returns a readable name for a language code, the code itself when unnamed, or "Unknown" when empty
+func languageName(code string) string {
+	if name, found := languageNames[code]; found {
+		return name
+	}
+	if code == "" {
+		return "Unknown"
+	}
+	return code
+}
@@ -0,0 +1,228 @@
+package main // Section 14 transport information read from Safety Data Sheets, for shipping papers
+
+import (
+	"encoding/csv" // Writes the transport report
+	"flag"         // Parses the report options
+	"fmt"          // Reports where the CSV was written
+	"io"           // Writes to a file or standard output
+	"os"           // Creates the output file
+	"regexp"       // Finds the section and its fields
+	"strings"      // Trims field values
+)
+
+// How a sheet says its product ships under one regulation, US DOT first since that's what the shipping papers follow
+type transportInfo struct {
+	Regulation   string `json:"regulation,omitempty"`    // DOT, TDG, IMDG, IATA or ADR; empty when the section doesn't say
+	NotRegulated bool   `json:"not_regulated,omitempty"` // The sheet says the product isn't regulated for transport
+	UNNumber     string `json:"un_number,omitempty"`     // UN or NA identification number such as UN1791
+	ShippingName string `json:"shipping_name,omitempty"` // Proper shipping name
+	HazardClass  string `json:"hazard_class,omitempty"`  // Hazard class or division such as 8 or 5.1
+	PackingGroup string `json:"packing_group,omitempty"` // I, II or III
+}
+
+var (
+	sectionFourteenRegex = regexp.MustCompile(`(?im)^[ \t]*(?:section[ \t]*)?14[ \t]*[.:]?[ \t]*[-–]?[ \t]*(?:transport|informaci[oó]n (?:relativa al|sobre el|de) transporte|informations relatives au transport)`) // Heading of the transport section
+	sectionFifteenRegex  = regexp.MustCompile(`(?im)^[ \t]*(?:section[ \t]*)?15[ \t]*[.:]?[ \t]*[-–]?[ \t]*(?:regulatory|informaci[oó]n reglamentaria|informations (?:relatives à la|réglementaires))`)              // Heading of the section that ends it
+	regulationRegex      = regexp.MustCompile(`(?m)(?:^|[\s(])(U\.?S\.? DOT|DOT|49 ?CFR|TDG|IMDG|IATA|ICAO|ADR|RID)\b`)                                                                                              // Regulation a block of the section is about
+	unNumberRegex        = regexp.MustCompile(`\b(UN|NA|ID)[ \t]?-?[ \t]?(\d{4})\b`)                                                                                                                                 // UN1791, NA 1993
+	shippingNameRegex    = regexp.MustCompile(`(?i)(?:un |dot )?proper shipping name|shipping name|designaci[oó]n oficial de transporte|d[ée]signation officielle de transport`)                                     // Label of the proper shipping name
+	hazardClassRegex     = regexp.MustCompile(`(?i)(?:transport )?hazard class(?:\(es\))?|\bclass(?:\(es\)|\b)|\bclase\b|\bclasse\b`)                                                                                // Label of the hazard class
+	packingGroupRegex    = regexp.MustCompile(`(?i)packing group|grupo de embalaje|groupe d'emballage|\bPG\b`)                                                                                                       // Label of the packing group
+	compactEntryRegex    = regexp.MustCompile(`\b(UN|NA|ID)[ \t]?(\d{4}),[ \t]*([^\n]+?),[ \t]*(\d(?:\.\d)?)[ \t]*(?:\([^)]*\))?,[ \t]*(?:PG[ \t]*)?(III|II|I)\b`)                                                   // "UN1791, Hypochlorite solution, 8, PG III"
+	notRegulatedRegex    = regexp.MustCompile(`(?i)not regulated|not restricted|not dangerous goods|not classified as (?:a )?dangerous|no regulado|non réglementé`)                                                  // Products that ship without hazmat papers
+	classValueRegex      = regexp.MustCompile(`^[ \t]*[:.]?[ \t]*(\d(?:\.\d)?)\b`)                                                                                                                                   // Class number right after its label
+	groupValueRegex      = regexp.MustCompile(`^[ \t]*[:.]?[ \t]*(III|II|I)\b`)                                                                                                                                      // Packing group right after its label
+)
+
+// Returns the transport information of the regulation shipping papers follow: DOT when the sheet lists it, else
+// the first block of the section that could be read; nil when there is none
+func findTransportInfo(text string) *transportInfo {
+	section := sectionFourteenText(text)
+	if section == "" {
+		return nil
+	}
+	var found *transportInfo
+	for _, block := range regulationBlocks(section) {
+		info := parseTransportBlock(block.Regulation, block.Text)
+		if info == (transportInfo{Regulation: block.Regulation}) {
+			continue // Nothing this block says could be read
+		}
+		if info.Regulation == "DOT" {
+			return &info
+		}
+		if found == nil {
+			found = &info
+		}
+	}
+	return found
+}
+
+// Returns the text of the transport section, from its heading to the regulatory heading
+func sectionFourteenText(text string) string {
+	location := sectionFourteenRegex.FindStringIndex(text)
+	if location == nil {
+		return ""
+	}
+	section := text[location[1]:]
+	if end := sectionFifteenRegex.FindStringIndex(section); end != nil {
+		section = section[:end[0]]
+	}
+	return section
+}
+
+// Part of the transport section about one regulation
+type regulationBlock struct {
+	Regulation string // DOT, TDG, IMDG, IATA or ADR; empty for text before the first regulation label
+	Text       string // Lines of the block
+}
+
+// Splits the transport section into the blocks each regulation label starts
+func regulationBlocks(section string) []regulationBlock {
+	locations := regulationRegex.FindAllStringSubmatchIndex(section, -1)
+	if len(locations) == 0 {
+		return []regulationBlock{{Text: section}}
+	}
+	var blocks []regulationBlock
+	if strings.TrimSpace(section[:locations[0][0]]) != "" {
+		blocks = append(blocks, regulationBlock{Text: section[:locations[0][0]]})
+	}
+	for i, location := range locations {
+		end := len(section)
+		if i+1 < len(locations) {
+			end = locations[i+1][0]
+		}
+		blocks = append(blocks, regulationBlock{Regulation: regulationName(section[location[2]:location[3]]), Text: section[location[1]:end]})
+	}
+	return blocks
+}
+
+// Maps the ways sheets name a regulation to one name per regulation
+func regulationName(label string) string {
+	switch label = strings.ToUpper(label); {
+	case strings.Contains(label, "DOT"), strings.Contains(label, "CFR"):
+		return "DOT"
+	case label == "ICAO":
+		return "IATA"
+	case label == "RID":
+		return "ADR"
+	}
+	return label
+}
+
+// Reads the fields of one regulation's block, as labelled fields or as a compact "UN1791, name, 8, PG III" line
+func parseTransportBlock(regulation string, block string) transportInfo {
+	info := transportInfo{Regulation: regulation}
+	if match := compactEntryRegex.FindStringSubmatch(block); match != nil {
+		info.UNNumber = identificationNumber(match[1], match[2])
+		info.ShippingName = strings.Join(strings.Fields(match[3]), " ")
+		info.HazardClass, info.PackingGroup = match[4], match[5]
+		return info
+	}
+	if match := unNumberRegex.FindStringSubmatch(block); match != nil {
+		info.UNNumber = identificationNumber(match[1], match[2])
+	}
+	info.ShippingName = labelledValue(block, shippingNameRegex)
+	if value := labelledValue(block, hazardClassRegex); value != "" {
+		if match := classValueRegex.FindStringSubmatch(value); match != nil {
+			info.HazardClass = match[1]
+		}
+	}
+	if value := labelledValue(block, packingGroupRegex); value != "" {
+		if match := groupValueRegex.FindStringSubmatch(value); match != nil {
+			info.PackingGroup = match[1]
+		}
+	}
+	if info.UNNumber == "" && notRegulatedRegex.MatchString(block) {
+		info = transportInfo{Regulation: regulation, NotRegulated: true}
+	}
+	return info
+}
+
+// Returns an identification number as written on shipping papers; IATA's ID numbers are UN numbers
+func identificationNumber(prefix string, number string) string {
+	if prefix == "ID" {
+		prefix = "UN"
+	}
+	return prefix + number
+}
+
+// Returns the rest of the line after the first label the pattern matches, without the separator; a shipping name
+// column that spills onto the next line isn't followed
+func labelledValue(block string, label *regexp.Regexp) string {
+	location := label.FindStringIndex(block)
+	if location == nil {
+		return ""
+	}
+	value, _, _ := strings.Cut(block[location[1]:], "\n")
+	value = strings.TrimLeft(value, " \t:.-–")
+	value = strings.Join(strings.Fields(value), " ")
+	if value == "" || strings.EqualFold(value, "not applicable") || strings.EqualFold(value, "n/a") || value == "-" {
+		return ""
+	}
+	return value
+}
+
+// Column headings of the transport report
+var transportHeader = []string{"Product", "File", "URL", "Regulation", "Regulated", "UN Number", "Proper Shipping Name", "Hazard Class", "Packing Group", "Revision Date"}
+
+// Writes the transport information of every sheet as CSV, for the shipping papers
+func runTransportReport(args []string) error {
+	flags := flag.NewFlagSet("report transport", flag.ExitOnError) // Options specific to this report
+	output := flags.String("output", "", "CSV file to write (defaults to standard output)")
+	regulatedOnly := flags.Bool("regulated-only", false, "only list sheets that give a UN or NA number")
+	manifestPath := flags.String("manifest", manifestFilePath, "manifest describing the archive")
+	include := addStateFilter(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	writer := csv.NewWriter(out)
+	if err := writer.Write(transportHeader); err != nil {
+		return err
+	}
+	rows := 0
+	for _, entry := range loadManifest(*manifestPath).list() {
+		if !include(entry) {
+			continue
+		}
+		entry = withSDSMetadata(entry) // Entries downloaded before transport data was extracted
+		info := transportInfo{}
+		if entry.SDS.Transport != nil {
+			info = *entry.SDS.Transport
+		}
+		if *regulatedOnly && info.UNNumber == "" {
+			continue
+		}
+		regulated := "unknown" // The sheet has no readable transport section
+		switch {
+		case info.UNNumber != "":
+			regulated = "yes"
+		case info.NotRegulated:
+			regulated = "no"
+		}
+		product := entry.SDS.Product
+		if product == "" {
+			product = productNameFromFile(entry.File)
+		}
+		if err := writer.Write([]string{product, entry.File, entry.URL, info.Regulation, regulated, info.UNNumber, info.ShippingName, info.HazardClass, info.PackingGroup, entry.SDS.RevisionDate}); err != nil {
+			return err
+		}
+		rows++
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "Wrote %d transport rows to %s\n", rows, *output)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main // Safe extraction of downloaded ZIP bundles
+
+import (
+	"archive/zip" // Reads the bundles
+	"bytes"       // Opens bundles held in memory
+	"errors"      // Signals exceeded limits
+	"fmt"         // Builds error messages
+	"io"          // Bounds decompressed reads
+	"log"         // Reports extracted files
+	"path"        // Validates entry names
+	"strings"     // Checks entry names
+)
+
+// Limits protecting the archive from zip bombs and malicious entry names; every one is configurable
+var (
+	extractZips            = false                     // Extract downloaded ZIP bundles next to the bundle
+	zipMaxTotalBytes       = int64(1024 * 1024 * 1024) // Decompressed bytes allowed per bundle, nested bundles included
+	zipMaxFiles            = 10000                     // Files allowed per bundle, nested bundles included
+	zipMaxDepth            = 2                         // Levels of ZIPs inside ZIPs that are extracted
+	zipMaxCompressionRatio = 200                       // Largest decompressed/compressed ratio accepted for one entry
+)
+
+var errZipLimit = errors.New("zip safety limit exceeded") // Wrapped by every limit violation
+
+// Running totals for one bundle and everything nested in it
+type zipBudget struct {
+	bytes int64 // Decompressed bytes written so far
+	files int   // Files written so far
+}
+
+// Extracts a ZIP bundle below destination (a storage key prefix), refusing entries that escape it and stopping at
+// the size, count, ratio and nesting limits; nothing is stored when any limit is hit
+func extractZIP(data []byte, destination string) ([]string, error) {
+	extracted := make(map[string][]byte) // Staged in memory so a violation leaves nothing behind
+	if err := stageZIP(data, strings.TrimSuffix(destination, "/"), 1, &zipBudget{}, extracted); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(extracted))
+	for key, contents := range extracted {
+		if err := archiveStorage.Put(key, contents); err != nil {
+			return keys, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Reads one level of a bundle into extracted, recursing into nested bundles up to zipMaxDepth
+func stageZIP(data []byte, destination string, depth int, budget *zipBudget, extracted map[string][]byte) error {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		name, err := safeZipEntryName(file.Name)
+		if err != nil {
+			return err
+		}
+		if budget.files++; budget.files > zipMaxFiles {
+			return fmt.Errorf("%w: more than %d files", errZipLimit, zipMaxFiles)
+		}
+		if file.CompressedSize64 > 0 && file.UncompressedSize64/file.CompressedSize64 > uint64(zipMaxCompressionRatio) {
+			return fmt.Errorf("%w: %s expands %d times", errZipLimit, file.Name, file.UncompressedSize64/file.CompressedSize64)
+		}
+		contents, err := readZipEntry(file, zipMaxTotalBytes-budget.bytes) // Header sizes can lie; count what is actually inflated
+		if err != nil {
+			return err
+		}
+		budget.bytes += int64(len(contents))
+
+		key := destination + "/" + name
+		if sniffDocumentKind(contents) == "zip" {
+			if depth >= zipMaxDepth {
+				log.Printf("Not extracting %s: nested more than %d levels deep", key, zipMaxDepth)
+			} else if err := stageZIP(contents, strings.TrimSuffix(key, path.Ext(key)), depth+1, budget, extracted); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		extracted[key] = contents
+	}
+	return nil
+}
+
+// Inflates one entry, failing once more than remaining bytes come out
+func readZipEntry(file *zip.File, remaining int64) ([]byte, error) {
+	entry, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer entry.Close()
+	contents, err := io.ReadAll(io.LimitReader(entry, remaining+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file.Name, err)
+	}
+	if int64(len(contents)) > remaining {
+		return nil, fmt.Errorf("%w: more than %s decompressed", errZipLimit, formatBytes(zipMaxTotalBytes))
+	}
+	return contents, nil
+}
+
+// Returns a cleaned entry name, rejecting absolute paths, drive letters and ".." segments (zip-slip)
+func safeZipEntryName(name string) (string, error) {
+	slashed := strings.ReplaceAll(name, `\`, "/") // Some tools write Windows separators
+	if strings.HasPrefix(slashed, "/") || (len(slashed) >= 2 && slashed[1] == ':') {
+		return "", fmt.Errorf("%w: absolute entry name %q", errZipLimit, name)
+	}
+	for _, segment := range strings.Split(slashed, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("%w: entry %q escapes the output directory", errZipLimit, name)
+		}
+	}
+	cleaned := path.Clean(slashed)
+	if cleaned == "." || strings.ContainsRune(cleaned, 0) {
+		return "", fmt.Errorf("%w: invalid entry name %q", errZipLimit, name)
+	}
+	return cleaned, nil
+}
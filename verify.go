@@ -0,0 +1,92 @@
+package main // Download verification helpers for the scraper
+
+import (
+	"bytes"           // Compares computed and advertised digests
+	"crypto/md5"      // Computes MD5 digests for Content-MD5 and Digest headers
+	"crypto/sha1"     // Computes SHA digests for legacy Digest headers
+	"crypto/sha256"   // Computes SHA-256 digests for Digest headers and the manifest
+	"crypto/sha512"   // Computes SHA-512 digests for Digest headers
+	"encoding/base64" // Decodes base64 digest values sent by servers
+	"encoding/hex"    // Encodes SHA-256 digests for the manifest
+	"fmt"             // Builds descriptive verification errors
+	"hash"            // Common interface implemented by every digest algorithm
+	"net/http"        // Provides the response headers being verified
+	"os"              // Checks the size of files written to disk
+	"strings"         // Splits and normalizes digest header values
+)
+
+// Maps lowercase digest algorithm names used in HTTP headers to their hash constructors
+var digestAlgorithms = map[string]func() hash.Hash{
+	"md5":     md5.New,
+	"sha":     sha1.New,
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+// Checks downloaded data against Content-Length, Content-MD5 and Digest headers when present; the digests cover the
+// bytes as sent, so they aren't checked when the transport decoded a compressed body
+func verifyDownload(header http.Header, contentLength int64, data []byte, decoded bool) error {
+	if contentLength >= 0 && contentLength != int64(len(data)) { // A short body means the transfer was truncated
+		return fmt.Errorf("received %d bytes but Content-Length was %d", len(data), contentLength)
+	}
+	if decoded {
+		return nil // The data is the gunzipped body, not what the digests were computed over
+	}
+	if contentMD5 := strings.TrimSpace(header.Get("Content-MD5")); contentMD5 != "" { // Legacy MD5 header
+		if err := compareDigest("md5", contentMD5, data); err != nil {
+			return fmt.Errorf("Content-MD5 mismatch: %w", err)
+		}
+	}
+	for _, headerName := range []string{"Digest", "Content-Digest", "Repr-Digest"} { // RFC 3230 and RFC 9530 headers
+		for _, value := range header.Values(headerName) { // A header may be repeated
+			for _, part := range strings.Split(value, ",") { // Each value may list several algorithms
+				algorithm, encoded, found := strings.Cut(strings.TrimSpace(part), "=")
+				if !found {
+					continue // Skip malformed entries
+				}
+				algorithm = strings.ToLower(strings.TrimSpace(algorithm))    // Algorithm names are case-insensitive
+				encoded = strings.Trim(strings.TrimSpace(encoded), ":")      // RFC 9530 wraps values in colons
+				if _, supported := digestAlgorithms[algorithm]; !supported { // Ignore algorithms we can't compute
+					continue
+				}
+				if err := compareDigest(algorithm, encoded, data); err != nil {
+					return fmt.Errorf("%s header mismatch: %w", headerName, err)
+				}
+			}
+		}
+	}
+	return nil // Everything advertised by the server matched
+}
+
+// Compares the base64 digest advertised by the server with the digest of the data
+func compareDigest(algorithm string, encoded string, data []byte) error {
+	expected, err := base64.StdEncoding.DecodeString(encoded) // Digests are sent base64 encoded
+	if err != nil {
+		return fmt.Errorf("invalid %s value %q: %w", algorithm, encoded, err)
+	}
+	hasher := digestAlgorithms[algorithm]() // Create the matching hash
+	hasher.Write(data)                      // Hash the downloaded data
+	actual := hasher.Sum(nil)               // Final digest
+	if !bytes.Equal(expected, actual) {     // Compare byte for byte
+		return fmt.Errorf("expected %s %s, got %s", algorithm, encoded, base64.StdEncoding.EncodeToString(actual))
+	}
+	return nil // Digest matched
+}
+
+// Returns the hex-encoded SHA-256 digest of the data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)        // Hash the data
+	return hex.EncodeToString(sum[:]) // Encode as lowercase hex
+}
+
+// Confirms the file on disk holds exactly the expected number of bytes
+func verifyFileSize(filePath string, expected int64) error {
+	info, err := os.Stat(filePath) // Look up the written file
+	if err != nil {
+		return err
+	}
+	if info.Size() != expected { // A mismatch means the write was incomplete
+		return fmt.Errorf("file %s is %d bytes, expected %d", filePath, info.Size(), expected)
+	}
+	return nil // Size matched
+}
@@ -0,0 +1,94 @@
+package main // Completion webhooks POSTed to callers of TriggerRun when their run finishes
+
+import (
+	"bytes"         // Wraps the request body
+	"crypto/hmac"   // Signs the payload
+	"crypto/sha256" // Hash used for the signature
+	"encoding/hex"  // Formats the signature
+	"encoding/json" // Encodes the run report
+	"fmt"           // Formats errors
+	"io"            // Drains responses
+	"log"           // Reports delivery failures
+	"net/http"      // Posts the report
+	"net/url"       // Validates webhook URLs
+	"strings"       // Trims response bodies
+	"time"          // Bounds and spaces out attempts
+)
+
+var webhookSecret = "" // Key the X-Poolseason-Signature header is computed with; empty sends reports unsigned
+
+const (
+	webhookAttempts     = 5                // Deliveries tried before a webhook is given up on
+	webhookInitialDelay = 2 * time.Second  // Wait before the second attempt, doubled for each one after
+	webhookTimeout      = 30 * time.Second // Longest one delivery may take
+)
+
+// States of a run's webhook as reported by GetRunStatus
+const (
+	webhookPending   = "pending"   // The run hasn't finished, or the report is still being delivered
+	webhookDelivered = "delivered" // The receiver answered with a 2xx status
+	webhookFailed    = "failed"    // Every attempt failed, or the receiver rejected the report
+)
+
+var webhookClient = &http.Client{Timeout: webhookTimeout, Transport: newAuditTransport(nil)} // Client used for every delivery
+
+// Checks that a caller's webhook URL is an absolute http or https URL
+func validateWebhookURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook_url %q: %v", rawURL, err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid webhook_url %q (expected an http or https URL)", rawURL)
+	}
+	return nil
+}
+
+// POSTs a finished run's report to its webhook, retrying with backoff while the receiver is unreachable or failing;
+// returns the last error when the report couldn't be delivered
+func deliverWebhook(target string, jobID string, report map[string]any) error {
+	payload, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	delay := webhookInitialDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := postWebhook(target, jobID, payload)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == webhookAttempts {
+			return err
+		}
+		log.Printf("Webhook for job %s failed (attempt %d of %d), retrying in %s: %v", jobID, attempt, webhookAttempts, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// Makes one delivery attempt; retry reports whether a later attempt could succeed
+func postWebhook(target string, jobID string, payload []byte) (retry bool, err error) {
+	request, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "poolseason-scraper")
+	request.Header.Set("X-Poolseason-Job", jobID)
+	if webhookSecret != "" { // Lets the receiver check the report came from this server
+		mac := hmac.New(sha256.New, []byte(webhookSecret))
+		mac.Write(payload)
+		request.Header.Set("X-Poolseason-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	response, err := webhookClient.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	reply, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("webhook receiver answered %s: %s", response.Status, strings.TrimSpace(string(reply)))
+	return response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusRequestTimeout, err
+}
@@ -0,0 +1,381 @@
+package main // Delta transfers of large ZIP bundles with zsync, reusing the blocks of the archived copy that didn't change
+
+import (
+	"bufio"           // Reads the control file header
+	"bytes"           // Compares block checksums
+	"context"         // Carries the document span and deadline
+	"crypto/sha1"     // Verifies the assembled file against the control file
+	"encoding/binary" // Reads MD4 words
+	"encoding/hex"    // Decodes the control file's SHA-1
+	"errors"          // Declares the no-control-file error
+	"fmt"             // Builds Range headers and errors
+	"io"              // Reads block checksums and ranges
+	"log"             // Reports delta transfers
+	"math/bits"       // MD4 rotations
+	"net/http"        // Fetches the control file and ranges
+	"net/url"         // Resolves the control file's URL field
+	"strconv"         // Parses header values
+	"strings"         // Parses header lines
+)
+
+var deltaMinSize int64 = 8 * 1024 * 1024 // Archived ZIPs at least this large are updated with zsync when the server publishes a control file; 0 disables
+
+var errNoDeltaControl = errors.New("no zsync control file") // The server doesn't publish <url>.zsync, so the file is downloaded whole
+
+const (
+	maxDeltaRanges   = 512  // Changed regions fetched one ranged request each; more than this is cheaper as one download
+	maxDeltaFraction = 0.75 // Share of the file that may have to be fetched for a delta to be worth it
+)
+
+// Parses the -delta-min-size option, e.g. 8MiB
+func setDeltaMinSize(value string) error {
+	size, ok := parseByteSize(value)
+	if !ok {
+		return fmt.Errorf("-delta-min-size must be a size such as 8MiB, or 0 to disable delta transfers")
+	}
+	deltaMinSize = size
+	return nil
+}
+
+// Reports whether an archived copy is a ZIP large enough for a delta transfer to pay off
+func wantsDeltaTransfer(previous manifestEntry) bool {
+	return deltaMinSize > 0 && previous.Size >= deltaMinSize && strings.HasSuffix(strings.ToLower(previous.File), ".zip")
+}
+
+// A parsed zsync control file: the target file's size and SHA-1 and a weak and a strong checksum of each block
+type zsyncControl struct {
+	URL           string   // Where the target file is fetched from
+	Length        int64    // Size of the target file
+	BlockSize     int      // Bytes per block; the last block is padded with zeros
+	SHA1          []byte   // SHA-1 of the whole target file
+	SeqMatches    int      // Consecutive blocks that must match before a match is trusted
+	RsumBytes     int      // Bytes of the rolling checksum kept per block
+	ChecksumBytes int      // Bytes of the MD4 checksum kept per block
+	Rsums         []uint32 // Rolling checksum of each block, masked to RsumBytes
+	Checksums     [][]byte // MD4 prefix of each block
+}
+
+// Downloads a changed document as a delta against its archived copy: fetches <url>.zsync, finds the blocks the
+// archived copy already holds, requests the rest as byte ranges and checks the result against the control file
+func fetchDelta(ctx context.Context, client *http.Client, documentURL string, previous manifestEntry) (fetchedPDF, error) {
+	control, header, err := fetchZsyncControl(ctx, client, documentURL)
+	if err != nil {
+		return fetchedPDF{}, err
+	}
+	old, err := archiveStorage.Get(previous.File)
+	if err != nil {
+		return fetchedPDF{}, fmt.Errorf("reading the archived copy: %w", err)
+	}
+	if digest := sha1.Sum(old); bytes.Equal(digest[:], control.SHA1) { // Changed back, or the change was elsewhere
+		runBandwidth.addSkipped("delta-reused", int64(len(old)))
+		unchanged := http.Header{}
+		if previous.ETag != "" {
+			unchanged.Set("ETag", previous.ETag)
+		}
+		if !previous.LastModified.IsZero() {
+			unchanged.Set("Last-Modified", previous.LastModified.UTC().Format(http.TimeFormat))
+		}
+		return deltaResult(old, unchanged)
+	}
+
+	known := control.matchBlocks(old) // Offset in the archived copy of each block it holds, or -1
+	data := make([]byte, control.Length)
+	var ranges [][2]int64 // Byte ranges of the blocks that have to be fetched, end exclusive
+	var missing int64
+	for index, offset := range known {
+		start := int64(index) * int64(control.BlockSize)
+		end := min(start+int64(control.BlockSize), control.Length)
+		if offset >= 0 {
+			copy(data[start:end], old[offset:])
+			continue
+		}
+		missing += end - start
+		if last := len(ranges) - 1; last >= 0 && ranges[last][1] == start {
+			ranges[last][1] = end // Adjacent changed blocks are fetched together
+		} else {
+			ranges = append(ranges, [2]int64{start, end})
+		}
+	}
+	if len(ranges) > maxDeltaRanges || float64(missing) > maxDeltaFraction*float64(control.Length) {
+		return fetchedPDF{}, fmt.Errorf("%s of %s changed in %d places; a full download is cheaper", formatBytes(missing), formatBytes(control.Length), len(ranges))
+	}
+	log.Printf("Updating %s with zsync: reusing %s of the archived copy, fetching %s in %d ranges", documentURL, formatBytes(control.Length-missing), formatBytes(missing), len(ranges))
+	for _, byteRange := range ranges {
+		rangeHeader, err := fetchByteRange(ctx, client, control.URL, data[byteRange[0]:byteRange[1]], byteRange[0], control.Length)
+		if err != nil {
+			return fetchedPDF{}, err
+		}
+		header = rangeHeader // Validators of the file itself, for the manifest
+	}
+	if digest := sha1.Sum(data); !bytes.Equal(digest[:], control.SHA1) {
+		runBandwidth.discard(missing)
+		return fetchedPDF{}, fmt.Errorf("the assembled file doesn't match the control file's SHA-1")
+	}
+	runBandwidth.addSkipped("delta-reused", control.Length-missing)
+	return deltaResult(data, header)
+}
+
+// Wraps a file assembled by a delta transfer as a download, after checking it's still a document the run accepts
+func deltaResult(data []byte, header http.Header) (fetchedPDF, error) {
+	header = header.Clone()
+	header.Del("Content-Range")
+	header.Del("Content-Length")
+	kind := refineOfficeKind(sniffDocumentKind(data), data)
+	if !acceptedKinds[kind] {
+		return fetchedPDF{}, fmt.Errorf("%w (%s, assembled by zsync)", errUnrecognizedContent, kind)
+	}
+	return fetchedPDF{Data: data, Header: header, Kind: kind}, nil
+}
+
+// Fetches and parses the control file published next to a document
+func fetchZsyncControl(ctx context.Context, client *http.Client, documentURL string) (zsyncControl, http.Header, error) {
+	controlURL, err := url.Parse(documentURL)
+	if err != nil {
+		return zsyncControl{}, nil, err
+	}
+	controlURL.Path += ".zsync"
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, controlURL.String(), nil)
+	if err != nil {
+		return zsyncControl{}, nil, err
+	}
+	host := getDomainFromURL(documentURL)
+	requestThrottle.wait()
+	response, err := client.Do(request)
+	if err != nil {
+		return zsyncControl{}, nil, err
+	}
+	defer response.Body.Close()
+	recordHostResponse(host, response.StatusCode)
+	if response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusGone {
+		return zsyncControl{}, nil, errNoDeltaControl
+	}
+	if response.StatusCode != http.StatusOK {
+		return zsyncControl{}, nil, fmt.Errorf("fetching %s: %s", controlURL, response.Status)
+	}
+	counted := &countingReader{reader: response.Body}
+	control, err := parseZsyncControl(bufio.NewReader(counted), controlURL)
+	runBandwidth.addPDF(counted.n, false)
+	if err != nil {
+		return zsyncControl{}, nil, fmt.Errorf("%s: %w", controlURL, err)
+	}
+	return control, response.Header, nil
+}
+
+// Counts the bytes read through it
+type countingReader struct {
+	reader io.Reader // Underlying reader
+	n      int64     // Bytes read so far
+}
+
+// Reads from the underlying reader, counting what arrives
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// Parses a zsync control file: "Key: value" header lines, a blank line, then the checksums of every block
+func parseZsyncControl(reader *bufio.Reader, controlURL *url.URL) (zsyncControl, error) {
+	control := zsyncControl{SeqMatches: 1, RsumBytes: 4, ChecksumBytes: 16}
+	fields := make(map[string]string)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return control, fmt.Errorf("truncated header: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if key, value, found := strings.Cut(line, ":"); found {
+			fields[key] = strings.TrimSpace(value)
+		}
+	}
+	var err error
+	if control.Length, err = strconv.ParseInt(fields["Length"], 10, 64); err != nil || control.Length <= 0 {
+		return control, fmt.Errorf("invalid Length %q", fields["Length"])
+	}
+	if control.BlockSize, err = strconv.Atoi(fields["Blocksize"]); err != nil || control.BlockSize <= 0 {
+		return control, fmt.Errorf("invalid Blocksize %q", fields["Blocksize"])
+	}
+	if control.SHA1, err = hex.DecodeString(fields["SHA-1"]); err != nil || len(control.SHA1) != sha1.Size {
+		return control, fmt.Errorf("invalid SHA-1 %q", fields["SHA-1"])
+	}
+	if lengths := fields["Hash-Lengths"]; lengths != "" {
+		if _, err := fmt.Sscanf(lengths, "%d,%d,%d", &control.SeqMatches, &control.RsumBytes, &control.ChecksumBytes); err != nil {
+			return control, fmt.Errorf("invalid Hash-Lengths %q", lengths)
+		}
+	}
+	if control.SeqMatches < 1 || control.SeqMatches > 2 || control.RsumBytes < 1 || control.RsumBytes > 4 || control.ChecksumBytes < 3 || control.ChecksumBytes > 16 {
+		return control, fmt.Errorf("unsupported Hash-Lengths %d,%d,%d", control.SeqMatches, control.RsumBytes, control.ChecksumBytes)
+	}
+	target := fields["URL"]
+	if target == "" {
+		return control, fmt.Errorf("no URL field (compressed targets aren't supported)")
+	}
+	resolved, err := controlURL.Parse(target) // Usually relative to the control file
+	if err != nil {
+		return control, fmt.Errorf("invalid URL %q", target)
+	}
+	control.URL = resolved.String()
+
+	blocks := int((control.Length + int64(control.BlockSize) - 1) / int64(control.BlockSize))
+	record := make([]byte, control.RsumBytes+control.ChecksumBytes)
+	for range blocks {
+		if _, err := io.ReadFull(reader, record); err != nil {
+			return control, fmt.Errorf("truncated block checksums: %w", err)
+		}
+		var rsum uint32
+		for _, b := range record[:control.RsumBytes] {
+			rsum = rsum<<8 | uint32(b)
+		}
+		control.Rsums = append(control.Rsums, rsum)
+		control.Checksums = append(control.Checksums, bytes.Clone(record[control.RsumBytes:]))
+	}
+	return control, nil
+}
+
+// Returns the mask keeping the bytes of a rolling checksum the control file records
+func (c zsyncControl) rsumMask() uint32 {
+	if c.RsumBytes == 4 {
+		return 0xffffffff
+	}
+	return 1<<(8*c.RsumBytes) - 1
+}
+
+// Slides a block-sized window over the old file, returning for each block of the target the offset in the old file
+// where it was found, or -1
+func (c zsyncControl) matchBlocks(old []byte) []int {
+	known := make([]int, len(c.Rsums))
+	byRsum := make(map[uint32][]int, len(c.Rsums))
+	for index, rsum := range c.Rsums {
+		known[index] = -1
+		byRsum[rsum] = append(byRsum[rsum], index)
+	}
+	size := c.BlockSize
+	padded := append(bytes.Clone(old), make([]byte, size)...) // The target's last block is checksummed with zero padding
+	mask := c.rsumMask()
+	matches := func(index int, offset int) bool {
+		if offset+size > len(padded) || rollingChecksum(padded[offset:offset+size])&mask != c.Rsums[index] {
+			return false
+		}
+		digest := md4Sum(padded[offset : offset+size])
+		return bytes.Equal(digest[:c.ChecksumBytes], c.Checksums[index])
+	}
+
+	a, b := rollingSums(padded[:min(size, len(padded))])
+	for offset := 0; offset+size <= len(padded) && offset < len(old); {
+		matched := false
+		if candidates := byRsum[(uint32(a)<<16|uint32(b))&mask]; candidates != nil {
+			digest := md4Sum(padded[offset : offset+size])
+			for _, index := range candidates {
+				if known[index] >= 0 || !bytes.Equal(digest[:c.ChecksumBytes], c.Checksums[index]) {
+					continue
+				}
+				if c.SeqMatches > 1 && index+1 < len(c.Rsums) && !matches(index+1, offset+size) {
+					continue // Short checksums need the next block to agree too
+				}
+				known[index] = offset
+				matched = true
+			}
+		}
+		if matched && offset+2*size <= len(padded) {
+			offset += size
+			a, b = rollingSums(padded[offset : offset+size])
+			continue
+		}
+		if offset+size >= len(padded) {
+			break
+		}
+		out, in := uint16(padded[offset]), uint16(padded[offset+size])
+		a = a - out + in
+		b = b - uint16(size)*out + a
+		offset++
+	}
+	return known
+}
+
+// Returns zsync's rolling checksum of a block: the byte sum and the sum of the running sums, 16 bits each
+func rollingSums(block []byte) (uint16, uint16) {
+	var a, b uint16
+	for _, c := range block {
+		a += uint16(c)
+		b += a
+	}
+	return a, b
+}
+
+// Returns the rolling checksum of a block packed as zsync stores it, a in the high half
+func rollingChecksum(block []byte) uint32 {
+	a, b := rollingSums(block)
+	return uint32(a)<<16 | uint32(b)
+}
+
+// Fetches bytes start onward into dst with a ranged request for a file of the given size
+func fetchByteRange(ctx context.Context, client *http.Client, fileURL string, dst []byte, start int64, size int64) (http.Header, error) {
+	end := start + int64(len(dst)) - 1
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	request, cancel := withFileDeadline(request)
+	defer cancel()
+	requestThrottle.wait()
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	recordHostResponse(getDomainFromURL(fileURL), response.StatusCode)
+	if response.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("expected 206 Partial Content for bytes %d-%d, got %s", start, end, response.Status)
+	}
+	if want := fmt.Sprintf("bytes %d-%d/%d", start, end, size); response.Header.Get("Content-Range") != want {
+		return nil, fmt.Errorf("server sent range %q instead of %q", response.Header.Get("Content-Range"), want)
+	}
+	n, err := io.ReadFull(response.Body, dst)
+	runBandwidth.addPDF(int64(n), err != nil)
+	return response.Header, err
+}
+
+// Returns the MD4 digest of data (RFC 1320), which zsync uses as its strong block checksum
+func md4Sum(data []byte) [16]byte {
+	state := [4]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476}
+	message := append(bytes.Clone(data), 0x80)
+	for len(message)%64 != 56 {
+		message = append(message, 0)
+	}
+	message = binary.LittleEndian.AppendUint64(message, uint64(len(data))*8)
+	var x [16]uint32
+	for chunk := message; len(chunk) > 0; chunk = chunk[64:] {
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(chunk[4*i:])
+		}
+		a, b, c, d := state[0], state[1], state[2], state[3]
+		for _, i := range []uint{0, 4, 8, 12} { // Round 1
+			a = bits.RotateLeft32(a+(b&c|^b&d)+x[i], 3)
+			d = bits.RotateLeft32(d+(a&b|^a&c)+x[i+1], 7)
+			c = bits.RotateLeft32(c+(d&a|^d&b)+x[i+2], 11)
+			b = bits.RotateLeft32(b+(c&d|^c&a)+x[i+3], 19)
+		}
+		for _, i := range []uint{0, 1, 2, 3} { // Round 2
+			a = bits.RotateLeft32(a+(b&c|b&d|c&d)+x[i]+0x5a827999, 3)
+			d = bits.RotateLeft32(d+(a&b|a&c|b&c)+x[i+4]+0x5a827999, 5)
+			c = bits.RotateLeft32(c+(d&a|d&b|a&b)+x[i+8]+0x5a827999, 9)
+			b = bits.RotateLeft32(b+(c&d|c&a|d&a)+x[i+12]+0x5a827999, 13)
+		}
+		for _, i := range []uint{0, 2, 1, 3} { // Round 3
+			a = bits.RotateLeft32(a+(b^c^d)+x[i]+0x6ed9eba1, 3)
+			d = bits.RotateLeft32(d+(a^b^c)+x[i+8]+0x6ed9eba1, 9)
+			c = bits.RotateLeft32(c+(d^a^b)+x[i+4]+0x6ed9eba1, 11)
+			b = bits.RotateLeft32(b+(c^d^a)+x[i+12]+0x6ed9eba1, 15)
+		}
+		state[0], state[1], state[2], state[3] = state[0]+a, state[1]+b, state[2]+c, state[3]+d
+	}
+	var digest [16]byte
+	for i, word := range state {
+		binary.LittleEndian.PutUint32(digest[4*i:], word)
+	}
+	return digest
+}